@@ -2,11 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/imphyy/NovelCraft/backend/internal/config"
 	"github.com/imphyy/NovelCraft/backend/internal/db"
 	"github.com/imphyy/NovelCraft/backend/internal/httpapi"
+	"github.com/imphyy/NovelCraft/backend/internal/tokenizer"
 )
 
 func main() {
@@ -15,13 +24,15 @@ func main() {
 	// Load config
 	cfg := config.Load()
 
-	// Log AI services status
-	if cfg.OpenAIAPIKey != "" {
-		log.Printf("✓ OpenAI API key configured (AI features enabled)")
-	} else {
-		log.Printf("✗ OpenAI API key not configured (AI features disabled)")
+	// Chunk sizes and token budgets are only exact with the embedded vocabulary
+	if !tokenizer.Default().Exact() && !cfg.TokenizerApproximate {
+		log.Fatal("Tokenizer vocabulary missing: run `go generate ./internal/tokenizer` before building, or set TOKENIZER_APPROXIMATE=true to estimate token counts")
 	}
 
+	// Log AI services status
+	log.Printf("AI chat provider: %s", cfg.ChatProvider)
+	log.Printf("AI embeddings provider: %s", cfg.EmbeddingsProvider)
+
 	// Connect to database
 	dbPool, err := db.Connect(ctx, cfg.DatabaseURL)
 	if err != nil {
@@ -29,11 +40,47 @@ func main() {
 	}
 	defer dbPool.Close()
 
+	// Admin subcommands run once against the database instead of serving
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, cfg, dbPool, os.Args[1], os.Args[2:]); err != nil {
+			dbPool.Close()
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	// Create server
 	server := httpapi.NewServer(dbPool, cfg)
 
-	log.Printf("Starting server on port %s", cfg.Port)
-	if err := server.Start(":" + cfg.Port); err != nil {
-		log.Fatal(err)
+	go func() {
+		log.Printf("Starting server on port %s", cfg.Port)
+		if err := server.Start(":" + cfg.Port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for an interrupt, then let in-flight requests and jobs finish
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	log.Printf("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown did not complete cleanly: %v", err)
+	}
+}
+
+// runCommand runs an admin subcommand, stopping early on an interrupt
+func runCommand(ctx context.Context, cfg *config.Config, dbPool *pgxpool.Pool, name string, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch name {
+	case "reindex":
+		return runReindex(ctx, cfg, dbPool, args)
+	default:
+		return errors.New("unknown command (available: reindex)")
 	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/imphyy/NovelCraft/backend/internal/ai"
+	"github.com/imphyy/NovelCraft/backend/internal/config"
+)
+
+// runReindex implements `api reindex`: chunk and embed existing chapters and
+// wiki pages, for content written before AI was enabled or after changing
+// the embedding model or chunk size
+func runReindex(ctx context.Context, cfg *config.Config, dbPool *pgxpool.Pool, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	projectID := flags.String("project", "", "Project ID to reindex (default: every project)")
+	force := flags.Bool("force", false, "Re-embed documents even when their content and model are unchanged")
+	concurrency := flags.Int("concurrency", ai.DefaultReindexConcurrency, "Documents embedded at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	embedder, err := ai.NewEmbedder(cfg)
+	if err != nil {
+		return fmt.Errorf("embeddings disabled: %w", err)
+	}
+	if embedder == nil {
+		return errors.New("embeddings disabled: no embeddings provider configured")
+	}
+
+	documentService := ai.NewDocumentService(dbPool, embedder, ai.ChunkOptions{
+		TargetTokens:  cfg.ChunkTargetTokens,
+		OverlapTokens: cfg.ChunkOverlapTokens,
+	})
+	reindexer := ai.NewReindexer(dbPool, documentService)
+
+	log.Printf("Reindexing with %s", embedder.Model())
+	result, err := reindexer.Reindex(ctx, *projectID, ai.ReindexOptions{
+		Concurrency: *concurrency,
+		Force:       *force,
+		OnProgress: func(p ai.ReindexProgress) {
+			if p.Error != "" {
+				log.Printf("[%d/%d] %s %s %q: %s: %s", p.Processed, p.Total, p.SourceType, p.SourceID, p.Title, p.Outcome, p.Error)
+				return
+			}
+			log.Printf("[%d/%d] %s %s %q: %s", p.Processed, p.Total, p.SourceType, p.SourceID, p.Title, p.Outcome)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reindex finished: %d updated, %d skipped, %d failed", result.Updated, result.Skipped, result.Failed)
+	if result.Failed > 0 {
+		return fmt.Errorf("%d documents failed to reindex", result.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized access to project")
+)
+
+// verifyProjectOwnership checks if user owns the project
+func verifyProjectOwnership(ctx context.Context, db *pgxpool.Pool, projectID, userID string) error {
+	var exists bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND user_id = $2)
+	`, projectID, userID).Scan(&exists)
+
+	if err != nil {
+		return fmt.Errorf("failed to verify ownership: %w", err)
+	}
+
+	if !exists {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
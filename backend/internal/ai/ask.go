@@ -3,104 +3,300 @@ package ai
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Citation struct {
-	SourceType string `json:"sourceType"` // "chapter" or "wiki_page"
-	SourceID   string `json:"sourceId"`
-	ChunkID    string `json:"chunkId"`
-	Content    string `json:"content"`
-	Similarity float64 `json:"similarity"`
+	Number        int     `json:"number"`     // The "Source N" the model was shown
+	SourceType    string  `json:"sourceType"` // "chapter" or "wiki_page"
+	SourceID      string  `json:"sourceId"`
+	Title         string  `json:"title"`
+	ChunkID       string  `json:"chunkId"`
+	Content       string  `json:"content"`
+	StartOffset   *int    `json:"startOffset,omitempty"` // Character offset of Content in the source
+	EndOffset     *int    `json:"endOffset,omitempty"`   // Exclusive
+	Similarity    float64 `json:"similarity"`
+	Score         float64 `json:"score"`
+	SortOrder     *int    `json:"sortOrder,omitempty"`     // Chapters only
+	ChapterStatus string  `json:"chapterStatus,omitempty"` // Chapters only
+	PageType      string  `json:"pageType,omitempty"`      // Wiki pages only
+}
+
+type AskRequest struct {
+	ProjectID string
+	Question  string
+	CanonSafe bool
+	MaxChunks int
+	Filter    RetrievalFilter
+	Retrieval RetrievalOptions
+	// StyleGuide is the project's style guide, added to the system prompt
+	StyleGuide string
+	// IncludeSummaries adds the stored chapter summaries, up to
+	// Filter.MaxSortOrder, as high-level context alongside the retrieved chunks
+	IncludeSummaries bool
+	// NoCache calls the model even when an identical request was cached
+	NoCache bool
+	// History holds earlier turns of a conversation, oldest first. When set,
+	// retrieval runs on a standalone rewrite of the question and the most
+	// recent turns that fit historyTokenBudget are sent to the model.
+	History []ChatMessage
 }
 
 type AskResponse struct {
-	Answer     string     `json:"answer"`
-	Citations  []Citation `json:"citations"`
-	TokensIn   int        `json:"tokensIn"`
-	TokensOut  int        `json:"tokensOut"`
+	Answer    string     `json:"answer"`
+	Citations []Citation `json:"citations"`
+	// ReferencedCitations lists the citation numbers the answer actually cites
+	ReferencedCitations []int `json:"referencedCitations"`
+	// Filter echoes the retrieval filter the citations were drawn under
+	Filter *RetrievalFilter `json:"filter,omitempty"`
+	// StandaloneQuestion is the condensed question used for retrieval when
+	// the question was asked with history
+	StandaloneQuestion string `json:"standaloneQuestion,omitempty"`
+	// SummariesUsed counts the chapter summaries added to the prompt
+	SummariesUsed int `json:"summariesUsed,omitempty"`
+	// Cached is set when the answer was served from the response cache
+	Cached    bool   `json:"cached"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
 }
 
+// historyTokenBudget caps how much of a conversation is replayed to the model
+const historyTokenBudget = 2000
+
 type AskService struct {
-	db                *pgxpool.Pool
-	retrievalService  *RetrievalService
-	chatService       *ChatService
+	db               *pgxpool.Pool
+	retrievalService *RetrievalService
+	chatProvider     ChatProvider
 }
 
-func NewAskService(db *pgxpool.Pool, retrievalService *RetrievalService, chatService *ChatService) *AskService {
+func NewAskService(db *pgxpool.Pool, retrievalService *RetrievalService, chatProvider ChatProvider) *AskService {
 	return &AskService{
-		db:                db,
-		retrievalService:  retrievalService,
-		chatService:       chatService,
+		db:               db,
+		retrievalService: retrievalService,
+		chatProvider:     chatProvider,
 	}
 }
 
+// completionFunc performs the chat call for a prepared prompt, either in one
+// shot or streamed
+type completionFunc func(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error)
+
 // Ask answers a question using RAG
-func (s *AskService) Ask(ctx context.Context, projectID, question string, canonSafe bool, maxChunks int) (*AskResponse, error) {
-	if s.retrievalService == nil || s.chatService == nil {
+func (s *AskService) Ask(ctx context.Context, req AskRequest) (*AskResponse, error) {
+	if s.retrievalService == nil || s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
+	}
+
+	return s.ask(ctx, req, s.chatProvider.CreateChatCompletion)
+}
+
+// AskStream answers a question like Ask, calling onDelta with each fragment of
+// the answer as the model produces it. The returned response carries the full
+// answer, citations and token usage.
+func (s *AskService) AskStream(ctx context.Context, req AskRequest, onDelta func(string) error) (*AskResponse, error) {
+	if s.retrievalService == nil || s.chatProvider == nil {
 		return nil, fmt.Errorf("AI services not configured")
 	}
 
+	streamed := false
+	resp, err := s.ask(ctx, req, func(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+		return streamCompletion(ctx, s.chatProvider, messages, temperature, maxTokens, func(delta string) error {
+			streamed = true
+			return onDelta(delta)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Answers that never reached the model (nothing retrieved) arrive in one piece
+	if !streamed && resp.Answer != "" {
+		if err := onDelta(resp.Answer); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *AskService) ask(ctx context.Context, req AskRequest, complete completionFunc) (*AskResponse, error) {
+	ctx = withoutCache(ctx, req.NoCache)
+
 	// Default to 10 chunks if not specified
+	maxChunks := req.MaxChunks
 	if maxChunks == 0 {
 		maxChunks = 10
 	}
 
-	// 1. Retrieve relevant chunks
-	chunks, err := s.retrievalService.Search(ctx, projectID, question, maxChunks)
+	history := trimHistory(req.History, historyTokenBudget)
+
+	// 1. Follow-up questions ("and what did she say next?") retrieve poorly on
+	// their own, so search with a standalone version of the question
+	query := req.Question
+	var condenseUsage ChatUsage
+	if len(history) > 0 {
+		condensed, usage, err := s.condenseQuestion(ctx, history, req.Question)
+		if err != nil {
+			return nil, err
+		}
+		query = condensed
+		condenseUsage = usage
+	}
+
+	// 2. Retrieve relevant chunks
+	chunks, err := s.retrievalService.Search(ctx, req.ProjectID, query, maxChunks, req.Filter, req.Retrieval)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve chunks: %w", err)
 	}
 
-	if len(chunks) == 0 {
+	var filter *RetrievalFilter
+	if !req.Filter.IsZero() {
+		filter = &req.Filter
+	}
+
+	var summaries string
+	var summariesUsed int
+	if req.IncludeSummaries {
+		summaries, summariesUsed, err = storySoFar(ctx, s.db, req.ProjectID, req.Filter.MaxSortOrder, DefaultSummaryContextTokens)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(chunks) == 0 && summaries == "" {
 		return &AskResponse{
-			Answer:    "No relevant content found in your project to answer this question.",
-			Citations: []Citation{},
+			Answer:              "No relevant content found in your project to answer this question.",
+			Citations:           []Citation{},
+			ReferencedCitations: []int{},
+			Filter:              filter,
+			StandaloneQuestion:  standaloneQuestion(query, req.Question),
+			TokensIn:            condenseUsage.PromptTokens,
+			TokensOut:           condenseUsage.CompletionTokens,
 		}, nil
 	}
 
-	// 2. Construct prompt
-	systemPrompt := buildSystemPrompt(canonSafe)
-	userPrompt := buildUserPrompt(question, chunks)
+	// 3. Construct prompt
+	systemPrompt := withStyleGuide(buildSystemPrompt(req.CanonSafe), req.StyleGuide)
+	userPrompt := buildUserPrompt(req.Question, chunks, summaries)
 
-	messages := []ChatMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt},
-	}
+	messages := make([]ChatMessage, 0, len(history)+2)
+	messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+	messages = append(messages, history...)
+	messages = append(messages, ChatMessage{Role: "user", Content: userPrompt})
 
-	// 3. Call OpenAI API
-	chatResp, err := s.chatService.CreateChatCompletion(ctx, messages, 0.7, 1000)
+	// 4. Call the chat provider
+	chatResp, err := complete(ctx, messages, 0.7, 1000)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call OpenAI: %w", err)
+		return nil, fmt.Errorf("failed to call chat provider: %w", err)
 	}
 
-	// 4. Extract answer
+	// 5. Extract answer
 	answer := ""
 	if len(chatResp.Choices) > 0 {
 		answer = chatResp.Choices[0].Message.Content
 	}
 
-	// 5. Build citations
+	// 6. Build citations
 	citations := make([]Citation, len(chunks))
 	for i, chunk := range chunks {
 		citations[i] = Citation{
-			SourceType: chunk.SourceType,
-			SourceID:   chunk.SourceID,
-			ChunkID:    chunk.ChunkID,
-			Content:    chunk.Content,
-			Similarity: chunk.Similarity,
+			Number:        i + 1,
+			SourceType:    chunk.SourceType,
+			SourceID:      chunk.SourceID,
+			Title:         chunk.Title,
+			ChunkID:       chunk.ChunkID,
+			Content:       chunk.Content,
+			StartOffset:   chunk.StartOffset,
+			EndOffset:     chunk.EndOffset,
+			Similarity:    chunk.Similarity,
+			Score:         chunk.Score,
+			SortOrder:     chunk.SortOrder,
+			ChapterStatus: chunk.ChapterStatus,
+			PageType:      chunk.PageType,
 		}
 	}
 
 	return &AskResponse{
-		Answer:    answer,
-		Citations: citations,
-		TokensIn:  chatResp.Usage.PromptTokens,
-		TokensOut: chatResp.Usage.CompletionTokens,
+		Answer:              answer,
+		Citations:           citations,
+		ReferencedCitations: referencedSources(answer, len(citations)),
+		Filter:              filter,
+		StandaloneQuestion:  standaloneQuestion(query, req.Question),
+		SummariesUsed:       summariesUsed,
+		Cached:              chatResp.Cached,
+		Model:               s.chatProvider.Model(),
+		TokensIn:            condenseUsage.PromptTokens + chatResp.Usage.PromptTokens,
+		TokensOut:           condenseUsage.CompletionTokens + chatResp.Usage.CompletionTokens,
 	}, nil
 }
 
+// condenseQuestion rewrites a follow-up question as one that can be understood
+// without the conversation
+func (s *AskService) condenseQuestion(ctx context.Context, history []ChatMessage, question string) (string, ChatUsage, error) {
+	var conversation strings.Builder
+	for _, msg := range history {
+		label := "User"
+		if msg.Role == "assistant" {
+			label = "Assistant"
+		}
+		conversation.WriteString(label + ": " + msg.Content + "\n\n")
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: `Rewrite the follow-up question so it can be understood without the conversation. Resolve pronouns and references to earlier turns using names and details from the conversation. Reply with the rewritten question only. If the question already stands on its own, repeat it unchanged.`},
+		{Role: "user", Content: fmt.Sprintf("Conversation:\n%s\nFollow-up question: %s", conversation.String(), question)},
+	}
+
+	resp, err := s.chatProvider.CreateChatCompletion(ctx, messages, 0, 200)
+	if err != nil {
+		return "", ChatUsage{}, fmt.Errorf("failed to condense question: %w", err)
+	}
+
+	condensed := ""
+	if len(resp.Choices) > 0 {
+		condensed = strings.TrimSpace(resp.Choices[0].Message.Content)
+	}
+	if condensed == "" {
+		condensed = question
+	}
+
+	return condensed, resp.Usage, nil
+}
+
+// trimHistory keeps the most recent turns that fit within budget tokens. The
+// result always starts with a user turn so the model never sees an answer
+// without its question.
+func trimHistory(history []ChatMessage, budget int) []ChatMessage {
+	start := len(history)
+	used := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		used += estimateTokens(history[i].Content)
+		if used > budget {
+			break
+		}
+		start = i
+	}
+
+	for start < len(history) && history[start].Role != "user" {
+		start++
+	}
+
+	return history[start:]
+}
+
+// standaloneQuestion returns the condensed query when it differs from the question
+func standaloneQuestion(query, question string) string {
+	if query == question {
+		return ""
+	}
+	return query
+}
+
 func buildSystemPrompt(canonSafe bool) string {
 	prompt := `You are an AI writing assistant for NovelCraft, a novel writing application.
 
@@ -127,16 +323,17 @@ Writing Rules:
 	return prompt
 }
 
-func buildUserPrompt(question string, chunks []RetrievedChunk) string {
-	prompt := "Retrieved Context:\n---\n\n"
+func buildUserPrompt(question string, chunks []RetrievedChunk, summaries string) string {
+	prompt := ""
+	if summaries != "" {
+		prompt += "Story So Far (chapter summaries, for orientation only; do not cite them):\n---\n\n"
+		prompt += summaries + "\n\n---\n\n"
+	}
 
-	for i, chunk := range chunks {
-		sourceLabel := fmt.Sprintf("Chapter")
-		if chunk.SourceType == "wiki_page" {
-			sourceLabel = "Wiki Page"
-		}
+	prompt += "Retrieved Context:\n---\n\n"
 
-		prompt += fmt.Sprintf("[Source %d - %s ID: %s]\n", i+1, sourceLabel, chunk.SourceID)
+	for i, chunk := range chunks {
+		prompt += fmt.Sprintf("[Source %d - %s]\n", i+1, sourceLabel(chunk))
 		prompt += chunk.Content + "\n\n"
 	}
 
@@ -146,3 +343,64 @@ func buildUserPrompt(question string, chunks []RetrievedChunk) string {
 
 	return prompt
 }
+
+// sourceLabel names a chunk's source for the model, e.g. `Chapter 3: "The Storm"`
+func sourceLabel(chunk RetrievedChunk) string {
+	if chunk.SourceType == "wiki_page" {
+		if chunk.Title == "" {
+			return "Wiki Page"
+		}
+		if chunk.PageType != "" {
+			return fmt.Sprintf("Wiki Page (%s): %q", chunk.PageType, chunk.Title)
+		}
+		return fmt.Sprintf("Wiki Page: %q", chunk.Title)
+	}
+
+	label := "Chapter"
+	if chunk.SortOrder != nil {
+		label = fmt.Sprintf("Chapter %d", *chunk.SortOrder)
+	}
+	if chunk.Title != "" {
+		label += fmt.Sprintf(": %q", chunk.Title)
+	}
+	return label
+}
+
+// sourceReference matches "Source 2", "Sources 1 and 3", "sources 2-4", "Sources 1, 2, and 5"
+var sourceReference = regexp.MustCompile(`(?i)\bsources?\s+(\d+(?:(?:\s*(?:,|and|&|-|–|to)\s*)+\d+)*)`)
+
+var sourceNumber = regexp.MustCompile(`\d+|-|–|to`)
+
+// referencedSources returns the source numbers between 1 and count that the
+// answer cites, in ascending order
+func referencedSources(answer string, count int) []int {
+	seen := make(map[int]bool)
+	for _, match := range sourceReference.FindAllStringSubmatch(answer, -1) {
+		tokens := sourceNumber.FindAllString(match[1], -1)
+		for i := 0; i < len(tokens); i++ {
+			n, err := strconv.Atoi(tokens[i])
+			if err != nil {
+				continue
+			}
+			// Expand ranges like "2-4"
+			if i+2 < len(tokens) && (tokens[i+1] == "-" || tokens[i+1] == "–" || tokens[i+1] == "to") {
+				if end, err := strconv.Atoi(tokens[i+2]); err == nil && end >= n && end-n <= count {
+					for k := n; k <= end; k++ {
+						seen[k] = true
+					}
+					i += 2
+					continue
+				}
+			}
+			seen[n] = true
+		}
+	}
+
+	referenced := []int{}
+	for n := 1; n <= count; n++ {
+		if seen[n] {
+			referenced = append(referenced, n)
+		}
+	}
+	return referenced
+}
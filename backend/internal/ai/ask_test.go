@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimHistory_KeepsRecentTurnsWithinBudget(t *testing.T) {
+	long := strings.Repeat("word ", 400)
+	history := []ChatMessage{
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "Who opened the door?"},
+		{Role: "assistant", Content: "Mara did."},
+	}
+
+	trimmed := trimHistory(history, 50)
+	require.Len(t, trimmed, 2)
+	assert.Equal(t, "Who opened the door?", trimmed[0].Content)
+
+	// A lone trailing answer is dropped rather than sent without its question
+	trimmed = trimHistory(history[1:2], 10000)
+	assert.Empty(t, trimmed)
+}
+
+func TestAskService_CondensesFollowUpQuestion(t *testing.T) {
+	fake := NewFakeChatProvider()
+	fake.Reply = func(messages []ChatMessage) string {
+		return "What did Mara say after opening the lighthouse door?"
+	}
+	service := NewAskService(nil, nil, fake)
+
+	condensed, usage, err := service.condenseQuestion(context.Background(), []ChatMessage{
+		{Role: "user", Content: "Who opened the lighthouse door?"},
+		{Role: "assistant", Content: "Mara did."},
+	}, "And what did she say after that?")
+	require.NoError(t, err)
+
+	assert.Equal(t, "What did Mara say after opening the lighthouse door?", condensed)
+	assert.Greater(t, usage.TotalTokens, 0)
+	calls := fake.Calls()
+	require.Len(t, calls, 1)
+	assert.Contains(t, calls[0][1].Content, "Assistant: Mara did.")
+	assert.Contains(t, calls[0][1].Content, "Follow-up question: And what did she say after that?")
+}
+
+func TestThreadTitle(t *testing.T) {
+	assert.Equal(t, "Who opened the door?", threadTitle("  Who opened\nthe door?  "))
+
+	title := threadTitle(strings.Repeat("lighthouse ", 20))
+	assert.True(t, strings.HasSuffix(title, "…"))
+	assert.LessOrEqual(t, len([]rune(title)), threadTitleLength+1)
+	for _, word := range strings.Fields(strings.TrimSuffix(title, "…")) {
+		assert.Equal(t, "lighthouse", word)
+	}
+}
+
+func TestReferencedSources(t *testing.T) {
+	answer := "According to Source 2, Mara kept the key. Sources 4-5 and source 1 agree, but Source 9 does not exist."
+	assert.Equal(t, []int{1, 2, 4, 5}, referencedSources(answer, 6))
+
+	assert.Equal(t, []int{1, 2, 3}, referencedSources("See Sources 1, 2, and 3.", 3))
+	assert.Equal(t, []int{}, referencedSources("The lighthouse has no keeper.", 3))
+}
+
+func TestSourceLabel(t *testing.T) {
+	sortOrder := 3
+	assert.Equal(t, `Chapter 3: "The Storm"`, sourceLabel(RetrievedChunk{SourceType: "chapter", Title: "The Storm", SortOrder: &sortOrder}))
+	assert.Equal(t, `Wiki Page (character): "Mara"`, sourceLabel(RetrievedChunk{SourceType: "wiki_page", Title: "Mara", PageType: "character"}))
+}
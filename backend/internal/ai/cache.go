@@ -0,0 +1,220 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// responseCachePruneInterval is how often expired cache entries are deleted
+const responseCachePruneInterval = time.Hour
+
+type noCacheKey struct{}
+
+// withoutCache marks ctx so CachingChatProvider calls the model even when it
+// has a cached response
+func withoutCache(ctx context.Context, noCache bool) context.Context {
+	if !noCache {
+		return ctx
+	}
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func cacheDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(noCacheKey{}).(bool)
+	return disabled
+}
+
+// responseCacheStore holds cached chat responses by key
+type responseCacheStore interface {
+	get(ctx context.Context, key string) (*ChatResponse, error) // nil when missing or expired
+	put(ctx context.Context, key, model string, resp *ChatResponse, expiresAt time.Time) error
+	prune(ctx context.Context) (int64, error)
+}
+
+// CachingChatProvider answers repeated requests from a cache instead of the
+// model. Requests are identical when the model, messages, temperature and
+// max tokens all match. Responses served from the cache have Cached set and
+// zero usage, since no tokens were spent.
+type CachingChatProvider struct {
+	provider ChatProvider
+	store    responseCacheStore
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewCachingChatProvider caches provider's responses in Postgres for ttl
+func NewCachingChatProvider(provider ChatProvider, db *pgxpool.Pool, ttl time.Duration) *CachingChatProvider {
+	return &CachingChatProvider{
+		provider: provider,
+		store:    &pgResponseCache{db: db},
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// Model returns the wrapped provider's model
+func (p *CachingChatProvider) Model() string {
+	return p.provider.Model()
+}
+
+// CreateChatCompletion returns the cached response for the request, or calls
+// the wrapped provider and caches its response
+func (p *CachingChatProvider) CreateChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	key := p.key(messages, temperature, maxTokens)
+	if cached := p.lookup(ctx, key); cached != nil {
+		return cached, nil
+	}
+
+	resp, err := p.provider.CreateChatCompletion(ctx, messages, temperature, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+	p.save(ctx, key, resp)
+	return resp, nil
+}
+
+// CreateJSONChatCompletion is CreateChatCompletion in the wrapped provider's
+// JSON mode, when it has one
+func (p *CachingChatProvider) CreateJSONChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	key := p.key(messages, temperature, maxTokens) + ":json"
+	if cached := p.lookup(ctx, key); cached != nil {
+		return cached, nil
+	}
+
+	resp, err := jsonCompletion(ctx, p.provider, messages, temperature, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+	p.save(ctx, key, resp)
+	return resp, nil
+}
+
+// StreamChatCompletion delivers a cached response as a single delta, or
+// streams from the wrapped provider and caches the full response
+func (p *CachingChatProvider) StreamChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error) {
+	key := p.key(messages, temperature, maxTokens)
+	if cached := p.lookup(ctx, key); cached != nil {
+		if len(cached.Choices) > 0 {
+			if err := onDelta(cached.Choices[0].Message.Content); err != nil {
+				return nil, err
+			}
+		}
+		return cached, nil
+	}
+
+	resp, err := streamCompletion(ctx, p.provider, messages, temperature, maxTokens, onDelta)
+	if err != nil {
+		return nil, err
+	}
+	p.save(ctx, key, resp)
+	return resp, nil
+}
+
+// key hashes everything that determines the model's response
+func (p *CachingChatProvider) key(messages []ChatMessage, temperature float64, maxTokens int) string {
+	encoded, _ := json.Marshal(messages)
+	return HashContent(p.provider.Model() + "\x00" + string(encoded) + "\x00" +
+		strconv.FormatFloat(temperature, 'g', -1, 64) + "\x00" + strconv.Itoa(maxTokens))
+}
+
+func (p *CachingChatProvider) lookup(ctx context.Context, key string) *ChatResponse {
+	if cacheDisabled(ctx) {
+		return nil
+	}
+	cached, err := p.store.get(ctx, key)
+	if err != nil {
+		// A broken cache shouldn't fail the request
+		log.Printf("AI response cache lookup failed: %v", err)
+		return nil
+	}
+	if cached == nil {
+		return nil
+	}
+	cached.Cached = true
+	cached.Usage = ChatUsage{}
+	return cached
+}
+
+func (p *CachingChatProvider) save(ctx context.Context, key string, resp *ChatResponse) {
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return
+	}
+	if err := p.store.put(ctx, key, p.provider.Model(), resp, p.now().Add(p.ttl)); err != nil {
+		log.Printf("AI response cache store failed: %v", err)
+		return
+	}
+
+	p.mu.Lock()
+	due := p.now().Sub(p.lastPruned) >= responseCachePruneInterval
+	if due {
+		p.lastPruned = p.now()
+	}
+	p.mu.Unlock()
+	if due {
+		if _, err := p.store.prune(ctx); err != nil {
+			log.Printf("AI response cache prune failed: %v", err)
+		}
+	}
+}
+
+// pgResponseCache stores responses in the ai_response_cache table
+type pgResponseCache struct {
+	db *pgxpool.Pool
+}
+
+func (c *pgResponseCache) get(ctx context.Context, key string) (*ChatResponse, error) {
+	var encoded []byte
+	err := c.db.QueryRow(ctx, `
+		SELECT response FROM ai_response_cache WHERE key = $1 AND expires_at > now()
+	`, key).Scan(&encoded)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get cached response: %w", err)
+	}
+
+	var resp ChatResponse
+	if err := json.Unmarshal(encoded, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+	return &resp, nil
+}
+
+func (c *pgResponseCache) put(ctx context.Context, key, model string, resp *ChatResponse, expiresAt time.Time) error {
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	_, err = c.db.Exec(ctx, `
+		INSERT INTO ai_response_cache (key, model, response, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET response = EXCLUDED.response,
+			expires_at = EXCLUDED.expires_at, created_at = now()
+	`, key, model, encoded, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to cache response: %w", err)
+	}
+	return nil
+}
+
+func (c *pgResponseCache) prune(ctx context.Context) (int64, error) {
+	tag, err := c.db.Exec(ctx, `DELETE FROM ai_response_cache WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune response cache: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryResponseCache is an in-process responseCacheStore for tests
+type memoryResponseCache struct {
+	now     func() time.Time
+	entries map[string]memoryCacheEntry
+	pruned  int
+}
+
+type memoryCacheEntry struct {
+	encoded   []byte
+	expiresAt time.Time
+}
+
+func (c *memoryResponseCache) get(ctx context.Context, key string) (*ChatResponse, error) {
+	entry, ok := c.entries[key]
+	if !ok || !entry.expiresAt.After(c.now()) {
+		return nil, nil
+	}
+	var resp ChatResponse
+	err := json.Unmarshal(entry.encoded, &resp)
+	return &resp, err
+}
+
+func (c *memoryResponseCache) put(ctx context.Context, key, model string, resp *ChatResponse, expiresAt time.Time) error {
+	encoded, err := json.Marshal(resp)
+	c.entries[key] = memoryCacheEntry{encoded: encoded, expiresAt: expiresAt}
+	return err
+}
+
+func (c *memoryResponseCache) prune(ctx context.Context) (int64, error) {
+	c.pruned++
+	return 0, nil
+}
+
+func newTestCachingProvider(provider ChatProvider, ttl time.Duration) (*CachingChatProvider, *time.Time) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	return &CachingChatProvider{
+		provider: provider,
+		store:    &memoryResponseCache{now: clock, entries: make(map[string]memoryCacheEntry)},
+		ttl:      ttl,
+		now:      clock,
+	}, &now
+}
+
+func TestCachingChatProvider(t *testing.T) {
+	fake := NewFakeChatProvider()
+	cache, now := newTestCachingProvider(fake, time.Hour)
+	ctx := context.Background()
+	messages := []ChatMessage{{Role: "user", Content: "Tighten this."}}
+
+	first, err := cache.CreateChatCompletion(ctx, messages, 0.5, 2000)
+	require.NoError(t, err)
+	assert.False(t, first.Cached)
+	assert.NotZero(t, first.Usage.PromptTokens)
+
+	second, err := cache.CreateChatCompletion(ctx, messages, 0.5, 2000)
+	require.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Zero(t, second.Usage.PromptTokens, "cached responses spend no tokens")
+	assert.Equal(t, first.Choices[0].Message.Content, second.Choices[0].Message.Content)
+	assert.Len(t, fake.Calls(), 1)
+
+	// Any change to the request is a different key
+	_, err = cache.CreateChatCompletion(ctx, messages, 0.7, 2000)
+	require.NoError(t, err)
+	_, err = cache.CreateChatCompletion(ctx, messages, 0.5, 1000)
+	require.NoError(t, err)
+	assert.Len(t, fake.Calls(), 3)
+
+	resp, err := cache.CreateChatCompletion(withoutCache(ctx, true), messages, 0.5, 2000)
+	require.NoError(t, err)
+	assert.False(t, resp.Cached, "noCache skips the lookup")
+	assert.Len(t, fake.Calls(), 4)
+
+	*now = now.Add(2 * time.Hour)
+	resp, err = cache.CreateChatCompletion(ctx, messages, 0.5, 2000)
+	require.NoError(t, err)
+	assert.False(t, resp.Cached, "expired entries are not served")
+	assert.Len(t, fake.Calls(), 5)
+}
+
+func TestCachingChatProviderStream(t *testing.T) {
+	fake := NewFakeChatProvider()
+	cache, _ := newTestCachingProvider(fake, time.Hour)
+	messages := []ChatMessage{{Role: "user", Content: "Expand this."}}
+
+	var deltas []string
+	onDelta := func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	}
+
+	first, err := streamCompletion(context.Background(), cache, messages, 0.5, 2000, onDelta)
+	require.NoError(t, err)
+	assert.Greater(t, len(deltas), 1, "misses stream from the provider")
+
+	deltas = nil
+	second, err := streamCompletion(context.Background(), cache, messages, 0.5, 2000, onDelta)
+	require.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Equal(t, []string{first.Choices[0].Message.Content}, deltas, "hits arrive as one delta")
+	assert.Len(t, fake.Calls(), 1)
+}
+
+func TestRewriteCached(t *testing.T) {
+	fake := NewFakeChatProvider()
+	cache, _ := newTestCachingProvider(fake, time.Hour)
+	service := NewRewriteService(nil, nil, cache)
+	req := RewriteRequest{Tool: RewriteToolTighten, Text: "She walked very slowly."}
+
+	resp, err := service.Rewrite(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, resp.Cached)
+
+	resp, err = service.Rewrite(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, resp.Cached)
+	assert.Zero(t, resp.TokensIn)
+
+	req.NoCache = true
+	resp, err = service.Rewrite(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, resp.Cached)
+	assert.Len(t, fake.Calls(), 2)
+}
@@ -1,17 +1,17 @@
 package ai
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 const (
-	OpenAIChatURL = "https://api.openai.com/v1/chat/completions"
-	ChatModel     = "gpt-4o" // Could be configurable per request
+	OpenAIBaseURL = "https://api.openai.com/v1"
+	ChatModel     = "gpt-4o" // Default when no model is configured
 )
 
 type ChatMessage struct {
@@ -20,61 +20,105 @@ type ChatMessage struct {
 }
 
 type ChatRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages"`
-	Temperature float64       `json:"temperature,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ResponseFormat selects JSON mode with Type "json_object"
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type ChatChoice struct {
+	Message ChatMessage `json:"message"`
+}
+
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type ChatResponse struct {
-	ID      string `json:"id"`
-	Choices []struct {
-		Message ChatMessage `json:"message"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	ID      string       `json:"id"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   ChatUsage    `json:"usage"`
+	// Cached is set by CachingChatProvider on responses served from its cache
+	Cached bool `json:"-"`
 }
 
+// ChatService talks to OpenAI or any server exposing the OpenAI chat
+// completions API (Ollama, llama.cpp, vLLM, ...)
 type ChatService struct {
-	apiKey     string
-	httpClient *http.Client
+	apiKey  string
+	baseURL string
+	model   string
+	client  *apiClient
 }
 
-func NewChatService(apiKey string) *ChatService {
+// NewChatService creates an OpenAI-compatible chat provider. An empty baseURL
+// targets OpenAI itself and an empty model falls back to ChatModel.
+func NewChatService(apiKey, baseURL, model string) *ChatService {
+	if baseURL == "" {
+		baseURL = OpenAIBaseURL
+	}
+	if model == "" {
+		model = ChatModel
+	}
 	return &ChatService{
-		apiKey:     apiKey,
-		httpClient: &http.Client{},
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  newAPIClient("chat", DefaultClientOptions()),
 	}
 }
 
-// CreateChatCompletion sends a chat completion request to OpenAI
+// WithClientOptions replaces the default timeouts and retry policy
+func (s *ChatService) WithClientOptions(opts ClientOptions) *ChatService {
+	s.client = newAPIClient("chat", opts)
+	return s
+}
+
+// Model returns the model name sent with each request
+func (s *ChatService) Model() string {
+	return s.model
+}
+
+// CreateChatCompletion sends a chat completion request to the configured endpoint
 func (s *ChatService) CreateChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
-	reqBody := ChatRequest{
-		Model:       ChatModel,
+	return s.complete(ctx, ChatRequest{
+		Model:       s.model,
 		Messages:    messages,
 		Temperature: temperature,
 		MaxTokens:   maxTokens,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", OpenAIChatURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	})
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+// CreateJSONChatCompletion is CreateChatCompletion in JSON mode, which
+// constrains the reply to one JSON object. The messages must ask for JSON.
+func (s *ChatService) CreateJSONChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	return s.complete(ctx, ChatRequest{
+		Model:          s.model,
+		Messages:       messages,
+		Temperature:    temperature,
+		MaxTokens:      maxTokens,
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+	})
+}
 
-	resp, err := s.httpClient.Do(req)
+func (s *ChatService) complete(ctx context.Context, reqBody ChatRequest) (*ChatResponse, error) {
+	resp, err := s.send(ctx, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -83,10 +127,6 @@ func (s *ChatService) CreateChatCompletion(ctx context.Context, messages []ChatM
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(body))
-	}
-
 	var chatResp ChatResponse
 	if err := json.Unmarshal(body, &chatResp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
@@ -94,3 +134,72 @@ func (s *ChatService) CreateChatCompletion(ctx context.Context, messages []ChatM
 
 	return &chatResp, nil
 }
+
+// StreamChatCompletion requests a streamed completion and calls onDelta for
+// each content fragment as it arrives
+func (s *ChatService) StreamChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error) {
+	resp, err := s.send(ctx, ChatRequest{
+		Model:         s.model,
+		Messages:      messages,
+		Temperature:   temperature,
+		MaxTokens:     maxTokens,
+		Stream:        true,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	chatResp := &ChatResponse{}
+	err = readServerSentEvents(resp.Body, func(data string) error {
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+
+		var chunk struct {
+			ID      string `json:"id"`
+			Choices []struct {
+				Delta ChatMessage `json:"delta"`
+			} `json:"choices"`
+			Usage *ChatUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+
+		if chunk.ID != "" {
+			chatResp.ID = chunk.ID
+		}
+		if chunk.Usage != nil {
+			chatResp.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chatResp.Choices = []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: content.String()}}}
+	fillMissingUsage(chatResp, messages)
+	return chatResp, nil
+}
+
+// send posts a chat request and returns the response if it succeeded
+func (s *ChatService) send(ctx context.Context, reqBody ChatRequest) (*http.Response, error) {
+	header := http.Header{}
+	if s.apiKey != "" {
+		header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	return s.client.post(ctx, s.baseURL+"/chat/completions", header, reqBody, reqBody.Stream)
+}
@@ -0,0 +1,221 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	AnthropicBaseURL   = "https://api.anthropic.com"
+	AnthropicVersion   = "2023-06-01"
+	AnthropicChatModel = "claude-sonnet-4-5"
+
+	// The Messages API requires max_tokens on every request
+	anthropicDefaultMaxTokens = 1024
+)
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature float64            `json:"temperature"`
+	MaxTokens   int                `json:"max_tokens"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// AnthropicChatService talks to Anthropic's Messages API
+type AnthropicChatService struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *apiClient
+}
+
+// NewAnthropicChatService creates an Anthropic chat provider. An empty baseURL
+// targets api.anthropic.com and an empty model falls back to AnthropicChatModel.
+func NewAnthropicChatService(apiKey, baseURL, model string) *AnthropicChatService {
+	if baseURL == "" {
+		baseURL = AnthropicBaseURL
+	}
+	if model == "" {
+		model = AnthropicChatModel
+	}
+	return &AnthropicChatService{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  newAPIClient("Anthropic", DefaultClientOptions()),
+	}
+}
+
+// WithClientOptions replaces the default timeouts and retry policy
+func (s *AnthropicChatService) WithClientOptions(opts ClientOptions) *AnthropicChatService {
+	s.client = newAPIClient("Anthropic", opts)
+	return s
+}
+
+// Model returns the model name sent with each request
+func (s *AnthropicChatService) Model() string {
+	return s.model
+}
+
+// CreateChatCompletion sends the conversation to the Messages API and returns
+// the reply in the same shape as an OpenAI chat completion
+func (s *AnthropicChatService) CreateChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	resp, err := s.send(ctx, s.buildRequest(messages, temperature, maxTokens))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var anthropicResp anthropicResponse
+	if err := json.Unmarshal(body, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range anthropicResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return anthropicChatResponse(anthropicResp.ID, text.String(), anthropicResp.Usage.InputTokens, anthropicResp.Usage.OutputTokens), nil
+}
+
+// StreamChatCompletion streams the reply from the Messages API, calling
+// onDelta for each text fragment
+func (s *AnthropicChatService) StreamChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error) {
+	reqBody := s.buildRequest(messages, temperature, maxTokens)
+	reqBody.Stream = true
+
+	resp, err := s.send(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var id string
+	var text strings.Builder
+	var inputTokens, outputTokens int
+	err = readServerSentEvents(resp.Body, func(data string) error {
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				ID    string `json:"id"`
+				Usage struct {
+					InputTokens  int `json:"input_tokens"`
+					OutputTokens int `json:"output_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			id = event.Message.ID
+			inputTokens = event.Message.Usage.InputTokens
+			outputTokens = event.Message.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				text.WriteString(event.Delta.Text)
+				return onDelta(event.Delta.Text)
+			}
+		case "message_delta":
+			outputTokens = event.Usage.OutputTokens
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return fmt.Errorf("Anthropic stream error: %s", event.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return anthropicChatResponse(id, text.String(), inputTokens, outputTokens), nil
+}
+
+func (s *AnthropicChatService) buildRequest(messages []ChatMessage, temperature float64, maxTokens int) anthropicRequest {
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	reqBody := anthropicRequest{
+		Model:       s.model,
+		Temperature: temperature,
+		MaxTokens:   maxTokens,
+	}
+
+	// Anthropic takes the system prompt as a top-level field rather than a message
+	var system []string
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		reqBody.Messages = append(reqBody.Messages, anthropicMessage{Role: msg.Role, Content: msg.Content})
+	}
+	reqBody.System = strings.Join(system, "\n\n")
+
+	return reqBody
+}
+
+// send posts a Messages API request and returns the response if it succeeded
+func (s *AnthropicChatService) send(ctx context.Context, reqBody anthropicRequest) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("x-api-key", s.apiKey)
+	header.Set("anthropic-version", AnthropicVersion)
+	return s.client.post(ctx, s.baseURL+"/v1/messages", header, reqBody, reqBody.Stream)
+}
+
+func anthropicChatResponse(id, text string, inputTokens, outputTokens int) *ChatResponse {
+	return &ChatResponse{
+		ID:      id,
+		Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: text}}},
+		Usage: ChatUsage{
+			PromptTokens:     inputTokens,
+			CompletionTokens: outputTokens,
+			TotalTokens:      inputTokens + outputTokens,
+		},
+	}
+}
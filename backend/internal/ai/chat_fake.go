@@ -0,0 +1,102 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// FakeChatModel is the model name reported by FakeChatProvider
+const FakeChatModel = "fake-chat"
+
+// FakeChatProvider is a deterministic in-process ChatProvider. It never touches
+// the network, which lets the AI stack run offline in CI and in tests.
+type FakeChatProvider struct {
+	// Reply produces the assistant message for a conversation. When nil the
+	// provider answers with a fixed string derived from the prompt hash.
+	Reply func(messages []ChatMessage) string
+
+	mu    sync.Mutex
+	calls [][]ChatMessage
+}
+
+func NewFakeChatProvider() *FakeChatProvider {
+	return &FakeChatProvider{}
+}
+
+// Model returns FakeChatModel
+func (p *FakeChatProvider) Model() string {
+	return FakeChatModel
+}
+
+// CreateChatCompletion records the conversation and returns a canned reply
+func (p *FakeChatProvider) CreateChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.calls = append(p.calls, append([]ChatMessage(nil), messages...))
+	p.mu.Unlock()
+
+	var reply string
+	if p.Reply != nil {
+		reply = p.Reply(messages)
+	} else {
+		reply = defaultFakeReply(messages)
+	}
+
+	promptTokens := 0
+	for _, msg := range messages {
+		promptTokens += estimateTokens(msg.Content)
+	}
+	completionTokens := estimateTokens(reply)
+
+	resp := &ChatResponse{
+		ID:      "fake-" + HashContent(reply)[:12],
+		Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: reply}}},
+	}
+	resp.Usage.PromptTokens = promptTokens
+	resp.Usage.CompletionTokens = completionTokens
+	resp.Usage.TotalTokens = promptTokens + completionTokens
+
+	return resp, nil
+}
+
+// StreamChatCompletion returns the same reply as CreateChatCompletion,
+// delivered one word at a time
+func (p *FakeChatProvider) StreamChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error) {
+	resp, err := p.CreateChatCompletion(ctx, messages, temperature, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.SplitAfter(resp.Choices[0].Message.Content, " ")
+	for _, word := range words {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if word == "" {
+			continue
+		}
+		if err := onDelta(word); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// Calls returns every conversation sent to the provider, oldest first
+func (p *FakeChatProvider) Calls() [][]ChatMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]ChatMessage(nil), p.calls...)
+}
+
+func defaultFakeReply(messages []ChatMessage) string {
+	var prompt string
+	for _, msg := range messages {
+		prompt += msg.Role + ":" + msg.Content + "\n"
+	}
+	return "Fake response " + HashContent(prompt)[:12] + " (see Source 1)."
+}
@@ -4,139 +4,348 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"github.com/imphyy/NovelCraft/backend/internal/tokenizer"
+)
+
+const (
+	// DefaultChunkTargetTokens is the chunk size chunks are cut towards
+	DefaultChunkTargetTokens = 256
+	// DefaultChunkOverlapTokens is how much of the end of one chunk is
+	// repeated at the start of the next, within a scene
+	DefaultChunkOverlapTokens = 48
 )
 
+// Kinds of boundary a chunk can end on, best last
 const (
-	// Target chunk size in characters (roughly 150-300 tokens)
-	ChunkSize       = 1000
-	ChunkOverlap    = 200
-	MinChunkSize    = 100
+	boundaryNone = iota
+	boundarySentence
+	boundaryLine
+	boundaryParagraph
 )
 
 type Chunk struct {
 	Index   int
 	Content string
 	Tokens  int
+	// StartOffset and EndOffset locate Content in the original text, in
+	// characters (runes), end exclusive
+	StartOffset int
+	EndOffset   int
+}
+
+// ChunkOptions sizes chunks in tokens
+type ChunkOptions struct {
+	TargetTokens  int
+	OverlapTokens int
+}
+
+func DefaultChunkOptions() ChunkOptions {
+	return ChunkOptions{
+		TargetTokens:  DefaultChunkTargetTokens,
+		OverlapTokens: DefaultChunkOverlapTokens,
+	}
+}
+
+// withDefaults fills in unset sizes and keeps the overlap under a quarter of
+// the target, so every chunk moves the text forward
+func (o ChunkOptions) withDefaults() ChunkOptions {
+	if o.TargetTokens <= 0 {
+		o.TargetTokens = DefaultChunkTargetTokens
+	}
+	if o.OverlapTokens < 0 {
+		o.OverlapTokens = 0
+	}
+	if o.OverlapTokens > o.TargetTokens/4 {
+		o.OverlapTokens = o.TargetTokens / 4
+	}
+	return o
 }
 
-// ChunkText splits text into overlapping chunks
+// ChunkText splits text into overlapping chunks of the default size
 func ChunkText(text string) []Chunk {
+	return ChunkTextWithOptions(text, DefaultChunkOptions())
+}
+
+// ChunkTextWithOptions splits text into scenes at scene breaks ("***", "#",
+// runs of blank lines), then each scene into chunks of about TargetTokens,
+// ending on paragraph, line or sentence boundaries where it can. Chunks
+// overlap within a scene but never across a scene break. It runs in time
+// linear in the length of text.
+func ChunkTextWithOptions(text string, opts ChunkOptions) []Chunk {
 	println("DEBUG: [ChunkText] Starting - text length:", len(text), "bytes")
+	opts = opts.withDefaults()
+	tok := tokenizer.Default()
 
-	if text == "" {
-		println("DEBUG: [ChunkText] Empty text, returning empty chunks")
-		return []Chunk{}
-	}
+	// Chunk on LF line endings; the offset cursors map back to the original
+	normalized := strings.ReplaceAll(text, "\r\n", "\n")
+	starts := newOffsetCursor(text)
+	ends := newOffsetCursor(text)
 
-	// Normalize whitespace
-	println("DEBUG: [ChunkText] Normalizing whitespace...")
-	text = strings.TrimSpace(text)
-	text = strings.ReplaceAll(text, "\r\n", "\n")
+	chunks := []Chunk{}
+	for _, scene := range splitScenes(normalized) {
+		spans := sceneSpans(tok, normalized, scene, opts.TargetTokens)
+		for _, r := range chunkSpans(normalized, spans, opts) {
+			start, end := r[0], r[1]
+			content := normalized[start:end]
+			chunks = append(chunks, Chunk{
+				Index:       len(chunks),
+				Content:     content,
+				Tokens:      tok.Count(content),
+				StartOffset: starts.runeOffset(start),
+				EndOffset:   ends.runeOffset(end),
+			})
+		}
+	}
 
-	var chunks []Chunk
-	textLen := utf8.RuneCountInString(text)
-	println("DEBUG: [ChunkText] Text rune count:", textLen)
+	println("DEBUG: [ChunkText] Completed - total chunks:", len(chunks))
+	return chunks
+}
 
-	if textLen <= ChunkSize {
-		// Text is small enough to be one chunk
-		println("DEBUG: [ChunkText] Text fits in one chunk")
-		return []Chunk{
-			{
-				Index:   0,
-				Content: text,
-				Tokens:  estimateTokens(text),
-			},
+// splitScenes returns the byte ranges of the scenes in text, without the
+// scene break lines themselves
+func splitScenes(text string) [][2]int {
+	var scenes [][2]int
+	sceneStart := 0
+	blankRun := 0
+	closeScene := func(end, next int) {
+		if strings.TrimSpace(text[sceneStart:end]) != "" {
+			scenes = append(scenes, [2]int{sceneStart, end})
 		}
+		sceneStart = next
 	}
 
-	println("DEBUG: [ChunkText] Splitting into multiple chunks...")
-	start := 0
-	index := 0
+	for lineStart := 0; lineStart < len(text); {
+		lineEnd := strings.IndexByte(text[lineStart:], '\n')
+		next := len(text)
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += lineStart
+			next = lineEnd + 1
+		}
+		line := strings.TrimSpace(text[lineStart:lineEnd])
 
-	for start < textLen {
-		println("DEBUG: [ChunkText] Processing chunk", index, "- start position:", start)
-		end := start + ChunkSize
-		if end > textLen {
-			end = textLen
+		switch {
+		case line == "":
+			blankRun++
+			// Two or more blank lines in a row separate scenes
+			if blankRun == 2 {
+				closeScene(lineStart, next)
+			} else if blankRun > 2 {
+				sceneStart = next
+			}
+		case isSceneBreak(line):
+			blankRun = 0
+			closeScene(lineStart, next)
+		case isHeading(line):
+			// Headings start a scene and stay part of it
+			blankRun = 0
+			closeScene(lineStart, lineStart)
+		default:
+			blankRun = 0
 		}
+		lineStart = next
+	}
+	closeScene(len(text), len(text))
 
-		// Try to break at sentence or paragraph boundary
-		println("DEBUG: [ChunkText] Getting substring from", start, "to", end)
-		chunkText := getSubstring(text, start, end)
+	return scenes
+}
 
-		// If not at the end, try to break at a good point
-		if end < textLen {
-			println("DEBUG: [ChunkText] Breaking at boundary...")
-			chunkText = breakAtBoundary(chunkText)
+// isSceneBreak reports whether a trimmed line is a scene break marker such as
+// "***", "* * *", "#" or "~~~"
+func isSceneBreak(line string) bool {
+	for _, r := range line {
+		if !strings.ContainsRune("*#~=-", r) && r != ' ' {
+			return false
 		}
+	}
+	return line != "-" && line != "--"
+}
 
-		// Skip if chunk is too small (unless it's the last one)
-		chunkLen := utf8.RuneCountInString(chunkText)
-		if chunkLen >= MinChunkSize || end >= textLen {
-			println("DEBUG: [ChunkText] Adding chunk", index, "with", chunkLen, "runes")
-			chunks = append(chunks, Chunk{
-				Index:   index,
-				Content: strings.TrimSpace(chunkText),
-				Tokens:  estimateTokens(chunkText),
-			})
-			index++
-		} else {
-			println("DEBUG: [ChunkText] Skipping chunk (too small):", chunkLen, "runes")
+// isHeading reports whether a trimmed line is a Markdown heading
+func isHeading(line string) bool {
+	hashes := 0
+	for hashes < len(line) && line[hashes] == '#' {
+		hashes++
+	}
+	return hashes > 0 && hashes <= 6 && hashes < len(line) && line[hashes] == ' '
+}
+
+// sceneSpans pretokenizes a scene, with offsets into the whole text.
+// Single pieces bigger than a quarter of the target, such as long runs of
+// unspaced CJK text, are split at rune boundaries so they can't force
+// oversized chunks.
+func sceneSpans(tok *tokenizer.Tokenizer, text string, scene [2]int, target int) []tokenizer.Span {
+	spans := tok.Spans(text[scene[0]:scene[1]])
+	maxTokens := max(1, target/4)
+
+	out := spans[:0:0]
+	for _, span := range spans {
+		span.Start += scene[0]
+		span.End += scene[0]
+		if span.Tokens <= maxTokens {
+			out = append(out, span)
+			continue
 		}
 
-		// Move start forward, accounting for overlap
-		actualChunkLen := utf8.RuneCountInString(chunkText)
-		advance := actualChunkLen - ChunkOverlap
-		if advance < 1 {
-			advance = 1 // Ensure we always move forward
+		parts := (span.Tokens + maxTokens - 1) / maxTokens
+		step := (span.End - span.Start + parts - 1) / parts
+		for start := span.Start; start < span.End; {
+			end := min(start+step, span.End)
+			for end < span.End && !utf8.RuneStart(text[end]) {
+				end++
+			}
+			out = append(out, tokenizer.Span{Start: start, End: end, Tokens: tok.Count(text[start:end])})
+			start = end
 		}
-		start += advance
-		println("DEBUG: [ChunkText] Moving start forward to:", start)
 	}
+	return out
+}
 
-	println("DEBUG: [ChunkText] Completed - total chunks:", len(chunks))
-	return chunks
+// chunkSpans groups a scene's spans into chunks, returning the trimmed byte
+// range of each
+func chunkSpans(text string, spans []tokenizer.Span, opts ChunkOptions) [][2]int {
+	// remaining[i] is the number of tokens in spans[i:]
+	remaining := make([]int, len(spans)+1)
+	for i := len(spans) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + spans[i].Tokens
+	}
+	// A tail smaller than this is folded into the chunk before it
+	minTail := opts.TargetTokens / 8
+
+	var ranges [][2]int
+	for i := 0; i < len(spans); {
+		cut := len(spans)
+		if remaining[i] > opts.TargetTokens+minTail {
+			cut = chooseCut(text, spans, remaining, i, opts.TargetTokens, minTail)
+		}
+
+		if start, end := trimRange(text, spans[i].Start, spans[cut-1].End); start < end {
+			ranges = append(ranges, [2]int{start, end})
+		}
+		if cut == len(spans) {
+			break
+		}
+
+		// Back off into the chunk just made for the overlap
+		next, overlap := cut, 0
+		for next > i+1 && overlap+spans[next-1].Tokens <= opts.OverlapTokens {
+			overlap += spans[next-1].Tokens
+			next--
+		}
+		i = next
+	}
+	return ranges
 }
 
-// getSubstring safely gets a substring by rune count
-func getSubstring(text string, start, end int) string {
-	runes := []rune(text)
-	if start >= len(runes) {
-		return ""
+// chooseCut picks where the chunk starting at span i ends: the best boundary
+// between half the target and the target, the latest of equally good ones,
+// or the target itself when there's no boundary
+func chooseCut(text string, spans []tokenizer.Span, remaining []int, i, target, minTail int) int {
+	tokens := 0
+	bestCut, bestScore := -1, boundaryNone
+	j := i
+	for j < len(spans) && (j == i || tokens+spans[j].Tokens <= target) {
+		tokens += spans[j].Tokens
+		j++
+		if tokens < target/2 || remaining[j] < minTail {
+			continue
+		}
+		if score := boundaryAfter(text, spans, j-1); score != boundaryNone && score >= bestScore {
+			bestCut, bestScore = j, score
+		}
 	}
-	if end > len(runes) {
-		end = len(runes)
+	if bestCut > 0 {
+		return bestCut
 	}
-	return string(runes[start:end])
+	return j
 }
 
-// breakAtBoundary attempts to break the chunk at a sentence or paragraph
-func breakAtBoundary(text string) string {
-	// Try to break at paragraph
-	if idx := strings.LastIndex(text, "\n\n"); idx > len(text)/2 {
-		return text[:idx]
+// boundaryAfter scores the boundary between spans[k] and the span after it
+func boundaryAfter(text string, spans []tokenizer.Span, k int) int {
+	piece := text[spans[k].Start:spans[k].End]
+	switch strings.Count(piece, "\n") {
+	case 0:
+	case 1:
+		return boundaryLine
+	default:
+		return boundaryParagraph
 	}
 
-	// Try to break at sentence
-	for _, delim := range []string{". ", "! ", "? ", ".\n", "!\n", "?\n"} {
-		if idx := strings.LastIndex(text, delim); idx > len(text)/2 {
-			return text[:idx+1]
+	// A sentence ends at terminal punctuation, possibly inside closing
+	// quotes or brackets, followed by whitespace
+	if k+1 < len(spans) {
+		next, _ := utf8.DecodeRuneInString(text[spans[k+1].Start:])
+		if !unicode.IsSpace(next) {
+			return boundaryNone
 		}
 	}
+	piece = strings.TrimRight(piece, "\"'”’)]")
+	last, _ := utf8.DecodeLastRuneInString(piece)
+	if strings.ContainsRune(".!?…", last) {
+		return boundarySentence
+	}
+	return boundaryNone
+}
 
-	// Try to break at newline
-	if idx := strings.LastIndex(text, "\n"); idx > len(text)/2 {
-		return text[:idx]
+// trimRange narrows text[start:end] to exclude surrounding whitespace
+func trimRange(text string, start, end int) (int, int) {
+	for start < end {
+		r, size := utf8.DecodeRuneInString(text[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += size
+	}
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= size
 	}
+	return start, end
+}
+
+// offsetCursor converts byte offsets in the CRLF-normalized text to rune
+// offsets in the original. Asking for offsets in non-decreasing order keeps
+// the conversion linear over a whole document.
+type offsetCursor struct {
+	original   string
+	pos        int // Byte offset in the original text
+	normalized int // Byte offset in the normalized text
+	runes      int // Rune offset in the original text
+}
+
+func newOffsetCursor(original string) *offsetCursor {
+	return &offsetCursor{original: original}
+}
 
-	// No good break point, return as is
-	return text
+func (c *offsetCursor) runeOffset(normalized int) int {
+	if normalized < c.normalized {
+		*c = offsetCursor{original: c.original}
+	}
+	for c.normalized < normalized && c.pos < len(c.original) {
+		if c.original[c.pos] == '\r' && c.pos+1 < len(c.original) && c.original[c.pos+1] == '\n' {
+			// Dropped by normalization
+			c.pos++
+			c.runes++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(c.original[c.pos:])
+		c.pos += size
+		c.normalized += size
+		c.runes++
+	}
+	return c.runes
 }
 
-// estimateTokens roughly estimates token count (1 token ≈ 4 characters)
+// estimateTokens counts the tokens in text with the shared tokenizer
 func estimateTokens(text string) int {
-	return len(text) / 4
+	return tokenizer.Default().Count(text)
 }
 
 // HashContent creates a SHA256 hash of content for change detection
@@ -1,10 +1,12 @@
 package ai
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestChunkText_SmallText(t *testing.T) {
@@ -19,7 +21,7 @@ func TestChunkText_SmallText(t *testing.T) {
 }
 
 func TestChunkText_LargeText(t *testing.T) {
-	// Create text larger than ChunkSize
+	// Create text larger than the default chunk target
 	paragraph := strings.Repeat("This is a test sentence. ", 100)
 	text := strings.Repeat(paragraph+"\n\n", 3)
 
@@ -63,3 +65,164 @@ func TestHashContent(t *testing.T) {
 	// Hash should be hex string
 	assert.Len(t, hash1, 64) // SHA256 = 32 bytes = 64 hex chars
 }
+
+func TestChunkText_Offsets(t *testing.T) {
+	text := "\r\n  Première ligne.\r\nSecond line.  "
+
+	chunks := ChunkText(text)
+
+	assert.Len(t, chunks, 1)
+	runes := []rune(text)
+	assert.Equal(t, "Première ligne.\r\nSecond line.", string(runes[chunks[0].StartOffset:chunks[0].EndOffset]))
+
+	paragraph := strings.Repeat("The tide came in over the causeway. ", 40)
+	text = paragraph + "\n\n" + paragraph
+	runes = []rune(text)
+	for _, chunk := range ChunkText(text) {
+		if chunk.Content == "" {
+			continue
+		}
+		assert.Equal(t, chunk.Content, string(runes[chunk.StartOffset:chunk.EndOffset]))
+	}
+}
+
+func TestChunkText_TokenTarget(t *testing.T) {
+	paragraph := strings.Repeat("The keeper trimmed the wick and watched the sea. ", 12)
+	text := strings.Repeat(paragraph+"\n\n", 20)
+	opts := ChunkOptions{TargetTokens: 120, OverlapTokens: 20}
+
+	chunks := ChunkTextWithOptions(text, opts)
+
+	assert.Greater(t, len(chunks), 5)
+	for _, chunk := range chunks[:len(chunks)-1] {
+		assert.LessOrEqual(t, chunk.Tokens, opts.TargetTokens)
+		assert.GreaterOrEqual(t, chunk.Tokens, opts.TargetTokens/2)
+		// Every chunk ends on a sentence
+		assert.True(t, strings.HasSuffix(chunk.Content, "."), chunk.Content)
+	}
+
+	// Consecutive chunks overlap
+	for i := 1; i < len(chunks); i++ {
+		assert.Less(t, chunks[i].StartOffset, chunks[i-1].EndOffset)
+	}
+}
+
+func TestChunkText_PrefersParagraphBreaks(t *testing.T) {
+	paragraph := strings.Repeat("Rain hammered the shutters. ", 15)
+	text := paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+
+	chunks := ChunkTextWithOptions(text, ChunkOptions{TargetTokens: 150})
+
+	// The paragraph break wins over the sentence breaks after it
+	require.Greater(t, len(chunks), 1)
+	assert.Equal(t, strings.TrimSpace(paragraph), chunks[0].Content)
+}
+
+func TestChunkText_SceneBreaks(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+	}{
+		{"asterisks", "\n\n***\n\n"},
+		{"spaced asterisks", "\n\n* * *\n\n"},
+		{"hash", "\n\n#\n\n"},
+		{"blank lines", "\n\n\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := "Mara left the harbour at dawn." + tt.separator + "Three days later, the storm came."
+
+			chunks := ChunkText(text)
+
+			require.Len(t, chunks, 2)
+			assert.Equal(t, "Mara left the harbour at dawn.", chunks[0].Content)
+			assert.Equal(t, "Three days later, the storm came.", chunks[1].Content)
+			assert.Equal(t, 1, chunks[1].Index)
+		})
+	}
+
+	// A single blank line is just a paragraph break
+	chunks := ChunkText("First paragraph.\n\nSecond paragraph.")
+	assert.Len(t, chunks, 1)
+
+	// Headings start a new scene and stay with it
+	chunks = ChunkText("Prologue text.\n\n## Chapter One\n\nIt began.")
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "## Chapter One\n\nIt began.", chunks[1].Content)
+}
+
+func TestChunkText_LongUnspacedText(t *testing.T) {
+	// No spaces means one pretokenized piece, which must still be split
+	text := strings.Repeat("灯台守は階段を上った", 300)
+
+	chunks := ChunkTextWithOptions(text, ChunkOptions{TargetTokens: 100})
+
+	assert.Greater(t, len(chunks), 1)
+	runes := []rune(text)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, chunk.Tokens, 100+100/8)
+		assert.Equal(t, chunk.Content, string(runes[chunk.StartOffset:chunk.EndOffset]))
+	}
+}
+
+func TestChunkOptions_WithDefaults(t *testing.T) {
+	assert.Equal(t, DefaultChunkOptions(), ChunkOptions{OverlapTokens: DefaultChunkOverlapTokens}.withDefaults())
+	assert.Equal(t, ChunkOptions{TargetTokens: 100, OverlapTokens: 25}, ChunkOptions{TargetTokens: 100, OverlapTokens: 90}.withDefaults())
+	assert.Equal(t, 0, ChunkOptions{TargetTokens: 100, OverlapTokens: -1}.withDefaults().OverlapTokens)
+}
+
+// manuscript generates a novel-length text of about words words, with
+// paragraphs, dialogue and a scene break every few thousand words
+func manuscript(words int) string {
+	sentences := []string{
+		"The lighthouse keeper climbed the stairs, counting each one aloud.",
+		`"You're late," said Mara, not looking up from the charts.`,
+		"Outside, the storm pressed its shoulder against the glass.",
+		"He set the lamp down and waited for the beam to come round again.",
+		"Nobody in the village had seen the ship since the winter of 1887.",
+	}
+
+	var b strings.Builder
+	count := 0
+	for i := 0; count < words; i++ {
+		sentence := sentences[i%len(sentences)]
+		b.WriteString(sentence)
+		count += len(strings.Fields(sentence))
+		switch {
+		case i%400 == 399:
+			fmt.Fprintf(&b, "\n\n***\n\n")
+		case i%6 == 5:
+			b.WriteString("\n\n")
+		default:
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+func TestChunkText_Manuscript(t *testing.T) {
+	text := manuscript(20_000)
+
+	chunks := ChunkText(text)
+
+	runes := []rune(text)
+	for i, chunk := range chunks {
+		assert.Equal(t, i, chunk.Index)
+		assert.LessOrEqual(t, chunk.Tokens, DefaultChunkTargetTokens+DefaultChunkTargetTokens/8)
+		assert.Equal(t, chunk.Content, string(runes[chunk.StartOffset:chunk.EndOffset]))
+		assert.NotContains(t, chunk.Content, "***")
+	}
+}
+
+func BenchmarkChunkText(b *testing.B) {
+	for _, words := range []int{10_000, 100_000} {
+		text := manuscript(words)
+		b.Run(fmt.Sprintf("%dk_words", words/1000), func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			for i := 0; i < b.N; i++ {
+				ChunkText(text)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultContinuityContextTokens is the budget for wiki pages and earlier
+	// passages when a check doesn't give one
+	DefaultContinuityContextTokens = 4000
+	// MaxContinuityChapterTokens bounds how much of the chapter is checked;
+	// the rest of a longer chapter is left out and the response says so
+	MaxContinuityChapterTokens = 8000
+
+	// Share of the context budget for wiki pages linked from the chapter;
+	// passages retrieved from earlier chapters get the rest
+	continuityWikiBudgetShare = 0.5
+	// Passages of the chapter used as retrieval queries, and hits kept per query
+	continuityProbes       = 8
+	continuityHitsPerProbe = 3
+
+	continuityMaxTokens = 2500
+)
+
+// Severities of a suspected continuity error
+const (
+	ContinuitySeverityLow    = "low"    // Probably deliberate or easily explained
+	ContinuitySeverityMedium = "medium" // A reader may notice
+	ContinuitySeverityHigh   = "high"   // Contradicts established canon outright
+)
+
+var continuitySeverityRank = map[string]int{
+	ContinuitySeverityHigh:   0,
+	ContinuitySeverityMedium: 1,
+	ContinuitySeverityLow:    2,
+}
+
+type ContinuityRequest struct {
+	ChapterID string
+	UserID    string
+	// ContextTokens is the budget for reference material
+	// (DefaultContinuityContextTokens when 0)
+	ContextTokens int
+}
+
+// ContinuityCitation points at the source a passage contradicts
+type ContinuityCitation struct {
+	SourceType string `json:"sourceType"` // "chapter" or "wiki_page"
+	SourceID   string `json:"sourceId"`
+	Title      string `json:"title"`
+	ChunkID    string `json:"chunkId,omitempty"`
+	// Quote is the conflicting text in the source
+	Quote string `json:"quote"`
+}
+
+// ContinuityIssue is one suspected contradiction between the chapter and
+// what the wiki or earlier chapters have established
+type ContinuityIssue struct {
+	// Quote is the passage of the chapter in question
+	Quote string `json:"quote"`
+	// Where Quote starts in the chapter, in characters. Nil when the model
+	// paraphrased instead of quoting exactly.
+	StartOffset  *int               `json:"startOffset,omitempty"`
+	Conflict     ContinuityCitation `json:"conflict"`
+	Severity     string             `json:"severity"`
+	Explanation  string             `json:"explanation"`
+	SuggestedFix string             `json:"suggestedFix"`
+}
+
+type ContinuityResponse struct {
+	ChapterID string            `json:"chapterId"`
+	Issues    []ContinuityIssue `json:"issues"`
+	// Sources lists the reference material the chapter was checked against
+	Sources []RewriteContextSource `json:"sources"`
+	// Truncated is set when the chapter was too long to check in full
+	Truncated bool   `json:"truncated"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
+}
+
+// ContinuityService checks chapters for contradictions with the wiki and
+// with earlier chapters
+type ContinuityService struct {
+	db               *pgxpool.Pool
+	retrievalService *RetrievalService
+	chatProvider     ChatProvider
+}
+
+func NewContinuityService(db *pgxpool.Pool, retrievalService *RetrievalService, chatProvider ChatProvider) *ContinuityService {
+	return &ContinuityService{
+		db:               db,
+		retrievalService: retrievalService,
+		chatProvider:     chatProvider,
+	}
+}
+
+// citedSource is one piece of reference material, labelled so the model
+// can cite it
+type citedSource struct {
+	label   string // "S1", "S2", ...
+	heading string
+	content string
+	source  RewriteContextSource
+}
+
+// Check compares a chapter the user owns against its linked wiki pages and
+// related passages from earlier chapters
+func (s *ContinuityService) Check(ctx context.Context, req ContinuityRequest) (*ContinuityResponse, error) {
+	if s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
+	}
+
+	var projectID, title, content string
+	var sortOrder int
+	err := s.db.QueryRow(ctx, `
+		SELECT c.project_id, c.title, c.sort_order, c.content
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+	`, req.ChapterID, req.UserID).Scan(&projectID, &title, &sortOrder, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+
+	resp := &ContinuityResponse{
+		ChapterID: req.ChapterID,
+		Issues:    []ContinuityIssue{},
+		Sources:   []RewriteContextSource{},
+		Model:     s.chatProvider.Model(),
+	}
+
+	text := truncateToTokens(content, MaxContinuityChapterTokens)
+	resp.Truncated = text != strings.TrimSpace(content)
+	if text == "" {
+		return resp, nil
+	}
+
+	budget := req.ContextTokens
+	if budget <= 0 {
+		budget = DefaultContinuityContextTokens
+	}
+	sources, err := s.gatherSources(ctx, projectID, req.ChapterID, sortOrder, text, budget)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		resp.Sources = append(resp.Sources, source.source)
+	}
+	if len(sources) == 0 {
+		// Nothing established yet to contradict
+		return resp, nil
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: continuitySystemPrompt},
+		{Role: "user", Content: buildContinuityUserPrompt(title, text, sources)},
+	}
+	chatResp, err := s.chatProvider.CreateChatCompletion(ctx, messages, 0.2, continuityMaxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call chat provider: %w", err)
+	}
+	resp.TokensIn = chatResp.Usage.PromptTokens
+	resp.TokensOut = chatResp.Usage.CompletionTokens
+
+	reply := ""
+	if len(chatResp.Choices) > 0 {
+		reply = chatResp.Choices[0].Message.Content
+	}
+	issues, err := parseContinuityIssues(reply, sources, content)
+	if err != nil {
+		return resp, err
+	}
+	resp.Issues = issues
+
+	return resp, nil
+}
+
+// gatherSources collects the wiki pages the chapter links to, then passages
+// from earlier chapters and other wiki pages that are similar to parts of the
+// chapter, until budget tokens are used
+func (s *ContinuityService) gatherSources(ctx context.Context, projectID, chapterID string, sortOrder int, text string, budget int) ([]citedSource, error) {
+	var sources []citedSource
+	add := func(heading, content string, source RewriteContextSource) {
+		source.Tokens = estimateTokens(content)
+		sources = append(sources, citedSource{
+			label:   fmt.Sprintf("S%d", len(sources)+1),
+			heading: heading,
+			content: content,
+			source:  source,
+		})
+		budget -= source.Tokens
+	}
+
+	// 1. Linked wiki pages, those named most prominently first
+	pages, err := linkedWikiPages(ctx, s.db, chapterID, text)
+	if err != nil {
+		return nil, err
+	}
+	wikiBudget := int(float64(budget) * continuityWikiBudgetShare)
+	included := make(map[string]bool)
+	for _, page := range pages {
+		if wikiBudget <= 0 {
+			break
+		}
+		content := truncateToTokens(page.content, wikiBudget)
+		if content == "" {
+			continue
+		}
+		add(fmt.Sprintf("Wiki Page (%s): %q", page.pageType, page.title), content, RewriteContextSource{
+			Kind:       RewriteContextWikiPage,
+			SourceType: "wiki_page",
+			SourceID:   page.id,
+			Title:      page.title,
+		})
+		included[page.id] = true
+		wikiBudget -= estimateTokens(content)
+	}
+
+	// 2. Passages similar to parts of the chapter, from chapters before it.
+	// Retrieval is best effort, like it is for rewrites.
+	if budget <= 0 || s.retrievalService == nil {
+		return sources, nil
+	}
+	opts := RetrievalOptions{Mode: RetrievalModeHybrid}
+	if s.retrievalService.embedder == nil {
+		opts.Mode = RetrievalModeKeyword
+	}
+	earlier := sortOrder - 1
+	filter := RetrievalFilter{MaxSortOrder: &earlier}
+
+	seen := make(map[string]bool)
+	var hits []RetrievedChunk
+	for _, probe := range continuityProbeTexts(text, continuityProbes) {
+		results, err := s.retrievalService.Search(ctx, projectID, probe, continuityHitsPerProbe, filter, opts)
+		if err != nil {
+			log.Printf("continuity check: retrieval failed for chapter %s: %v", chapterID, err)
+			break
+		}
+		for _, hit := range results {
+			if seen[hit.ChunkID] || hit.SourceID == chapterID || included[hit.SourceID] {
+				continue
+			}
+			seen[hit.ChunkID] = true
+			hits = append(hits, hit)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	for _, hit := range hits {
+		if budget <= 0 {
+			break
+		}
+		content := truncateToTokens(hit.Content, budget)
+		if content == "" {
+			continue
+		}
+		add(sourceLabel(hit), content, RewriteContextSource{
+			Kind:       RewriteContextRetrieval,
+			SourceType: hit.SourceType,
+			SourceID:   hit.SourceID,
+			Title:      hit.Title,
+			ChunkID:    hit.ChunkID,
+		})
+	}
+
+	return sources, nil
+}
+
+// continuityProbeTexts picks up to n chunks spread evenly through text to
+// use as retrieval queries
+func continuityProbeTexts(text string, n int) []string {
+	chunks := ChunkText(text)
+	if len(chunks) <= n {
+		probes := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			probes[i] = chunk.Content
+		}
+		return probes
+	}
+
+	probes := make([]string, n)
+	for i := range probes {
+		probes[i] = chunks[i*len(chunks)/n].Content
+	}
+	return probes
+}
+
+const continuitySystemPrompt = `You are a continuity editor for NovelCraft, a novel writing application.
+
+Your task is to find places where a chapter contradicts established facts in the reference sources: physical descriptions (eye colour, height, scars), ages and dates, names and titles, relationships, locations and distances, who knows what and when, and the state of objects.
+
+Rules:
+- Only report contradictions with the numbered reference sources, never with your own knowledge
+- Do not report style problems, typos or plot holes that no source contradicts
+- Quote the chapter and the source exactly, keeping each quote short (one sentence at most)
+- If nothing contradicts the sources, return an empty list
+
+Respond with JSON only, in this shape:
+{"issues": [{"quote": "exact text from the chapter", "source": "S1", "sourceQuote": "exact text from the source", "severity": "low|medium|high", "explanation": "what contradicts what", "suggestedFix": "how to make the chapter consistent"}]}
+
+Severity: "high" contradicts established canon outright, "medium" is an inconsistency a reader may notice, "low" is probably deliberate or easily explained.`
+
+func buildContinuityUserPrompt(title, text string, sources []citedSource) string {
+	var b strings.Builder
+	b.WriteString("Reference Sources:\n---\n\n")
+	for _, source := range sources {
+		b.WriteString("[" + source.label + "] " + source.heading + "\n" + source.content + "\n\n")
+	}
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "Chapter %q:\n---\n%s\n---\n\n", title, text)
+	b.WriteString("List every suspected continuity error in the chapter as JSON.")
+	return b.String()
+}
+
+// parseContinuityIssues decodes the model's reply. Issues citing a source that
+// wasn't given, or without a quote, are dropped rather than shown with a
+// made-up citation.
+func parseContinuityIssues(reply string, sources []citedSource, content string) ([]ContinuityIssue, error) {
+	var parsed struct {
+		Issues []struct {
+			Quote        string `json:"quote"`
+			Source       string `json:"source"`
+			SourceQuote  string `json:"sourceQuote"`
+			Severity     string `json:"severity"`
+			Explanation  string `json:"explanation"`
+			SuggestedFix string `json:"suggestedFix"`
+		} `json:"issues"`
+	}
+	if err := decodeModelJSON(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	byLabel := make(map[string]citedSource, len(sources))
+	for _, source := range sources {
+		byLabel[source.label] = source
+	}
+
+	issues := []ContinuityIssue{}
+	for _, item := range parsed.Issues {
+		quote := strings.TrimSpace(item.Quote)
+		source, ok := byLabel[strings.Trim(strings.TrimSpace(item.Source), "[]")]
+		if quote == "" || !ok {
+			log.Printf("continuity check: dropping issue citing %q: %q", item.Source, quote)
+			continue
+		}
+
+		severity := strings.ToLower(strings.TrimSpace(item.Severity))
+		if _, ok := continuitySeverityRank[severity]; !ok {
+			severity = ContinuitySeverityMedium
+		}
+
+		issue := ContinuityIssue{
+			Quote: quote,
+			Conflict: ContinuityCitation{
+				SourceType: source.source.SourceType,
+				SourceID:   source.source.SourceID,
+				Title:      source.source.Title,
+				ChunkID:    source.source.ChunkID,
+				Quote:      strings.TrimSpace(item.SourceQuote),
+			},
+			Severity:     severity,
+			Explanation:  strings.TrimSpace(item.Explanation),
+			SuggestedFix: strings.TrimSpace(item.SuggestedFix),
+		}
+		if i := strings.Index(content, quote); i >= 0 {
+			offset := utf8.RuneCountInString(content[:i])
+			issue.StartOffset = &offset
+		}
+		issues = append(issues, issue)
+	}
+
+	// Most severe first, then in reading order
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if continuitySeverityRank[a.Severity] != continuitySeverityRank[b.Severity] {
+			return continuitySeverityRank[a.Severity] < continuitySeverityRank[b.Severity]
+		}
+		if a.StartOffset == nil || b.StartOffset == nil {
+			return a.StartOffset != nil
+		}
+		return *a.StartOffset < *b.StartOffset
+	})
+
+	return issues, nil
+}
@@ -0,0 +1,76 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeModelJSON(t *testing.T) {
+	var v struct {
+		Issues []string `json:"issues"`
+	}
+
+	require.NoError(t, decodeModelJSON("```json\n{\"issues\": [\"a\"]}\n```", &v))
+	assert.Equal(t, []string{"a"}, v.Issues)
+
+	require.NoError(t, decodeModelJSON(`Here is what I found: {"issues": ["b"]} Hope this helps!`, &v))
+	assert.Equal(t, []string{"b"}, v.Issues)
+
+	assert.ErrorIs(t, decodeModelJSON("No issues found.", &v), ErrInvalidModelOutput)
+	assert.ErrorIs(t, decodeModelJSON(`{"issues": [}`, &v), ErrInvalidModelOutput)
+}
+
+func TestParseContinuityIssues(t *testing.T) {
+	sources := []citedSource{
+		{label: "S1", source: RewriteContextSource{Kind: RewriteContextWikiPage, SourceType: "wiki_page", SourceID: "page-mara", Title: "Mara"}},
+		{label: "S2", source: RewriteContextSource{Kind: RewriteContextRetrieval, SourceType: "chapter", SourceID: "ch-1", Title: "Arrival", ChunkID: "chunk-7"}},
+	}
+	content := "Mara turned. Her brown eyes narrowed.\n\nShe was twenty, and had never seen the sea."
+
+	reply := `{"issues": [
+		{"quote": "had never seen the sea", "source": "S2", "sourceQuote": "Mara stood on the shore", "severity": "Medium", "explanation": "She was at the coast in chapter one", "suggestedFix": "had not seen the sea since childhood"},
+		{"quote": "Her brown eyes narrowed.", "source": "[S1]", "sourceQuote": "Eyes: green", "severity": "high", "explanation": "The wiki gives green eyes", "suggestedFix": "Her green eyes narrowed."},
+		{"quote": "She was twenty", "source": "S9", "severity": "high", "explanation": "Invented source"},
+		{"quote": "", "source": "S1", "severity": "low"},
+		{"quote": "a paraphrase", "source": "S1", "severity": "catastrophic"}
+	]}`
+
+	issues, err := parseContinuityIssues(reply, sources, content)
+	require.NoError(t, err)
+	require.Len(t, issues, 3, "issues without a quote or citing unknown sources are dropped")
+
+	assert.Equal(t, "Her brown eyes narrowed.", issues[0].Quote)
+	assert.Equal(t, ContinuitySeverityHigh, issues[0].Severity)
+	assert.Equal(t, ContinuityCitation{SourceType: "wiki_page", SourceID: "page-mara", Title: "Mara", Quote: "Eyes: green"}, issues[0].Conflict)
+	require.NotNil(t, issues[0].StartOffset)
+	assert.Equal(t, 13, *issues[0].StartOffset)
+
+	assert.Equal(t, ContinuitySeverityMedium, issues[1].Severity)
+	assert.Equal(t, "chunk-7", issues[1].Conflict.ChunkID)
+	require.NotNil(t, issues[1].StartOffset)
+	assert.Equal(t, strings.Index(content, "had never"), *issues[1].StartOffset)
+
+	assert.Equal(t, ContinuitySeverityMedium, issues[2].Severity, "unknown severities become medium")
+	assert.Nil(t, issues[2].StartOffset, "paraphrases have no offset")
+
+	issues, err = parseContinuityIssues(`{"issues": []}`, sources, content)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestContinuityProbeTexts(t *testing.T) {
+	var paragraphs []string
+	for i := 0; i < 40; i++ {
+		paragraphs = append(paragraphs, strings.Repeat("The river ran past the mill and under the bridge. ", 12))
+	}
+	text := strings.Join(paragraphs, "\n\n")
+
+	probes := continuityProbeTexts(text, 5)
+	assert.Len(t, probes, 5)
+	assert.Equal(t, ChunkText(text)[0].Content, probes[0])
+
+	assert.Len(t, continuityProbeTexts("A short chapter.", 5), 1)
+}
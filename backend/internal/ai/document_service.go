@@ -10,43 +10,64 @@ import (
 )
 
 type DocumentService struct {
-	db                *pgxpool.Pool
-	embeddingsService *EmbeddingsService
+	db           *pgxpool.Pool
+	embedder     Embedder
+	chunkOptions ChunkOptions
 }
 
-func NewDocumentService(db *pgxpool.Pool, embeddingsService *EmbeddingsService) *DocumentService {
+func NewDocumentService(db *pgxpool.Pool, embedder Embedder, chunkOptions ChunkOptions) *DocumentService {
 	return &DocumentService{
-		db:                db,
-		embeddingsService: embeddingsService,
+		db:           db,
+		embedder:     embedder,
+		chunkOptions: chunkOptions,
 	}
 }
 
-// ProcessDocument chunks and embeds a document
+// ProcessDocument chunks and embeds a document, unless its content and
+// embedding model are unchanged since it was last indexed
 func (s *DocumentService) ProcessDocument(ctx context.Context, projectID, sourceType, sourceID, content string) error {
+	_, err := s.IndexDocument(ctx, projectID, sourceType, sourceID, content, false)
+	return err
+}
+
+// IndexDocument is ProcessDocument with the option to re-embed unchanged
+// documents. It reports whether the document was (re)indexed.
+func (s *DocumentService) IndexDocument(ctx context.Context, projectID, sourceType, sourceID, content string, force bool) (bool, error) {
 	println("DEBUG: [ProcessDocument] Starting - sourceType:", sourceType, "sourceID:", sourceID)
 
 	// Calculate content hash
 	contentHash := HashContent(content)
 	println("DEBUG: [ProcessDocument] Content hash calculated:", contentHash)
 
-	// Check if document exists and is unchanged
+	model, dimensions := s.embeddingModel(), s.embeddingDimensions()
+	store, err := embeddingStoreFor(dimensions)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if document exists and is unchanged. Documents indexed before the
+	// model was tracked have an unknown model and are always re-embedded.
 	var existingHash string
-	err := s.db.QueryRow(ctx, `
-		SELECT content_hash FROM documents
+	var existingModel *string
+	var existingDimensions *int
+	err = s.db.QueryRow(ctx, `
+		SELECT content_hash, embedding_model, embedding_dimensions FROM documents
 		WHERE source_type = $1 AND source_id = $2
-	`, sourceType, sourceID).Scan(&existingHash)
+	`, sourceType, sourceID).Scan(&existingHash, &existingModel, &existingDimensions)
 
-	if err == nil && existingHash == contentHash {
+	sameModel := existingModel != nil && *existingModel == model &&
+		existingDimensions != nil && *existingDimensions == dimensions
+	if err == nil && !force && existingHash == contentHash && sameModel {
 		// Content unchanged, skip processing
 		println("DEBUG: [ProcessDocument] Content unchanged, skipping")
-		return nil
+		return false, nil
 	}
 	println("DEBUG: [ProcessDocument] Content changed or new document")
 
 	// Start transaction
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 	println("DEBUG: [ProcessDocument] Transaction started")
@@ -54,31 +75,36 @@ func (s *DocumentService) ProcessDocument(ctx context.Context, projectID, source
 	// Upsert document
 	var documentID string
 	err = tx.QueryRow(ctx, `
-		INSERT INTO documents (project_id, source_type, source_id, content, content_hash)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO documents (project_id, source_type, source_id, content, content_hash, embedding_model, embedding_dimensions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (source_type, source_id)
-		DO UPDATE SET content = EXCLUDED.content, content_hash = EXCLUDED.content_hash, updated_at = now()
+		DO UPDATE SET content = EXCLUDED.content, content_hash = EXCLUDED.content_hash,
+			embedding_model = EXCLUDED.embedding_model, embedding_dimensions = EXCLUDED.embedding_dimensions,
+			updated_at = now()
 		RETURNING id
-	`, projectID, sourceType, sourceID, content, contentHash).Scan(&documentID)
+	`, projectID, sourceType, sourceID, content, contentHash, model, dimensions).Scan(&documentID)
 	if err != nil {
-		return fmt.Errorf("failed to upsert document: %w", err)
+		return false, fmt.Errorf("failed to upsert document: %w", err)
 	}
 	println("DEBUG: [ProcessDocument] Document upserted, ID:", documentID)
 
 	// Delete old chunks
 	_, err = tx.Exec(ctx, `DELETE FROM chunks WHERE document_id = $1`, documentID)
 	if err != nil {
-		return fmt.Errorf("failed to delete old chunks: %w", err)
+		return false, fmt.Errorf("failed to delete old chunks: %w", err)
 	}
 	println("DEBUG: [ProcessDocument] Old chunks deleted")
 
 	// Chunk the content
-	chunks := ChunkText(content)
+	chunks := ChunkTextWithOptions(content, s.chunkOptions)
 	println("DEBUG: [ProcessDocument] Content chunked into", len(chunks), "chunks")
 	if len(chunks) == 0 {
 		// Empty content, just commit and return
 		println("DEBUG: [ProcessDocument] No chunks, committing")
-		return tx.Commit(ctx)
+		if err := tx.Commit(ctx); err != nil {
+			return false, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return true, nil
 	}
 
 	// Prepare texts for embedding
@@ -87,18 +113,17 @@ func (s *DocumentService) ProcessDocument(ctx context.Context, projectID, source
 		texts[i] = chunk.Content
 	}
 
-	// Generate embeddings (if API key is configured)
+	// Generate embeddings (if an embedder is configured)
 	var embeddings [][]float32
-	if s.embeddingsService != nil {
+	if s.embedder != nil {
 		println("DEBUG: [ProcessDocument] Generating embeddings for", len(texts), "chunks")
-		embeddings, err = s.embeddingsService.GenerateEmbeddings(ctx, texts)
+		embeddings, err = s.embedder.GenerateEmbeddings(ctx, texts)
 		if err != nil {
-			// Log error but don't fail - we can still store chunks without embeddings
-			fmt.Printf("Warning: failed to generate embeddings: %v\n", err)
-			embeddings = make([][]float32, len(chunks))
-		} else {
-			println("DEBUG: [ProcessDocument] Embeddings generated:", len(embeddings))
+			// Fail so the job is retried; the previous chunks stay in place
+			// because the transaction is rolled back
+			return false, fmt.Errorf("failed to generate embeddings: %w", err)
 		}
+		println("DEBUG: [ProcessDocument] Embeddings generated:", len(embeddings))
 	} else {
 		println("DEBUG: [ProcessDocument] No embeddings service configured")
 	}
@@ -112,22 +137,36 @@ func (s *DocumentService) ProcessDocument(ctx context.Context, projectID, source
 			embedding = fmt.Sprintf("[%v]", joinFloats(embeddings[i]))
 		}
 
-		_, err = tx.Exec(ctx, `
-			INSERT INTO chunks (document_id, project_id, chunk_index, content, token_count, embedding)
-			VALUES ($1, $2, $3, $4, $5, $6)
-		`, documentID, projectID, chunk.Index, chunk.Content, chunk.Tokens, embedding)
+		err = store.insertChunk(ctx, tx, documentID, projectID, chunk, embedding, model, dimensions)
 		if err != nil {
-			return fmt.Errorf("failed to insert chunk: %w", err)
+			return false, fmt.Errorf("failed to insert chunk: %w", err)
 		}
 	}
 	println("DEBUG: [ProcessDocument] All chunks inserted")
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	println("DEBUG: [ProcessDocument] Transaction committed successfully")
-	return nil
+	return true, nil
+}
+
+// embeddingModel names the model new chunks are embedded with, or "" when
+// there is no embedder
+func (s *DocumentService) embeddingModel() string {
+	if s.embedder == nil {
+		return ""
+	}
+	return s.embedder.Model()
+}
+
+// embeddingDimensions is the length of the vectors the embedder produces
+func (s *DocumentService) embeddingDimensions() int {
+	if s.embedder == nil {
+		return EmbeddingDimensions
+	}
+	return s.embedder.Dimensions()
 }
 
 // DeleteDocument removes a document and its chunks
@@ -0,0 +1,92 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrUnsupportedDimensions = errors.New("unsupported embedding dimensions")
+
+// embeddingStore says where vectors of one dimension are kept. pgvector
+// indexes need a fixed dimension, so 1536-dimensional vectors live in
+// chunks.embedding and every other supported size has its own table keyed by
+// chunk_id. Supporting a new size takes a migration creating the table and
+// an entry in embeddingStores.
+type embeddingStore struct {
+	table string // "" for chunks.embedding
+}
+
+var embeddingStores = map[int]embeddingStore{
+	1536: {},
+	768:  {table: "chunk_embeddings_768"},
+}
+
+// embeddingStoreFor returns the store for vectors of the given dimension
+func embeddingStoreFor(dimensions int) (embeddingStore, error) {
+	store, ok := embeddingStores[dimensions]
+	if !ok {
+		sizes := make([]int, 0, len(embeddingStores))
+		for d := range embeddingStores {
+			sizes = append(sizes, d)
+		}
+		sort.Ints(sizes)
+		supported := make([]string, len(sizes))
+		for i, d := range sizes {
+			supported[i] = fmt.Sprint(d)
+		}
+		return embeddingStore{}, fmt.Errorf("%w: %d (supported: %s)", ErrUnsupportedDimensions, dimensions, strings.Join(supported, ", "))
+	}
+	return store, nil
+}
+
+// column is the vector column for queries over chunks c joined with join()
+func (st embeddingStore) column() string {
+	if st.table == "" {
+		return "c.embedding"
+	}
+	return "ce.embedding"
+}
+
+// join is the extra join needed to reach the vectors, if any
+func (st embeddingStore) join() string {
+	if st.table == "" {
+		return ""
+	}
+	return `
+		JOIN ` + st.table + ` ce ON ce.chunk_id = c.id`
+}
+
+// insertChunk stores a chunk with its vector, which may be nil
+func (st embeddingStore) insertChunk(ctx context.Context, tx pgx.Tx, documentID, projectID string, chunk Chunk, embedding interface{}, model string, dimensions int) error {
+	var chunkModel interface{}
+	var chunkDimensions interface{}
+	if embedding != nil {
+		chunkModel, chunkDimensions = model, dimensions
+	}
+
+	var inline interface{}
+	if st.table == "" {
+		inline = embedding
+	}
+
+	var chunkID string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO chunks (document_id, project_id, chunk_index, content, token_count, embedding, start_offset, end_offset, embedding_model, embedding_dimensions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`, documentID, projectID, chunk.Index, chunk.Content, chunk.Tokens, inline, chunk.StartOffset, chunk.EndOffset, chunkModel, chunkDimensions).Scan(&chunkID)
+	if err != nil {
+		return err
+	}
+
+	if st.table == "" || embedding == nil {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO `+st.table+` (chunk_id, embedding) VALUES ($1, $2)`, chunkID, embedding)
+	return err
+}
@@ -1,39 +1,79 @@
 package ai
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"time"
+	"strings"
 )
 
 const (
-	OpenAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
 	EmbeddingModel      = "text-embedding-3-small"
 	EmbeddingDimensions = 1536
+
+	// OpenAI accepts at most 2048 inputs and 300k tokens per embeddings
+	// request. The token cap leaves room for the estimate running low.
+	maxEmbeddingInputs      = 2048
+	maxEmbeddingBatchTokens = 250000
 )
 
+// EmbeddingsService talks to OpenAI or any server exposing the OpenAI
+// embeddings API
 type EmbeddingsService struct {
 	apiKey     string
-	httpClient *http.Client
+	baseURL    string
+	model      string
+	dimensions int
+	// Only OpenAI's text-embedding-3 models accept a dimensions parameter
+	sendDimensions bool
+	client         *apiClient
 }
 
-func NewEmbeddingsService(apiKey string) *EmbeddingsService {
+// NewEmbeddingsService creates an OpenAI-compatible embedder. An empty baseURL
+// targets OpenAI itself, an empty model falls back to EmbeddingModel and a
+// zero dimensions to EmbeddingDimensions.
+func NewEmbeddingsService(apiKey, baseURL, model string, dimensions int) *EmbeddingsService {
+	if baseURL == "" {
+		baseURL = OpenAIBaseURL
+	}
+	if model == "" {
+		model = EmbeddingModel
+	}
+	if dimensions <= 0 {
+		dimensions = EmbeddingDimensions
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
 	return &EmbeddingsService{
-		apiKey: apiKey,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		apiKey:         apiKey,
+		baseURL:        baseURL,
+		model:          model,
+		dimensions:     dimensions,
+		sendDimensions: baseURL == OpenAIBaseURL,
+		client:         newAPIClient("embeddings", DefaultClientOptions()),
 	}
 }
 
+// WithClientOptions replaces the default timeouts and retry policy
+func (s *EmbeddingsService) WithClientOptions(opts ClientOptions) *EmbeddingsService {
+	s.client = newAPIClient("embeddings", opts)
+	return s
+}
+
+// Model returns the embedding model name
+func (s *EmbeddingsService) Model() string {
+	return s.model
+}
+
+// Dimensions returns the expected vector length
+func (s *EmbeddingsService) Dimensions() int {
+	return s.dimensions
+}
+
 type embeddingRequest struct {
 	Model      string   `json:"model"`
 	Input      []string `json:"input"`
-	Dimensions int      `json:"dimensions"`
+	Dimensions int      `json:"dimensions,omitempty"`
 }
 
 type embeddingResponse struct {
@@ -47,68 +87,93 @@ type embeddingResponse struct {
 	} `json:"usage"`
 }
 
-// GenerateEmbeddings generates embeddings for multiple text chunks
+// GenerateEmbeddings generates embeddings for multiple text chunks, split
+// into as many requests as the provider's per-request limits require
 func (s *EmbeddingsService) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
-	println("DEBUG: [GenerateEmbeddings] Starting - chunk count:", len(texts))
-
 	if len(texts) == 0 {
 		return [][]float32{}, nil
 	}
 
-	if s.apiKey == "" {
+	if s.apiKey == "" && s.baseURL == OpenAIBaseURL {
 		return nil, fmt.Errorf("OpenAI API key not configured")
 	}
 
-	reqBody := embeddingRequest{
-		Model:      EmbeddingModel,
-		Input:      texts,
-		Dimensions: EmbeddingDimensions,
+	embeddings := make([][]float32, len(texts))
+	batches := batchInputs(texts, maxEmbeddingInputs, maxEmbeddingBatchTokens)
+	for _, batch := range batches {
+		if err := s.embedBatch(ctx, texts[batch.start:batch.end], embeddings[batch.start:batch.end]); err != nil {
+			return nil, err
+		}
 	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-	println("DEBUG: [GenerateEmbeddings] Request payload size:", len(jsonData), "bytes")
+	return embeddings, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", OpenAIEmbeddingsURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// embedBatch embeds texts in one request, writing the vectors into out
+func (s *EmbeddingsService) embedBatch(ctx context.Context, texts []string, out [][]float32) error {
+	reqBody := embeddingRequest{
+		Model: s.model,
+		Input: texts,
+	}
+	if s.sendDimensions {
+		reqBody.Dimensions = s.dimensions
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+	header := http.Header{}
+	if s.apiKey != "" {
+		header.Set("Authorization", "Bearer "+s.apiKey)
+	}
 
-	println("DEBUG: [GenerateEmbeddings] Sending request to OpenAI...")
-	startTime := time.Now()
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.client.post(ctx, s.baseURL+"/embeddings", header, reqBody, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
-	println("DEBUG: [GenerateEmbeddings] Received response in", time.Since(startTime).Seconds(), "seconds, status:", resp.StatusCode)
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
-	}
 
 	var embResp embeddingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return fmt.Errorf("failed to decode response: %w", err)
 	}
-	println("DEBUG: [GenerateEmbeddings] Decoded response, got", len(embResp.Data), "embeddings")
 
-	// Extract embeddings in order
-	embeddings := make([][]float32, len(texts))
 	for _, data := range embResp.Data {
-		if data.Index < len(embeddings) {
-			embeddings[data.Index] = data.Embedding
+		if len(data.Embedding) != s.dimensions {
+			return fmt.Errorf("model %s returned %d dimensions, expected %d", s.model, len(data.Embedding), s.dimensions)
+		}
+		if data.Index >= 0 && data.Index < len(out) {
+			out[data.Index] = data.Embedding
 		}
 	}
+	for i, embedding := range out {
+		if embedding == nil {
+			return fmt.Errorf("model %s returned no embedding for input %d", s.model, i)
+		}
+	}
+	return nil
+}
 
-	println("DEBUG: [GenerateEmbeddings] Successfully generated embeddings")
-	return embeddings, nil
+// inputRange is a half-open range of inputs sent in one request
+type inputRange struct {
+	start, end int
+}
+
+// batchInputs groups consecutive texts into batches of at most maxInputs
+// texts and maxTokens estimated tokens. A single text over maxTokens gets a
+// batch of its own and is left for the provider to reject.
+func batchInputs(texts []string, maxInputs, maxTokens int) []inputRange {
+	var batches []inputRange
+	start, tokens := 0, 0
+	for i, text := range texts {
+		n := estimateTokens(text)
+		if i > start && (i-start >= maxInputs || tokens+n > maxTokens) {
+			batches = append(batches, inputRange{start, i})
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	if start < len(texts) {
+		batches = append(batches, inputRange{start, len(texts)})
+	}
+	return batches
 }
 
 // GenerateEmbedding generates a single embedding
@@ -0,0 +1,124 @@
+package ai
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// LocalEmbeddingModel is the model name reported by LocalEmbedder
+const LocalEmbeddingModel = "local-hash-v1"
+
+// localStopWords are dropped before hashing so that function words do not
+// dominate the similarity between short queries and long chunks
+var localStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "did": true, "do": true, "does": true,
+	"for": true, "from": true, "had": true, "has": true, "have": true, "he": true,
+	"her": true, "his": true, "i": true, "in": true, "is": true, "it": true,
+	"its": true, "of": true, "on": true, "or": true, "she": true, "that": true,
+	"the": true, "their": true, "they": true, "this": true, "to": true, "was": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true,
+	"who": true, "why": true, "with": true, "you": true,
+}
+
+// LocalEmbedder is a deterministic bag-of-words embedder using the hashing
+// trick. It needs no network or model files, so chunking and retrieval work in
+// development and integration tests without an API key. Its vectors only
+// capture lexical overlap, not meaning.
+type LocalEmbedder struct {
+	dimensions int
+}
+
+func NewLocalEmbedder(dimensions int) *LocalEmbedder {
+	if dimensions <= 0 {
+		dimensions = EmbeddingDimensions
+	}
+	return &LocalEmbedder{dimensions: dimensions}
+}
+
+// Model returns LocalEmbeddingModel
+func (e *LocalEmbedder) Model() string {
+	return LocalEmbeddingModel
+}
+
+// Dimensions returns the vector length
+func (e *LocalEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+// GenerateEmbeddings embeds each text independently
+func (e *LocalEmbedder) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		embeddings[i] = e.embed(text)
+	}
+	return embeddings, nil
+}
+
+// GenerateEmbedding embeds a single text
+func (e *LocalEmbedder) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.embed(text), nil
+}
+
+func (e *LocalEmbedder) embed(text string) []float32 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	counts := make(map[string]float64)
+	prev := ""
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if word == "" || localStopWords[word] {
+			prev = ""
+			continue
+		}
+		counts[word]++
+		if prev != "" {
+			// Bigrams carry a little word order ("red door" vs "door red")
+			counts[prev+" "+word] += 0.5
+		}
+		prev = word
+	}
+
+	vector := make([]float64, e.dimensions)
+	for term, count := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+
+		// Sublinear term frequency, with a hash bit choosing the sign so that
+		// collisions cancel out on average instead of accumulating
+		weight := 1 + math.Log(count)
+		if sum&(1<<63) != 0 {
+			weight = -weight
+		}
+		vector[sum%uint64(e.dimensions)] += weight
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	result := make([]float32, e.dimensions)
+	if norm == 0 {
+		// pgvector cannot compute cosine distance for a zero vector
+		result[0] = 1
+		return result
+	}
+	for i, v := range vector {
+		result[i] = float32(v / norm)
+	}
+	return result
+}
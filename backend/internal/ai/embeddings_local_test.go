@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/config"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestLocalEmbedder_DeterministicAndNormalized(t *testing.T) {
+	embedder := NewLocalEmbedder(0)
+	ctx := context.Background()
+
+	first, err := embedder.GenerateEmbedding(ctx, "Mara opened the red door of the lighthouse.")
+	require.NoError(t, err)
+	second, err := embedder.GenerateEmbedding(ctx, "Mara opened the red door of the lighthouse.")
+	require.NoError(t, err)
+
+	assert.Len(t, first, EmbeddingDimensions)
+	assert.Equal(t, first, second)
+	assert.InDelta(t, 1.0, math.Sqrt(cosine(first, first)), 1e-5)
+}
+
+func TestLocalEmbedder_LexicalSimilarity(t *testing.T) {
+	embedder := NewLocalEmbedder(256)
+	vectors, err := embedder.GenerateEmbeddings(context.Background(), []string{
+		"Who keeps the lighthouse on Greyhaven?",
+		"The lighthouse on Greyhaven is kept by old Tobias.",
+		"The merchant sold spices in the southern market.",
+	})
+	require.NoError(t, err)
+	require.Len(t, vectors, 3)
+
+	assert.Greater(t, cosine(vectors[0], vectors[1]), cosine(vectors[0], vectors[2]))
+}
+
+func TestLocalEmbedder_EmptyTextIsNotZeroVector(t *testing.T) {
+	vector, err := NewLocalEmbedder(8).GenerateEmbedding(context.Background(), "the and of")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, cosine(vector, vector), 1e-6)
+}
+
+func TestNewEmbedder(t *testing.T) {
+	embedder, err := NewEmbedder(&config.Config{EmbeddingsProvider: ProviderAuto})
+	require.NoError(t, err)
+	assert.Equal(t, LocalEmbeddingModel, embedder.Model(), "auto without a key falls back to local")
+
+	embedder, err = NewEmbedder(&config.Config{EmbeddingsProvider: ProviderAuto, OpenAIAPIKey: "sk-test"})
+	require.NoError(t, err)
+	assert.Equal(t, EmbeddingModel, embedder.Model())
+
+	embedder, err = NewEmbedder(&config.Config{EmbeddingsProvider: ProviderOpenAI})
+	require.NoError(t, err)
+	assert.Nil(t, embedder)
+
+	_, err = NewEmbedder(&config.Config{EmbeddingsProvider: ProviderOpenAICompatible})
+	assert.Error(t, err)
+}
+
+func TestEmbeddingsService_RejectsWrongDimensions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		w.Write([]byte(`{"data":[{"embedding":[0.1,0.2,0.3],"index":0}]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewEmbeddingsService("", server.URL+"/v1", "nomic-embed-text", 768)
+	_, err := embedder.GenerateEmbedding(context.Background(), "hello")
+	assert.ErrorContains(t, err, "returned 3 dimensions, expected 768")
+}
@@ -0,0 +1,710 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/imphyy/NovelCraft/backend/internal/wiki"
+)
+
+const (
+	// DefaultEntityMinOccurrences is how often a name must appear before it
+	// is suggested
+	DefaultEntityMinOccurrences = 2
+	// MaxEntitySuggestions bounds the suggestions returned, most frequent first
+	MaxEntitySuggestions = 100
+
+	entitySampleSentences = 3
+	entitySampleMaxRunes  = 300
+	// Candidates sent to the model for classification, most frequent first
+	entityModelCandidates = 80
+	entityModelMaxTokens  = 3000
+)
+
+// ErrModelUnavailable is returned when a tool's optional model pass is asked
+// for without a chat provider configured
+var ErrModelUnavailable = errors.New("AI chat provider not configured")
+
+// EntityOptions controls entity extraction
+type EntityOptions struct {
+	// UseModel asks the chat model to drop false positives and correct the
+	// page types the heuristic guessed
+	UseModel bool
+	// MinOccurrences defaults to DefaultEntityMinOccurrences
+	MinOccurrences int
+	StyleGuide     string
+}
+
+// EntitySample is a sentence an entity appears in
+type EntitySample struct {
+	ChapterID    string `json:"chapterId"`
+	ChapterTitle string `json:"chapterTitle"`
+	Sentence     string `json:"sentence"`
+}
+
+// EntitySuggestion is a name that appears in the manuscript without a wiki page
+type EntitySuggestion struct {
+	Name        string         `json:"name"`
+	Slug        string         `json:"slug"`
+	PageType    string         `json:"pageType"`
+	Occurrences int            `json:"occurrences"`
+	Chapters    int            `json:"chapters"` // Number of chapters it appears in
+	Samples     []EntitySample `json:"samples"`
+}
+
+type EntityExtractionResponse struct {
+	Suggestions []EntitySuggestion `json:"suggestions"`
+	// Existing counts candidates left out because a wiki page has their slug
+	Existing  int    `json:"existing"`
+	UsedModel bool   `json:"usedModel"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
+}
+
+// NewWikiPage is a page to create from a suggestion
+type NewWikiPage struct {
+	Title    string `json:"title" validate:"required,min=1,max=255"`
+	PageType string `json:"pageType" validate:"required,oneof=character location event concept item faction"`
+}
+
+type SkippedWikiPage struct {
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+type CreateWikiPagesResult struct {
+	Created []wiki.WikiPage   `json:"created"`
+	Skipped []SkippedWikiPage `json:"skipped"`
+}
+
+// EntityService suggests wiki pages for the characters, places, items and
+// factions a manuscript names but the wiki doesn't cover yet
+type EntityService struct {
+	db           *pgxpool.Pool
+	wikiService  *wiki.Service
+	chatProvider ChatProvider // Optional, for the model pass
+}
+
+func NewEntityService(db *pgxpool.Pool, wikiService *wiki.Service, chatProvider ChatProvider) *EntityService {
+	return &EntityService{
+		db:           db,
+		wikiService:  wikiService,
+		chatProvider: chatProvider,
+	}
+}
+
+// entityText is one chapter to extract entities from
+type entityText struct {
+	chapterID string
+	title     string
+	content   string
+}
+
+// ExtractFromChapter suggests wiki pages for one chapter the user owns
+func (s *EntityService) ExtractFromChapter(ctx context.Context, chapterID, userID string, opts EntityOptions) (*EntityExtractionResponse, error) {
+	var projectID string
+	text := entityText{chapterID: chapterID}
+	err := s.db.QueryRow(ctx, `
+		SELECT c.project_id, c.title, c.content
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+	`, chapterID, userID).Scan(&projectID, &text.title, &text.content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+
+	return s.extract(ctx, projectID, []entityText{text}, opts)
+}
+
+// ExtractFromProject suggests wiki pages for every chapter of a project the
+// user owns
+func (s *EntityService) ExtractFromProject(ctx context.Context, projectID, userID string, opts EntityOptions) (*EntityExtractionResponse, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, title, content FROM chapters WHERE project_id = $1 AND deleted_at IS NULL ORDER BY sort_order
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+	defer rows.Close()
+
+	var texts []entityText
+	for rows.Next() {
+		var text entityText
+		if err := rows.Scan(&text.chapterID, &text.title, &text.content); err != nil {
+			return nil, fmt.Errorf("failed to scan chapter: %w", err)
+		}
+		texts = append(texts, text)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+
+	return s.extract(ctx, projectID, texts, opts)
+}
+
+func (s *EntityService) extract(ctx context.Context, projectID string, texts []entityText, opts EntityOptions) (*EntityExtractionResponse, error) {
+	if opts.UseModel && s.chatProvider == nil {
+		return nil, ErrModelUnavailable
+	}
+	if opts.MinOccurrences <= 0 {
+		opts.MinOccurrences = DefaultEntityMinOccurrences
+	}
+
+	existing, err := s.existingSlugs(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &EntityExtractionResponse{Suggestions: []EntitySuggestion{}}
+	for _, suggestion := range extractEntities(texts, opts.MinOccurrences) {
+		if existing[suggestion.Slug] {
+			resp.Existing++
+			continue
+		}
+		resp.Suggestions = append(resp.Suggestions, suggestion)
+	}
+
+	if opts.UseModel && len(resp.Suggestions) > 0 {
+		resp.UsedModel = true
+		resp.Model = s.chatProvider.Model()
+		if err := s.classify(ctx, resp, opts.StyleGuide); err != nil {
+			return resp, err
+		}
+	}
+
+	if len(resp.Suggestions) > MaxEntitySuggestions {
+		resp.Suggestions = resp.Suggestions[:MaxEntitySuggestions]
+	}
+	return resp, nil
+}
+
+func (s *EntityService) existingSlugs(ctx context.Context, projectID string) (map[string]bool, error) {
+	rows, err := s.db.Query(ctx, `SELECT slug FROM wiki_pages WHERE project_id = $1`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wiki pages: %w", err)
+	}
+	defer rows.Close()
+
+	slugs := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, fmt.Errorf("failed to scan wiki page: %w", err)
+		}
+		slugs[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list wiki pages: %w", err)
+	}
+	return slugs, nil
+}
+
+const entitySystemPrompt = `You are a story bible assistant for NovelCraft, a novel writing application.
+
+You are given names found in a manuscript by a simple capitalisation heuristic, each with example sentences. Decide for each one whether it deserves a wiki page and what kind of page.
+
+Rules:
+- Keep characters, locations, items, factions, events and in-world concepts
+- Drop words that are only capitalised because they start a sentence or line of dialogue, interjections, real-world brands, days, months and honorifics on their own
+- pageType is one of: character, location, item, faction, event, concept
+
+Respond with JSON only, in this shape:
+{"entities": [{"name": "name exactly as given", "keep": true, "pageType": "character"}]}`
+
+// classify runs the model pass over the most frequent suggestions, dropping
+// the ones it rejects and taking its page types
+func (s *EntityService) classify(ctx context.Context, resp *EntityExtractionResponse, styleGuide string) error {
+	candidates := resp.Suggestions
+	if len(candidates) > entityModelCandidates {
+		candidates = candidates[:entityModelCandidates]
+	}
+
+	var b strings.Builder
+	b.WriteString("Candidate Names:\n---\n\n")
+	for _, candidate := range candidates {
+		fmt.Fprintf(&b, "%s (guessed %s, %d occurrences)\n", candidate.Name, candidate.PageType, candidate.Occurrences)
+		for i, sample := range candidate.Samples {
+			if i == 2 {
+				break
+			}
+			b.WriteString("- " + sample.Sentence + "\n")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("---\n\nClassify every candidate as JSON.")
+
+	messages := []ChatMessage{
+		{Role: "system", Content: withStyleGuide(entitySystemPrompt, styleGuide)},
+		{Role: "user", Content: b.String()},
+	}
+	chatResp, err := s.chatProvider.CreateChatCompletion(ctx, messages, 0, entityModelMaxTokens)
+	if err != nil {
+		return fmt.Errorf("failed to call chat provider: %w", err)
+	}
+	resp.TokensIn = chatResp.Usage.PromptTokens
+	resp.TokensOut = chatResp.Usage.CompletionTokens
+
+	reply := ""
+	if len(chatResp.Choices) > 0 {
+		reply = chatResp.Choices[0].Message.Content
+	}
+	var parsed struct {
+		Entities []struct {
+			Name     string `json:"name"`
+			Keep     *bool  `json:"keep"`
+			PageType string `json:"pageType"`
+		} `json:"entities"`
+	}
+	if err := decodeModelJSON(reply, &parsed); err != nil {
+		return err
+	}
+
+	resp.Suggestions = applyEntityClassification(resp.Suggestions, len(candidates), func(name string) (bool, string, bool) {
+		for _, entity := range parsed.Entities {
+			if strings.EqualFold(strings.TrimSpace(entity.Name), name) {
+				return entity.Keep == nil || *entity.Keep, entity.PageType, true
+			}
+		}
+		return false, "", false
+	})
+	return nil
+}
+
+// applyEntityClassification applies the model's verdicts to the first
+// classified suggestions. Suggestions the model didn't mention are kept as
+// the heuristic left them.
+func applyEntityClassification(suggestions []EntitySuggestion, classified int, verdict func(name string) (keep bool, pageType string, found bool)) []EntitySuggestion {
+	kept := suggestions[:0]
+	for i, suggestion := range suggestions {
+		if i < classified {
+			keep, pageType, found := verdict(suggestion.Name)
+			if found && !keep {
+				continue
+			}
+			if found && entityPageTypes[pageType] {
+				suggestion.PageType = pageType
+			}
+		}
+		kept = append(kept, suggestion)
+	}
+	return kept
+}
+
+// CreatePages creates empty wiki pages for accepted suggestions. Pages whose
+// slug is already taken are skipped rather than failing the batch.
+func (s *EntityService) CreatePages(ctx context.Context, projectID, userID string, pages []NewWikiPage) (*CreateWikiPagesResult, error) {
+	result := &CreateWikiPagesResult{Created: []wiki.WikiPage{}, Skipped: []SkippedWikiPage{}}
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		title := strings.TrimSpace(page.Title)
+		slug := wiki.GenerateSlug(title)
+		if slug == "" {
+			result.Skipped = append(result.Skipped, SkippedWikiPage{Title: page.Title, Reason: "title has no letters or digits"})
+			continue
+		}
+		if seen[slug] {
+			result.Skipped = append(result.Skipped, SkippedWikiPage{Title: page.Title, Reason: "duplicate in request"})
+			continue
+		}
+		seen[slug] = true
+
+		created, err := s.wikiService.Create(ctx, projectID, userID, title, page.PageType)
+		if errors.Is(err, wiki.ErrSlugTaken) {
+			result.Skipped = append(result.Skipped, SkippedWikiPage{Title: page.Title, Reason: "a page with this title already exists"})
+			continue
+		}
+		if errors.Is(err, wiki.ErrUnauthorized) {
+			return nil, ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Created = append(result.Created, *created)
+	}
+	return result, nil
+}
+
+var entityPageTypes = map[string]bool{
+	"character": true,
+	"location":  true,
+	"item":      true,
+	"faction":   true,
+	"event":     true,
+	"concept":   true,
+}
+
+// Lowercase words that may join the capitalised words of one name, as in
+// "Order of the Flame" or "Jan van Eyck"
+var entityConnectors = map[string]bool{
+	"of": true, "the": true, "de": true, "du": true, "del": true, "van": true,
+	"von": true, "da": true, "di": true, "la": true, "le": true, "al": true, "el": true,
+}
+
+// Capitalised words that are never names on their own, and are trimmed from
+// the start of a name ("When Mara" is Mara)
+var entityStopwords = toSet(`a an the this that these those i i'm i'd i'll i've me my mine
+	he him his she her hers it its we us our ours they them their theirs you your yours
+	and but or nor so yet if then than when where why how what who whom whose which while
+	as at by for from in into of on to with without after before during since until
+	now there here yes no not oh ah well okay ok hey please thanks sorry still just even
+	once twice perhaps maybe all some any every each both none one two three
+	monday tuesday wednesday thursday friday saturday sunday
+	january february march april may june july august september october november december
+	chapter part book prologue epilogue
+	mr mrs ms miss dr sir madam lord lady captain king queen prince princess master mistress
+	god`)
+
+// Honorifics kept at the start of a name ("Lady Ashford") though they are
+// not names on their own
+var entityHonorifics = toSet(`mr mrs ms miss dr sir madam dame lord lady captain king queen
+	prince princess duke duchess count countess baron baroness master mistress father mother
+	brother sister uncle aunt general commander lieutenant sergeant professor doctor saint`)
+
+// Abbreviations whose full stop doesn't end a sentence
+var entityAbbreviations = toSet(`mr mrs ms dr st mt capt lt sgt prof gen col`)
+
+var entityTypeWords = map[string]string{
+	"city": "location", "town": "location", "village": "location", "river": "location",
+	"mountain": "location", "mountains": "location", "forest": "location", "wood": "location",
+	"woods": "location", "sea": "location", "lake": "location", "castle": "location",
+	"keep": "location", "tower": "location", "isle": "location", "island": "location",
+	"islands": "location", "valley": "location", "street": "location", "road": "location",
+	"kingdom": "location", "empire": "location", "bay": "location", "port": "location",
+	"hall": "location", "abbey": "location", "temple": "location", "palace": "location",
+	"bridge": "location", "gate": "location", "inn": "location", "hills": "location",
+	"order": "faction", "guild": "faction", "house": "faction", "clan": "faction",
+	"company": "faction", "brotherhood": "faction", "sisterhood": "faction", "council": "faction",
+	"legion": "faction", "church": "faction", "army": "faction", "court": "faction",
+	"circle": "faction", "alliance": "faction", "society": "faction", "league": "faction",
+	"tribe": "faction", "guard": "faction", "watch": "faction", "cult": "faction",
+	"sword": "item", "blade": "item", "ring": "item", "crown": "item", "stone": "item",
+	"amulet": "item", "staff": "item", "orb": "item", "shield": "item", "dagger": "item",
+	"chalice": "item", "tome": "item", "codex": "item", "key": "item",
+	"war": "event", "battle": "event", "siege": "event", "festival": "event",
+	"treaty": "event", "rebellion": "event", "uprising": "event", "massacre": "event",
+	"coronation": "event", "plague": "event",
+}
+
+// Words before a name that suggest a place
+var entityLocationPrepositions = toSet(`in at to from into through toward towards near across
+	reached entered left beyond outside inside within`)
+
+func toSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// entityCandidate accumulates what the heuristic sees of one name
+type entityCandidate struct {
+	forms        map[string]int // Surface forms by count
+	occurrences  int
+	nonInitial   int // Occurrences not at the start of a sentence or quote
+	afterPlace   int // Occurrences after a place preposition
+	chapters     map[string]bool
+	chapterOrder int // Index of the first chapter it appears in
+	samples      []EntitySample
+}
+
+// word is a word of a sentence with the context the heuristic needs
+type word struct {
+	text    string
+	initial bool // First word of a sentence or of quoted speech
+	joined  bool // Separated from the previous word only by spaces
+}
+
+// extractEntities finds capitalised phrases used like names in texts and
+// returns those seen at least minOccurrences times, most frequent first
+func extractEntities(texts []entityText, minOccurrences int) []EntitySuggestion {
+	candidates := make(map[string]*entityCandidate)
+	lowercase := make(map[string]int) // Uses of each word in lowercase
+
+	for order, text := range texts {
+		for _, sentence := range splitSentences(text.content) {
+			words := sentenceWords(sentence)
+			for _, w := range words {
+				if first, _ := utf8.DecodeRuneInString(w.text); unicode.IsLower(first) {
+					lowercase[w.text]++
+				}
+			}
+
+			for _, phrase := range namePhrases(words) {
+				key := wiki.GenerateSlug(phrase.name)
+				if key == "" {
+					continue
+				}
+				c := candidates[key]
+				if c == nil {
+					c = &entityCandidate{forms: make(map[string]int), chapters: make(map[string]bool), chapterOrder: order}
+					candidates[key] = c
+				}
+				c.forms[phrase.name]++
+				c.occurrences++
+				if !phrase.initial {
+					c.nonInitial++
+				}
+				if entityLocationPrepositions[phrase.before] {
+					c.afterPlace++
+				}
+				c.chapters[text.chapterID] = true
+				if len(c.samples) < entitySampleSentences && !hasSample(c.samples, sentence) {
+					c.samples = append(c.samples, EntitySample{
+						ChapterID:    text.chapterID,
+						ChapterTitle: text.title,
+						Sentence:     truncateRunes(sentence, entitySampleMaxRunes),
+					})
+				}
+			}
+		}
+	}
+
+	var suggestions []EntitySuggestion
+	for slug, c := range candidates {
+		name := preferredForm(c.forms)
+		if c.occurrences < minOccurrences {
+			continue
+		}
+		if !strings.Contains(name, " ") {
+			// A single word seen only at the start of sentences, or more
+			// often in lowercase, is an ordinary word
+			if c.nonInitial == 0 || lowercase[strings.ToLower(name)] >= c.nonInitial {
+				continue
+			}
+		}
+		suggestions = append(suggestions, EntitySuggestion{
+			Name:        name,
+			Slug:        slug,
+			PageType:    guessPageType(name, c),
+			Occurrences: c.occurrences,
+			Chapters:    len(c.chapters),
+			Samples:     c.samples,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Occurrences != suggestions[j].Occurrences {
+			return suggestions[i].Occurrences > suggestions[j].Occurrences
+		}
+		return suggestions[i].Name < suggestions[j].Name
+	})
+	return suggestions
+}
+
+type namePhrase struct {
+	name    string
+	initial bool
+	before  string // Lowercased word before the name, if any
+}
+
+// namePhrases returns the runs of capitalised words in a sentence, joined by
+// connectors and trimmed of stopwords and possessives
+func namePhrases(words []word) []namePhrase {
+	var phrases []namePhrase
+	for i := 0; i < len(words); {
+		if !isCapitalised(words[i].text) {
+			i++
+			continue
+		}
+
+		// Extend the run over capitalised words and connectors followed by one
+		start, end := i, i+1
+		for end < len(words) && words[end].joined {
+			if isCapitalised(words[end].text) {
+				end++
+				continue
+			}
+			next := end
+			for next < len(words) && words[next].joined && entityConnectors[words[next].text] {
+				next++
+			}
+			if next > end && next < len(words) && words[next].joined && isCapitalised(words[next].text) {
+				end = next + 1
+				continue
+			}
+			break
+		}
+		i = end
+
+		// Trim stopwords from the front ("When Mara", "The Iron Guild"),
+		// keeping honorifics that lead into a name
+		for start < end && entityStopwords[strings.ToLower(words[start].text)] &&
+			!(entityHonorifics[strings.ToLower(words[start].text)] && end-start > 1) {
+			start++
+		}
+		if start == end {
+			continue
+		}
+
+		parts := make([]string, 0, end-start)
+		for _, w := range words[start:end] {
+			parts = append(parts, w.text)
+		}
+		last := len(parts) - 1
+		parts[last] = strings.TrimSuffix(strings.TrimSuffix(parts[last], "'s"), "’s")
+		if len(parts) == 1 && entityStopwords[strings.ToLower(parts[0])] {
+			continue
+		}
+
+		phrase := namePhrase{name: strings.Join(parts, " "), initial: words[start].initial}
+		if start > 0 {
+			phrase.before = strings.ToLower(words[start-1].text)
+		}
+		phrases = append(phrases, phrase)
+	}
+	return phrases
+}
+
+// splitSentences splits text into sentences at terminal punctuation and line
+// breaks
+func splitSentences(text string) []string {
+	var sentences []string
+	emit := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			sentences = append(sentences, s)
+		}
+	}
+
+	start := 0
+	for i, r := range text {
+		switch r {
+		case '\n':
+			emit(text[start:i])
+			start = i + 1
+		case '.', '!', '?', '…':
+			end := i + utf8.RuneLen(r)
+			// Closing quotes and brackets belong to the sentence they end
+			for end < len(text) && strings.ContainsRune(`"'”’)]`, rune(text[end])) {
+				end++
+			}
+			if end < len(text) && text[end] != ' ' && text[end] != '\n' && text[end] != '\t' {
+				continue
+			}
+			if r == '.' && entityAbbreviations[strings.ToLower(lastWord(text[start:i]))] {
+				continue
+			}
+			emit(text[start:end])
+			start = end
+		}
+	}
+	emit(text[start:])
+	return sentences
+}
+
+// sentenceWords splits a sentence into words, noting which start the
+// sentence or a quotation and which directly follow the previous word
+func sentenceWords(sentence string) []word {
+	var words []word
+	initial, joined := true, false
+	var current strings.Builder
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		text := strings.Trim(current.String(), "'’-")
+		if text != "" {
+			words = append(words, word{text: text, initial: initial, joined: joined})
+			initial = false
+		}
+		current.Reset()
+		joined = true
+	}
+
+	for _, r := range sentence {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’' || r == '-':
+			current.WriteRune(r)
+		case r == ' ':
+			flush()
+		default:
+			flush()
+			joined = false
+			if strings.ContainsRune("\"“‘:—–(", r) {
+				initial = true
+			}
+		}
+	}
+	flush()
+	return words
+}
+
+func isCapitalised(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsUpper(r)
+}
+
+func lastWord(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimLeft(fields[len(fields)-1], `"'“‘(`)
+}
+
+// guessPageType picks a page type from the words of a name and how it is used
+func guessPageType(name string, c *entityCandidate) string {
+	words := strings.Fields(strings.ToLower(name))
+	if entityHonorifics[words[0]] {
+		return "character"
+	}
+	// The last word usually says what kind of thing it is ("Iron Guild",
+	// "River Tam"), then any other
+	if pageType, ok := entityTypeWords[words[len(words)-1]]; ok {
+		return pageType
+	}
+	for _, w := range words {
+		if pageType, ok := entityTypeWords[w]; ok {
+			return pageType
+		}
+	}
+	if c.afterPlace*2 >= c.occurrences {
+		return "location"
+	}
+	return "character"
+}
+
+// preferredForm returns the most common spelling of a name
+func preferredForm(forms map[string]int) string {
+	best, count := "", 0
+	for form, n := range forms {
+		if n > count || (n == count && form < best) {
+			best, count = form, n
+		}
+	}
+	return best
+}
+
+func hasSample(samples []EntitySample, sentence string) bool {
+	for _, sample := range samples {
+		if sample.Sentence == truncateRunes(sentence, entitySampleMaxRunes) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
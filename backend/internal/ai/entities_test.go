@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractEntities(t *testing.T) {
+	texts := []entityText{
+		{chapterID: "ch-1", title: "Arrival", content: `Mara Vell rode into Ostwick at dusk. The gates of Ostwick were shut.
+"Open up," said Mara Vell. When the guard refused, she waited for Dr. Hale.
+Dr. Hale came at last. He brought news of the Iron Guild.
+Suddenly the bells rang. She felt Mara's horse shy.`},
+		{chapterID: "ch-2", title: "The Mill", content: `The Iron Guild met at the mill. They spoke of Mara Vell in whispers.
+Suddenly a stranger entered. Hale said nothing. Mara Vell listened from the rafters.
+Back in Ostwick, the lamps were lit.`},
+	}
+
+	suggestions := extractEntities(texts, 2)
+	byName := make(map[string]EntitySuggestion)
+	for _, s := range suggestions {
+		byName[s.Name] = s
+	}
+
+	require.Contains(t, byName, "Mara Vell")
+	assert.Equal(t, 4, byName["Mara Vell"].Occurrences)
+	assert.Equal(t, 2, byName["Mara Vell"].Chapters)
+	assert.Equal(t, "mara-vell", byName["Mara Vell"].Slug)
+	assert.Equal(t, "character", byName["Mara Vell"].PageType)
+	assert.Len(t, byName["Mara Vell"].Samples, 3)
+	assert.Equal(t, "Arrival", byName["Mara Vell"].Samples[0].ChapterTitle)
+
+	require.Contains(t, byName, "Ostwick")
+	assert.Equal(t, "location", byName["Ostwick"].PageType, "usually follows a place preposition")
+
+	require.Contains(t, byName, "Iron Guild", "a leading The is trimmed")
+	assert.Equal(t, "faction", byName["Iron Guild"].PageType)
+
+	assert.Contains(t, byName, "Hale", "the abbreviation doesn't end the sentence")
+	assert.NotContains(t, byName, "Mara", "the possessive is stripped, but seen once")
+	assert.NotContains(t, byName, "Mara's")
+	assert.NotContains(t, byName, "Suddenly", "only ever starts a sentence")
+	assert.NotContains(t, byName, "When")
+	assert.NotContains(t, byName, "Open")
+
+	assert.Equal(t, "Mara Vell", suggestions[0].Name, "most frequent first")
+}
+
+func TestNamePhrases(t *testing.T) {
+	tests := []struct {
+		sentence string
+		want     []string
+	}{
+		{"They swore an oath to the Order of the Flame.", []string{"Order of the Flame"}},
+		{"When Lady Ashford arrived, Tom left.", []string{"Lady Ashford", "Tom"}},
+		{"The Captain sighed.", nil},
+		{`"Where is Kell?" asked Jan van Eyck.`, []string{"Kell", "Jan van Eyck"}},
+		{"She saw Ana, Bo and Cy.", []string{"Ana", "Bo", "Cy"}},
+		{"She took Mara's horse.", []string{"Mara"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sentence, func(t *testing.T) {
+			var got []string
+			for _, p := range namePhrases(sentenceWords(tt.sentence)) {
+				got = append(got, p.name)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	got := splitSentences("Mr. Hale left. \"Wait!\" she cried. It was 3.5 miles…\nNext line")
+	assert.Equal(t, []string{"Mr. Hale left.", `"Wait!"`, "she cried.", "It was 3.5 miles…", "Next line"}, got)
+}
+
+func TestApplyEntityClassification(t *testing.T) {
+	suggestions := []EntitySuggestion{
+		{Name: "Mara", PageType: "character"},
+		{Name: "Morning", PageType: "character"},
+		{Name: "Silverfall", PageType: "character"},
+		{Name: "Kell", PageType: "character"},
+		{Name: "Unclassified", PageType: "character"},
+	}
+	verdicts := map[string]struct {
+		keep     bool
+		pageType string
+	}{
+		"Mara":       {true, "character"},
+		"Morning":    {false, ""},
+		"Silverfall": {true, "location"},
+		"Kell":       {true, "wizard"},
+	}
+
+	got := applyEntityClassification(suggestions, 4, func(name string) (bool, string, bool) {
+		v, ok := verdicts[name]
+		return v.keep, v.pageType, ok
+	})
+
+	require.Len(t, got, 4)
+	assert.Equal(t, "Mara", got[0].Name)
+	assert.Equal(t, EntitySuggestion{Name: "Silverfall", PageType: "location"}, got[1])
+	assert.Equal(t, "character", got[2].PageType, "unknown page types are ignored")
+	assert.Equal(t, "Unclassified", got[3].Name, "suggestions past the classified ones are kept")
+}
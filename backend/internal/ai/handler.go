@@ -1,20 +1,172 @@
 package ai
 
 import (
+	"context"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/imphyy/NovelCraft/backend/internal/apierrors"
+	"github.com/imphyy/NovelCraft/backend/internal/versioning"
 )
 
 type Handler struct {
-	askService     *AskService
-	rewriteService *RewriteService
+	askService      *AskService
+	rewriteService  *RewriteService
+	jobQueue        *JobQueue
+	usageService    *UsageService
+	threadService   *ThreadService
+	settingsService *SettingsService
+	reindexer       *Reindexer
+	continuity      *ContinuityService
+	wikiDraft       *WikiDraftService
+	entities        *EntityService
+	summaries       *SummaryService
+}
+
+type askRequest struct {
+	Question  string            `json:"question" validate:"required,min=1,max=1000"`
+	CanonSafe bool              `json:"canonSafe"`
+	MaxChunks int               `json:"maxChunks"`
+	Filter    *RetrievalFilter  `json:"filter"`
+	Retrieval *RetrievalOptions `json:"retrieval"`
+	// IncludeSummaries adds stored chapter summaries up to
+	// filter.maxSortOrder as high-level context
+	IncludeSummaries bool `json:"includeSummaries"`
+	// NoCache calls the model even when an identical request was cached
+	NoCache bool `json:"noCache"`
+}
+
+type rewriteRequest struct {
+	// Tool is a built-in RewriteTool or the name of one of the project's custom tools
+	Tool        string `json:"tool" validate:"required,min=2,max=40"`
+	Text        string `json:"text" validate:"required,min=1,max=10000"`
+	Instruction string `json:"instruction" validate:"max=500"`
+	// UseContext pulls surrounding text, linked wiki pages and related
+	// passages into the prompt, within ContextTokens
+	UseContext    bool `json:"useContext"`
+	ContextTokens int  `json:"contextTokens" validate:"omitempty,min=100,max=8000"`
+	// NoCache calls the model even when an identical request was cached
+	NoCache bool `json:"noCache"`
+	// Candidates asks for several alternative rewrites with labels and tones
+	Candidates int `json:"candidates" validate:"omitempty,min=1,max=5"`
+}
+
+func (r askRequest) toAskRequest(projectID string) AskRequest {
+	req := AskRequest{
+		ProjectID:        projectID,
+		Question:         r.Question,
+		CanonSafe:        r.CanonSafe,
+		MaxChunks:        r.MaxChunks,
+		IncludeSummaries: r.IncludeSummaries,
+		NoCache:          r.NoCache,
+	}
+	if r.Filter != nil {
+		req.Filter = *r.Filter
+	}
+	if r.Retrieval != nil {
+		req.Retrieval = *r.Retrieval
+	}
+	return req
+}
+
+func (r rewriteRequest) toRewriteRequest(chapterID, userID string) RewriteRequest {
+	return RewriteRequest{
+		Tool:          RewriteTool(r.Tool),
+		Text:          r.Text,
+		Instruction:   r.Instruction,
+		UseContext:    r.UseContext,
+		ContextTokens: r.ContextTokens,
+		ChapterID:     chapterID,
+		UserID:        userID,
+		NoCache:       r.NoCache,
+		Candidates:    r.Candidates,
+	}
+}
+
+type updateSettingsRequest struct {
+	StyleGuide string              `json:"styleGuide" validate:"max=10000"`
+	Tools      []CustomRewriteTool `json:"tools" validate:"max=50,dive"`
+}
+
+type reindexRequest struct {
+	// Force re-embeds unchanged documents too
+	Force       bool `json:"force"`
+	Concurrency int  `json:"concurrency" validate:"omitempty,min=1,max=16"`
+}
+
+type continuityRequest struct {
+	// ContextTokens is the budget for wiki pages and earlier passages
+	ContextTokens int `json:"contextTokens" validate:"omitempty,min=500,max=16000"`
+}
+
+type wikiDraftRequest struct {
+	// ContextTokens is the budget for manuscript passages
+	ContextTokens int `json:"contextTokens" validate:"omitempty,min=500,max=16000"`
+}
+
+type entitiesRequest struct {
+	// UseModel adds a chat model pass that drops false positives and
+	// corrects page types
+	UseModel       bool `json:"useModel"`
+	MinOccurrences int  `json:"minOccurrences" validate:"omitempty,min=1,max=100"`
+}
+
+type createEntityPagesRequest struct {
+	Pages []NewWikiPage `json:"pages" validate:"required,min=1,max=100,dive"`
+}
+
+type summaryRequest struct {
+	// Force regenerates a summary that is still fresh
+	Force bool `json:"force"`
+}
+
+type storySoFarRequest struct {
+	// ThroughChapterID is the last chapter included; empty includes them all
+	ThroughChapterID string `json:"throughChapterId" validate:"omitempty,uuid"`
+	// Generate summarises chapters whose summary is missing or stale
+	Generate bool `json:"generate"`
+}
+
+type createThreadRequest struct {
+	Title string `json:"title" validate:"max=200"`
+}
+
+// HandlerDeps lists the services behind the AI endpoints. Any of them may be
+// nil when the provider it needs isn't configured; its endpoints then
+// respond 503.
+type HandlerDeps struct {
+	Ask        *AskService
+	Rewrite    *RewriteService
+	JobQueue   *JobQueue
+	Usage      *UsageService
+	Threads    *ThreadService
+	Settings   *SettingsService
+	Reindexer  *Reindexer
+	Continuity *ContinuityService
+	WikiDraft  *WikiDraftService
+	Entities   *EntityService
+	Summaries  *SummaryService
 }
 
-func NewHandler(askService *AskService, rewriteService *RewriteService) *Handler {
+func NewHandler(deps HandlerDeps) *Handler {
 	return &Handler{
-		askService:     askService,
-		rewriteService: rewriteService,
+		askService:      deps.Ask,
+		rewriteService:  deps.Rewrite,
+		jobQueue:        deps.JobQueue,
+		usageService:    deps.Usage,
+		threadService:   deps.Threads,
+		settingsService: deps.Settings,
+		reindexer:       deps.Reindexer,
+		continuity:      deps.Continuity,
+		wikiDraft:       deps.WikiDraft,
+		entities:        deps.Entities,
+		summaries:       deps.Summaries,
 	}
 }
 
@@ -28,11 +180,7 @@ func (h *Handler) Ask(c echo.Context) error {
 	projectID := c.Param("projectId")
 	userID := c.Get("user_id").(string)
 
-	var req struct {
-		Question   string `json:"question" validate:"required,min=1,max=1000"`
-		CanonSafe  bool   `json:"canonSafe"`
-		MaxChunks  int    `json:"maxChunks"`
-	}
+	var req askRequest
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
@@ -42,20 +190,27 @@ func (h *Handler) Ask(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	// TODO: Verify user owns this project (check projects service)
-	_ = userID
+	askReq, err := h.prepareAsk(c, projectID, userID, req)
+	if err != nil {
+		return err
+	}
+
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "ask"}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
 
 	println("DEBUG: Processing AI question for project:", projectID)
 	println("DEBUG: Question:", req.Question)
-	resp, err := h.askService.Ask(c.Request().Context(), projectID, req.Question, req.CanonSafe, req.MaxChunks)
+	start := time.Now()
+	resp, err := h.askService.Ask(c.Request().Context(), askReq)
+	h.recordUsage(c.Request().Context(), usage, start, askUsage(resp), err)
 	if err != nil {
 		println("ERROR: Ask service failed:", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to process question: "+err.Error())
+		return providerError(c, err, "failed to process question")
 	}
 	println("DEBUG: Successfully processed question")
 
-	// TODO: Log AI request to database for usage tracking
-
 	return c.JSON(http.StatusOK, resp)
 }
 
@@ -69,11 +224,7 @@ func (h *Handler) Rewrite(c echo.Context) error {
 	chapterID := c.Param("id")
 	userID := c.Get("user_id").(string)
 
-	var req struct {
-		Tool        string `json:"tool" validate:"required,oneof=rewrite expand tighten dialogue show_vs_tell summarize"`
-		Text        string `json:"text" validate:"required,min=1,max=10000"`
-		Instruction string `json:"instruction" validate:"max=500"`
-	}
+	var req rewriteRequest
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
@@ -83,22 +234,1121 @@ func (h *Handler) Rewrite(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	// TODO: Verify user owns this chapter
-	_ = chapterID
-	_ = userID
+	rewriteReq, err := h.prepareRewrite(c, chapterID, userID, req)
+	if err != nil {
+		return err
+	}
 
-	rewriteReq := RewriteRequest{
-		Tool:        RewriteTool(req.Tool),
-		Text:        req.Text,
-		Instruction: req.Instruction,
+	usage := UsageRecord{UserID: userID, ChapterID: chapterID, Tool: req.Tool}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
 	}
 
+	start := time.Now()
 	resp, err := h.rewriteService.Rewrite(c.Request().Context(), rewriteReq)
+	h.recordUsage(c.Request().Context(), usage, start, rewriteUsage(resp), err)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		return providerError(c, err, "failed to process rewrite")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// AskStream godoc
+// POST /api/projects/:projectId/ai/ask/stream
+// Streams the answer as Server-Sent Events: "delta" events with text
+// fragments, then a "done" event carrying the full AskResponse.
+func (h *Handler) AskStream(c echo.Context) error {
+	if h.askService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req askRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	askReq, err := h.prepareAsk(c, projectID, userID, req)
+	if err != nil {
+		return err
+	}
+
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "ask"}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	// The request context is cancelled when the client disconnects, which
+	// aborts the upstream model call
+	ctx := c.Request().Context()
+	stream := newSSEWriter(c)
+
+	start := time.Now()
+	resp, err := h.askService.AskStream(ctx, askReq, stream.Delta)
+	h.recordUsage(ctx, usage, start, askUsage(resp), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("ask stream failed: %v", err)
+		return providerStreamError(stream, err, "failed to process question")
+	}
+
+	return stream.Send(SSEEventDone, resp)
+}
+
+// RewriteStream godoc
+// POST /api/chapters/:id/ai/rewrite/stream
+// Streams the rewrite as Server-Sent Events: "delta" events with text
+// fragments, then a "done" event carrying the full RewriteResponse.
+func (h *Handler) RewriteStream(c echo.Context) error {
+	if h.rewriteService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	chapterID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	var req rewriteRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	rewriteReq, err := h.prepareRewrite(c, chapterID, userID, req)
+	if err != nil {
+		return err
+	}
+
+	usage := UsageRecord{UserID: userID, ChapterID: chapterID, Tool: req.Tool}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	stream := newSSEWriter(c)
+
+	start := time.Now()
+	resp, err := h.rewriteService.RewriteStream(ctx, rewriteReq, stream.Delta)
+	h.recordUsage(ctx, usage, start, rewriteUsage(resp), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			return stream.Error("chapter not found")
+		}
+		return providerStreamError(stream, err, "failed to process rewrite")
+	}
+
+	return stream.Send(SSEEventDone, resp)
+}
+
+// ContinuityCheck godoc
+// POST /api/chapters/:id/ai/continuity
+// Checks the chapter against its linked wiki pages and earlier chapters and
+// responds with the suspected contradictions.
+func (h *Handler) ContinuityCheck(c echo.Context) error {
+	if h.continuity == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	chapterID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	var req continuityRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	usage := UsageRecord{UserID: userID, ChapterID: chapterID, Tool: "continuity"}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := h.continuity.Check(c.Request().Context(), ContinuityRequest{
+		ChapterID:     chapterID,
+		UserID:        userID,
+		ContextTokens: req.ContextTokens,
+	})
+	h.recordUsage(c.Request().Context(), usage, start, continuityUsage(resp), err)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		return providerError(c, err, "failed to check continuity")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// DraftWikiPage godoc
+// POST /api/wiki/:id/ai/draft
+// Drafts the page from the chapters that mention it, or refreshes existing
+// content. Nothing is saved: the response carries the proposed content, a
+// line diff against the current content and citations for the author to
+// review. The ETag is the page version the draft was made from; accepting
+// the draft is a PATCH /api/wiki/:id conditional on it with If-Match.
+func (h *Handler) DraftWikiPage(c echo.Context) error {
+	if h.wikiDraft == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	pageID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	var req wikiDraftRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	draftReq := WikiDraftRequest{PageID: pageID, UserID: userID, ContextTokens: req.ContextTokens}
+	usage := UsageRecord{UserID: userID, Tool: "wiki_draft"}
+	if h.settingsService != nil {
+		settings, err := h.settingsService.ForWikiPage(c.Request().Context(), pageID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "wiki page not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+		}
+		draftReq.StyleGuide = settings.StyleGuide
+		usage.ProjectID = settings.ProjectID
+	}
+
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := h.wikiDraft.Draft(c.Request().Context(), draftReq)
+	h.recordUsage(c.Request().Context(), usage, start, wikiDraftUsage(resp), err)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "wiki page not found")
+		case errors.Is(err, ErrNoMentions):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "no chapter mentions this page yet")
+		}
+		return providerError(c, err, "failed to draft wiki page")
+	}
+
+	versioning.SetETag(c, resp.Version)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ExtractChapterEntities godoc
+// POST /api/chapters/:id/ai/entities
+// Suggests wiki pages for the names a chapter uses that have no page yet
+func (h *Handler) ExtractChapterEntities(c echo.Context) error {
+	if h.entities == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	chapterID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	var req entitiesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	opts := EntityOptions{UseModel: req.UseModel, MinOccurrences: req.MinOccurrences}
+	usage := UsageRecord{UserID: userID, ChapterID: chapterID, Tool: "entities"}
+	if req.UseModel && h.settingsService != nil {
+		settings, err := h.settingsService.ForChapter(c.Request().Context(), chapterID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+		}
+		opts.StyleGuide = settings.StyleGuide
+		usage.ProjectID = settings.ProjectID
+	}
+
+	return h.extractEntities(c, usage, opts, func(ctx context.Context) (*EntityExtractionResponse, error) {
+		return h.entities.ExtractFromChapter(ctx, chapterID, userID, opts)
+	})
+}
+
+// ExtractProjectEntities godoc
+// POST /api/projects/:projectId/ai/entities
+// Suggests wiki pages for the names used across a project's chapters that
+// have no page yet
+func (h *Handler) ExtractProjectEntities(c echo.Context) error {
+	if h.entities == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req entitiesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	opts := EntityOptions{UseModel: req.UseModel, MinOccurrences: req.MinOccurrences}
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "entities"}
+	if req.UseModel && h.settingsService != nil {
+		settings, err := h.settingsService.ForProject(c.Request().Context(), projectID, userID)
+		if err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				return echo.NewHTTPError(http.StatusForbidden, "access denied")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+		}
+		opts.StyleGuide = settings.StyleGuide
+	}
+
+	return h.extractEntities(c, usage, opts, func(ctx context.Context) (*EntityExtractionResponse, error) {
+		return h.entities.ExtractFromProject(ctx, projectID, userID, opts)
+	})
+}
+
+// extractEntities runs an extraction, checking the budget and recording usage
+// only when the model pass is asked for
+func (h *Handler) extractEntities(c echo.Context, usage UsageRecord, opts EntityOptions, extract func(ctx context.Context) (*EntityExtractionResponse, error)) error {
+	if opts.UseModel {
+		if ok, err := h.checkBudget(c, usage); !ok {
+			return err
+		}
+	}
+
+	start := time.Now()
+	resp, err := extract(c.Request().Context())
+	if opts.UseModel && !errors.Is(err, ErrModelUnavailable) {
+		h.recordUsage(c.Request().Context(), usage, start, entitiesUsage(resp), err)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		case errors.Is(err, ErrUnauthorized):
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		case errors.Is(err, ErrModelUnavailable):
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "AI chat provider not configured")
+		}
+		return providerError(c, err, "failed to extract entities")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// CreateEntityPages godoc
+// POST /api/projects/:projectId/ai/entities/create
+// Creates empty wiki pages for accepted suggestions. Titles whose page
+// already exists are reported as skipped.
+func (h *Handler) CreateEntityPages(c echo.Context) error {
+	if h.entities == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req createEntityPagesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	result, err := h.entities.CreatePages(c.Request().Context(), projectID, userID, req.Pages)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create wiki pages")
+	}
+
+	return c.JSON(http.StatusCreated, result)
+}
+
+// GetChapterSummary godoc
+// GET /api/chapters/:id/ai/summary
+// Returns the stored summary, flagged stale when the chapter has changed
+// since it was summarised
+func (h *Handler) GetChapterSummary(c echo.Context) error {
+	if h.summaries == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	chapterID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	summary, err := h.summaries.Get(c.Request().Context(), chapterID, userID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to process rewrite")
+		if errors.Is(err, ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get chapter summary")
+	}
+
+	return c.JSON(http.StatusOK, summary)
+}
+
+// SummarizeChapter godoc
+// POST /api/chapters/:id/ai/summary
+// Summarises the chapter unless its stored summary is still fresh
+func (h *Handler) SummarizeChapter(c echo.Context) error {
+	if h.summaries == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	chapterID := c.Param("id")
+	userID := c.Get("user_id").(string)
+
+	var req summaryRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	styleGuide := ""
+	usage := UsageRecord{UserID: userID, ChapterID: chapterID, Tool: "summary"}
+	if h.settingsService != nil {
+		settings, err := h.settingsService.ForChapter(c.Request().Context(), chapterID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+		}
+		styleGuide = settings.StyleGuide
+		usage.ProjectID = settings.ProjectID
+	}
+
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
 	}
 
-	// TODO: Log AI request to database for usage tracking
+	start := time.Now()
+	resp, err := h.summaries.Summarize(c.Request().Context(), chapterID, userID, styleGuide, req.Force)
+	if resp != nil && resp.Generated {
+		h.recordUsage(c.Request().Context(), usage, start, summaryUsage(resp), err)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		case errors.Is(err, ErrEmptyChapter):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "chapter is empty")
+		}
+		return providerError(c, err, "failed to summarise chapter")
+	}
 
 	return c.JSON(http.StatusOK, resp)
 }
+
+// StorySoFar godoc
+// POST /api/projects/:projectId/ai/story-so-far
+// Composes the chapter summaries in manuscript order up to a chapter,
+// optionally summarising chapters whose summary is missing or stale first
+func (h *Handler) StorySoFar(c echo.Context) error {
+	if h.summaries == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req storySoFarRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	storyReq := StorySoFarRequest{
+		ProjectID:        projectID,
+		UserID:           userID,
+		ThroughChapterID: req.ThroughChapterID,
+		Generate:         req.Generate,
+	}
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "summary"}
+	if req.Generate {
+		if h.settingsService != nil {
+			settings, err := h.settingsService.ForProject(c.Request().Context(), projectID, userID)
+			if err != nil {
+				if errors.Is(err, ErrUnauthorized) {
+					return echo.NewHTTPError(http.StatusForbidden, "access denied")
+				}
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+			}
+			storyReq.StyleGuide = settings.StyleGuide
+		}
+
+		if ok, err := h.checkBudget(c, usage); !ok {
+			return err
+		}
+	}
+
+	start := time.Now()
+	resp, err := h.summaries.StorySoFar(c.Request().Context(), storyReq)
+	if resp != nil && resp.Model != "" {
+		h.recordUsage(c.Request().Context(), usage, start, storySoFarUsage(resp), err)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		case errors.Is(err, ErrNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		return providerError(c, err, "failed to summarise chapters")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// IndexStatus godoc
+// GET /api/projects/:projectId/ai/index-status
+func (h *Handler) IndexStatus(c echo.Context) error {
+	if h.jobQueue == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI indexing not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	status, err := h.jobQueue.IndexStatus(c.Request().Context(), projectID, userID)
+	if err != nil {
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get index status")
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// Reindex godoc
+// POST /api/projects/:projectId/ai/reindex
+// Chunks and embeds every chapter and wiki page in the project, skipping
+// documents whose content and embedding model are unchanged unless force is
+// set. Responds with the totals once every document is done.
+func (h *Handler) Reindex(c echo.Context) error {
+	if h.reindexer == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI indexing not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req reindexRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	result, err := h.reindexer.ReindexProject(c.Request().Context(), projectID, userID, ReindexOptions{
+		Concurrency: req.Concurrency,
+		Force:       req.Force,
+	})
+	if err != nil {
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		log.Printf("reindex of project %s failed: %v", projectID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reindex project")
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// ReindexStream godoc
+// POST /api/projects/:projectId/ai/reindex/stream
+// Reindexes like Reindex, streaming a "progress" event after each document
+// and a "done" event carrying the ReindexResult.
+func (h *Handler) ReindexStream(c echo.Context) error {
+	if h.reindexer == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI indexing not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req reindexRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	if err := h.reindexer.VerifyAccess(ctx, projectID, userID); err != nil {
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reindex project")
+	}
+
+	// Disconnecting cancels the run; documents already done stay indexed
+	stream := newSSEWriter(c)
+	result, err := h.reindexer.Reindex(ctx, projectID, ReindexOptions{
+		Concurrency: req.Concurrency,
+		Force:       req.Force,
+		OnProgress: func(progress ReindexProgress) {
+			stream.Send(SSEEventProgress, progress)
+		},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("reindex stream of project %s failed: %v", projectID, err)
+		return stream.Error("failed to reindex project")
+	}
+
+	return stream.Send(SSEEventDone, result)
+}
+
+// CreateThread godoc
+// POST /api/projects/:projectId/ai/threads
+func (h *Handler) CreateThread(c echo.Context) error {
+	if h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req createThreadRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	thread, err := h.threadService.CreateThread(c.Request().Context(), projectID, userID, req.Title)
+	if err != nil {
+		return threadError(err, "failed to create thread")
+	}
+
+	return c.JSON(http.StatusCreated, thread)
+}
+
+// ListThreads godoc
+// GET /api/projects/:projectId/ai/threads
+func (h *Handler) ListThreads(c echo.Context) error {
+	if h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	threads, err := h.threadService.ListThreads(c.Request().Context(), projectID, userID)
+	if err != nil {
+		return threadError(err, "failed to list threads")
+	}
+
+	return c.JSON(http.StatusOK, threads)
+}
+
+// GetThread godoc
+// GET /api/projects/:projectId/ai/threads/:threadId
+func (h *Handler) GetThread(c echo.Context) error {
+	if h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	threadID := c.Param("threadId")
+	userID := c.Get("user_id").(string)
+
+	thread, err := h.threadService.GetThread(c.Request().Context(), projectID, threadID, userID)
+	if err != nil {
+		return threadError(err, "failed to get thread")
+	}
+
+	return c.JSON(http.StatusOK, thread)
+}
+
+// DeleteThread godoc
+// DELETE /api/projects/:projectId/ai/threads/:threadId
+func (h *Handler) DeleteThread(c echo.Context) error {
+	if h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	threadID := c.Param("threadId")
+	userID := c.Get("user_id").(string)
+
+	if err := h.threadService.DeleteThread(c.Request().Context(), projectID, threadID, userID); err != nil {
+		return threadError(err, "failed to delete thread")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AskInThread godoc
+// POST /api/projects/:projectId/ai/threads/:threadId/messages
+// Answers a follow-up question using the thread's earlier turns and appends
+// both the question and the answer to the thread.
+func (h *Handler) AskInThread(c echo.Context) error {
+	if h.askService == nil || h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	threadID := c.Param("threadId")
+	userID := c.Get("user_id").(string)
+
+	var req askRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	history, err := h.threadService.History(ctx, projectID, threadID, userID)
+	if err != nil {
+		return threadError(err, "failed to get thread")
+	}
+
+	askReq, err := h.prepareAsk(c, projectID, userID, req)
+	if err != nil {
+		return err
+	}
+	askReq.History = history
+
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "ask"}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := h.askService.Ask(ctx, askReq)
+	h.recordUsage(ctx, usage, start, askUsage(resp), err)
+	if err != nil {
+		return providerError(c, err, "failed to process question")
+	}
+
+	msg, err := h.threadService.AppendExchange(ctx, threadID, req.Question, start, resp)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save thread messages")
+	}
+
+	return c.JSON(http.StatusOK, ThreadAskResponse{AskResponse: *resp, ThreadID: threadID, MessageID: msg.ID})
+}
+
+// AskInThreadStream godoc
+// POST /api/projects/:projectId/ai/threads/:threadId/messages/stream
+// Streams a thread answer like AskStream; the "done" event carries a
+// ThreadAskResponse once the exchange has been saved.
+func (h *Handler) AskInThreadStream(c echo.Context) error {
+	if h.askService == nil || h.threadService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	threadID := c.Param("threadId")
+	userID := c.Get("user_id").(string)
+
+	var req askRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	history, err := h.threadService.History(ctx, projectID, threadID, userID)
+	if err != nil {
+		return threadError(err, "failed to get thread")
+	}
+
+	askReq, err := h.prepareAsk(c, projectID, userID, req)
+	if err != nil {
+		return err
+	}
+	askReq.History = history
+
+	usage := UsageRecord{UserID: userID, ProjectID: projectID, Tool: "ask"}
+	if ok, err := h.checkBudget(c, usage); !ok {
+		return err
+	}
+
+	stream := newSSEWriter(c)
+
+	start := time.Now()
+	resp, err := h.askService.AskStream(ctx, askReq, stream.Delta)
+	h.recordUsage(ctx, usage, start, askUsage(resp), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return providerStreamError(stream, err, "failed to process question")
+	}
+
+	msg, err := h.threadService.AppendExchange(ctx, threadID, req.Question, start, resp)
+	if err != nil {
+		return stream.Error("failed to save thread messages")
+	}
+
+	return stream.Send(SSEEventDone, ThreadAskResponse{AskResponse: *resp, ThreadID: threadID, MessageID: msg.ID})
+}
+
+// GetSettings godoc
+// GET /api/projects/:projectId/ai/settings
+func (h *Handler) GetSettings(c echo.Context) error {
+	if h.settingsService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	settings, err := h.settingsService.ForProject(c.Request().Context(), projectID, userID)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings godoc
+// PUT /api/projects/:projectId/ai/settings
+// Replaces the style guide and the full list of custom rewrite tools.
+func (h *Handler) UpdateSettings(c echo.Context) error {
+	if h.settingsService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	projectID := c.Param("projectId")
+	userID := c.Get("user_id").(string)
+
+	var req updateSettingsRequest
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	settings, err := h.settingsService.Update(c.Request().Context(), projectID, userID, ProjectAISettings{
+		StyleGuide: req.StyleGuide,
+		Tools:      req.Tools,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		case errors.Is(err, ErrInvalidSettings):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save AI settings")
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// Usage godoc
+// GET /api/me/ai-usage?from=YYYY-MM-DD&to=YYYY-MM-DD
+// Defaults to the current month; "to" is inclusive.
+func (h *Handler) Usage(c echo.Context) error {
+	if h.usageService == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "AI services not configured")
+	}
+
+	userID := c.Get("user_id").(string)
+
+	from, to := monthBounds(time.Now())
+	if value := c.QueryParam("from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid from date")
+		}
+		from = parsed
+	}
+	if value := c.QueryParam("to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid to date")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return echo.NewHTTPError(http.StatusBadRequest, "to must not be before from")
+	}
+
+	report, err := h.usageService.Report(c.Request().Context(), userID, from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI usage")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// prepareAsk builds the service request for an Ask endpoint, applying the
+// project's AI settings. It checks the user owns the project.
+func (h *Handler) prepareAsk(c echo.Context, projectID, userID string, req askRequest) (AskRequest, error) {
+	askReq := req.toAskRequest(projectID)
+	if h.settingsService == nil {
+		return askReq, nil
+	}
+
+	settings, err := h.settingsService.ForProject(c.Request().Context(), projectID, userID)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return askReq, echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return askReq, echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+	}
+	askReq.StyleGuide = settings.StyleGuide
+
+	return askReq, nil
+}
+
+// prepareRewrite builds the service request for a rewrite endpoint, resolving
+// the tool against the built-in and project tools. It checks the user owns
+// the chapter.
+func (h *Handler) prepareRewrite(c echo.Context, chapterID, userID string, req rewriteRequest) (RewriteRequest, error) {
+	rewriteReq := req.toRewriteRequest(chapterID, userID)
+
+	var settings *ProjectAISettings
+	if h.settingsService != nil {
+		var err error
+		settings, err = h.settingsService.ForChapter(c.Request().Context(), chapterID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return rewriteReq, echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+			}
+			return rewriteReq, echo.NewHTTPError(http.StatusInternalServerError, "failed to get AI settings")
+		}
+		rewriteReq.StyleGuide = settings.StyleGuide
+	}
+
+	custom, ok := settings.RewriteTool(req.Tool)
+	if !ok {
+		return rewriteReq, echo.NewHTTPError(http.StatusBadRequest, "unknown rewrite tool: "+req.Tool)
+	}
+	rewriteReq.CustomTool = custom
+
+	return rewriteReq, nil
+}
+
+// threadError maps thread service errors to HTTP errors
+func threadError(err error, message string) error {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	case errors.Is(err, ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "thread not found")
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, message)
+	}
+}
+
+// providerStatus maps a failed model call to an HTTP status and message.
+// Errors that aren't the provider's fall back to a 500 with message.
+func providerStatus(err error, message string) (int, string) {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests, "the AI provider is rate limiting requests, try again shortly"
+	case errors.Is(err, ErrContextLengthExceeded):
+		return http.StatusRequestEntityTooLarge, "text is too long for the AI model"
+	case errors.Is(err, ErrAuthFailed):
+		return http.StatusBadGateway, "the AI provider rejected the configured credentials"
+	case errors.Is(err, ErrProviderUnavailable):
+		return http.StatusServiceUnavailable, "the AI provider is unavailable, try again shortly"
+	case errors.Is(err, ErrInvalidModelOutput):
+		return http.StatusBadGateway, "the AI model returned a malformed response, try again"
+	default:
+		return http.StatusInternalServerError, message
+	}
+}
+
+// providerError maps a failed model call to an HTTP error, passing on the
+// provider's Retry-After when it is rate limiting us
+func providerError(c echo.Context, err error, message string) error {
+	status, message := providerStatus(err, message)
+	var apiErr *APIError
+	if status == http.StatusTooManyRequests && errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(apiErr.RetryAfter.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	return echo.NewHTTPError(status, message)
+}
+
+// providerStreamError ends a stream after a failed model call. The stream has
+// already sent a 200, so the status it would have had goes in the event.
+func providerStreamError(stream *sseWriter, err error, message string) error {
+	status, message := providerStatus(err, message)
+	return stream.Send(SSEEventError, map[string]interface{}{"message": message, "status": status})
+}
+
+// tokenUsage is the part of a tool response that goes into the usage ledger
+type tokenUsage struct {
+	Model     string
+	TokensIn  int
+	TokensOut int
+	Cached    bool
+}
+
+func askUsage(resp *AskResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut, Cached: resp.Cached}
+}
+
+func rewriteUsage(resp *RewriteResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut, Cached: resp.Cached}
+}
+
+func continuityUsage(resp *ContinuityResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut}
+}
+
+func wikiDraftUsage(resp *WikiDraftResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut}
+}
+
+func entitiesUsage(resp *EntityExtractionResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut}
+}
+
+func summaryUsage(resp *SummaryResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut}
+}
+
+func storySoFarUsage(resp *StorySoFarResponse) tokenUsage {
+	if resp == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{Model: resp.Model, TokensIn: resp.TokensIn, TokensOut: resp.TokensOut}
+}
+
+// checkBudget reports whether the user may make another AI call. When they
+// may not, the budget error has already been written to the response and the
+// returned error is what the handler should return.
+func (h *Handler) checkBudget(c echo.Context, record UsageRecord) (bool, error) {
+	if h.usageService == nil {
+		return true, nil
+	}
+
+	status, err := h.usageService.CheckBudget(c.Request().Context(), record.UserID)
+	if errors.Is(err, ErrBudgetExceeded) {
+		record.Outcome = OutcomeBudgetExceeded
+		h.writeUsage(record)
+		return false, apierrors.BudgetExceeded(c, "monthly AI token budget exceeded", map[string]interface{}{
+			"limit":    status.Limit,
+			"used":     status.Used,
+			"resetsAt": status.PeriodEnd,
+		})
+	}
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "failed to check AI budget")
+	}
+
+	return true, nil
+}
+
+// recordUsage writes the outcome of an AI call to the usage ledger
+func (h *Handler) recordUsage(ctx context.Context, record UsageRecord, start time.Time, usage tokenUsage, callErr error) {
+	if h.usageService == nil {
+		return
+	}
+
+	record.Latency = time.Since(start)
+	record.Model = usage.Model
+	record.TokensIn = usage.TokensIn
+	record.TokensOut = usage.TokensOut
+	record.Cached = usage.Cached
+	switch {
+	case callErr == nil:
+		record.Outcome = OutcomeSuccess
+	case ctx.Err() != nil:
+		record.Outcome = OutcomeCancelled
+	default:
+		record.Outcome = OutcomeError
+		record.Error = callErr.Error()
+	}
+
+	h.writeUsage(record)
+}
+
+func (h *Handler) writeUsage(record UsageRecord) {
+	// The request context may already be cancelled (client disconnected)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.usageService.Record(ctx, record); err != nil {
+		log.Printf("failed to record AI usage: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of provider failure. Provider calls return an *APIError wrapping one
+// of these, so callers can branch with errors.Is.
+var (
+	ErrRateLimited           = errors.New("AI provider rate limit exceeded")
+	ErrContextLengthExceeded = errors.New("request exceeds the model's context length")
+	ErrAuthFailed            = errors.New("AI provider rejected the credentials")
+	ErrProviderUnavailable   = errors.New("AI provider unavailable")
+	ErrProviderRequest       = errors.New("AI provider rejected the request")
+)
+
+// APIError is a failed call to a model provider
+type APIError struct {
+	Provider   string
+	StatusCode int // 0 when no response was received
+	Message    string
+	// RetryAfter is how long the provider asked us to wait, if it said
+	RetryAfter time.Duration
+	Kind       error
+
+	retryable bool
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s request failed: %s", e.Provider, e.Message)
+	}
+	return fmt.Sprintf("%s API error (status %d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// ClientOptions configures the HTTP client shared by the model providers
+type ClientOptions struct {
+	// Timeout bounds each attempt of a non-streaming call, body included
+	Timeout time.Duration
+	// StreamTimeout bounds how long a streaming call waits for the response
+	// headers; the stream itself may run as long as it needs
+	StreamTimeout time.Duration
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries  int
+	BaseBackoff time.Duration // Upper bound of the first retry delay, doubled on each retry
+	MaxBackoff  time.Duration
+	// MaxRetryAfter caps how long a Retry-After header may make us wait;
+	// longer waits fail straight away
+	MaxRetryAfter time.Duration
+}
+
+func (o *ClientOptions) setDefaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = 120 * time.Second
+	}
+	if o.StreamTimeout <= 0 {
+		o.StreamTimeout = 60 * time.Second
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.BaseBackoff <= 0 {
+		o.BaseBackoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 20 * time.Second
+	}
+	if o.MaxRetryAfter <= 0 {
+		o.MaxRetryAfter = 60 * time.Second
+	}
+}
+
+// DefaultClientOptions are used until a provider is given its configured options
+func DefaultClientOptions() ClientOptions {
+	opts := ClientOptions{MaxRetries: 3}
+	opts.setDefaults()
+	return opts
+}
+
+// apiClient posts JSON to a model provider, retrying rate limits, server
+// errors and network failures with jittered exponential backoff
+type apiClient struct {
+	provider     string
+	opts         ClientOptions
+	client       *http.Client
+	streamClient *http.Client
+	// sleep waits between attempts; tests replace it
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newAPIClient(provider string, opts ClientOptions) *apiClient {
+	opts.setDefaults()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = opts.StreamTimeout
+
+	return &apiClient{
+		provider:     provider,
+		opts:         opts,
+		client:       &http.Client{Timeout: opts.Timeout},
+		streamClient: &http.Client{Transport: transport},
+		sleep:        sleepContext,
+	}
+}
+
+// post sends body as JSON and returns the successful response, which the
+// caller must close. Only the request is retried: once a response is
+// returned its body is the caller's, so streams are never replayed.
+func (c *apiClient) post(ctx context.Context, url string, header http.Header, body interface{}, stream bool) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := c.client
+	if stream {
+		client = c.streamClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header = header.Clone()
+		req.Header.Set("Content-Type", "application/json")
+
+		var apiErr *APIError
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			apiErr = &APIError{Provider: c.provider, Message: err.Error(), Kind: ErrProviderUnavailable, retryable: true}
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return resp, nil
+		default:
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			apiErr = classifyResponse(c.provider, resp, data)
+		}
+
+		if !apiErr.retryable || attempt >= c.opts.MaxRetries || apiErr.RetryAfter > c.opts.MaxRetryAfter {
+			return nil, apiErr
+		}
+
+		delay := c.backoff(attempt, apiErr.RetryAfter)
+		log.Printf("%s: %v; retrying in %s (retry %d of %d)", c.provider, apiErr, delay.Round(time.Millisecond), attempt+1, c.opts.MaxRetries)
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt+1. A Retry-After
+// from the provider wins; otherwise the delay is drawn from the upper half
+// of an exponentially growing window, so concurrent clients spread out.
+func (c *apiClient) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter + rand.N(c.opts.BaseBackoff/4+1)
+	}
+
+	window := c.opts.BaseBackoff
+	for i := 0; i < attempt && window < c.opts.MaxBackoff; i++ {
+		window *= 2
+	}
+	if window > c.opts.MaxBackoff {
+		window = c.opts.MaxBackoff
+	}
+	return window/2 + rand.N(window/2+1)
+}
+
+// classifyResponse turns an error response into an APIError of the right kind
+func classifyResponse(provider string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+		Kind:       ErrProviderRequest,
+	}
+
+	// OpenAI and Anthropic both nest the details under "error"
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &parsed)
+	apiErr.Message = parsed.Error.Message
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if len(apiErr.Message) > 500 {
+			apiErr.Message = apiErr.Message[:500] + "…"
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = resp.Status
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = ErrAuthFailed
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = ErrRateLimited
+		// An exhausted quota won't recover by waiting
+		apiErr.retryable = parsed.Error.Code != "insufficient_quota"
+	case resp.StatusCode == http.StatusRequestEntityTooLarge || isContextLengthError(parsed.Error.Code, apiErr.Message):
+		apiErr.Kind = ErrContextLengthExceeded
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		// Includes Anthropic's 529 "overloaded"
+		apiErr.Kind = ErrProviderUnavailable
+		apiErr.retryable = true
+	}
+
+	return apiErr
+}
+
+func isContextLengthError(code, message string) bool {
+	if code == "context_length_exceeded" {
+		return true
+	}
+	message = strings.ToLower(message)
+	for _, phrase := range []string{"context length", "context window", "prompt is too long", "too many tokens"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads OpenAI's retry-after-ms or the standard Retry-After
+// header, in seconds or as an HTTP date. It returns 0 when neither is set.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,189 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testChatService returns a chat provider for server that records retry
+// delays instead of sleeping
+func testChatService(server *httptest.Server, delays *[]time.Duration) *ChatService {
+	service := NewChatService("sk-test", server.URL, "m")
+	service.client.sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	return service
+}
+
+func TestAPIClient_RetriesRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"Rate limit reached","code":"rate_limit_exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"x","choices":[{"message":{"role":"assistant","content":"hi"}}]}`))
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	resp, err := testChatService(server, &delays).CreateChatCompletion(context.Background(), []ChatMessage{{Role: "user", Content: "hello"}}, 0, 10)
+
+	require.NoError(t, err)
+	assert.Equal(t, "hi", resp.Choices[0].Message.Content)
+	assert.Equal(t, int32(2), calls.Load())
+	require.Len(t, delays, 1)
+	assert.GreaterOrEqual(t, delays[0], 2*time.Second, "Retry-After is honoured")
+}
+
+func TestAPIClient_GivesUp(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		calls  int32
+		kind   error
+	}{
+		{"auth is not retried", http.StatusUnauthorized, `{"error":{"message":"Incorrect API key"}}`, 1, ErrAuthFailed},
+		{"context length is not retried", http.StatusBadRequest, `{"error":{"message":"This model's maximum context length is 8192 tokens","code":"context_length_exceeded"}}`, 1, ErrContextLengthExceeded},
+		{"exhausted quota is not retried", http.StatusTooManyRequests, `{"error":{"message":"You exceeded your current quota","code":"insufficient_quota"}}`, 1, ErrRateLimited},
+		{"server errors are retried", http.StatusServiceUnavailable, `upstream connect error`, 4, ErrProviderUnavailable},
+		{"anthropic overload is retried", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, 4, ErrProviderUnavailable},
+		{"other client errors are not retried", http.StatusBadRequest, `{"error":{"message":"Invalid value for temperature"}}`, 1, ErrProviderRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			_, err := testChatService(server, &delays).CreateChatCompletion(context.Background(), []ChatMessage{{Role: "user", Content: "hello"}}, 0, 10)
+
+			assert.ErrorIs(t, err, tt.kind)
+			assert.Equal(t, tt.calls, calls.Load())
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+		})
+	}
+}
+
+func TestAPIClient_Backoff(t *testing.T) {
+	client := newAPIClient("test", ClientOptions{BaseBackoff: time.Second, MaxBackoff: 4 * time.Second})
+
+	for attempt, window := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := client.backoff(attempt, 0)
+			assert.GreaterOrEqual(t, delay, window/2)
+			assert.LessOrEqual(t, delay, window)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter(header(), now))
+	assert.Equal(t, 3*time.Second, parseRetryAfter(header("Retry-After", "3"), now))
+	assert.Equal(t, 1500*time.Millisecond, parseRetryAfter(header("Retry-After", "3", "Retry-After-Ms", "1500"), now))
+	assert.Equal(t, 10*time.Second, parseRetryAfter(header("Retry-After", now.Add(10*time.Second).Format(http.TimeFormat)), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(header("Retry-After", "soon"), now))
+}
+
+func TestEmbeddingsService_Batches(t *testing.T) {
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req embeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sizes = append(sizes, len(req.Input))
+
+		var resp embeddingResponse
+		resp.Data = make([]struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}, len(req.Input))
+		for i, input := range req.Input {
+			resp.Data[i].Embedding = []float32{float32(len(input)), 0, 0}
+			resp.Data[i].Index = i
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	texts := make([]string, maxEmbeddingInputs+5)
+	for i := range texts {
+		texts[i] = strings.Repeat("a", i%7+1)
+	}
+
+	embedder := NewEmbeddingsService("", server.URL, "nomic-embed-text", 3)
+	embeddings, err := embedder.GenerateEmbeddings(context.Background(), texts)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int{maxEmbeddingInputs, 5}, sizes)
+	require.Len(t, embeddings, len(texts))
+	for i, embedding := range embeddings {
+		assert.Equal(t, float32(len(texts[i])), embedding[0], "embedding %d is out of order", i)
+	}
+}
+
+func TestBatchInputs(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	n := estimateTokens(long)
+
+	assert.Empty(t, batchInputs(nil, 10, 1000))
+	assert.Equal(t, []inputRange{{0, 2}, {2, 4}, {4, 5}}, batchInputs([]string{"a", "b", "c", "d", "e"}, 2, 1000))
+	assert.Equal(t, []inputRange{{0, 2}, {2, 3}}, batchInputs([]string{long, long, long}, 10, 2*n))
+	assert.Equal(t, []inputRange{{0, 1}, {1, 2}}, batchInputs([]string{long, "a"}, 10, n/2), "an oversized input gets its own batch")
+}
+
+func TestProviderError(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{&APIError{StatusCode: 429, Kind: ErrRateLimited, RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "2"},
+		{&APIError{StatusCode: 400, Kind: ErrContextLengthExceeded}, http.StatusRequestEntityTooLarge, ""},
+		{&APIError{StatusCode: 401, Kind: ErrAuthFailed}, http.StatusBadGateway, ""},
+		{&APIError{Kind: ErrProviderUnavailable}, http.StatusServiceUnavailable, ""},
+		{errors.New("failed to search chunks"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+		err := providerError(c, tt.err, "failed to process question")
+
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(err, &httpErr))
+		assert.Equal(t, tt.status, httpErr.Code, tt.err.Error())
+		assert.Equal(t, tt.retryAfter, c.Response().Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,141 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Per-document index states reported by IndexStatus
+const (
+	IndexStateIndexed = "indexed"  // Embedded content matches the source
+	IndexStateStale   = "stale"    // Source or embedding model changed (or never indexed) and no job is queued
+	IndexStatePending = "pending"  // Waiting in the queue
+	IndexStateRunning = "running"  // Being processed now
+	IndexStateRetry   = "retrying" // Failed at least once, waiting for the next attempt
+	IndexStateFailed  = "failed"   // Dead-lettered after exhausting its attempts
+)
+
+type DocumentIndexStatus struct {
+	SourceType    string     `json:"sourceType"`
+	SourceID      string     `json:"sourceId"`
+	Title         string     `json:"title"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+type IndexStatus struct {
+	Indexed   int                   `json:"indexed"`
+	Stale     int                   `json:"stale"`
+	Pending   int                   `json:"pending"`
+	Running   int                   `json:"running"`
+	Retrying  int                   `json:"retrying"`
+	Failed    int                   `json:"failed"`
+	Documents []DocumentIndexStatus `json:"documents"`
+}
+
+type jobState struct {
+	status    string
+	attempts  int
+	lastError string
+	runAt     time.Time
+}
+
+// IndexStatus reports the embedding state of every chapter and wiki page in a project
+func (q *JobQueue) IndexStatus(ctx context.Context, projectID, userID string) (*IndexStatus, error) {
+	if err := verifyProjectOwnership(ctx, q.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	// Jobs by source; a running job outranks a pending one, which outranks a dead one
+	rank := map[string]int{JobStatusRunning: 3, JobStatusPending: 2, JobStatusDead: 1}
+	jobs := make(map[string]jobState)
+
+	rows, err := q.db.Query(ctx, `
+		SELECT source_type, source_id, status, attempts, last_error, run_at
+		FROM embedding_jobs
+		WHERE project_id = $1
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list embedding jobs: %w", err)
+	}
+	for rows.Next() {
+		var sourceType, sourceID string
+		var job jobState
+		if err := rows.Scan(&sourceType, &sourceID, &job.status, &job.attempts, &job.lastError, &job.runAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan embedding job: %w", err)
+		}
+		key := sourceType + ":" + sourceID
+		if existing, ok := jobs[key]; !ok || rank[job.status] > rank[existing.status] {
+			jobs[key] = job
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list embedding jobs: %w", err)
+	}
+
+	rows, err = q.db.Query(ctx, `
+		SELECT s.source_type, s.id, s.title,
+		       s.hash = d.content_hash AND d.embedding_model = $2 AND d.embedding_dimensions = $3
+		FROM (
+			SELECT 'chapter' AS source_type, id, title, sort_order AS position,
+			       encode(sha256(convert_to(content, 'UTF8')), 'hex') AS hash
+			FROM chapters WHERE project_id = $1 AND deleted_at IS NULL
+			UNION ALL
+			SELECT 'wiki_page', id, title, NULL,
+			       encode(sha256(convert_to(content, 'UTF8')), 'hex')
+			FROM wiki_pages WHERE project_id = $1
+		) s
+		LEFT JOIN documents d ON d.source_type = s.source_type AND d.source_id = s.id
+		ORDER BY s.source_type, s.position, s.title
+	`, projectID, q.documentService.embeddingModel(), q.documentService.embeddingDimensions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	defer rows.Close()
+
+	status := &IndexStatus{Documents: []DocumentIndexStatus{}}
+	for rows.Next() {
+		var doc DocumentIndexStatus
+		var upToDate *bool
+		if err := rows.Scan(&doc.SourceType, &doc.SourceID, &doc.Title, &upToDate); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+
+		job, hasJob := jobs[doc.SourceType+":"+doc.SourceID]
+		switch {
+		case hasJob && job.status == JobStatusRunning:
+			doc.State = IndexStateRunning
+			status.Running++
+		case hasJob && job.status == JobStatusPending && job.attempts > 0:
+			doc.State = IndexStateRetry
+			runAt := job.runAt
+			doc.NextAttemptAt = &runAt
+			status.Retrying++
+		case hasJob && job.status == JobStatusPending:
+			doc.State = IndexStatePending
+			status.Pending++
+		case hasJob && job.status == JobStatusDead:
+			doc.State = IndexStateFailed
+			status.Failed++
+		case upToDate != nil && *upToDate:
+			doc.State = IndexStateIndexed
+			status.Indexed++
+		default:
+			doc.State = IndexStateStale
+			status.Stale++
+		}
+		if hasJob {
+			doc.Attempts = job.attempts
+			doc.LastError = job.lastError
+		}
+
+		status.Documents = append(status.Documents, doc)
+	}
+
+	return status, rows.Err()
+}
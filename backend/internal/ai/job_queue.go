@@ -0,0 +1,419 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Embedding job statuses. Succeeded jobs are deleted, so a document without a
+// job is either indexed or was never queued.
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDead    = "dead"
+)
+
+type JobQueueOptions struct {
+	Workers      int           // Number of concurrent workers
+	MaxAttempts  int           // Attempts before a job is dead-lettered
+	Debounce     time.Duration // Delay before a queued job runs, so autosaves coalesce
+	MaxDelay     time.Duration // Longest a pending job is pushed back by repeated saves
+	PollInterval time.Duration // How often idle workers look for due jobs
+	JobTimeout   time.Duration // Upper bound for a single ProcessDocument call
+	Lease        time.Duration // Running jobs older than this are assumed abandoned
+	BaseBackoff  time.Duration // First retry delay, doubled on each attempt
+	MaxBackoff   time.Duration
+}
+
+func (o *JobQueueOptions) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.Debounce < 0 {
+		o.Debounce = 0
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = 30 * time.Second
+	}
+	if o.MaxDelay < o.Debounce {
+		o.MaxDelay = o.Debounce
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.JobTimeout <= 0 {
+		o.JobTimeout = 120 * time.Second
+	}
+	if o.Lease <= 0 {
+		o.Lease = 2 * o.JobTimeout
+	}
+	if o.BaseBackoff <= 0 {
+		o.BaseBackoff = 5 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Minute
+	}
+}
+
+type embeddingJob struct {
+	ID         string
+	ProjectID  string
+	SourceType string
+	SourceID   string
+	Attempts   int
+}
+
+// JobQueue is a Postgres-backed queue that chunks and embeds documents in the
+// background. Jobs survive restarts, are retried with exponential backoff and
+// are dead-lettered after MaxAttempts.
+type JobQueue struct {
+	db              *pgxpool.Pool
+	documentService *DocumentService
+	opts            JobQueueOptions
+
+	wake     chan struct{}
+	stop     chan struct{}
+	abort    context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+func NewJobQueue(db *pgxpool.Pool, documentService *DocumentService, opts JobQueueOptions) *JobQueue {
+	opts.setDefaults()
+	return &JobQueue{
+		db:              db,
+		documentService: documentService,
+		opts:            opts,
+		wake:            make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+	}
+}
+
+// EnqueueDocument schedules a document for (re)processing. If the document
+// already has a pending job, that job is pushed back by the debounce interval
+// and its retry count is reset instead of adding a second job. A job is never
+// pushed back more than MaxDelay past its creation, so steady autosaving
+// can't keep a document from being indexed.
+func (q *JobQueue) EnqueueDocument(ctx context.Context, projectID, sourceType, sourceID string) error {
+	_, err := q.db.Exec(ctx, `
+		INSERT INTO embedding_jobs (project_id, source_type, source_id, run_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+		ON CONFLICT (source_type, source_id) WHERE status = 'pending'
+		DO UPDATE SET
+			run_at = LEAST(embedding_jobs.created_at + make_interval(secs => $5), EXCLUDED.run_at),
+			attempts = 0,
+			last_error = ''
+	`, projectID, sourceType, sourceID, q.opts.Debounce.Seconds(), q.opts.MaxDelay.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue document: %w", err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// enqueueModelChanges queues every document embedded with a model or
+// dimension other than the configured one, or with no recorded model, so
+// changing either re-embeds the whole index
+func (q *JobQueue) enqueueModelChanges(ctx context.Context) error {
+	model := q.documentService.embeddingModel()
+	if model == "" {
+		return nil
+	}
+
+	result, err := q.db.Exec(ctx, `
+		INSERT INTO embedding_jobs (project_id, source_type, source_id)
+		SELECT project_id, source_type, source_id
+		FROM documents
+		WHERE embedding_model IS DISTINCT FROM $1 OR embedding_dimensions IS DISTINCT FROM $2
+		ON CONFLICT (source_type, source_id) WHERE status = 'pending' DO NOTHING
+	`, model, q.documentService.embeddingDimensions())
+	if err != nil {
+		return err
+	}
+	if n := result.RowsAffected(); n > 0 {
+		log.Printf("embedding queue: re-embedding %d documents with %s", n, model)
+	}
+	return nil
+}
+
+// Start launches the worker pool. Workers run until Shutdown is called.
+func (q *JobQueue) Start() {
+	workCtx, abort := context.WithCancel(context.Background())
+	q.abort = abort
+
+	if err := q.enqueueModelChanges(workCtx); err != nil {
+		log.Printf("embedding queue: failed to queue documents for the new embedding model: %v", err)
+	}
+
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker(workCtx)
+	}
+}
+
+// Shutdown stops picking up new jobs and waits for in-flight jobs to finish.
+// If ctx expires first, in-flight jobs are cancelled and returned to the queue.
+func (q *JobQueue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if q.abort != nil {
+			q.abort()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *JobQueue) worker(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Reclaim jobs left running by a worker that crashed or was killed, so
+		// their documents aren't blocked until the next restart
+		if err := q.reclaimAbandoned(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("embedding queue: failed to reclaim abandoned jobs: %v", err)
+		}
+
+		// Drain every due job before going back to sleep
+		for {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+
+			job, err := q.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("embedding queue: failed to claim job: %v", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(ctx, job)
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim locks the oldest due job, skipping documents that already have a job
+// running so the same document is never processed concurrently
+func (q *JobQueue) claim(ctx context.Context) (*embeddingJob, error) {
+	var job embeddingJob
+	err := q.db.QueryRow(ctx, `
+		UPDATE embedding_jobs
+		SET status = 'running', locked_at = now(), attempts = attempts + 1
+		WHERE id = (
+			SELECT j.id FROM embedding_jobs j
+			WHERE j.status = 'pending' AND j.run_at <= now()
+			AND NOT EXISTS (
+				SELECT 1 FROM embedding_jobs r
+				WHERE r.source_type = j.source_type AND r.source_id = j.source_id AND r.status = 'running'
+			)
+			ORDER BY j.run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, project_id, source_type, source_id, attempts
+	`).Scan(&job.ID, &job.ProjectID, &job.SourceType, &job.SourceID, &job.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *JobQueue) run(ctx context.Context, job *embeddingJob) {
+	jobCtx, cancel := context.WithTimeout(ctx, q.opts.JobTimeout)
+	defer cancel()
+
+	err := q.process(jobCtx, job)
+
+	// Record the outcome even if the worker context was cancelled mid-job
+	recordCtx, recordCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer recordCancel()
+
+	switch {
+	case err == nil:
+		err = q.complete(recordCtx, job)
+	case ctx.Err() != nil:
+		// Shutting down: hand the job back without counting the attempt
+		err = q.release(recordCtx, job)
+	default:
+		log.Printf("embedding queue: %s %s attempt %d failed: %v", job.SourceType, job.SourceID, job.Attempts, err)
+		err = q.fail(recordCtx, job, err)
+	}
+	if err != nil {
+		log.Printf("embedding queue: failed to record outcome for job %s: %v", job.ID, err)
+	}
+}
+
+// process loads the current content of the source and hands it to the
+// DocumentService. Loading at run time means coalesced jobs always embed the
+// latest save.
+func (q *JobQueue) process(ctx context.Context, job *embeddingJob) error {
+	content, err := sourceContent(ctx, q.db, job.SourceType, job.SourceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Source was deleted after the job was queued
+		return q.documentService.DeleteDocument(ctx, job.SourceType, job.SourceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return q.documentService.ProcessDocument(ctx, job.ProjectID, job.SourceType, job.SourceID, content)
+}
+
+// complete removes the job along with any dead-lettered jobs it supersedes
+func (q *JobQueue) complete(ctx context.Context, job *embeddingJob) error {
+	_, err := q.db.Exec(ctx, `
+		DELETE FROM embedding_jobs
+		WHERE id = $1 OR (source_type = $2 AND source_id = $3 AND status = 'dead')
+	`, job.ID, job.SourceType, job.SourceID)
+	return err
+}
+
+func (q *JobQueue) release(ctx context.Context, job *embeddingJob) error {
+	return q.reschedule(ctx, job.ID, job.Attempts-1, "", 0)
+}
+
+func (q *JobQueue) fail(ctx context.Context, job *embeddingJob, jobErr error) error {
+	if job.Attempts >= q.opts.MaxAttempts {
+		_, err := q.db.Exec(ctx, `
+			UPDATE embedding_jobs SET status = 'dead', locked_at = NULL, last_error = $2
+			WHERE id = $1
+		`, job.ID, jobErr.Error())
+		return err
+	}
+	return q.reschedule(ctx, job.ID, job.Attempts, jobErr.Error(), q.backoff(job.Attempts))
+}
+
+// reschedule returns a running job to the pending state. If the document was
+// saved again while the job ran, the newer pending job supersedes this one.
+func (q *JobQueue) reschedule(ctx context.Context, jobID string, attempts int, lastError string, delay time.Duration) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := rescheduleTx(ctx, tx, jobID, attempts, lastError, delay); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func rescheduleTx(ctx context.Context, tx pgx.Tx, jobID string, attempts int, lastError string, delay time.Duration) error {
+	result, err := tx.Exec(ctx, `
+		DELETE FROM embedding_jobs j
+		WHERE j.id = $1 AND EXISTS (
+			SELECT 1 FROM embedding_jobs p
+			WHERE p.source_type = j.source_type AND p.source_id = j.source_id AND p.status = 'pending'
+		)
+	`, jobID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE embedding_jobs
+			SET status = 'pending', locked_at = NULL, attempts = $2, last_error = $3, run_at = now() + make_interval(secs => $4)
+			WHERE id = $1
+		`, jobID, attempts, lastError, delay.Seconds())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the next attempt: BaseBackoff doubled for
+// each failed attempt, capped at MaxBackoff
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := q.opts.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= q.opts.MaxBackoff {
+			return q.opts.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// reclaimAbandoned returns jobs whose lease has expired to the queue. The
+// jobs stay locked until they are rescheduled, so workers reclaiming at the
+// same time never reschedule the same job twice.
+func (q *JobQueue) reclaimAbandoned(ctx context.Context) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, attempts FROM embedding_jobs
+		WHERE status = 'running' AND locked_at < now() - make_interval(secs => $1)
+		FOR UPDATE SKIP LOCKED
+	`, q.opts.Lease.Seconds())
+	if err != nil {
+		return err
+	}
+	jobs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (embeddingJob, error) {
+		var job embeddingJob
+		err := row.Scan(&job.ID, &job.Attempts)
+		return job, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := rescheduleTx(ctx, tx, job.ID, job.Attempts, "worker stopped before the job finished", 0); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
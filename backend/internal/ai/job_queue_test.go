@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/db/dbtest"
+)
+
+func TestJobQueue_Backoff(t *testing.T) {
+	q := NewJobQueue(nil, nil, JobQueueOptions{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	assert.Equal(t, time.Second, q.backoff(1))
+	assert.Equal(t, 2*time.Second, q.backoff(2))
+	assert.Equal(t, 8*time.Second, q.backoff(4))
+	assert.Equal(t, 10*time.Second, q.backoff(5), "capped at MaxBackoff")
+	assert.Equal(t, 10*time.Second, q.backoff(50))
+}
+
+func TestJobQueueOptions_Defaults(t *testing.T) {
+	opts := JobQueueOptions{}
+	opts.setDefaults()
+
+	assert.Equal(t, 2, opts.Workers)
+	assert.Equal(t, 5, opts.MaxAttempts)
+	assert.Equal(t, 2*opts.JobTimeout, opts.Lease)
+	assert.Equal(t, 30*time.Second, opts.MaxDelay)
+
+	// The cap never undercuts the debounce itself
+	opts = JobQueueOptions{Debounce: time.Minute, MaxDelay: time.Second}
+	opts.setDefaults()
+	assert.Equal(t, time.Minute, opts.MaxDelay)
+}
+
+func TestJobQueue_ReclaimAbandoned(t *testing.T) {
+	pool := dbtest.Connect(t)
+	_, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	// One job whose worker died an hour ago, one still within its lease
+	insert := func(lockedFor time.Duration) string {
+		var id string
+		err := pool.QueryRow(ctx, `
+			INSERT INTO embedding_jobs (project_id, source_type, source_id, status, attempts, locked_at)
+			VALUES ($1, 'chapter', gen_random_uuid(), 'running', 2, now() - make_interval(secs => $2))
+			RETURNING id
+		`, projectID, lockedFor.Seconds()).Scan(&id)
+		require.NoError(t, err)
+		return id
+	}
+	abandoned := insert(time.Hour)
+	live := insert(time.Second)
+
+	q := NewJobQueue(pool, nil, JobQueueOptions{Lease: time.Minute})
+	require.NoError(t, q.reclaimAbandoned(ctx))
+	// Reclaiming again, as another worker's poll would, changes nothing
+	require.NoError(t, q.reclaimAbandoned(ctx))
+
+	status := func(id string) (string, int, bool) {
+		var status string
+		var attempts int
+		var due bool
+		err := pool.QueryRow(ctx, `
+			SELECT status, attempts, run_at <= now() FROM embedding_jobs WHERE id = $1
+		`, id).Scan(&status, &attempts, &due)
+		require.NoError(t, err)
+		return status, attempts, due
+	}
+
+	got, attempts, due := status(abandoned)
+	assert.Equal(t, JobStatusPending, got)
+	assert.Equal(t, 2, attempts, "the abandoned attempt still counts")
+	assert.True(t, due)
+
+	got, _, _ = status(live)
+	assert.Equal(t, JobStatusRunning, got)
+}
+
+func TestJobQueue_EnqueueDocumentCapsDebounce(t *testing.T) {
+	pool := dbtest.Connect(t)
+	_, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	q := NewJobQueue(pool, nil, JobQueueOptions{Debounce: time.Minute, MaxDelay: 5 * time.Minute})
+	var sourceID string
+	require.NoError(t, pool.QueryRow(ctx, `SELECT gen_random_uuid()`).Scan(&sourceID))
+
+	runAt := func() time.Duration {
+		var secs float64
+		err := pool.QueryRow(ctx, `
+			SELECT EXTRACT(EPOCH FROM run_at - now()) FROM embedding_jobs
+			WHERE source_id = $1 AND status = 'pending'
+		`, sourceID).Scan(&secs)
+		require.NoError(t, err)
+		return time.Duration(secs * float64(time.Second))
+	}
+
+	// A save pushes the pending job back by the debounce interval
+	require.NoError(t, q.EnqueueDocument(ctx, projectID, "chapter", sourceID))
+	require.NoError(t, q.EnqueueDocument(ctx, projectID, "chapter", sourceID))
+	assert.InDelta(t, time.Minute, runAt(), float64(5*time.Second))
+
+	// Once the job has waited MaxDelay, further saves no longer push it back
+	_, err := pool.Exec(ctx, `
+		UPDATE embedding_jobs SET created_at = now() - interval '10 minutes' WHERE source_id = $1
+	`, sourceID)
+	require.NoError(t, err)
+	require.NoError(t, q.EnqueueDocument(ctx, projectID, "chapter", sourceID))
+	assert.LessOrEqual(t, runAt(), time.Duration(0))
+
+	var jobs int
+	require.NoError(t, pool.QueryRow(ctx, `SELECT count(*) FROM embedding_jobs WHERE source_id = $1`, sourceID).Scan(&jobs))
+	assert.Equal(t, 1, jobs)
+}
+
+func TestJobQueue_EnqueueModelChanges(t *testing.T) {
+	pool := dbtest.Connect(t)
+	_, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	q := NewJobQueue(pool, NewDocumentService(pool, NewLocalEmbedder(0), DefaultChunkOptions()), JobQueueOptions{})
+
+	insert := func(model *string, dimensions *int) string {
+		var sourceID string
+		err := pool.QueryRow(ctx, `
+			INSERT INTO documents (project_id, source_type, source_id, content, content_hash, embedding_model, embedding_dimensions)
+			VALUES ($1, 'chapter', gen_random_uuid(), '', '', $2, $3)
+			RETURNING source_id
+		`, projectID, model, dimensions).Scan(&sourceID)
+		require.NoError(t, err)
+		return sourceID
+	}
+	model, otherModel := LocalEmbeddingModel, "text-embedding-3-small"
+	dimensions, otherDimensions := EmbeddingDimensions, 768
+	current := insert(&model, &dimensions)
+	changedModel := insert(&otherModel, &dimensions)
+	changedDimensions := insert(&model, &otherDimensions)
+	// Indexed before models were recorded, so the model is unknown
+	unknown := insert(nil, nil)
+
+	require.NoError(t, q.enqueueModelChanges(ctx))
+	// Running it again, as the next restart would, adds no second job
+	require.NoError(t, q.enqueueModelChanges(ctx))
+
+	queued := func(sourceID string) int {
+		var n int
+		err := pool.QueryRow(ctx, `
+			SELECT count(*) FROM embedding_jobs WHERE source_id = $1 AND status = 'pending'
+		`, sourceID).Scan(&n)
+		require.NoError(t, err)
+		return n
+	}
+	assert.Equal(t, 0, queued(current))
+	assert.Equal(t, 1, queued(changedModel))
+	assert.Equal(t, 1, queued(changedDimensions))
+	assert.Equal(t, 1, queued(unknown))
+}
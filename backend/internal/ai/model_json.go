@@ -0,0 +1,28 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidModelOutput is returned when a tool asked the model for JSON and
+// got something it couldn't parse
+var ErrInvalidModelOutput = errors.New("model returned malformed output")
+
+// decodeModelJSON decodes a JSON object from a model reply. Models often wrap
+// JSON in a Markdown fence or add a sentence around it, so the outermost
+// object is cut out of the reply before decoding.
+func decodeModelJSON(reply string, v interface{}) error {
+	text := strings.TrimSpace(reply)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("%w: no JSON object in reply", ErrInvalidModelOutput)
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidModelOutput, err)
+	}
+	return nil
+}
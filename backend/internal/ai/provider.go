@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/imphyy/NovelCraft/backend/internal/config"
+)
+
+// Supported chat providers (AI_CHAT_PROVIDER)
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOpenAICompatible = "openai_compatible"
+	ProviderAnthropic        = "anthropic"
+	ProviderFake             = "fake"
+)
+
+// Supported embeddings providers (EMBEDDINGS_PROVIDER), in addition to
+// ProviderOpenAI and ProviderOpenAICompatible
+const (
+	ProviderAuto  = "auto"
+	ProviderLocal = "local"
+)
+
+// ChatProvider is implemented by every LLM backend used by the AI tools
+type ChatProvider interface {
+	CreateChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error)
+	Model() string
+}
+
+// Embedder is implemented by every embeddings backend used for chunking and retrieval
+type Embedder interface {
+	GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+	GenerateEmbedding(ctx context.Context, text string) ([]float32, error)
+	Model() string
+	Dimensions() int
+}
+
+// NewChatProvider builds the chat provider selected in config. It returns nil
+// without an error when the selected provider is missing its credentials, so
+// AI features stay disabled instead of failing at startup.
+func NewChatProvider(cfg *config.Config) (ChatProvider, error) {
+	switch cfg.ChatProvider {
+	case "", ProviderOpenAI:
+		if cfg.OpenAIAPIKey == "" {
+			return nil, nil
+		}
+		return NewChatService(cfg.OpenAIAPIKey, cfg.ChatBaseURL, cfg.OpenAIChatModel).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderOpenAICompatible:
+		if cfg.ChatBaseURL == "" {
+			return nil, fmt.Errorf("AI_CHAT_BASE_URL is required for provider %q", cfg.ChatProvider)
+		}
+		return NewChatService(cfg.OpenAIAPIKey, cfg.ChatBaseURL, cfg.OpenAIChatModel).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderAnthropic:
+		if cfg.AnthropicAPIKey == "" {
+			return nil, nil
+		}
+		return NewAnthropicChatService(cfg.AnthropicAPIKey, cfg.ChatBaseURL, cfg.AnthropicChatModel).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderFake:
+		return NewFakeChatProvider(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown chat provider %q", cfg.ChatProvider)
+	}
+}
+
+// clientOptions applies the configured timeout and retry count to the
+// provider HTTP client
+func clientOptions(cfg *config.Config) ClientOptions {
+	timeout := time.Duration(cfg.AIRequestTimeout) * time.Second
+	return ClientOptions{
+		Timeout:       timeout,
+		StreamTimeout: timeout,
+		MaxRetries:    cfg.AIMaxRetries,
+	}
+}
+
+// NewEmbedder builds the embeddings provider selected in config. The "auto"
+// provider uses OpenAI when OPENAI_API_KEY is set and the local hashing
+// embedder otherwise, so documents are always chunked and searchable.
+// Dimensions without a place to store their vectors are rejected up front.
+func NewEmbedder(cfg *config.Config) (Embedder, error) {
+	embedder, err := newEmbedder(cfg)
+	if err != nil || embedder == nil {
+		return nil, err
+	}
+	if _, err := embeddingStoreFor(embedder.Dimensions()); err != nil {
+		return nil, err
+	}
+	return embedder, nil
+}
+
+func newEmbedder(cfg *config.Config) (Embedder, error) {
+	switch cfg.EmbeddingsProvider {
+	case "", ProviderAuto:
+		if cfg.OpenAIAPIKey == "" {
+			return NewLocalEmbedder(cfg.EmbeddingDimensions), nil
+		}
+		return NewEmbeddingsService(cfg.OpenAIAPIKey, "", cfg.OpenAIEmbedModel, cfg.EmbeddingDimensions).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderOpenAI:
+		if cfg.OpenAIAPIKey == "" {
+			return nil, nil
+		}
+		return NewEmbeddingsService(cfg.OpenAIAPIKey, cfg.EmbeddingsBaseURL, cfg.OpenAIEmbedModel, cfg.EmbeddingDimensions).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderOpenAICompatible:
+		if cfg.EmbeddingsBaseURL == "" {
+			return nil, fmt.Errorf("EMBEDDINGS_BASE_URL is required for provider %q", cfg.EmbeddingsProvider)
+		}
+		return NewEmbeddingsService(cfg.OpenAIAPIKey, cfg.EmbeddingsBaseURL, cfg.OpenAIEmbedModel, cfg.EmbeddingDimensions).WithClientOptions(clientOptions(cfg)), nil
+
+	case ProviderLocal:
+		return NewLocalEmbedder(cfg.EmbeddingDimensions), nil
+
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q", cfg.EmbeddingsProvider)
+	}
+}
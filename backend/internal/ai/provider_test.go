@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/config"
+)
+
+func TestNewChatProvider(t *testing.T) {
+	provider, err := NewChatProvider(&config.Config{ChatProvider: ProviderOpenAI})
+	require.NoError(t, err)
+	assert.Nil(t, provider, "OpenAI without a key should be disabled")
+
+	provider, err = NewChatProvider(&config.Config{ChatProvider: ProviderOpenAI, OpenAIAPIKey: "sk-test", OpenAIChatModel: "gpt-4o-mini"})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", provider.Model())
+
+	_, err = NewChatProvider(&config.Config{ChatProvider: ProviderOpenAICompatible})
+	assert.Error(t, err, "OpenAI-compatible provider needs a base URL")
+
+	provider, err = NewChatProvider(&config.Config{ChatProvider: ProviderAnthropic, AnthropicAPIKey: "key"})
+	require.NoError(t, err)
+	assert.Equal(t, AnthropicChatModel, provider.Model())
+
+	provider, err = NewChatProvider(&config.Config{ChatProvider: ProviderFake})
+	require.NoError(t, err)
+	assert.Equal(t, FakeChatModel, provider.Model())
+
+	_, err = NewChatProvider(&config.Config{ChatProvider: "nope"})
+	assert.Error(t, err)
+}
+
+func TestNewEmbedder_Dimensions(t *testing.T) {
+	embedder, err := NewEmbedder(&config.Config{EmbeddingsProvider: ProviderLocal, EmbeddingDimensions: 768})
+	require.NoError(t, err)
+	assert.Equal(t, 768, embedder.Dimensions())
+
+	_, err = NewEmbedder(&config.Config{EmbeddingsProvider: ProviderLocal, EmbeddingDimensions: 512})
+	assert.ErrorIs(t, err, ErrUnsupportedDimensions)
+
+	embedder, err = NewEmbedder(&config.Config{EmbeddingsProvider: ProviderOpenAI})
+	require.NoError(t, err)
+	assert.Nil(t, embedder, "OpenAI without a key should be disabled")
+}
+
+func TestChatService_OpenAICompatible(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"), "no key configured")
+
+		var req ChatRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "llama3", req.Model)
+		assert.Len(t, req.Messages, 2)
+
+		w.Write([]byte(`{"id":"x","choices":[{"message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	provider := NewChatService("", server.URL+"/v1/", "llama3")
+	resp, err := provider.CreateChatCompletion(context.Background(), []ChatMessage{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "hello"},
+	}, 0.5, 100)
+
+	require.NoError(t, err)
+	assert.Equal(t, "hi", resp.Choices[0].Message.Content)
+	assert.Equal(t, 3, resp.Usage.PromptTokens)
+}
+
+func TestAnthropicChatService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-api-key"))
+		assert.Equal(t, AnthropicVersion, r.Header.Get("anthropic-version"))
+
+		var req anthropicRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "sys", req.System)
+		assert.Equal(t, []anthropicMessage{{Role: "user", Content: "hello"}}, req.Messages)
+		assert.Equal(t, anthropicDefaultMaxTokens, req.MaxTokens)
+
+		w.Write([]byte(`{"id":"msg_1","content":[{"type":"text","text":"Hello "},{"type":"text","text":"there"}],"usage":{"input_tokens":10,"output_tokens":2}}`))
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicChatService("key", server.URL, "")
+	resp, err := provider.CreateChatCompletion(context.Background(), []ChatMessage{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "hello"},
+	}, 0.7, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, "Hello there", resp.Choices[0].Message.Content)
+	assert.Equal(t, 10, resp.Usage.PromptTokens)
+	assert.Equal(t, 2, resp.Usage.CompletionTokens)
+	assert.Equal(t, 12, resp.Usage.TotalTokens)
+}
+
+func TestFakeChatProvider_Deterministic(t *testing.T) {
+	provider := NewFakeChatProvider()
+	messages := []ChatMessage{{Role: "user", Content: "Who is Mara?"}}
+
+	first, err := provider.CreateChatCompletion(context.Background(), messages, 0.7, 100)
+	require.NoError(t, err)
+	second, err := provider.CreateChatCompletion(context.Background(), messages, 0.7, 100)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.Choices[0].Message.Content, second.Choices[0].Message.Content)
+	assert.Len(t, provider.Calls(), 2)
+
+	provider.Reply = func([]ChatMessage) string { return "canned" }
+	resp, err := provider.CreateChatCompletion(context.Background(), messages, 0.7, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "canned", resp.Choices[0].Message.Content)
+}
@@ -0,0 +1,279 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DefaultReindexConcurrency = 4
+	MaxReindexConcurrency     = 16
+)
+
+// Outcomes of reindexing one document
+const (
+	ReindexUpdated = "updated" // Chunked and embedded again
+	ReindexSkipped = "skipped" // Content and embedding model unchanged
+	ReindexFailed  = "failed"
+)
+
+type ReindexOptions struct {
+	// Concurrency bounds how many documents are embedded at once
+	Concurrency int
+	// Force re-embeds documents even when they are unchanged, e.g. after
+	// changing the chunk size
+	Force bool
+	// OnProgress is called after each document, one call at a time
+	OnProgress func(ReindexProgress)
+}
+
+// ReindexProgress reports the running totals after one document
+type ReindexProgress struct {
+	Total      int    `json:"total"`
+	Processed  int    `json:"processed"`
+	Updated    int    `json:"updated"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	SourceType string `json:"sourceType"`
+	SourceID   string `json:"sourceId"`
+	Title      string `json:"title"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ReindexFailure struct {
+	SourceType string `json:"sourceType"`
+	SourceID   string `json:"sourceId"`
+	Title      string `json:"title"`
+	Error      string `json:"error"`
+}
+
+type ReindexResult struct {
+	Model    string           `json:"model"`
+	Total    int              `json:"total"`
+	Updated  int              `json:"updated"`
+	Skipped  int              `json:"skipped"`
+	Failed   int              `json:"failed"`
+	Failures []ReindexFailure `json:"failures"`
+}
+
+type reindexSource struct {
+	projectID  string
+	sourceType string
+	sourceID   string
+	title      string
+}
+
+// documentIndexer is the part of DocumentService a reindex drives
+type documentIndexer interface {
+	IndexDocument(ctx context.Context, projectID, sourceType, sourceID, content string, force bool) (bool, error)
+	embeddingModel() string
+}
+
+// Reindexer chunks and embeds every chapter and wiki page of a project, for
+// content written before AI was enabled or indexed with another model
+type Reindexer struct {
+	db      *pgxpool.Pool
+	indexer documentIndexer
+	// content loads a source's current content, pgx.ErrNoRows if it is gone
+	content func(ctx context.Context, sourceType, sourceID string) (string, error)
+}
+
+func NewReindexer(db *pgxpool.Pool, documentService *DocumentService) *Reindexer {
+	return &Reindexer{
+		db:      db,
+		indexer: documentService,
+		content: func(ctx context.Context, sourceType, sourceID string) (string, error) {
+			return sourceContent(ctx, db, sourceType, sourceID)
+		},
+	}
+}
+
+// VerifyAccess returns ErrUnauthorized unless the user owns the project
+func (r *Reindexer) VerifyAccess(ctx context.Context, projectID, userID string) error {
+	return verifyProjectOwnership(ctx, r.db, projectID, userID)
+}
+
+// ReindexProject reindexes a project the user owns
+func (r *Reindexer) ReindexProject(ctx context.Context, projectID, userID string, opts ReindexOptions) (*ReindexResult, error) {
+	if err := r.VerifyAccess(ctx, projectID, userID); err != nil {
+		return nil, err
+	}
+	return r.Reindex(ctx, projectID, opts)
+}
+
+// Reindex reindexes one project, or every project when projectID is empty.
+// It doesn't check ownership, so it is only for admin tooling. Documents
+// that fail are reported in the result rather than stopping the run.
+func (r *Reindexer) Reindex(ctx context.Context, projectID string, opts ReindexOptions) (*ReindexResult, error) {
+	sources, err := r.listSources(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return r.reindexSources(ctx, sources, opts)
+}
+
+// reindexSources indexes sources on a bounded pool of workers
+func (r *Reindexer) reindexSources(ctx context.Context, sources []reindexSource, opts ReindexOptions) (*ReindexResult, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultReindexConcurrency
+	}
+	if opts.Concurrency > MaxReindexConcurrency {
+		opts.Concurrency = MaxReindexConcurrency
+	}
+
+	result := &ReindexResult{
+		Model:    r.indexer.embeddingModel(),
+		Total:    len(sources),
+		Failures: []ReindexFailure{},
+	}
+
+	var mu sync.Mutex
+	record := func(source reindexSource, outcome string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		progress := ReindexProgress{
+			SourceType: source.sourceType,
+			SourceID:   source.sourceID,
+			Title:      source.title,
+			Outcome:    outcome,
+		}
+		switch outcome {
+		case ReindexUpdated:
+			result.Updated++
+		case ReindexSkipped:
+			result.Skipped++
+		case ReindexFailed:
+			result.Failed++
+			progress.Error = err.Error()
+			result.Failures = append(result.Failures, ReindexFailure{
+				SourceType: source.sourceType,
+				SourceID:   source.sourceID,
+				Title:      source.title,
+				Error:      err.Error(),
+			})
+		}
+
+		if opts.OnProgress != nil {
+			progress.Total = result.Total
+			progress.Processed = result.Updated + result.Skipped + result.Failed
+			progress.Updated = result.Updated
+			progress.Skipped = result.Skipped
+			progress.Failed = result.Failed
+			opts.OnProgress(progress)
+		}
+	}
+
+	queue := make(chan reindexSource)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range queue {
+				updated, err := r.reindexSource(ctx, source, opts.Force)
+				switch {
+				case err != nil:
+					record(source, ReindexFailed, err)
+				case updated:
+					record(source, ReindexUpdated, nil)
+				default:
+					record(source, ReindexSkipped, nil)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, source := range sources {
+		select {
+		case queue <- source:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// reindexSource loads a source's current content and indexes it
+func (r *Reindexer) reindexSource(ctx context.Context, source reindexSource, force bool) (bool, error) {
+	content, err := r.content(ctx, source.sourceType, source.sourceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted since the run started; the delete already removed its document
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return r.indexer.IndexDocument(ctx, source.projectID, source.sourceType, source.sourceID, content, force)
+}
+
+// listSources returns the chapters and wiki pages to reindex, chapters first
+// in reading order
+func (r *Reindexer) listSources(ctx context.Context, projectID string) ([]reindexSource, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT project_id, source_type, id, title
+		FROM (
+			SELECT project_id, 'chapter' AS source_type, id, title, sort_order AS position FROM chapters
+			WHERE deleted_at IS NULL
+			UNION ALL
+			SELECT project_id, 'wiki_page', id, title, NULL FROM wiki_pages
+		) s
+		WHERE $1 = '' OR project_id::text = $1
+		ORDER BY project_id, source_type, position, title
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	defer rows.Close()
+
+	var sources []reindexSource
+	for rows.Next() {
+		var source reindexSource
+		if err := rows.Scan(&source.projectID, &source.sourceType, &source.sourceID, &source.title); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		sources = append(sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	return sources, nil
+}
+
+// sourceContent loads the current content of a chapter or wiki page. It
+// returns pgx.ErrNoRows when the source no longer exists.
+func sourceContent(ctx context.Context, db *pgxpool.Pool, sourceType, sourceID string) (string, error) {
+	var query string
+	switch sourceType {
+	case "chapter":
+		query = `SELECT content FROM chapters WHERE id = $1`
+	case "wiki_page":
+		query = `SELECT content FROM wiki_pages WHERE id = $1`
+	default:
+		return "", fmt.Errorf("unknown source type %q", sourceType)
+	}
+
+	var content string
+	err := db.QueryRow(ctx, query, sourceID).Scan(&content)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s content: %w", sourceType, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,245 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/db/dbtest"
+)
+
+// fakeIndexer updates the sources in changed, fails the ones in fail and
+// skips the rest, tracking how many calls run at once
+type fakeIndexer struct {
+	changed map[string]bool
+	fail    map[string]bool
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	forced      []bool
+}
+
+func (f *fakeIndexer) IndexDocument(ctx context.Context, projectID, sourceType, sourceID, content string, force bool) (bool, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.forced = append(f.forced, force)
+	f.mu.Unlock()
+
+	// Hold the call open long enough for the other workers to start theirs
+	time.Sleep(5 * time.Millisecond)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	if f.fail[sourceID] {
+		return false, errors.New("embedding failed")
+	}
+	return force || f.changed[sourceID], nil
+}
+
+func (f *fakeIndexer) embeddingModel() string {
+	return "fake-model"
+}
+
+func newFakeReindexer(indexer *fakeIndexer, deleted ...string) *Reindexer {
+	gone := make(map[string]bool)
+	for _, id := range deleted {
+		gone[id] = true
+	}
+	return &Reindexer{
+		indexer: indexer,
+		content: func(ctx context.Context, sourceType, sourceID string) (string, error) {
+			if gone[sourceID] {
+				return "", pgx.ErrNoRows
+			}
+			return "content of " + sourceID, nil
+		},
+	}
+}
+
+func reindexSources(ids ...string) []reindexSource {
+	sources := make([]reindexSource, len(ids))
+	for i, id := range ids {
+		sources[i] = reindexSource{projectID: "p", sourceType: "chapter", sourceID: id, title: "Title " + id}
+	}
+	return sources
+}
+
+func TestReindexer_ReindexSources(t *testing.T) {
+	tests := []struct {
+		name     string
+		indexer  *fakeIndexer
+		deleted  []string
+		force    bool
+		updated  int
+		skipped  int
+		failed   []string
+		allForce bool
+	}{
+		{
+			name:    "unchanged documents are skipped",
+			indexer: &fakeIndexer{changed: map[string]bool{"b": true}},
+			updated: 1,
+			skipped: 3,
+		},
+		{
+			name:     "force re-embeds everything",
+			indexer:  &fakeIndexer{},
+			force:    true,
+			updated:  4,
+			allForce: true,
+		},
+		{
+			name:    "failures are reported without stopping the run",
+			indexer: &fakeIndexer{changed: map[string]bool{"a": true}, fail: map[string]bool{"c": true}},
+			updated: 1,
+			skipped: 2,
+			failed:  []string{"c"},
+		},
+		{
+			name:    "sources deleted mid-run are skipped",
+			indexer: &fakeIndexer{changed: map[string]bool{"a": true, "d": true}},
+			deleted: []string{"d"},
+			updated: 1,
+			skipped: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFakeReindexer(tt.indexer, tt.deleted...)
+			var progress []ReindexProgress
+			result, err := r.reindexSources(context.Background(), reindexSources("a", "b", "c", "d"), ReindexOptions{
+				Concurrency: 2,
+				Force:       tt.force,
+				OnProgress: func(p ReindexProgress) {
+					progress = append(progress, p)
+				},
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, "fake-model", result.Model)
+			assert.Equal(t, 4, result.Total)
+			assert.Equal(t, tt.updated, result.Updated)
+			assert.Equal(t, tt.skipped, result.Skipped)
+			assert.Equal(t, len(tt.failed), result.Failed)
+			for i, id := range tt.failed {
+				assert.Equal(t, id, result.Failures[i].SourceID)
+				assert.Equal(t, "Title "+id, result.Failures[i].Title)
+				assert.Equal(t, "embedding failed", result.Failures[i].Error)
+			}
+			if tt.allForce {
+				assert.NotContains(t, tt.indexer.forced, false)
+			}
+
+			// One progress event per document, with running totals
+			require.Len(t, progress, 4)
+			for i, p := range progress {
+				assert.Equal(t, 4, p.Total)
+				assert.Equal(t, i+1, p.Processed)
+				assert.Equal(t, p.Processed, p.Updated+p.Skipped+p.Failed)
+				if p.Outcome == ReindexFailed {
+					assert.NotEmpty(t, p.Error)
+				}
+			}
+			last := progress[len(progress)-1]
+			assert.Equal(t, result.Updated, last.Updated)
+			assert.Equal(t, result.Skipped, last.Skipped)
+			assert.Equal(t, result.Failed, last.Failed)
+		})
+	}
+}
+
+func TestReindexer_ReindexSourcesConcurrency(t *testing.T) {
+	ids := make([]string, 2*MaxReindexConcurrency)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+
+	tests := []struct {
+		name        string
+		concurrency int
+		want        int
+	}{
+		{name: "bounded", concurrency: 3, want: 3},
+		{name: "serial", concurrency: 1, want: 1},
+		{name: "capped", concurrency: 100, want: MaxReindexConcurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexer := &fakeIndexer{}
+			_, err := newFakeReindexer(indexer).reindexSources(context.Background(), reindexSources(ids...), ReindexOptions{Concurrency: tt.concurrency})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, indexer.maxInFlight, tt.want)
+			if tt.want == 1 {
+				assert.Equal(t, 1, indexer.maxInFlight)
+			}
+		})
+	}
+}
+
+func TestReindexer_ReindexSourcesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := newFakeReindexer(&fakeIndexer{}).reindexSources(ctx, reindexSources("a", "b", "c"), ReindexOptions{Concurrency: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+	// Whatever finished before the run stopped is still reported
+	require.NotNil(t, result)
+	assert.Equal(t, 3, result.Total)
+}
+
+func TestReindexer_ReindexProject(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO chapters (project_id, sort_order, title, content) VALUES ($1, 1, 'Chapter', 'The storm broke over the harbour.')
+	`, projectID)
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, `
+		INSERT INTO wiki_pages (project_id, title, slug, content, page_type) VALUES ($1, 'Mara', 'mara', 'Mara keeps the lighthouse.', 'character')
+	`, projectID)
+	require.NoError(t, err)
+
+	r := NewReindexer(pool, NewDocumentService(pool, NewLocalEmbedder(0), DefaultChunkOptions()))
+
+	result, err := r.ReindexProject(ctx, projectID, userID, ReindexOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, LocalEmbeddingModel, result.Model)
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 2, result.Updated)
+
+	// Unchanged content hashes are skipped unless forced
+	result, err = r.ReindexProject(ctx, projectID, userID, ReindexOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Skipped)
+
+	result, err = r.ReindexProject(ctx, projectID, userID, ReindexOptions{Force: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Updated)
+
+	// Editing a chapter re-embeds only that chapter
+	_, err = pool.Exec(ctx, `UPDATE chapters SET content = 'The storm passed.' WHERE project_id = $1`, projectID)
+	require.NoError(t, err)
+	result, err = r.ReindexProject(ctx, projectID, userID, ReindexOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 1, result.Skipped)
+
+	otherUser, _ := dbtest.Project(t, pool)
+	_, err = r.ReindexProject(ctx, projectID, otherUser, ReindexOptions{})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
@@ -4,65 +4,215 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type RetrievedChunk struct {
-	ChunkID     string
-	DocumentID  string
-	SourceType  string
-	SourceID    string
-	Content     string
-	TokenCount  int
-	Similarity  float64
+	ChunkID    string
+	DocumentID string
+	SourceType string
+	SourceID   string
+	Content    string
+	TokenCount int
+	Similarity float64
+	// Score is the fused rank score in hybrid mode, and the similarity or
+	// keyword rank otherwise
+	Score float64
+
+	// Where the chunk sits in its source, in characters. Nil for chunks
+	// indexed before offsets were recorded.
+	StartOffset *int
+	EndOffset   *int
+
+	// Source metadata used by retrieval filters and citations
+	Title         string // Chapter or wiki page title
+	SortOrder     *int   // Chapters only
+	ChapterStatus string // Chapters only
+	PageType      string // Wiki pages only
+}
+
+// RetrievalFilter restricts which sources a search may return. The zero value
+// searches the whole project.
+type RetrievalFilter struct {
+	// MaxSortOrder limits chapters to those at or before this position in the
+	// manuscript, so answers can be given "as of" a chapter without spoilers
+	MaxSortOrder *int `json:"maxSortOrder,omitempty" validate:"omitempty,min=0"`
+	// ChapterStatuses limits chapters to the given statuses (chapters.Statuses)
+	ChapterStatuses []string `json:"chapterStatuses,omitempty" validate:"omitempty,dive,chapter_status"`
+	// ExcludeWiki leaves wiki pages out entirely
+	ExcludeWiki bool `json:"excludeWiki,omitempty"`
+	// WikiPageTypes limits wiki pages to the given page types
+	WikiPageTypes []string `json:"wikiPageTypes,omitempty" validate:"omitempty,dive,oneof=character location event concept item faction"`
+	// WikiTags limits wiki pages to those carrying at least one of the given tags
+	WikiTags []string `json:"wikiTags,omitempty" validate:"omitempty,dive,min=1,max=100"`
 }
 
+// IsZero reports whether the filter lets every source through
+func (f RetrievalFilter) IsZero() bool {
+	return f.MaxSortOrder == nil && len(f.ChapterStatuses) == 0 && !f.ExcludeWiki &&
+		len(f.WikiPageTypes) == 0 && len(f.WikiTags) == 0
+}
+
+// conditions returns SQL conditions for the filter over documents d, chapters
+// ch and wiki_pages wp, appending their parameters to args
+func (f RetrievalFilter) conditions(args []interface{}) ([]string, []interface{}) {
+	var conds []string
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.MaxSortOrder != nil {
+		conds = append(conds, "(d.source_type <> 'chapter' OR ch.sort_order <= "+param(*f.MaxSortOrder)+")")
+	}
+	if len(f.ChapterStatuses) > 0 {
+		conds = append(conds, "(d.source_type <> 'chapter' OR ch.status = ANY("+param(f.ChapterStatuses)+"))")
+	}
+	if f.ExcludeWiki {
+		conds = append(conds, "d.source_type <> 'wiki_page'")
+	}
+	if len(f.WikiPageTypes) > 0 {
+		conds = append(conds, "(d.source_type <> 'wiki_page' OR wp.page_type = ANY("+param(f.WikiPageTypes)+"))")
+	}
+	if len(f.WikiTags) > 0 {
+		conds = append(conds, `(d.source_type <> 'wiki_page' OR EXISTS (
+			SELECT 1 FROM wiki_page_tags wpt
+			JOIN wiki_tags wt ON wt.id = wpt.wiki_tag_id
+			WHERE wpt.wiki_page_id = wp.id AND wt.name = ANY(`+param(f.WikiTags)+`)
+		))`)
+	}
+
+	return conds, args
+}
+
+// filteredSearchProbes is the number of ivfflat lists scanned when a filter is
+// applied. The index returns candidates before the WHERE clause runs, so a
+// narrow filter over the default single list can leave too few results.
+const filteredSearchProbes = 10
+
 type RetrievalService struct {
-	db                *pgxpool.Pool
-	embeddingsService *EmbeddingsService
+	db       *pgxpool.Pool
+	embedder Embedder
 }
 
-func NewRetrievalService(db *pgxpool.Pool, embeddingsService *EmbeddingsService) *RetrievalService {
+func NewRetrievalService(db *pgxpool.Pool, embedder Embedder) *RetrievalService {
 	return &RetrievalService{
-		db:                db,
-		embeddingsService: embeddingsService,
+		db:       db,
+		embedder: embedder,
 	}
 }
 
-// Search finds the most relevant chunks for a query
-func (s *RetrievalService) Search(ctx context.Context, projectID, query string, limit int) ([]RetrievedChunk, error) {
-	if s.embeddingsService == nil {
-		return nil, fmt.Errorf("embeddings service not configured")
+// Search finds the most relevant chunks for a query among the sources allowed
+// by filter, ranked according to opts.Mode
+func (s *RetrievalService) Search(ctx context.Context, projectID, query string, limit int, filter RetrievalFilter, opts RetrievalOptions) ([]RetrievedChunk, error) {
+	opts = opts.withDefaults()
+
+	var embeddingStr string
+	if opts.Mode != RetrievalModeKeyword {
+		if s.embedder == nil {
+			return nil, fmt.Errorf("embeddings service not configured")
+		}
+
+		// Generate embedding for the query
+		queryEmbedding, err := s.embedder.GenerateEmbedding(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+		}
+
+		// Convert embedding to pgvector format
+		embeddingStr = fmt.Sprintf("[%v]", joinFloats(queryEmbedding))
 	}
 
-	// Generate embedding for the query
-	queryEmbedding, err := s.embeddingsService.GenerateEmbedding(ctx, query)
+	// The probes setting only lasts for this transaction
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
-	// Convert embedding to pgvector format
-	embeddingStr := fmt.Sprintf("[%v]", joinFloats(queryEmbedding))
+	switch opts.Mode {
+	case RetrievalModeKeyword:
+		return s.keywordSearch(ctx, tx, projectID, query, limit, filter)
+	case RetrievalModeHybrid:
+		return s.hybridSearch(ctx, tx, projectID, query, embeddingStr, limit, filter, opts)
+	default:
+		return s.vectorSearch(ctx, tx, projectID, embeddingStr, limit, filter)
+	}
+}
 
-	// Search for similar chunks using cosine distance
-	rows, err := s.db.Query(ctx, `
-		SELECT
+// chunkColumns and chunkJoins are shared by the retrieval queries. The
+// columns match scanChunks; $1 is always the query (embedding or text).
+const (
+	chunkColumns = `
 			c.id,
 			c.document_id,
 			d.source_type,
 			d.source_id,
 			c.content,
-			c.token_count,
-			1 - (c.embedding <=> $1::vector) as similarity
+			c.token_count`
+	chunkSourceColumns = `
+			c.start_offset,
+			c.end_offset,
+			COALESCE(ch.title, wp.title, ''),
+			ch.sort_order,
+			COALESCE(ch.status, ''),
+			COALESCE(wp.page_type, '')`
+	chunkJoins = `
 		FROM chunks c
 		JOIN documents d ON c.document_id = d.id
-		WHERE c.project_id = $2 AND c.embedding IS NOT NULL
-		ORDER BY c.embedding <=> $1::vector
+		LEFT JOIN chapters ch ON d.source_type = 'chapter' AND ch.id = d.source_id
+		LEFT JOIN wiki_pages wp ON d.source_type = 'wiki_page' AND wp.id = d.source_id`
+)
+
+// vectorSearch ranks chunks by cosine similarity to the query embedding.
+// Only vectors from the configured model are compared; chunks embedded
+// before models were recorded may come from any model and are left out until
+// they are re-embedded.
+func (s *RetrievalService) vectorSearch(ctx context.Context, tx pgx.Tx, projectID, embeddingStr string, limit int, filter RetrievalFilter) ([]RetrievedChunk, error) {
+	store, err := embeddingStoreFor(s.embedder.Dimensions())
+	if err != nil {
+		return nil, err
+	}
+	column := store.column()
+
+	conds, args := filter.conditions([]interface{}{embeddingStr, projectID, limit, s.embedder.Model()})
+	where := "c.project_id = $2 AND ch.deleted_at IS NULL AND " + column + " IS NOT NULL AND c.embedding_model = $4"
+	for _, cond := range conds {
+		where += " AND " + cond
+	}
+
+	if !filter.IsZero() {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL ivfflat.probes = %d", filteredSearchProbes)); err != nil {
+			return nil, fmt.Errorf("failed to configure search: %w", err)
+		}
+	}
+
+	// Search for similar chunks using cosine distance
+	rows, err := tx.Query(ctx, `
+		SELECT`+chunkColumns+`,
+			1 - (`+column+` <=> $1::vector) AS score,`+chunkSourceColumns+chunkJoins+store.join()+`
+		WHERE `+where+`
+		ORDER BY `+column+` <=> $1::vector
 		LIMIT $3
-	`, embeddingStr, projectID, limit)
+	`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search chunks: %w", err)
 	}
+
+	chunks, err := scanChunks(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chunks {
+		chunks[i].Similarity = chunks[i].Score
+	}
+
+	return chunks, nil
+}
+
+// scanChunks reads rows selected with chunkColumns, a score and chunkSourceColumns
+func scanChunks(rows pgx.Rows) ([]RetrievedChunk, error) {
 	defer rows.Close()
 
 	var results []RetrievedChunk
@@ -75,13 +225,22 @@ func (s *RetrievalService) Search(ctx context.Context, projectID, query string,
 			&chunk.SourceID,
 			&chunk.Content,
 			&chunk.TokenCount,
-			&chunk.Similarity,
+			&chunk.Score,
+			&chunk.StartOffset,
+			&chunk.EndOffset,
+			&chunk.Title,
+			&chunk.SortOrder,
+			&chunk.ChapterStatus,
+			&chunk.PageType,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan chunk: %w", err)
 		}
 		results = append(results, chunk)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search chunks: %w", err)
+	}
 
 	return results, nil
 }
@@ -0,0 +1,248 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type RetrievalMode string
+
+const (
+	RetrievalModeVector  RetrievalMode = "vector"  // Cosine similarity only
+	RetrievalModeKeyword RetrievalMode = "keyword" // Full-text rank only
+	RetrievalModeHybrid  RetrievalMode = "hybrid"  // Reciprocal rank fusion of both, plus wiki link boosts
+)
+
+const (
+	// rrfK dampens the advantage of the very top ranks in reciprocal rank
+	// fusion; 60 is the value from the original RRF paper
+	rrfK = 60
+
+	// hybridCandidateFactor is how many candidates each ranking contributes
+	// to the fusion, as a multiple of the requested limit
+	hybridCandidateFactor = 4
+
+	defaultVectorWeight  = 1.0
+	defaultKeywordWeight = 1.0
+	defaultLinkWeight    = 0.5
+)
+
+// RetrievalOptions selects how chunks are ranked. Weights only apply in
+// hybrid mode; nil weights take their defaults.
+type RetrievalOptions struct {
+	Mode          RetrievalMode `json:"mode,omitempty" validate:"omitempty,oneof=vector keyword hybrid"`
+	VectorWeight  *float64      `json:"vectorWeight,omitempty" validate:"omitempty,min=0,max=10"`
+	KeywordWeight *float64      `json:"keywordWeight,omitempty" validate:"omitempty,min=0,max=10"`
+	// LinkWeight boosts chunks from documents that link to (or are) wiki
+	// pages named in the query
+	LinkWeight *float64 `json:"linkWeight,omitempty" validate:"omitempty,min=0,max=10"`
+}
+
+func (o RetrievalOptions) withDefaults() RetrievalOptions {
+	if o.Mode == "" {
+		o.Mode = RetrievalModeVector
+	}
+	if o.VectorWeight == nil {
+		weight := defaultVectorWeight
+		o.VectorWeight = &weight
+	}
+	if o.KeywordWeight == nil {
+		weight := defaultKeywordWeight
+		o.KeywordWeight = &weight
+	}
+	if o.LinkWeight == nil {
+		weight := defaultLinkWeight
+		o.LinkWeight = &weight
+	}
+	return o
+}
+
+// keywordQuery turns free text into an OR of its stemmed terms, so a question
+// matches chunks containing any of its words rather than all of them
+const keywordQuery = `replace(plainto_tsquery('english', $1)::text, '&', '|')::tsquery`
+
+// keywordSearch ranks chunks by full-text relevance to the query
+func (s *RetrievalService) keywordSearch(ctx context.Context, tx pgx.Tx, projectID, query string, limit int, filter RetrievalFilter) ([]RetrievedChunk, error) {
+	conds, args := filter.conditions([]interface{}{query, projectID, limit})
+	where := "c.project_id = $2 AND ch.deleted_at IS NULL AND c.content_tsv @@ " + keywordQuery
+	for _, cond := range conds {
+		where += " AND " + cond
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT`+chunkColumns+`,
+			ts_rank_cd(c.content_tsv, `+keywordQuery+`) AS score,`+chunkSourceColumns+chunkJoins+`
+		WHERE `+where+`
+		ORDER BY score DESC
+		LIMIT $3
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chunks: %w", err)
+	}
+
+	return scanChunks(rows)
+}
+
+// hybridSearch fuses the vector and keyword rankings with reciprocal rank
+// fusion, then boosts chunks whose documents link to wiki pages named in the
+// query. Exact proper nouns that embeddings blur still surface this way.
+func (s *RetrievalService) hybridSearch(ctx context.Context, tx pgx.Tx, projectID, query, embeddingStr string, limit int, filter RetrievalFilter, opts RetrievalOptions) ([]RetrievedChunk, error) {
+	candidates := limit * hybridCandidateFactor
+
+	vectorHits, err := s.vectorSearch(ctx, tx, projectID, embeddingStr, candidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	keywordHits, err := s.keywordSearch(ctx, tx, projectID, query, candidates, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	linked, err := s.linkedSources(ctx, tx, projectID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return fuseRankings(vectorHits, keywordHits, linked, opts, limit), nil
+}
+
+// linkedSources returns the sources ("source_type:source_id") of every
+// document that is, or links to, a wiki page whose title appears in the query
+func (s *RetrievalService) linkedSources(ctx context.Context, tx pgx.Tx, projectID, query string) (map[string]bool, error) {
+	rows, err := tx.Query(ctx, `SELECT id, title FROM wiki_pages WHERE project_id = $1`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wiki pages: %w", err)
+	}
+	defer rows.Close()
+
+	var named []string
+	for rows.Next() {
+		var id, title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, fmt.Errorf("failed to scan wiki page: %w", err)
+		}
+		if mentionsTitle(query, title) {
+			named = append(named, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get wiki pages: %w", err)
+	}
+
+	linked := make(map[string]bool)
+	if len(named) == 0 {
+		return linked, nil
+	}
+
+	for _, id := range named {
+		linked[sourceKey("wiki_page", id)] = true
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT DISTINCT source_type, source_id
+		FROM wiki_links
+		WHERE project_id = $1 AND target_page_id = ANY($2)
+	`, projectID, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wiki links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceType, sourceID string
+		if err := rows.Scan(&sourceType, &sourceID); err != nil {
+			return nil, fmt.Errorf("failed to scan wiki link: %w", err)
+		}
+		linked[sourceKey(sourceType, sourceID)] = true
+	}
+
+	return linked, rows.Err()
+}
+
+// fuseRankings combines vector and keyword hits with weighted reciprocal rank
+// fusion. Linked sources count as an extra ranking in which they all come first.
+func fuseRankings(vectorHits, keywordHits []RetrievedChunk, linked map[string]bool, opts RetrievalOptions, limit int) []RetrievedChunk {
+	opts = opts.withDefaults()
+
+	byID := make(map[string]*RetrievedChunk)
+	var order []string
+	add := func(hits []RetrievedChunk, weight float64) {
+		for rank, hit := range hits {
+			chunk, ok := byID[hit.ChunkID]
+			if !ok {
+				hit := hit
+				hit.Score = 0
+				chunk = &hit
+				byID[hit.ChunkID] = chunk
+				order = append(order, hit.ChunkID)
+			}
+			chunk.Score += weight / float64(rrfK+rank+1)
+		}
+	}
+	add(vectorHits, *opts.VectorWeight)
+	add(keywordHits, *opts.KeywordWeight)
+
+	fused := make([]RetrievedChunk, 0, len(order))
+	for _, id := range order {
+		chunk := byID[id]
+		if linked[sourceKey(chunk.SourceType, chunk.SourceID)] {
+			chunk.Score += *opts.LinkWeight / float64(rrfK+1)
+		}
+		fused = append(fused, *chunk)
+	}
+
+	// Stable so ties keep vector order
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].Score > fused[j].Score
+	})
+
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused
+}
+
+// mentionsTitle reports whether title appears in text as whole words,
+// ignoring case, so "Al" doesn't match "also"
+func mentionsTitle(text, title string) bool {
+	title = strings.ToLower(strings.TrimSpace(title))
+	if title == "" {
+		return false
+	}
+	text = strings.ToLower(text)
+
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], title)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(title)
+		if isWordBoundary(text, start-1) && isWordBoundary(text, end) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// isWordBoundary reports whether the byte at i is outside text or not part of a word
+func isWordBoundary(text string, i int) bool {
+	if i < 0 || i >= len(text) {
+		return true
+	}
+	r := rune(text[i])
+	if r >= 0x80 {
+		// Inside a multi-byte rune, which is a letter in any realistic title
+		return false
+	}
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func sourceKey(sourceType, sourceID string) string {
+	return sourceType + ":" + sourceID
+}
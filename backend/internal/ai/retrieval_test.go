@@ -0,0 +1,105 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrievalFilter_Conditions(t *testing.T) {
+	conds, args := RetrievalFilter{}.conditions([]interface{}{"[0]", "project", 10})
+	assert.Empty(t, conds)
+	assert.Len(t, args, 3)
+	assert.True(t, RetrievalFilter{}.IsZero())
+
+	maxSortOrder := 5
+	filter := RetrievalFilter{
+		MaxSortOrder:    &maxSortOrder,
+		ChapterStatuses: []string{"complete"},
+		WikiPageTypes:   []string{"character"},
+		WikiTags:        []string{"act-one"},
+	}
+	conds, args = filter.conditions([]interface{}{"[0]", "project", 10})
+	assert.False(t, filter.IsZero())
+	assert.Len(t, conds, 4)
+	assert.Equal(t, []interface{}{"[0]", "project", 10, 5, []string{"complete"}, []string{"character"}, []string{"act-one"}}, args)
+	assert.Contains(t, conds[0], "ch.sort_order <= $4")
+	assert.Contains(t, conds[1], "ch.status = ANY($5)")
+	assert.Contains(t, conds[2], "wp.page_type = ANY($6)")
+	assert.Contains(t, conds[3], "wt.name = ANY($7)")
+
+	// Excluding the wiki needs no parameters
+	conds, args = RetrievalFilter{ExcludeWiki: true}.conditions(nil)
+	assert.Equal(t, []string{"d.source_type <> 'wiki_page'"}, conds)
+	assert.Empty(t, args)
+}
+
+func TestRetrievalFilter_Validation(t *testing.T) {
+	v := newTestValidator(t)
+
+	assert.NoError(t, v.Validate(&askRequest{Question: "Who?", Filter: &RetrievalFilter{ChapterStatuses: []string{"draft", "revision", "complete"}}}))
+
+	err := v.Validate(&askRequest{Question: "Who?", Filter: &RetrievalFilter{ChapterStatuses: []string{"final"}}})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "ChapterStatuses"))
+	}
+
+	err = v.Validate(&askRequest{Question: "Who?", Filter: &RetrievalFilter{WikiPageTypes: []string{"spaceship"}}})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "WikiPageTypes"))
+	}
+}
+
+func TestFuseRankings(t *testing.T) {
+	chunk := func(id, sourceID string) RetrievedChunk {
+		return RetrievedChunk{ChunkID: id, SourceType: "chapter", SourceID: sourceID}
+	}
+	vectorHits := []RetrievedChunk{chunk("a", "ch1"), chunk("b", "ch2"), chunk("c", "ch3")}
+	keywordHits := []RetrievedChunk{chunk("c", "ch3"), chunk("d", "ch4")}
+
+	fused := fuseRankings(vectorHits, keywordHits, nil, RetrievalOptions{}, 10)
+	ids := make([]string, len(fused))
+	for i, c := range fused {
+		ids[i] = c.ChunkID
+	}
+	// "c" is found by both rankings, so it overtakes the top vector hit
+	assert.Equal(t, []string{"c", "a", "b", "d"}, ids)
+
+	// Ignoring keywords restores the vector order
+	zero := 0.0
+	fused = fuseRankings(vectorHits, keywordHits, nil, RetrievalOptions{KeywordWeight: &zero}, 2)
+	assert.Equal(t, "a", fused[0].ChunkID)
+	assert.Len(t, fused, 2)
+
+	// Chunks from documents linked to a named wiki page are boosted
+	linked := map[string]bool{sourceKey("chapter", "ch4"): true}
+	weight := 2.0
+	fused = fuseRankings(vectorHits, keywordHits, linked, RetrievalOptions{LinkWeight: &weight}, 10)
+	assert.Equal(t, "d", fused[0].ChunkID)
+}
+
+func TestMentionsTitle(t *testing.T) {
+	assert.True(t, mentionsTitle("What does Mara know about the Saltmarsh?", "saltmarsh"))
+	assert.True(t, mentionsTitle("Is the Iron Court still loyal?", "Iron Court"))
+	assert.False(t, mentionsTitle("Who else also knew?", "Al"))
+	assert.True(t, mentionsTitle("Al left first.", "Al"))
+	assert.False(t, mentionsTitle("anything", "  "))
+}
+
+func TestEmbeddingStoreFor(t *testing.T) {
+	store, err := embeddingStoreFor(1536)
+	require.NoError(t, err)
+	assert.Equal(t, "c.embedding", store.column())
+	assert.Empty(t, store.join())
+
+	store, err = embeddingStoreFor(768)
+	require.NoError(t, err)
+	assert.Equal(t, "ce.embedding", store.column())
+	assert.Contains(t, store.join(), "JOIN chunk_embeddings_768 ce ON ce.chunk_id = c.id")
+
+	_, err = embeddingStoreFor(3072)
+	assert.ErrorIs(t, err, ErrUnsupportedDimensions)
+	assert.Contains(t, err.Error(), "768, 1536")
+}
@@ -3,6 +3,8 @@ package ai
 import (
 	"context"
 	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type RewriteTool string
@@ -16,52 +18,158 @@ const (
 	RewriteToolSummarize    RewriteTool = "summarize"
 )
 
+// BuiltinRewriteTools lists the tools every project has. Projects can add
+// their own with CustomRewriteTool.
+var BuiltinRewriteTools = []RewriteTool{
+	RewriteToolRewrite,
+	RewriteToolExpand,
+	RewriteToolTighten,
+	RewriteToolDialogue,
+	RewriteToolShowVsTell,
+	RewriteToolSummarize,
+}
+
+// IsBuiltinRewriteTool reports whether name is one of BuiltinRewriteTools
+func IsBuiltinRewriteTool(name string) bool {
+	for _, tool := range BuiltinRewriteTools {
+		if string(tool) == name {
+			return true
+		}
+	}
+	return false
+}
+
 type RewriteRequest struct {
 	Tool        RewriteTool `json:"tool"`
 	Text        string      `json:"text"`
 	Instruction string      `json:"instruction,omitempty"` // Optional user instruction
+
+	// UseContext adds the text around the selection in ChapterID, the wiki
+	// pages the chapter links to and related passages to the prompt, within
+	// ContextTokens (DefaultRewriteContextTokens when 0)
+	UseContext    bool   `json:"useContext,omitempty"`
+	ContextTokens int    `json:"contextTokens,omitempty"`
+	ChapterID     string `json:"-"`
+	UserID        string `json:"-"`
+
+	// StyleGuide is the project's style guide, added to the system prompt
+	StyleGuide string `json:"-"`
+	// CustomTool defines Tool when it is not a built-in tool
+	CustomTool *CustomRewriteTool `json:"-"`
+	// NoCache calls the model even when an identical request was cached
+	NoCache bool `json:"-"`
+	// Candidates asks for that many alternative rewrites, each with a label
+	// and tone. The dialogue tool returns DefaultDialogueCandidates when 0.
+	Candidates int `json:"candidates,omitempty"`
 }
 
 type RewriteResponse struct {
 	OriginalText string `json:"originalText"`
+	// RewrittenText is the rewrite, or the first candidate's text
 	RewrittenText string `json:"rewrittenText"`
-	TokensIn     int    `json:"tokensIn"`
-	TokensOut    int    `json:"tokensOut"`
+	// Candidates holds the alternatives when the request asked for several
+	Candidates []RewriteCandidate `json:"candidates,omitempty"`
+	// ContextSources lists the project context given to the model
+	ContextSources []RewriteContextSource `json:"contextSources,omitempty"`
+	// Cached is set when the response was served from the response cache
+	Cached    bool   `json:"cached"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
 }
 
 type RewriteService struct {
-	chatService *ChatService
+	db               *pgxpool.Pool
+	retrievalService *RetrievalService
+	chatProvider     ChatProvider
 }
 
-func NewRewriteService(chatService *ChatService) *RewriteService {
+func NewRewriteService(db *pgxpool.Pool, retrievalService *RetrievalService, chatProvider ChatProvider) *RewriteService {
 	return &RewriteService{
-		chatService: chatService,
+		db:               db,
+		retrievalService: retrievalService,
+		chatProvider:     chatProvider,
 	}
 }
 
 // Rewrite applies an AI writing tool to the given text
 func (s *RewriteService) Rewrite(ctx context.Context, req RewriteRequest) (*RewriteResponse, error) {
-	if s.chatService == nil {
+	if s.chatProvider == nil {
 		return nil, fmt.Errorf("AI services not configured")
 	}
 
-	systemPrompt := buildRewriteSystemPrompt(req.Tool)
-	userPrompt := buildRewriteUserPrompt(req.Tool, req.Text, req.Instruction)
+	return s.rewrite(ctx, req, s.chatProvider.CreateChatCompletion)
+}
 
-	messages := []ChatMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt},
+// RewriteStream applies an AI writing tool like Rewrite, calling onDelta with
+// each fragment of the rewritten text as the model produces it. Requests for
+// candidates aren't streamed, since the reply is JSON; the candidates arrive
+// in the returned response only.
+func (s *RewriteService) RewriteStream(ctx context.Context, req RewriteRequest, onDelta func(string) error) (*RewriteResponse, error) {
+	if s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
 	}
 
+	return s.rewrite(ctx, req, func(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+		return streamCompletion(ctx, s.chatProvider, messages, temperature, maxTokens, onDelta)
+	})
+}
+
+func (s *RewriteService) rewrite(ctx context.Context, req RewriteRequest, complete completionFunc) (*RewriteResponse, error) {
+	var projectContext *rewriteContext
+	if req.UseContext {
+		if s.db == nil {
+			return nil, fmt.Errorf("rewrite context not configured")
+		}
+		var err error
+		projectContext, err = s.gatherRewriteContext(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var systemPrompt, userPrompt string
 	// Use lower temperature for more consistent rewrites
 	temperature := 0.5
-	if req.Tool == RewriteToolDialogue {
-		temperature = 0.8 // More creativity for dialogue variants
+	maxTokens := 2000
+	if req.CustomTool != nil {
+		prompt, err := req.CustomTool.renderUserPrompt(req.Text, req.Instruction)
+		if err != nil {
+			return nil, err
+		}
+		systemPrompt = req.CustomTool.SystemPrompt
+		userPrompt = prompt
+		temperature = req.CustomTool.Temperature
+		maxTokens = customToolMaxTokens
+		if req.CustomTool.MaxTokens > 0 {
+			maxTokens = req.CustomTool.MaxTokens
+		}
+	} else {
+		systemPrompt = buildRewriteSystemPrompt(req.Tool)
+		userPrompt = buildRewriteUserPrompt(req.Tool, req.Text, req.Instruction)
+		if req.Tool == RewriteToolDialogue {
+			temperature = 0.8 // More creativity for dialogue variants
+		}
 	}
 
-	chatResp, err := s.chatService.CreateChatCompletion(ctx, messages, temperature, 2000)
+	candidates := req.candidateCount()
+	if candidates > 0 {
+		systemPrompt = withCandidateFormat(systemPrompt, candidates)
+		userPrompt += fmt.Sprintf("\n\nReturn exactly %d candidates as JSON.", candidates)
+		maxTokens = candidateMaxTokens(maxTokens, candidates)
+		complete = func(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+			return jsonCompletion(ctx, s.chatProvider, messages, temperature, maxTokens)
+		}
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: withStyleGuide(systemPrompt, req.StyleGuide)},
+		{Role: "user", Content: projectContext.prompt() + userPrompt},
+	}
+
+	chatResp, err := complete(withoutCache(ctx, req.NoCache), messages, temperature, maxTokens)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call OpenAI: %w", err)
+		return nil, fmt.Errorf("failed to call chat provider: %w", err)
 	}
 
 	rewrittenText := ""
@@ -69,12 +177,26 @@ func (s *RewriteService) Rewrite(ctx context.Context, req RewriteRequest) (*Rewr
 		rewrittenText = chatResp.Choices[0].Message.Content
 	}
 
-	return &RewriteResponse{
+	resp := &RewriteResponse{
 		OriginalText:  req.Text,
 		RewrittenText: rewrittenText,
+		Cached:        chatResp.Cached,
+		Model:         s.chatProvider.Model(),
 		TokensIn:      chatResp.Usage.PromptTokens,
 		TokensOut:     chatResp.Usage.CompletionTokens,
-	}, nil
+	}
+	if projectContext != nil {
+		resp.ContextSources = projectContext.sources
+	}
+	if candidates > 0 {
+		resp.Candidates = parseRewriteCandidates(rewrittenText, candidates)
+		if len(resp.Candidates) == 0 {
+			return resp, fmt.Errorf("%w: no candidates in reply", ErrInvalidModelOutput)
+		}
+		resp.RewrittenText = resp.Candidates[0].Text
+	}
+
+	return resp, nil
 }
 
 func buildRewriteSystemPrompt(tool RewriteTool) string {
@@ -105,7 +227,7 @@ Task: Remove redundancy, sharpen prose, and make the text more concise. Cut unne
 
 	case RewriteToolDialogue:
 		return base + `Tool: DIALOGUE VARIANTS
-Task: Generate 2-3 alternative ways the character could say the same thing, each with a different tone or subtext. Format each variant on a new line, prefixed with "Option 1:", "Option 2:", etc.`
+Task: Generate alternative ways the character could say the same thing, each with a different tone or subtext.`
 
 	case RewriteToolShowVsTell:
 		return base + `Tool: SHOW VS TELL
@@ -143,7 +265,7 @@ func buildRewriteUserPrompt(tool RewriteTool, text string, instruction string) s
 		prompt += "Please tighten this text by removing redundancy and sharpening the prose."
 
 	case RewriteToolDialogue:
-		prompt += "Please generate 2-3 alternative ways this dialogue could be written, each with a different tone or subtext."
+		prompt += "Please generate alternative ways this dialogue could be written, each with a different tone or subtext."
 
 	case RewriteToolShowVsTell:
 		prompt += "Please convert this text from 'telling' to 'showing' through action, dialogue, or sensory detail."
@@ -0,0 +1,138 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxRewriteCandidates bounds the candidates one rewrite can ask for
+	MaxRewriteCandidates = 5
+	// DefaultDialogueCandidates is how many variants the dialogue tool
+	// returns when the request doesn't say
+	DefaultDialogueCandidates = 3
+
+	// Completion tokens allowed across all candidates of one request
+	maxCandidateTokens = 4000
+)
+
+// RewriteCandidate is one of several alternative rewrites
+type RewriteCandidate struct {
+	// Label is a short name for the candidate, e.g. "Wry"
+	Label string `json:"label"`
+	// Tone describes the tone or approach the candidate takes
+	Tone string `json:"tone"`
+	Text string `json:"text"`
+}
+
+// candidateCount is how many candidates a request asks for, or 0 for a single
+// plain rewrite. The dialogue tool always returns candidates.
+func (r RewriteRequest) candidateCount() int {
+	n := r.Candidates
+	if n > MaxRewriteCandidates {
+		n = MaxRewriteCandidates
+	}
+	if r.CustomTool == nil && r.Tool == RewriteToolDialogue {
+		if n <= 0 {
+			return DefaultDialogueCandidates
+		}
+		return n
+	}
+	if n > 1 {
+		return n
+	}
+	return 0
+}
+
+// candidateMaxTokens scales a tool's completion budget to n candidates
+func candidateMaxTokens(maxTokens, n int) int {
+	if maxTokens*n > maxCandidateTokens {
+		return maxCandidateTokens
+	}
+	return maxTokens * n
+}
+
+// withCandidateFormat replaces a tool's instruction to return only the
+// rewritten text with the JSON format for n candidates
+func withCandidateFormat(systemPrompt string, n int) string {
+	return systemPrompt + fmt.Sprintf(`
+
+Output format (this replaces the rule about returning only the rewritten text):
+Write %d distinct candidates, each taking a different approach, tone or subtext. Respond with JSON only, in this shape:
+{"candidates": [{"label": "one or two words naming the approach", "tone": "one sentence describing the tone or approach", "text": "the rewritten text"}]}`, n)
+}
+
+// candidateLine matches the "Option 1:", "1." or "2)" that starts a candidate
+// in a free-text reply, with an optional parenthesised tone before the colon
+var candidateLine = regexp.MustCompile(`(?im)^\s*(?:\*\*)?(?:option|variant|version)?\s*(\d+)\s*(?:\(([^)]*)\))?\s*[:.)\-]\s*(?:\*\*)?\s*`)
+
+// parseRewriteCandidates reads up to n candidates from a reply. JSON is
+// expected; replies that ignored the format are split on "Option N:" style
+// prefixes, and failing that the whole reply is one candidate.
+func parseRewriteCandidates(reply string, n int) []RewriteCandidate {
+	var parsed struct {
+		Candidates []RewriteCandidate `json:"candidates"`
+	}
+	candidates := []RewriteCandidate{}
+	if err := decodeModelJSON(reply, &parsed); err == nil && len(parsed.Candidates) > 0 {
+		candidates = parsed.Candidates
+	} else if start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]"); start >= 0 && end > start {
+		// A bare array of candidates
+		_ = json.Unmarshal([]byte(reply[start:end+1]), &candidates)
+	}
+
+	var kept []RewriteCandidate
+	for _, candidate := range candidates {
+		candidate.Text = strings.TrimSpace(candidate.Text)
+		if candidate.Text == "" {
+			continue
+		}
+		candidate.Label = strings.TrimSpace(candidate.Label)
+		candidate.Tone = strings.TrimSpace(candidate.Tone)
+		if candidate.Label == "" {
+			candidate.Label = fmt.Sprintf("Option %d", len(kept)+1)
+		}
+		kept = append(kept, candidate)
+	}
+	if len(kept) == 0 {
+		kept = splitFreeTextCandidates(reply)
+	}
+
+	if len(kept) > n {
+		kept = kept[:n]
+	}
+	return kept
+}
+
+// splitFreeTextCandidates splits a reply on numbered option prefixes
+func splitFreeTextCandidates(reply string) []RewriteCandidate {
+	reply = strings.TrimSpace(reply)
+	if reply == "" {
+		return []RewriteCandidate{}
+	}
+
+	matches := candidateLine.FindAllStringSubmatchIndex(reply, -1)
+	if len(matches) < 2 {
+		return []RewriteCandidate{{Label: "Option 1", Text: reply}}
+	}
+
+	var candidates []RewriteCandidate
+	for i, match := range matches {
+		end := len(reply)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		text := strings.TrimSpace(reply[match[1]:end])
+		if text == "" {
+			continue
+		}
+		candidate := RewriteCandidate{Label: fmt.Sprintf("Option %d", len(candidates)+1), Text: text}
+		if match[4] >= 0 {
+			candidate.Tone = strings.TrimSpace(reply[match[4]:match[5]])
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRewriteCandidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		n     int
+		want  []RewriteCandidate
+	}{
+		{
+			name: "json",
+			reply: `{"candidates": [
+				{"label": "Wry", "tone": "Dry and amused", "text": "Well, that went well."},
+				{"label": "", "tone": "Bitter", "text": " Of course it did. "},
+				{"label": "Empty", "tone": "", "text": ""}
+			]}`,
+			n: 3,
+			want: []RewriteCandidate{
+				{Label: "Wry", Tone: "Dry and amused", Text: "Well, that went well."},
+				{Label: "Option 2", Tone: "Bitter", Text: "Of course it did."},
+			},
+		},
+		{
+			name:  "fenced json trimmed to n",
+			reply: "```json\n{\"candidates\": [{\"label\": \"A\", \"text\": \"One.\"}, {\"label\": \"B\", \"text\": \"Two.\"}]}\n```",
+			n:     1,
+			want:  []RewriteCandidate{{Label: "A", Text: "One."}},
+		},
+		{
+			name:  "bare array",
+			reply: `[{"label": "Cold", "tone": "Curt", "text": "Go."}]`,
+			n:     3,
+			want:  []RewriteCandidate{{Label: "Cold", Tone: "Curt", Text: "Go."}},
+		},
+		{
+			name:  "free text options",
+			reply: "Option 1 (Wry): \"Well, that went well.\"\nOption 2: \"Of course it did.\"\n**Option 3:** \"Fine.\"",
+			n:     3,
+			want: []RewriteCandidate{
+				{Label: "Option 1", Tone: "Wry", Text: `"Well, that went well."`},
+				{Label: "Option 2", Text: `"Of course it did."`},
+				{Label: "Option 3", Text: `"Fine."`},
+			},
+		},
+		{
+			name:  "plain reply",
+			reply: "She said nothing at all.",
+			n:     2,
+			want:  []RewriteCandidate{{Label: "Option 1", Text: "She said nothing at all."}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRewriteCandidates(tt.reply, tt.n))
+		})
+	}
+}
+
+func TestCandidateCount(t *testing.T) {
+	assert.Equal(t, 0, RewriteRequest{Tool: RewriteToolTighten}.candidateCount())
+	assert.Equal(t, 0, RewriteRequest{Tool: RewriteToolTighten, Candidates: 1}.candidateCount())
+	assert.Equal(t, 4, RewriteRequest{Tool: RewriteToolTighten, Candidates: 4}.candidateCount())
+	assert.Equal(t, MaxRewriteCandidates, RewriteRequest{Tool: RewriteToolExpand, Candidates: 50}.candidateCount())
+	assert.Equal(t, DefaultDialogueCandidates, RewriteRequest{Tool: RewriteToolDialogue}.candidateCount())
+	assert.Equal(t, 1, RewriteRequest{Tool: RewriteToolDialogue, Candidates: 1}.candidateCount())
+
+	custom := &CustomRewriteTool{Name: "dialogue_pirate"}
+	assert.Equal(t, 0, RewriteRequest{Tool: "dialogue_pirate", CustomTool: custom}.candidateCount())
+	assert.Equal(t, 2, RewriteRequest{Tool: "dialogue_pirate", CustomTool: custom, Candidates: 2}.candidateCount())
+}
+
+// jsonModeProvider records whether JSON mode was used, optionally rejecting it
+type jsonModeProvider struct {
+	*FakeChatProvider
+	rejectJSON bool
+	jsonCalls  int
+}
+
+func (p *jsonModeProvider) CreateJSONChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	p.jsonCalls++
+	if p.rejectJSON {
+		return nil, &APIError{Provider: "chat", StatusCode: 400, Message: "response_format is not supported", Kind: ErrProviderRequest}
+	}
+	return p.CreateChatCompletion(ctx, messages, temperature, maxTokens)
+}
+
+func TestRewriteCandidates(t *testing.T) {
+	reply := `{"candidates": [{"label": "Wry", "tone": "Dry", "text": "Lovely weather."}, {"label": "Grim", "tone": "Flat", "text": "It's raining."}]}`
+
+	for _, reject := range []bool{false, true} {
+		fake := NewFakeChatProvider()
+		fake.Reply = func(messages []ChatMessage) string { return reply }
+		provider := &jsonModeProvider{FakeChatProvider: fake, rejectJSON: reject}
+		service := NewRewriteService(nil, nil, provider)
+
+		resp, err := service.Rewrite(context.Background(), RewriteRequest{Tool: RewriteToolTighten, Text: "It was raining.", Candidates: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 1, provider.jsonCalls)
+		require.Len(t, resp.Candidates, 2)
+		assert.Equal(t, RewriteCandidate{Label: "Wry", Tone: "Dry", Text: "Lovely weather."}, resp.Candidates[0])
+		assert.Equal(t, "Lovely weather.", resp.RewrittenText)
+		assert.Len(t, fake.Calls(), 1, "a rejected JSON mode falls back to a plain request")
+
+		calls := fake.Calls()
+		assert.Contains(t, calls[0][0].Content, `"candidates"`)
+		assert.True(t, strings.HasSuffix(calls[0][1].Content, "Return exactly 2 candidates as JSON."))
+	}
+}
+
+func TestRewriteDialogueStreamReturnsCandidates(t *testing.T) {
+	fake := NewFakeChatProvider()
+	fake.Reply = func(messages []ChatMessage) string {
+		return "Option 1: \"Go.\"\nOption 2: \"Please go.\"\nOption 3: \"Leave. Now.\""
+	}
+	service := NewRewriteService(nil, nil, fake)
+
+	var deltas []string
+	resp, err := service.RewriteStream(context.Background(), RewriteRequest{Tool: RewriteToolDialogue, Text: `"Get out."`}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, deltas, "candidate replies are JSON, so they aren't streamed")
+	require.Len(t, resp.Candidates, 3)
+	assert.Equal(t, `"Leave. Now."`, resp.Candidates[2].Text)
+}
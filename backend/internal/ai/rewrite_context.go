@@ -0,0 +1,317 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/imphyy/NovelCraft/backend/internal/tokenizer"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultRewriteContextTokens is the context budget used when a rewrite
+	// asks for project context without giving one
+	DefaultRewriteContextTokens = 1500
+
+	// Shares of the budget reserved for the text around the selection and for
+	// linked wiki pages; retrieval hits get whatever is left over
+	surroundingBudgetShare = 0.4
+	wikiBudgetShare        = 0.4
+
+	rewriteRetrievalHits = 5
+)
+
+// Kinds of context a rewrite can draw on
+const (
+	RewriteContextSurrounding = "surrounding" // Paragraphs around the selection
+	RewriteContextWikiPage    = "wiki_page"   // Wiki page linked from the chapter
+	RewriteContextRetrieval   = "retrieval"   // Chunk retrieved for the selection
+)
+
+// RewriteContextSource describes one piece of context given to the model
+type RewriteContextSource struct {
+	Kind       string `json:"kind"`
+	SourceType string `json:"sourceType"` // "chapter" or "wiki_page"
+	SourceID   string `json:"sourceId"`
+	Title      string `json:"title"`
+	ChunkID    string `json:"chunkId,omitempty"`
+	Tokens     int    `json:"tokens"`
+}
+
+// rewriteContext is the project context gathered for a rewrite
+type rewriteContext struct {
+	before  string
+	after   string
+	entries []rewriteContextEntry
+	sources []RewriteContextSource
+}
+
+type rewriteContextEntry struct {
+	label   string
+	content string
+}
+
+// gatherRewriteContext collects the paragraphs around the selection, the wiki
+// pages the chapter links to and the best retrieval hits for the selection,
+// in that order of priority, until the token budget is spent
+func (s *RewriteService) gatherRewriteContext(ctx context.Context, req RewriteRequest) (*rewriteContext, error) {
+	budget := req.ContextTokens
+	if budget <= 0 {
+		budget = DefaultRewriteContextTokens
+	}
+
+	var projectID, chapterTitle, content string
+	var sortOrder int
+	err := s.db.QueryRow(ctx, `
+		SELECT c.project_id, c.title, c.sort_order, c.content
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+	`, req.ChapterID, req.UserID).Scan(&projectID, &chapterTitle, &sortOrder, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+
+	rc := &rewriteContext{}
+	remaining := budget
+
+	// 1. The text either side of the selection, nearest paragraphs first
+	before, after := surroundingParagraphs(content, req.Text, int(float64(budget)*surroundingBudgetShare))
+	if before != "" || after != "" {
+		tokens := estimateTokens(before) + estimateTokens(after)
+		rc.before, rc.after = before, after
+		rc.sources = append(rc.sources, RewriteContextSource{
+			Kind:       RewriteContextSurrounding,
+			SourceType: "chapter",
+			SourceID:   req.ChapterID,
+			Title:      chapterTitle,
+			Tokens:     tokens,
+		})
+		remaining -= tokens
+	}
+
+	// 2. Wiki pages linked from the chapter, those named in the selection first
+	wikiBudget := int(float64(budget) * wikiBudgetShare)
+	if wikiBudget > remaining {
+		wikiBudget = remaining
+	}
+	included := make(map[string]bool)
+	pages, err := linkedWikiPages(ctx, s.db, req.ChapterID, req.Text)
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		if wikiBudget <= 0 {
+			break
+		}
+		text := truncateToTokens(page.content, wikiBudget)
+		tokens := estimateTokens(text)
+		if tokens == 0 {
+			continue
+		}
+		rc.entries = append(rc.entries, rewriteContextEntry{
+			label:   fmt.Sprintf("Wiki Page (%s): %q", page.pageType, page.title),
+			content: text,
+		})
+		rc.sources = append(rc.sources, RewriteContextSource{
+			Kind:       RewriteContextWikiPage,
+			SourceType: "wiki_page",
+			SourceID:   page.id,
+			Title:      page.title,
+			Tokens:     tokens,
+		})
+		included[page.id] = true
+		wikiBudget -= tokens
+		remaining -= tokens
+	}
+
+	// 3. Retrieval hits for the selection from earlier chapters and the wiki.
+	// Retrieval is best effort: the rewrite still works without it.
+	if remaining > 0 && s.retrievalService != nil {
+		opts := RetrievalOptions{Mode: RetrievalModeHybrid}
+		if s.retrievalService.embedder == nil {
+			opts.Mode = RetrievalModeKeyword
+		}
+		hits, err := s.retrievalService.Search(ctx, projectID, req.Text, rewriteRetrievalHits,
+			RetrievalFilter{MaxSortOrder: &sortOrder}, opts)
+		if err != nil {
+			log.Printf("rewrite context: retrieval failed for chapter %s: %v", req.ChapterID, err)
+		}
+		for _, hit := range hits {
+			if remaining <= 0 {
+				break
+			}
+			// The selection's own chapter is covered by the surrounding text
+			if hit.SourceID == req.ChapterID || included[hit.SourceID] {
+				continue
+			}
+			text := truncateToTokens(hit.Content, remaining)
+			tokens := estimateTokens(text)
+			if tokens == 0 {
+				continue
+			}
+			rc.entries = append(rc.entries, rewriteContextEntry{label: sourceLabel(hit), content: text})
+			rc.sources = append(rc.sources, RewriteContextSource{
+				Kind:       RewriteContextRetrieval,
+				SourceType: hit.SourceType,
+				SourceID:   hit.SourceID,
+				Title:      hit.Title,
+				ChunkID:    hit.ChunkID,
+				Tokens:     tokens,
+			})
+			remaining -= tokens
+		}
+	}
+
+	return rc, nil
+}
+
+type linkedWikiPage struct {
+	id       string
+	title    string
+	pageType string
+	content  string
+}
+
+// linkedWikiPages returns the wiki pages a chapter links to, with those named
+// in text first
+func linkedWikiPages(ctx context.Context, db *pgxpool.Pool, chapterID, text string) ([]linkedWikiPage, error) {
+	rows, err := db.Query(ctx, `
+		SELECT wp.id, wp.title, wp.page_type, wp.content
+		FROM wiki_links l
+		JOIN wiki_pages wp ON l.target_page_id = wp.id
+		WHERE l.source_type = 'chapter' AND l.source_id = $1
+		ORDER BY wp.title ASC
+	`, chapterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get linked wiki pages: %w", err)
+	}
+	defer rows.Close()
+
+	var pages []linkedWikiPage
+	for rows.Next() {
+		var page linkedWikiPage
+		if err := rows.Scan(&page.id, &page.title, &page.pageType, &page.content); err != nil {
+			return nil, fmt.Errorf("failed to scan wiki page: %w", err)
+		}
+		pages = append(pages, page)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get linked wiki pages: %w", err)
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return mentionsTitle(text, pages[i].title) && !mentionsTitle(text, pages[j].title)
+	})
+
+	return pages, nil
+}
+
+// surroundingParagraphs returns the paragraphs immediately before and after
+// selection within content, alternating outwards until budget tokens are
+// used. Both are empty when the selection can't be found.
+func surroundingParagraphs(content, selection string, budget int) (string, string) {
+	selection = strings.TrimSpace(selection)
+	if selection == "" || budget <= 0 {
+		return "", ""
+	}
+	pos := strings.Index(content, selection)
+	if pos < 0 {
+		return "", ""
+	}
+
+	before := splitParagraphs(content[:pos])
+	after := splitParagraphs(content[pos+len(selection):])
+
+	var keptBefore, keptAfter []string
+	used := 0
+	for i, j := len(before)-1, 0; i >= 0 || j < len(after); i, j = i-1, j+1 {
+		if i >= 0 {
+			tokens := estimateTokens(before[i])
+			if used+tokens > budget {
+				break
+			}
+			keptBefore = append([]string{before[i]}, keptBefore...)
+			used += tokens
+		}
+		if j < len(after) {
+			tokens := estimateTokens(after[j])
+			if used+tokens > budget {
+				break
+			}
+			keptAfter = append(keptAfter, after[j])
+			used += tokens
+		}
+	}
+
+	return strings.Join(keptBefore, "\n\n"), strings.Join(keptAfter, "\n\n")
+}
+
+// splitParagraphs splits text on blank lines, dropping empty paragraphs
+func splitParagraphs(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var paragraphs []string
+	for _, p := range strings.Split(text, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// truncateToTokens cuts text to at most the given number of tokens, between
+// words
+func truncateToTokens(text string, tokens int) string {
+	text = strings.TrimSpace(text)
+	if estimateTokens(text) <= tokens {
+		return text
+	}
+	if tokens <= 0 {
+		return ""
+	}
+
+	// Leave room for the ellipsis
+	cut, used := 0, 1
+	for _, span := range tokenizer.Default().Spans(text) {
+		if used+span.Tokens > tokens {
+			break
+		}
+		used += span.Tokens
+		cut = span.End
+	}
+	truncated := strings.TrimSpace(text[:cut])
+	if truncated == "" {
+		return ""
+	}
+	return truncated + " …"
+}
+
+// prompt renders the context as a reference section for the rewrite prompt
+func (rc *rewriteContext) prompt() string {
+	if rc == nil || (rc.before == "" && rc.after == "" && len(rc.entries) == 0) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Project Context (for reference only, do not rewrite it):\n---\n\n")
+	if rc.before != "" {
+		b.WriteString("[Text before the selection]\n" + rc.before + "\n\n")
+	}
+	if rc.after != "" {
+		b.WriteString("[Text after the selection]\n" + rc.after + "\n\n")
+	}
+	for _, entry := range rc.entries {
+		b.WriteString("[" + entry.label + "]\n" + entry.content + "\n\n")
+	}
+	b.WriteString("---\n\n")
+	b.WriteString("Keep names, facts and character voices consistent with the context above.\n\n")
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSurroundingParagraphs(t *testing.T) {
+	content := strings.Join([]string{
+		"The storm broke at dawn.",
+		"Mara climbed the lighthouse stairs.",
+		"She lit the lamp.",
+		"Below, the boats turned for home.",
+		"By noon the sea was glass.",
+	}, "\n\n")
+
+	before, after := surroundingParagraphs(content, "She lit the lamp.", 1000)
+	assert.Equal(t, "The storm broke at dawn.\n\nMara climbed the lighthouse stairs.", before)
+	assert.Equal(t, "Below, the boats turned for home.\n\nBy noon the sea was glass.", after)
+
+	// A tight budget keeps only the nearest paragraphs
+	before, after = surroundingParagraphs(content, "She lit the lamp.", 17)
+	assert.Equal(t, "Mara climbed the lighthouse stairs.", before)
+	assert.Equal(t, "Below, the boats turned for home.", after)
+
+	before, after = surroundingParagraphs(content, "Not in the chapter", 1000)
+	assert.Empty(t, before)
+	assert.Empty(t, after)
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	text := strings.Repeat("lantern ", 100)
+
+	assert.Equal(t, "short", truncateToTokens("short", 10))
+	truncated := truncateToTokens(text, 10)
+	assert.True(t, strings.HasSuffix(truncated, " …"))
+	assert.LessOrEqual(t, estimateTokens(strings.TrimSuffix(truncated, " …")), 10)
+	for _, word := range strings.Fields(strings.TrimSuffix(truncated, " …")) {
+		assert.Equal(t, "lantern", word)
+	}
+	assert.Empty(t, truncateToTokens(text, 0))
+}
+
+func TestRewriteContextPrompt(t *testing.T) {
+	var empty *rewriteContext
+	assert.Empty(t, empty.prompt())
+
+	rc := &rewriteContext{
+		before:  "Mara climbed the stairs.",
+		entries: []rewriteContextEntry{{label: `Wiki Page (character): "Mara"`, content: "Keeper of the light. Speaks in short sentences."}},
+	}
+	prompt := rc.prompt()
+	assert.Contains(t, prompt, "[Text before the selection]\nMara climbed the stairs.")
+	assert.Contains(t, prompt, `[Wiki Page (character): "Mara"]`)
+	assert.NotContains(t, prompt, "[Text after the selection]")
+}
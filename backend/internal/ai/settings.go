@@ -0,0 +1,270 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrInvalidSettings = errors.New("invalid AI settings")
+
+// customToolName keeps custom tool names usable as identifiers in the API
+var customToolName = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+
+// ProjectAISettings customises the AI tools for one project
+type ProjectAISettings struct {
+	ProjectID string `json:"projectId"`
+	// StyleGuide is added to the system prompt of every AI tool, e.g. British
+	// spelling, tense and POV rules, banned words
+	StyleGuide string              `json:"styleGuide"`
+	Tools      []CustomRewriteTool `json:"tools"`
+	UpdatedAt  *time.Time          `json:"updatedAt,omitempty"`
+}
+
+// CustomRewriteTool is a user-defined rewrite tool. It is used like the
+// built-in RewriteTool values, by name.
+type CustomRewriteTool struct {
+	Name  string `json:"name" validate:"required,min=2,max=40"`
+	Label string `json:"label" validate:"max=100"`
+	// SystemPrompt replaces the built-in tool instructions
+	SystemPrompt string `json:"systemPrompt" validate:"required,max=4000"`
+	// UserPromptTemplate is a text/template rendered with .Text and
+	// .Instruction. Empty uses defaultCustomToolTemplate.
+	UserPromptTemplate string  `json:"userPromptTemplate" validate:"max=4000"`
+	Temperature        float64 `json:"temperature" validate:"min=0,max=2"`
+	MaxTokens          int     `json:"maxTokens" validate:"omitempty,min=1,max=4000"`
+}
+
+const defaultCustomToolTemplate = `Original Text:
+---
+{{.Text}}
+---
+{{if .Instruction}}
+User Instruction: {{.Instruction}}
+{{end}}
+Please apply the tool to this text.`
+
+// customToolMaxTokens is used when a custom tool doesn't set MaxTokens
+const customToolMaxTokens = 2000
+
+// customToolPromptData is what user prompt templates can reference
+type customToolPromptData struct {
+	Text        string
+	Instruction string
+}
+
+// renderUserPrompt fills in the tool's user prompt template
+func (t CustomRewriteTool) renderUserPrompt(text, instruction string) (string, error) {
+	source := t.UserPromptTemplate
+	if strings.TrimSpace(source) == "" {
+		source = defaultCustomToolTemplate
+	}
+
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("%w: tool %q: %v", ErrInvalidSettings, t.Name, err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, customToolPromptData{Text: text, Instruction: instruction}); err != nil {
+		return "", fmt.Errorf("%w: tool %q: %v", ErrInvalidSettings, t.Name, err)
+	}
+	return out.String(), nil
+}
+
+// validate checks what struct tags can't: the name and the template
+func (t CustomRewriteTool) validate() error {
+	if !customToolName.MatchString(t.Name) {
+		return fmt.Errorf("%w: tool name %q must be lowercase letters, digits and underscores", ErrInvalidSettings, t.Name)
+	}
+	if IsBuiltinRewriteTool(t.Name) {
+		return fmt.Errorf("%w: tool name %q is a built-in tool", ErrInvalidSettings, t.Name)
+	}
+	_, err := t.renderUserPrompt("sample text", "sample instruction")
+	return err
+}
+
+// RewriteTool looks up a rewrite tool by name. Built-in tools return a nil
+// custom tool; ok is false when the name matches neither.
+func (s *ProjectAISettings) RewriteTool(name string) (custom *CustomRewriteTool, ok bool) {
+	if IsBuiltinRewriteTool(name) {
+		return nil, true
+	}
+	if s == nil {
+		return nil, false
+	}
+	for i := range s.Tools {
+		if s.Tools[i].Name == name {
+			return &s.Tools[i], true
+		}
+	}
+	return nil, false
+}
+
+// SettingsService stores per-project AI settings
+type SettingsService struct {
+	db *pgxpool.Pool
+}
+
+func NewSettingsService(db *pgxpool.Pool) *SettingsService {
+	return &SettingsService{db: db}
+}
+
+// ForProject returns a project's AI settings, which are empty until saved
+func (s *SettingsService) ForProject(ctx context.Context, projectID, userID string) (*ProjectAISettings, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.load(ctx, projectID)
+}
+
+// ForChapter returns the AI settings of the project a chapter belongs to.
+// ErrNotFound covers chapters the user doesn't own.
+func (s *SettingsService) ForChapter(ctx context.Context, chapterID, userID string) (*ProjectAISettings, error) {
+	var projectID string
+	err := s.db.QueryRow(ctx, `
+		SELECT c.project_id
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+	`, chapterID, userID).Scan(&projectID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+
+	return s.load(ctx, projectID)
+}
+
+// ForWikiPage returns the AI settings of the project a wiki page belongs to.
+// ErrNotFound covers pages the user doesn't own.
+func (s *SettingsService) ForWikiPage(ctx context.Context, pageID, userID string) (*ProjectAISettings, error) {
+	var projectID string
+	err := s.db.QueryRow(ctx, `
+		SELECT wp.project_id
+		FROM wiki_pages wp
+		JOIN projects p ON wp.project_id = p.id
+		WHERE wp.id = $1 AND p.user_id = $2
+	`, pageID, userID).Scan(&projectID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get wiki page: %w", err)
+	}
+
+	return s.load(ctx, projectID)
+}
+
+// Update replaces a project's style guide and custom tools
+func (s *SettingsService) Update(ctx context.Context, projectID, userID string, settings ProjectAISettings) (*ProjectAISettings, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, tool := range settings.Tools {
+		if err := tool.validate(); err != nil {
+			return nil, err
+		}
+		if seen[tool.Name] {
+			return nil, fmt.Errorf("%w: duplicate tool name %q", ErrInvalidSettings, tool.Name)
+		}
+		seen[tool.Name] = true
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO project_ai_settings (project_id, style_guide)
+		VALUES ($1, $2)
+		ON CONFLICT (project_id) DO UPDATE SET style_guide = EXCLUDED.style_guide, updated_at = now()
+	`, projectID, strings.TrimSpace(settings.StyleGuide))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save AI settings: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM project_rewrite_tools WHERE project_id = $1`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace rewrite tools: %w", err)
+	}
+
+	for i, tool := range settings.Tools {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO project_rewrite_tools (project_id, name, label, system_prompt, user_prompt_template, temperature, max_tokens, sort_order)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, projectID, tool.Name, tool.Label, tool.SystemPrompt, tool.UserPromptTemplate, tool.Temperature, tool.MaxTokens, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save rewrite tool: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.load(ctx, projectID)
+}
+
+func (s *SettingsService) load(ctx context.Context, projectID string) (*ProjectAISettings, error) {
+	settings := &ProjectAISettings{ProjectID: projectID, Tools: []CustomRewriteTool{}}
+
+	var updatedAt time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT style_guide, updated_at FROM project_ai_settings WHERE project_id = $1
+	`, projectID).Scan(&settings.StyleGuide, &updatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get AI settings: %w", err)
+	}
+	if err == nil {
+		settings.UpdatedAt = &updatedAt
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT name, label, system_prompt, user_prompt_template, temperature, max_tokens
+		FROM project_rewrite_tools
+		WHERE project_id = $1
+		ORDER BY sort_order ASC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rewrite tools: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tool CustomRewriteTool
+		if err := rows.Scan(&tool.Name, &tool.Label, &tool.SystemPrompt, &tool.UserPromptTemplate, &tool.Temperature, &tool.MaxTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan rewrite tool: %w", err)
+		}
+		settings.Tools = append(settings.Tools, tool)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get rewrite tools: %w", err)
+	}
+
+	return settings, nil
+}
+
+// withStyleGuide appends a project's style guide to a system prompt
+func withStyleGuide(systemPrompt, styleGuide string) string {
+	styleGuide = strings.TrimSpace(styleGuide)
+	if styleGuide == "" {
+		return systemPrompt
+	}
+	return systemPrompt + "\n\nProject Style Guide (follow it in everything you write):\n" + styleGuide
+}
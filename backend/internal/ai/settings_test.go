@@ -0,0 +1,68 @@
+package ai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomRewriteTool_Validate(t *testing.T) {
+	valid := CustomRewriteTool{Name: "noir", SystemPrompt: "Rewrite as hard-boiled noir.", UserPromptTemplate: "Text: {{.Text}}\n{{.Instruction}}"}
+	assert.NoError(t, valid.validate())
+
+	for name, tool := range map[string]CustomRewriteTool{
+		"built-in name": {Name: "expand", SystemPrompt: "x"},
+		"bad name":      {Name: "Noir Tool", SystemPrompt: "x"},
+		"bad template":  {Name: "noir", SystemPrompt: "x", UserPromptTemplate: "{{.Text"},
+		"unknown field": {Name: "noir", SystemPrompt: "x", UserPromptTemplate: "{{.Chapter}}"},
+	} {
+		assert.ErrorIs(t, tool.validate(), ErrInvalidSettings, name)
+	}
+}
+
+func TestProjectAISettings_RewriteTool(t *testing.T) {
+	settings := &ProjectAISettings{Tools: []CustomRewriteTool{{Name: "noir", SystemPrompt: "x"}}}
+
+	custom, ok := settings.RewriteTool("tighten")
+	assert.True(t, ok)
+	assert.Nil(t, custom)
+
+	custom, ok = settings.RewriteTool("noir")
+	assert.True(t, ok)
+	assert.Equal(t, "noir", custom.Name)
+
+	_, ok = settings.RewriteTool("haiku")
+	assert.False(t, ok)
+
+	// Without settings only the built-in tools exist
+	var none *ProjectAISettings
+	_, ok = none.RewriteTool("noir")
+	assert.False(t, ok)
+}
+
+func TestRewriteService_CustomToolAndStyleGuide(t *testing.T) {
+	fake := NewFakeChatProvider()
+	service := NewRewriteService(nil, nil, fake)
+
+	_, err := service.Rewrite(context.Background(), RewriteRequest{
+		Tool:        "noir",
+		Text:        "The rain fell.",
+		Instruction: "More smoke",
+		StyleGuide:  "British spelling. Past tense.",
+		CustomTool: &CustomRewriteTool{
+			Name:               "noir",
+			SystemPrompt:       "Rewrite as hard-boiled noir.",
+			UserPromptTemplate: "Scene: {{.Text}} / Note: {{.Instruction}}",
+			Temperature:        0.9,
+		},
+	})
+	require.NoError(t, err)
+
+	calls := fake.Calls()
+	require.Len(t, calls, 1)
+	assert.Contains(t, calls[0][0].Content, "Rewrite as hard-boiled noir.")
+	assert.Contains(t, calls[0][0].Content, "British spelling. Past tense.")
+	assert.Equal(t, "Scene: The rain fell. / Note: More smoke", calls[0][1].Content)
+}
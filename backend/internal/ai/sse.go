@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server-Sent Events emitted by the streaming endpoints
+const (
+	SSEEventDelta = "delta" // {"text": "..."} fragment of the model output
+	SSEEventDone  = "done"  // Final response with citations and token usage
+	SSEEventError = "error" // {"message": "..."} the stream failed
+
+	SSEEventProgress = "progress" // ReindexProgress after each document
+)
+
+// sseWriter writes Server-Sent Events to an echo response
+type sseWriter struct {
+	res *echo.Response
+}
+
+// newSSEWriter sends the event-stream headers and returns a writer for events
+func newSSEWriter(c echo.Context) *sseWriter {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	return &sseWriter{res: res}
+}
+
+// Send writes one event with a JSON payload and flushes it to the client
+func (w *sseWriter) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if _, err := fmt.Fprintf(w.res, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	w.res.Flush()
+	return nil
+}
+
+// Delta sends a fragment of model output
+func (w *sseWriter) Delta(text string) error {
+	return w.Send(SSEEventDelta, map[string]string{"text": text})
+}
+
+// Error sends a terminal error event
+func (w *sseWriter) Error(message string) error {
+	return w.Send(SSEEventError, map[string]string{"message": message})
+}
@@ -0,0 +1,104 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StreamingChatProvider is implemented by chat providers that can deliver a
+// completion incrementally. onDelta is called with each content fragment; an
+// error returned from it aborts the stream.
+type StreamingChatProvider interface {
+	ChatProvider
+	StreamChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error)
+}
+
+// JSONChatProvider is implemented by chat providers with a JSON mode that
+// constrains the reply to one JSON object
+type JSONChatProvider interface {
+	ChatProvider
+	CreateJSONChatCompletion(ctx context.Context, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error)
+}
+
+// jsonCompletion uses JSON mode on providers that have it. Providers without
+// it, or servers that reject the option, get a plain request, so callers must
+// still parse replies leniently.
+func jsonCompletion(ctx context.Context, provider ChatProvider, messages []ChatMessage, temperature float64, maxTokens int) (*ChatResponse, error) {
+	if structured, ok := provider.(JSONChatProvider); ok {
+		resp, err := structured.CreateJSONChatCompletion(ctx, messages, temperature, maxTokens)
+		if !errors.Is(err, ErrProviderRequest) {
+			return resp, err
+		}
+	}
+	return provider.CreateChatCompletion(ctx, messages, temperature, maxTokens)
+}
+
+// errStreamDone stops readServerSentEvents without reporting an error
+var errStreamDone = errors.New("stream done")
+
+// streamCompletion streams from providers that support it and falls back to a
+// single delta carrying the whole completion for those that don't
+func streamCompletion(ctx context.Context, provider ChatProvider, messages []ChatMessage, temperature float64, maxTokens int, onDelta func(string) error) (*ChatResponse, error) {
+	if streaming, ok := provider.(StreamingChatProvider); ok {
+		return streaming.StreamChatCompletion(ctx, messages, temperature, maxTokens, onDelta)
+	}
+
+	resp, err := provider.CreateChatCompletion(ctx, messages, temperature, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) > 0 && resp.Choices[0].Message.Content != "" {
+		if err := onDelta(resp.Choices[0].Message.Content); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// readServerSentEvents calls onData with the payload of every "data:" line in
+// an event stream until EOF or until onData returns errStreamDone
+func readServerSentEvents(body io.Reader, onData func(data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+		if err := onData(data); err != nil {
+			if errors.Is(err, errStreamDone) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+	return nil
+}
+
+// fillMissingUsage estimates token counts when a streaming endpoint did not
+// report usage (many OpenAI-compatible servers ignore stream_options)
+func fillMissingUsage(resp *ChatResponse, messages []ChatMessage) {
+	if resp.Usage.TotalTokens > 0 {
+		return
+	}
+	for _, msg := range messages {
+		resp.Usage.PromptTokens += estimateTokens(msg.Content)
+	}
+	if len(resp.Choices) > 0 {
+		resp.Usage.CompletionTokens = estimateTokens(resp.Choices[0].Message.Content)
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+}
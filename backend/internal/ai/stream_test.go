@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/chapters"
+)
+
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v *testValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
+
+// newTestValidator validates like the server's validator
+func newTestValidator(t *testing.T) *testValidator {
+	v := validator.New()
+	require.NoError(t, chapters.RegisterValidation(v))
+	return &testValidator{validator: v}
+}
+
+func collectDeltas(deltas *[]string) func(string) error {
+	return func(delta string) error {
+		*deltas = append(*deltas, delta)
+		return nil
+	}
+}
+
+func TestChatService_Stream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n"))
+		w.Write([]byte("data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n"))
+		w.Write([]byte("data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n"))
+		w.Write([]byte("data: {\"id\":\"c1\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	var deltas []string
+	resp, err := NewChatService("", server.URL, "m").StreamChatCompletion(context.Background(),
+		[]ChatMessage{{Role: "user", Content: "hi"}}, 0.5, 10, collectDeltas(&deltas))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hello", " world"}, deltas)
+	assert.Equal(t, "Hello world", resp.Choices[0].Message.Content)
+	assert.Equal(t, 7, resp.Usage.TotalTokens)
+}
+
+func TestAnthropicChatService_Stream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":9,\"output_tokens\":1}}}\n\n"))
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Once\"}}\n\n"))
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\" upon\"}}\n\n"))
+		w.Write([]byte("event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":3}}\n\n"))
+		w.Write([]byte("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+	}))
+	defer server.Close()
+
+	var deltas []string
+	resp, err := NewAnthropicChatService("key", server.URL, "").StreamChatCompletion(context.Background(),
+		[]ChatMessage{{Role: "user", Content: "hi"}}, 0.5, 10, collectDeltas(&deltas))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Once", " upon"}, deltas)
+	assert.Equal(t, "msg_1", resp.ID)
+	assert.Equal(t, 9, resp.Usage.PromptTokens)
+	assert.Equal(t, 3, resp.Usage.CompletionTokens)
+}
+
+// nonStreamingProvider hides the streaming method of the fake provider
+type nonStreamingProvider struct {
+	ChatProvider
+}
+
+func TestStreamCompletion_FallsBackToSingleDelta(t *testing.T) {
+	fake := NewFakeChatProvider()
+	fake.Reply = func([]ChatMessage) string { return "all at once" }
+
+	var deltas []string
+	resp, err := streamCompletion(context.Background(), nonStreamingProvider{fake}, nil, 0, 0, collectDeltas(&deltas))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"all at once"}, deltas)
+	assert.Equal(t, "all at once", resp.Choices[0].Message.Content)
+}
+
+func TestHandler_RewriteStream(t *testing.T) {
+	fake := NewFakeChatProvider()
+	fake.Reply = func([]ChatMessage) string { return "The rain fell softly." }
+	handler := NewHandler(HandlerDeps{Rewrite: NewRewriteService(nil, nil, fake)})
+
+	e := echo.New()
+	e.Validator = newTestValidator(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/chapters/c1/ai/rewrite/stream",
+		strings.NewReader(`{"tool":"tighten","text":"The rain, it fell down very softly."}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("c1")
+	c.Set("user_id", "u1")
+
+	require.NoError(t, handler.RewriteStream(c))
+
+	body := rec.Body.String()
+	assert.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, body, "event: delta\ndata: {\"text\":\"The \"}\n\n")
+	assert.Contains(t, body, "event: done\ndata: ")
+	assert.Contains(t, body, `"rewrittenText":"The rain fell softly."`)
+	assert.Less(t, strings.Index(body, "event: delta"), strings.Index(body, "event: done"))
+}
@@ -0,0 +1,362 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// MaxSummaryChapterTokens bounds how much of a chapter is summarised
+	MaxSummaryChapterTokens = 12000
+	// MaxSummariesPerRequest bounds how many chapters one story-so-far
+	// request summarises; the rest are reported as missing
+	MaxSummariesPerRequest = 10
+	// DefaultSummaryContextTokens is the budget for chapter summaries added
+	// to an Ask prompt
+	DefaultSummaryContextTokens = 1500
+
+	summaryMaxTokens = 400
+)
+
+// ErrEmptyChapter is returned when asked to summarise a chapter with no text
+var ErrEmptyChapter = errors.New("chapter is empty")
+
+// ChapterSummary is the stored summary of a chapter. Summary is empty when
+// the chapter hasn't been summarised.
+type ChapterSummary struct {
+	ChapterID string `json:"chapterId"`
+	Title     string `json:"title"`
+	SortOrder int    `json:"sortOrder"`
+	Summary   string `json:"summary"`
+	// Stale is set when the chapter has changed since it was summarised
+	Stale     bool       `json:"stale"`
+	Model     string     `json:"model,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// fresh reports whether the summary describes the chapter as it is now
+func (s ChapterSummary) fresh() bool {
+	return s.Summary != "" && !s.Stale
+}
+
+type SummaryResponse struct {
+	Summary ChapterSummary `json:"summary"`
+	// Generated is set when the model was called; a fresh stored summary is
+	// returned as is
+	Generated bool   `json:"generated"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
+}
+
+type StorySoFarRequest struct {
+	ProjectID string
+	UserID    string
+	// ThroughChapterID is the last chapter included. Empty includes them all.
+	ThroughChapterID string
+	// Generate summarises chapters whose summary is missing or stale, up to
+	// MaxSummariesPerRequest of them
+	Generate   bool
+	StyleGuide string
+}
+
+type StorySoFarResponse struct {
+	ThroughChapterID string           `json:"throughChapterId,omitempty"`
+	Chapters         []ChapterSummary `json:"chapters"`
+	// Synopsis joins the fresh summaries in manuscript order
+	Synopsis string `json:"synopsis"`
+	// Missing counts chapters left out of the synopsis because their summary
+	// is missing or stale
+	Missing   int    `json:"missing"`
+	Generated int    `json:"generated"`
+	Model     string `json:"model,omitempty"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
+}
+
+// SummaryService keeps an AI summary of each chapter, regenerated once the
+// chapter's content hash no longer matches the one summarised
+type SummaryService struct {
+	db           *pgxpool.Pool
+	chatProvider ChatProvider
+}
+
+func NewSummaryService(db *pgxpool.Pool, chatProvider ChatProvider) *SummaryService {
+	return &SummaryService{
+		db:           db,
+		chatProvider: chatProvider,
+	}
+}
+
+// summaryRow is a chapter with its stored summary, if any
+type summaryRow struct {
+	ChapterSummary
+	projectID   string
+	content     string
+	summaryHash string
+}
+
+// Get returns the stored summary of a chapter the user owns
+func (s *SummaryService) Get(ctx context.Context, chapterID, userID string) (*ChapterSummary, error) {
+	row, err := s.chapter(ctx, chapterID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &row.ChapterSummary, nil
+}
+
+// Summarize returns a fresh summary of a chapter the user owns, calling the
+// model when the stored one is missing or stale, or when force is set
+func (s *SummaryService) Summarize(ctx context.Context, chapterID, userID, styleGuide string, force bool) (*SummaryResponse, error) {
+	if s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
+	}
+
+	row, err := s.chapter(ctx, chapterID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &SummaryResponse{Summary: row.ChapterSummary}
+	if row.fresh() && !force {
+		return resp, nil
+	}
+
+	// A forced summary should be a new one, not the cached response
+	usage, err := s.summarize(withoutCache(ctx, force), row, styleGuide)
+	resp.Generated = true
+	resp.Model = s.chatProvider.Model()
+	resp.TokensIn = usage.PromptTokens
+	resp.TokensOut = usage.CompletionTokens
+	if err != nil {
+		return resp, err
+	}
+	resp.Summary = row.ChapterSummary
+	return resp, nil
+}
+
+// StorySoFar composes the chapter summaries of a project the user owns, in
+// manuscript order, up to and including a chapter
+func (s *SummaryService) StorySoFar(ctx context.Context, req StorySoFarRequest) (*StorySoFarResponse, error) {
+	if req.Generate && s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
+	}
+	if err := verifyProjectOwnership(ctx, s.db, req.ProjectID, req.UserID); err != nil {
+		return nil, err
+	}
+
+	var maxSortOrder *int
+	if req.ThroughChapterID != "" {
+		var sortOrder int
+		err := s.db.QueryRow(ctx, `
+			SELECT sort_order FROM chapters WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL
+		`, req.ThroughChapterID, req.ProjectID).Scan(&sortOrder)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrNotFound
+			}
+			return nil, fmt.Errorf("failed to get chapter: %w", err)
+		}
+		maxSortOrder = &sortOrder
+	}
+
+	rows, err := summaryRows(ctx, s.db, req.ProjectID, maxSortOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &StorySoFarResponse{ThroughChapterID: req.ThroughChapterID, Chapters: []ChapterSummary{}}
+	for _, row := range rows {
+		if req.Generate && !row.fresh() && strings.TrimSpace(row.content) != "" && resp.Generated < MaxSummariesPerRequest {
+			usage, err := s.summarize(ctx, row, req.StyleGuide)
+			resp.Model = s.chatProvider.Model()
+			resp.TokensIn += usage.PromptTokens
+			resp.TokensOut += usage.CompletionTokens
+			if err != nil {
+				return resp, err
+			}
+			resp.Generated++
+		}
+		if !row.fresh() && strings.TrimSpace(row.content) != "" {
+			resp.Missing++
+		}
+		resp.Chapters = append(resp.Chapters, row.ChapterSummary)
+	}
+	resp.Synopsis, _ = composeStorySoFar(resp.Chapters, 0)
+
+	return resp, nil
+}
+
+// storySoFar returns the synopsis of a project's chapters up to maxSortOrder
+// (nil for all of them) from fresh summaries only, within budget tokens, and
+// the number of summaries it includes
+func storySoFar(ctx context.Context, db *pgxpool.Pool, projectID string, maxSortOrder *int, budget int) (string, int, error) {
+	rows, err := summaryRows(ctx, db, projectID, maxSortOrder)
+	if err != nil {
+		return "", 0, err
+	}
+	summaries := make([]ChapterSummary, len(rows))
+	for i, row := range rows {
+		summaries[i] = row.ChapterSummary
+	}
+	synopsis, included := composeStorySoFar(summaries, budget)
+	return synopsis, included, nil
+}
+
+// composeStorySoFar joins fresh summaries in order under chapter headings.
+// With a budget, the latest summaries that fit are kept.
+func composeStorySoFar(summaries []ChapterSummary, budget int) (string, int) {
+	var parts []string
+	used := 0
+	for i := len(summaries) - 1; i >= 0; i-- {
+		summary := summaries[i]
+		if !summary.fresh() {
+			continue
+		}
+		part := fmt.Sprintf("Chapter %d: %q\n%s\n\n", summary.SortOrder, summary.Title, strings.TrimSpace(summary.Summary))
+		if budget > 0 {
+			tokens := estimateTokens(part)
+			if used+tokens > budget {
+				break
+			}
+			used += tokens
+		}
+		parts = append(parts, part)
+	}
+
+	var b strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		b.WriteString(parts[i])
+	}
+	return strings.TrimSpace(b.String()), len(parts)
+}
+
+func (s *SummaryService) chapter(ctx context.Context, chapterID, userID string) (*summaryRow, error) {
+	row := &summaryRow{}
+	var summary, hash, model *string
+	err := s.db.QueryRow(ctx, `
+		SELECT c.id, c.project_id, c.title, c.sort_order, c.content,
+		       cs.summary, cs.content_hash, cs.model, cs.updated_at
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		LEFT JOIN chapter_summaries cs ON cs.chapter_id = c.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+	`, chapterID, userID).Scan(&row.ChapterID, &row.projectID, &row.Title, &row.SortOrder, &row.content,
+		&summary, &hash, &model, &row.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+	row.setSummary(summary, hash, model)
+	return row, nil
+}
+
+// summaryRows lists a project's chapters up to maxSortOrder with their
+// stored summaries
+func summaryRows(ctx context.Context, db *pgxpool.Pool, projectID string, maxSortOrder *int) ([]*summaryRow, error) {
+	rows, err := db.Query(ctx, `
+		SELECT c.id, c.title, c.sort_order, c.content,
+		       cs.summary, cs.content_hash, cs.model, cs.updated_at
+		FROM chapters c
+		LEFT JOIN chapter_summaries cs ON cs.chapter_id = c.id
+		WHERE c.project_id = $1 AND c.deleted_at IS NULL AND ($2::int IS NULL OR c.sort_order <= $2)
+		ORDER BY c.sort_order
+	`, projectID, maxSortOrder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*summaryRow
+	for rows.Next() {
+		row := &summaryRow{projectID: projectID}
+		var summary, hash, model *string
+		if err := rows.Scan(&row.ChapterID, &row.Title, &row.SortOrder, &row.content,
+			&summary, &hash, &model, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan chapter: %w", err)
+		}
+		row.setSummary(summary, hash, model)
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+	return result, nil
+}
+
+// setSummary fills in the stored summary columns, which are NULL when the
+// chapter has none
+func (r *summaryRow) setSummary(summary, hash, model *string) {
+	if summary == nil {
+		return
+	}
+	r.Summary = *summary
+	r.summaryHash = *hash
+	r.Model = *model
+	r.Stale = r.summaryHash != HashContent(r.content)
+}
+
+const summarySystemPrompt = `You are a story bible assistant for NovelCraft, a novel writing application.
+
+Summarise the chapter you are given for the author's reference. The summary will be read alongside the summaries of the other chapters to recall the story so far.
+
+Rules:
+- Write one paragraph of 3 to 6 sentences in the present tense
+- Cover the key events, decisions and revelations, and how the chapter ends
+- Name the characters and places involved
+- Do not evaluate the writing or add anything the chapter doesn't say
+- Reply with the summary only`
+
+// summarize calls the model for a chapter and stores the result against the
+// hash of the content summarised
+func (s *SummaryService) summarize(ctx context.Context, row *summaryRow, styleGuide string) (ChatUsage, error) {
+	text := truncateToTokens(row.content, MaxSummaryChapterTokens)
+	if text == "" {
+		return ChatUsage{}, ErrEmptyChapter
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: withStyleGuide(summarySystemPrompt, styleGuide)},
+		{Role: "user", Content: fmt.Sprintf("Chapter %d: %q\n---\n%s\n---\n\nSummarise this chapter.", row.SortOrder, row.Title, text)},
+	}
+	chatResp, err := s.chatProvider.CreateChatCompletion(ctx, messages, 0.3, summaryMaxTokens)
+	if err != nil {
+		return ChatUsage{}, fmt.Errorf("failed to call chat provider: %w", err)
+	}
+
+	summary := ""
+	if len(chatResp.Choices) > 0 {
+		summary = strings.TrimSpace(chatResp.Choices[0].Message.Content)
+	}
+	if summary == "" {
+		return chatResp.Usage, fmt.Errorf("%w: empty summary", ErrInvalidModelOutput)
+	}
+
+	hash := HashContent(row.content)
+	var updatedAt time.Time
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO chapter_summaries (chapter_id, content_hash, summary, model)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chapter_id) DO UPDATE SET content_hash = EXCLUDED.content_hash,
+			summary = EXCLUDED.summary, model = EXCLUDED.model
+		RETURNING updated_at
+	`, row.ChapterID, hash, summary, s.chatProvider.Model()).Scan(&updatedAt)
+	if err != nil {
+		return chatResp.Usage, fmt.Errorf("failed to save chapter summary: %w", err)
+	}
+
+	row.Summary = summary
+	row.summaryHash = hash
+	row.Model = s.chatProvider.Model()
+	row.Stale = false
+	row.UpdatedAt = &updatedAt
+	return chatResp.Usage, nil
+}
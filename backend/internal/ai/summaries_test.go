@@ -0,0 +1,54 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeStorySoFar(t *testing.T) {
+	summaries := []ChapterSummary{
+		{SortOrder: 1, Title: "Arrival", Summary: "Mara reaches Ostwick."},
+		{SortOrder: 2, Title: "The Mill", Summary: "The Guild meets.", Stale: true},
+		{SortOrder: 3, Title: "Empty"},
+		{SortOrder: 4, Title: "Flight", Summary: "Mara flees north.\n"},
+	}
+
+	synopsis, included := composeStorySoFar(summaries, 0)
+	assert.Equal(t, 2, included, "stale and missing summaries are left out")
+	assert.Equal(t, "Chapter 1: \"Arrival\"\nMara reaches Ostwick.\n\nChapter 4: \"Flight\"\nMara flees north.", synopsis)
+
+	last := "Chapter 4: \"Flight\"\nMara flees north.\n\n"
+	synopsis, included = composeStorySoFar(summaries, estimateTokens(last))
+	assert.Equal(t, 1, included)
+	assert.Equal(t, strings.TrimSpace(last), synopsis, "the latest summaries are kept within the budget")
+}
+
+func TestSummaryRowStale(t *testing.T) {
+	summary, hash, model := "Mara reaches Ostwick.", HashContent("Mara rode in."), "gpt-4o-mini"
+
+	row := &summaryRow{content: "Mara rode in."}
+	row.setSummary(&summary, &hash, &model)
+	assert.False(t, row.Stale)
+	assert.True(t, row.fresh())
+
+	row = &summaryRow{content: "Mara rode in at dusk."}
+	row.setSummary(&summary, &hash, &model)
+	assert.True(t, row.Stale, "the chapter changed since it was summarised")
+
+	row = &summaryRow{content: "Mara rode in."}
+	row.setSummary(nil, nil, nil)
+	assert.False(t, row.fresh())
+	assert.Empty(t, row.Summary)
+}
+
+func TestBuildUserPromptWithSummaries(t *testing.T) {
+	chunks := []RetrievedChunk{{SourceType: "chapter", Title: "Arrival", Content: "Mara rode in."}}
+
+	prompt := buildUserPrompt("Where is Mara?", chunks, "Chapter 1: \"Arrival\"\nMara reaches Ostwick.")
+	assert.Contains(t, prompt, "Story So Far")
+	assert.Less(t, strings.Index(prompt, "Mara reaches Ostwick."), strings.Index(prompt, "[Source 1"))
+
+	assert.NotContains(t, buildUserPrompt("Where is Mara?", chunks, ""), "Story So Far")
+}
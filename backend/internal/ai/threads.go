@@ -0,0 +1,290 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// threadHistoryLimit bounds how many messages are loaded to build a
+// conversation; trimHistory then cuts them down to the token budget
+const threadHistoryLimit = 50
+
+// threadTitleLength is the length of titles taken from a thread's first question
+const threadTitleLength = 80
+
+type Thread struct {
+	ID        string          `json:"id"`
+	ProjectID string          `json:"projectId"`
+	Title     string          `json:"title"`
+	Messages  []ThreadMessage `json:"messages,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type ThreadMessage struct {
+	ID                 string     `json:"id"`
+	ThreadID           string     `json:"threadId"`
+	Role               string     `json:"role"` // "user" or "assistant"
+	Content            string     `json:"content"`
+	Citations          []Citation `json:"citations,omitempty"`
+	StandaloneQuestion string     `json:"standaloneQuestion,omitempty"`
+	TokensIn           int        `json:"tokensIn"`
+	TokensOut          int        `json:"tokensOut"`
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+// ThreadAskResponse is an answer given within a thread
+type ThreadAskResponse struct {
+	AskResponse
+	ThreadID  string `json:"threadId"`
+	MessageID string `json:"messageId"`
+}
+
+// ThreadService persists conversational Ask threads
+type ThreadService struct {
+	db *pgxpool.Pool
+}
+
+func NewThreadService(db *pgxpool.Pool) *ThreadService {
+	return &ThreadService{db: db}
+}
+
+// CreateThread starts an empty thread. An empty title is filled in from the
+// first question.
+func (s *ThreadService) CreateThread(ctx context.Context, projectID, userID, title string) (*Thread, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	var t Thread
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO ai_threads (project_id, title)
+		VALUES ($1, $2)
+		RETURNING id, project_id, title, created_at, updated_at
+	`, projectID, strings.TrimSpace(title)).Scan(&t.ID, &t.ProjectID, &t.Title, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create thread: %w", err)
+	}
+
+	return &t, nil
+}
+
+// ListThreads returns a project's threads, most recently active first
+func (s *ThreadService) ListThreads(ctx context.Context, projectID, userID string) ([]Thread, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, project_id, title, created_at, updated_at
+		FROM ai_threads
+		WHERE project_id = $1
+		ORDER BY updated_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threads: %w", err)
+	}
+	defer rows.Close()
+
+	threads := []Thread{}
+	for rows.Next() {
+		var t Thread
+		if err := rows.Scan(&t.ID, &t.ProjectID, &t.Title, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thread: %w", err)
+		}
+		threads = append(threads, t)
+	}
+
+	return threads, rows.Err()
+}
+
+// GetThread returns a thread with all of its messages
+func (s *ThreadService) GetThread(ctx context.Context, projectID, threadID, userID string) (*Thread, error) {
+	t, err := s.getThread(ctx, projectID, threadID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, thread_id, role, content, citations, standalone_question, tokens_in, tokens_out, created_at
+		FROM ai_thread_messages
+		WHERE thread_id = $1
+		ORDER BY created_at ASC
+	`, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread messages: %w", err)
+	}
+	defer rows.Close()
+
+	t.Messages = []ThreadMessage{}
+	for rows.Next() {
+		var m ThreadMessage
+		var citations []byte
+		if err := rows.Scan(&m.ID, &m.ThreadID, &m.Role, &m.Content, &citations, &m.StandaloneQuestion, &m.TokensIn, &m.TokensOut, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thread message: %w", err)
+		}
+		if err := json.Unmarshal(citations, &m.Citations); err != nil {
+			return nil, fmt.Errorf("failed to decode citations: %w", err)
+		}
+		t.Messages = append(t.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get thread messages: %w", err)
+	}
+
+	return t, nil
+}
+
+// DeleteThread deletes a thread and its messages
+func (s *ThreadService) DeleteThread(ctx context.Context, projectID, threadID, userID string) error {
+	if _, err := s.getThread(ctx, projectID, threadID, userID); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(ctx, `DELETE FROM ai_threads WHERE id = $1`, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to delete thread: %w", err)
+	}
+
+	return nil
+}
+
+// History returns the recent turns of a thread, oldest first, ready to be
+// passed as AskRequest.History
+func (s *ThreadService) History(ctx context.Context, projectID, threadID, userID string) ([]ChatMessage, error) {
+	if _, err := s.getThread(ctx, projectID, threadID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT role, content
+		FROM ai_thread_messages
+		WHERE thread_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, threadID, threadHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []ChatMessage
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.Role, &msg.Content); err != nil {
+			return nil, fmt.Errorf("failed to scan thread message: %w", err)
+		}
+		history = append(history, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get thread history: %w", err)
+	}
+
+	// Newest first from the query; conversations are replayed oldest first
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return history, nil
+}
+
+// AppendExchange stores a question and its answer in a thread and returns the
+// answer's message. askedAt is when the question was received, so the two
+// messages keep their order even though they are written together.
+func (s *ThreadService) AppendExchange(ctx context.Context, threadID, question string, askedAt time.Time, resp *AskResponse) (*ThreadMessage, error) {
+	citations, err := json.Marshal(resp.Citations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode citations: %w", err)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ai_thread_messages (thread_id, role, content, created_at)
+		VALUES ($1, 'user', $2, $3)
+	`, threadID, question, askedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store question: %w", err)
+	}
+
+	m := ThreadMessage{
+		ThreadID:           threadID,
+		Role:               "assistant",
+		Content:            resp.Answer,
+		Citations:          resp.Citations,
+		StandaloneQuestion: resp.StandaloneQuestion,
+		TokensIn:           resp.TokensIn,
+		TokensOut:          resp.TokensOut,
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO ai_thread_messages (thread_id, role, content, citations, standalone_question, tokens_in, tokens_out, created_at)
+		VALUES ($1, 'assistant', $2, $3, $4, $5, $6, clock_timestamp())
+		RETURNING id, created_at
+	`, threadID, m.Content, citations, m.StandaloneQuestion, m.TokensIn, m.TokensOut).Scan(&m.ID, &m.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store answer: %w", err)
+	}
+
+	// Touch the thread so it sorts first, titling it after its first question
+	_, err = tx.Exec(ctx, `
+		UPDATE ai_threads
+		SET title = CASE WHEN title = '' THEN $2 ELSE title END, updated_at = now()
+		WHERE id = $1
+	`, threadID, threadTitle(question))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update thread: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &m, nil
+}
+
+// getThread loads a thread after checking the user owns its project
+func (s *ThreadService) getThread(ctx context.Context, projectID, threadID, userID string) (*Thread, error) {
+	if err := verifyProjectOwnership(ctx, s.db, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	var t Thread
+	err := s.db.QueryRow(ctx, `
+		SELECT id, project_id, title, created_at, updated_at
+		FROM ai_threads
+		WHERE id = $1 AND project_id = $2
+	`, threadID, projectID).Scan(&t.ID, &t.ProjectID, &t.Title, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+
+	return &t, nil
+}
+
+// threadTitle shortens a question to a thread title on a word boundary
+func threadTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if len([]rune(title)) <= threadTitleLength {
+		return title
+	}
+
+	runes := []rune(title)[:threadTitleLength]
+	if cut := strings.LastIndex(string(runes), " "); cut > 0 {
+		return string(runes)[:cut] + "…"
+	}
+	return string(runes) + "…"
+}
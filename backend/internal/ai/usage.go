@@ -0,0 +1,205 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrBudgetExceeded = errors.New("monthly AI token budget exceeded")
+
+// Outcomes recorded in the usage ledger
+const (
+	OutcomeSuccess        = "success"
+	OutcomeError          = "error"
+	OutcomeCancelled      = "cancelled"
+	OutcomeBudgetExceeded = "budget_exceeded"
+)
+
+// UsageRecord is one AI tool call written to the ledger
+type UsageRecord struct {
+	UserID    string
+	ProjectID string // Optional
+	ChapterID string // Optional, used to resolve ProjectID when it is empty
+	Tool      string
+	Model     string
+	TokensIn  int
+	TokensOut int
+	Latency   time.Duration
+	Outcome   string
+	Error     string
+	// Cached is set when the response came from the response cache
+	Cached bool
+}
+
+// BudgetStatus describes a user's token budget for the current month.
+// Limit is 0 when the user has no budget.
+type BudgetStatus struct {
+	Limit       int       `json:"limit"`
+	Used        int       `json:"used"`
+	Remaining   int       `json:"remaining"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+}
+
+type UsageDay struct {
+	Day       string `json:"day"` // YYYY-MM-DD (UTC)
+	Tool      string `json:"tool"`
+	Requests  int    `json:"requests"`
+	Errors    int    `json:"errors"`
+	CacheHits int    `json:"cacheHits"`
+	TokensIn  int    `json:"tokensIn"`
+	TokensOut int    `json:"tokensOut"`
+}
+
+type UsageReport struct {
+	From      time.Time    `json:"from"`
+	To        time.Time    `json:"to"`
+	Requests  int          `json:"requests"`
+	CacheHits int          `json:"cacheHits"`
+	TokensIn  int          `json:"tokensIn"`
+	TokensOut int          `json:"tokensOut"`
+	Days      []UsageDay   `json:"days"`
+	Budget    BudgetStatus `json:"budget"`
+}
+
+type UsageService struct {
+	db            *pgxpool.Pool
+	defaultBudget int
+}
+
+// NewUsageService creates the usage ledger. defaultBudget is the monthly token
+// budget for users without their own; 0 means unlimited.
+func NewUsageService(db *pgxpool.Pool, defaultBudget int) *UsageService {
+	return &UsageService{
+		db:            db,
+		defaultBudget: defaultBudget,
+	}
+}
+
+// Record writes a ledger entry
+func (s *UsageService) Record(ctx context.Context, record UsageRecord) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO ai_requests (user_id, project_id, tool, model, tokens_in, tokens_out, latency_ms, outcome, error, cached)
+		VALUES (
+			$1,
+			COALESCE(NULLIF($2, '')::uuid, (SELECT project_id FROM chapters WHERE id::text = $3)),
+			$4, $5, $6, $7, $8, $9, $10, $11
+		)
+	`, record.UserID, record.ProjectID, record.ChapterID, record.Tool, record.Model,
+		record.TokensIn, record.TokensOut, record.Latency.Milliseconds(), record.Outcome, record.Error, record.Cached)
+	if err != nil {
+		return fmt.Errorf("failed to record AI request: %w", err)
+	}
+	return nil
+}
+
+// CheckBudget returns the user's budget status, and ErrBudgetExceeded if the
+// user has used up their tokens for the month. The check happens before the
+// call, so concurrent requests can overshoot the budget slightly.
+func (s *UsageService) CheckBudget(ctx context.Context, userID string) (*BudgetStatus, error) {
+	status, err := s.Budget(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Limit > 0 && status.Used >= status.Limit {
+		return status, ErrBudgetExceeded
+	}
+	return status, nil
+}
+
+// Budget returns the user's token budget and usage for the current UTC month
+func (s *UsageService) Budget(ctx context.Context, userID string) (*BudgetStatus, error) {
+	periodStart, periodEnd := monthBounds(time.Now())
+
+	var userBudget *int
+	var used int
+	err := s.db.QueryRow(ctx, `
+		SELECT
+			u.ai_monthly_token_budget,
+			COALESCE((
+				SELECT SUM(r.tokens_in + r.tokens_out) FROM ai_requests r
+				WHERE r.user_id = u.id AND r.created_at >= $2 AND r.created_at < $3
+			), 0)
+		FROM users u
+		WHERE u.id = $1
+	`, userID, periodStart, periodEnd).Scan(&userBudget, &used)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI budget: %w", err)
+	}
+
+	status := &BudgetStatus{
+		Limit:       s.defaultBudget,
+		Used:        used,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+	}
+	if userBudget != nil {
+		status.Limit = *userBudget
+	}
+	if status.Limit > 0 {
+		status.Remaining = status.Limit - used
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+	}
+
+	return status, nil
+}
+
+// Report summarises a user's AI usage between from (inclusive) and to
+// (exclusive), grouped by UTC day and tool
+func (s *UsageService) Report(ctx context.Context, userID string, from, to time.Time) (*UsageReport, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT
+			to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD') AS day,
+			tool,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE outcome <> 'success'),
+			COUNT(*) FILTER (WHERE cached),
+			COALESCE(SUM(tokens_in), 0),
+			COALESCE(SUM(tokens_out), 0)
+		FROM ai_requests
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY day, tool
+		ORDER BY day ASC, tool ASC
+	`, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI usage: %w", err)
+	}
+	defer rows.Close()
+
+	report := &UsageReport{From: from, To: to, Days: []UsageDay{}}
+	for rows.Next() {
+		var day UsageDay
+		if err := rows.Scan(&day.Day, &day.Tool, &day.Requests, &day.Errors, &day.CacheHits, &day.TokensIn, &day.TokensOut); err != nil {
+			return nil, fmt.Errorf("failed to scan AI usage: %w", err)
+		}
+		report.Requests += day.Requests
+		report.CacheHits += day.CacheHits
+		report.TokensIn += day.TokensIn
+		report.TokensOut += day.TokensOut
+		report.Days = append(report.Days, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get AI usage: %w", err)
+	}
+
+	budget, err := s.Budget(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	report.Budget = *budget
+
+	return report, nil
+}
+
+// monthBounds returns the start of the UTC month containing t and the start of the next one
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
@@ -0,0 +1,356 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/imphyy/NovelCraft/backend/internal/textdiff"
+	"github.com/imphyy/NovelCraft/backend/internal/wiki"
+)
+
+const (
+	// DefaultWikiDraftContextTokens is the budget for manuscript passages when
+	// a draft request doesn't give one
+	DefaultWikiDraftContextTokens = 6000
+
+	// Share of the budget for passages from chapters that link to the page;
+	// retrieval on the title gets whatever is left over
+	wikiDraftMentionBudgetShare = 0.75
+	wikiDraftRetrievalHits      = 10
+
+	wikiDraftMaxTokens = 3000
+)
+
+// Draft modes
+const (
+	WikiDraftNew     = "draft"   // The page was empty
+	WikiDraftRefresh = "refresh" // Existing content was updated
+)
+
+// ErrNoMentions is returned when there is nothing in the manuscript to draft
+// a wiki page from
+var ErrNoMentions = errors.New("wiki page is not mentioned in the manuscript")
+
+type WikiDraftRequest struct {
+	PageID string
+	UserID string
+	// ContextTokens is the budget for manuscript passages
+	// (DefaultWikiDraftContextTokens when 0)
+	ContextTokens int
+	StyleGuide    string
+}
+
+// WikiDraftCitation ties a statement in the proposed content to the passage
+// it came from
+type WikiDraftCitation struct {
+	Claim      string `json:"claim"`
+	Quote      string `json:"quote"`
+	SourceType string `json:"sourceType"` // "chapter" or "wiki_page"
+	SourceID   string `json:"sourceId"`
+	Title      string `json:"title"`
+	ChunkID    string `json:"chunkId,omitempty"`
+}
+
+// WikiDraftResponse is a proposed new version of a wiki page. Nothing is
+// saved: the author accepts it by saving ProposedContent through
+// PATCH /api/wiki/:id with If-Match set to the ETag of Version, so a page
+// saved since the draft was made is answered with 409 and a merge instead of
+// being overwritten.
+type WikiDraftResponse struct {
+	PageID          string              `json:"pageId"`
+	Title           string              `json:"title"`
+	Mode            string              `json:"mode"`
+	Version         int                 `json:"version"` // Page version the draft was made from
+	CurrentContent  string              `json:"currentContent"`
+	ProposedContent string              `json:"proposedContent"`
+	Diff            []textdiff.Op       `json:"diff"`
+	Citations       []WikiDraftCitation `json:"citations"`
+	// Sources lists the manuscript passages given to the model
+	Sources   []RewriteContextSource `json:"sources"`
+	Model     string                 `json:"model,omitempty"`
+	TokensIn  int                    `json:"tokensIn"`
+	TokensOut int                    `json:"tokensOut"`
+}
+
+// WikiDraftService drafts wiki page content from the chapters that mention
+// the page, so the story bible keeps up with the manuscript
+type WikiDraftService struct {
+	db               *pgxpool.Pool
+	wikiService      *wiki.Service
+	retrievalService *RetrievalService
+	chatProvider     ChatProvider
+}
+
+func NewWikiDraftService(db *pgxpool.Pool, wikiService *wiki.Service, retrievalService *RetrievalService, chatProvider ChatProvider) *WikiDraftService {
+	return &WikiDraftService{
+		db:               db,
+		wikiService:      wikiService,
+		retrievalService: retrievalService,
+		chatProvider:     chatProvider,
+	}
+}
+
+// Draft proposes content for a wiki page the user owns. An empty page gets a
+// fresh draft; a page with content gets it revised to match the manuscript.
+func (s *WikiDraftService) Draft(ctx context.Context, req WikiDraftRequest) (*WikiDraftResponse, error) {
+	if s.chatProvider == nil {
+		return nil, fmt.Errorf("AI services not configured")
+	}
+
+	page, err := s.wikiService.Get(ctx, req.PageID, req.UserID)
+	if err != nil {
+		if errors.Is(err, wiki.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	mentions, err := s.wikiService.GetMentions(ctx, req.PageID, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := req.ContextTokens
+	if budget <= 0 {
+		budget = DefaultWikiDraftContextTokens
+	}
+	sources, err := s.gatherSources(ctx, page, mentions, budget)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, ErrNoMentions
+	}
+
+	resp := &WikiDraftResponse{
+		PageID:         page.ID,
+		Title:          page.Title,
+		Mode:           WikiDraftNew,
+		Version:        page.Version,
+		CurrentContent: page.Content,
+		Citations:      []WikiDraftCitation{},
+		Sources:        []RewriteContextSource{},
+		Model:          s.chatProvider.Model(),
+	}
+	if strings.TrimSpace(page.Content) != "" {
+		resp.Mode = WikiDraftRefresh
+	}
+	for _, source := range sources {
+		resp.Sources = append(resp.Sources, source.source)
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: withStyleGuide(wikiDraftSystemPrompt, req.StyleGuide)},
+		{Role: "user", Content: buildWikiDraftUserPrompt(page, sources)},
+	}
+	chatResp, err := s.chatProvider.CreateChatCompletion(ctx, messages, 0.3, wikiDraftMaxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call chat provider: %w", err)
+	}
+	resp.TokensIn = chatResp.Usage.PromptTokens
+	resp.TokensOut = chatResp.Usage.CompletionTokens
+
+	reply := ""
+	if len(chatResp.Choices) > 0 {
+		reply = chatResp.Choices[0].Message.Content
+	}
+	content, citations, err := parseWikiDraft(reply, sources)
+	if err != nil {
+		return resp, err
+	}
+	resp.ProposedContent = content
+	resp.Citations = citations
+	resp.Diff = textdiff.Lines(page.Content, content)
+
+	return resp, nil
+}
+
+// gatherSources collects passages naming the page from the chapters that link
+// to it, in reading order, then retrieval hits for the title from the rest of
+// the manuscript and wiki
+func (s *WikiDraftService) gatherSources(ctx context.Context, page *wiki.WikiPage, mentions []wiki.Mention, budget int) ([]citedSource, error) {
+	var sources []citedSource
+	seen := make(map[string]bool)
+	add := func(hit RetrievedChunk) {
+		content := truncateToTokens(hit.Content, budget)
+		if content == "" || seen[hit.ChunkID] {
+			return
+		}
+		seen[hit.ChunkID] = true
+		tokens := estimateTokens(content)
+		sources = append(sources, citedSource{
+			label:   fmt.Sprintf("S%d", len(sources)+1),
+			heading: sourceLabel(hit),
+			content: content,
+			source: RewriteContextSource{
+				Kind:       RewriteContextRetrieval,
+				SourceType: hit.SourceType,
+				SourceID:   hit.SourceID,
+				Title:      hit.Title,
+				ChunkID:    hit.ChunkID,
+				Tokens:     tokens,
+			},
+		})
+		budget -= tokens
+	}
+
+	// 1. Chunks of linking chapters that name the page
+	chapterIDs := make([]string, len(mentions))
+	for i, mention := range mentions {
+		chapterIDs[i] = mention.ChapterID
+	}
+	if len(chapterIDs) > 0 {
+		passages, err := s.mentioningChunks(ctx, chapterIDs, page.Title)
+		if err != nil {
+			return nil, err
+		}
+		reserved := budget - int(float64(budget)*wikiDraftMentionBudgetShare)
+		for _, passage := range passages {
+			if budget <= reserved {
+				break
+			}
+			add(passage)
+		}
+	}
+
+	// 2. Retrieval on the title, for chapters that name the page without
+	// linking it and for related wiki pages. Best effort, as elsewhere.
+	if budget > 0 && s.retrievalService != nil {
+		opts := RetrievalOptions{Mode: RetrievalModeHybrid}
+		if s.retrievalService.embedder == nil {
+			opts.Mode = RetrievalModeKeyword
+		}
+		hits, err := s.retrievalService.Search(ctx, page.ProjectID, page.Title, wikiDraftRetrievalHits, RetrievalFilter{}, opts)
+		if err != nil {
+			log.Printf("wiki draft: retrieval failed for page %s: %v", page.ID, err)
+		}
+		for _, hit := range hits {
+			if budget <= 0 {
+				break
+			}
+			// The page's own content is already in the prompt
+			if hit.SourceID == page.ID {
+				continue
+			}
+			add(hit)
+		}
+	}
+
+	return sources, nil
+}
+
+// mentioningChunks returns the indexed chunks of the given chapters that
+// contain title, in reading order
+func (s *WikiDraftService) mentioningChunks(ctx context.Context, chapterIDs []string, title string) ([]RetrievedChunk, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT c.id, c.document_id, d.source_id, c.content, c.token_count, ch.title, ch.sort_order
+		FROM chunks c
+		JOIN documents d ON c.document_id = d.id
+		JOIN chapters ch ON ch.id = d.source_id
+		WHERE d.source_type = 'chapter' AND ch.deleted_at IS NULL
+		  AND d.source_id = ANY($1::uuid[])
+		  AND position(lower($2) in lower(c.content)) > 0
+		ORDER BY ch.sort_order, c.chunk_index
+	`, chapterIDs, title)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mentioning chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []RetrievedChunk
+	for rows.Next() {
+		chunk := RetrievedChunk{SourceType: "chapter"}
+		var sortOrder int
+		if err := rows.Scan(&chunk.ChunkID, &chunk.DocumentID, &chunk.SourceID, &chunk.Content, &chunk.TokenCount, &chunk.Title, &sortOrder); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %w", err)
+		}
+		chunk.SortOrder = &sortOrder
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get mentioning chunks: %w", err)
+	}
+
+	return chunks, nil
+}
+
+const wikiDraftSystemPrompt = `You are a story bible editor for NovelCraft, a novel writing application.
+
+Your task is to write the wiki page for one character, location, item, faction, event or concept, using only what the numbered manuscript passages establish.
+
+Rules:
+- Only state facts the passages support; never invent details
+- Write in Markdown with short sections (for example Description, Background, Relationships, Appearances) and omit sections with nothing to say
+- Where the passages disagree, state the latest version and mention the discrepancy
+- When the page already has content, keep the author's wording, structure and any facts the passages don't contradict, and add or correct what the manuscript now establishes
+- Keep [[Wiki Links]] the author wrote and link other pages' names the same way
+- Cite the passage behind every factual statement
+
+Respond with JSON only, in this shape:
+{"content": "the full Markdown page", "citations": [{"claim": "statement from the page", "source": "S1", "quote": "exact supporting text from the passage"}]}`
+
+func buildWikiDraftUserPrompt(page *wiki.WikiPage, sources []citedSource) string {
+	var b strings.Builder
+	b.WriteString("Manuscript Passages:\n---\n\n")
+	for _, source := range sources {
+		b.WriteString("[" + source.label + "] " + source.heading + "\n" + source.content + "\n\n")
+	}
+	b.WriteString("---\n\n")
+
+	fmt.Fprintf(&b, "Wiki Page (%s): %q\n", page.PageType, page.Title)
+	if strings.TrimSpace(page.Content) == "" {
+		b.WriteString("The page is empty. Draft it from the passages above.")
+	} else {
+		b.WriteString("Current Content:\n---\n" + page.Content + "\n---\n\n")
+		b.WriteString("Update the page so it reflects everything the passages establish.")
+	}
+	return b.String()
+}
+
+// parseWikiDraft decodes the model's reply. Citations of passages that weren't
+// given are dropped.
+func parseWikiDraft(reply string, sources []citedSource) (string, []WikiDraftCitation, error) {
+	var parsed struct {
+		Content   string `json:"content"`
+		Citations []struct {
+			Claim  string `json:"claim"`
+			Source string `json:"source"`
+			Quote  string `json:"quote"`
+		} `json:"citations"`
+	}
+	if err := decodeModelJSON(reply, &parsed); err != nil {
+		return "", nil, err
+	}
+	content := strings.TrimSpace(parsed.Content)
+	if content == "" {
+		return "", nil, fmt.Errorf("%w: empty page content", ErrInvalidModelOutput)
+	}
+
+	byLabel := make(map[string]citedSource, len(sources))
+	for _, source := range sources {
+		byLabel[source.label] = source
+	}
+
+	citations := []WikiDraftCitation{}
+	for _, item := range parsed.Citations {
+		source, ok := byLabel[strings.Trim(strings.TrimSpace(item.Source), "[]")]
+		if !ok {
+			log.Printf("wiki draft: dropping citation of unknown source %q", item.Source)
+			continue
+		}
+		citations = append(citations, WikiDraftCitation{
+			Claim:      strings.TrimSpace(item.Claim),
+			Quote:      strings.TrimSpace(item.Quote),
+			SourceType: source.source.SourceType,
+			SourceID:   source.source.SourceID,
+			Title:      source.source.Title,
+			ChunkID:    source.source.ChunkID,
+		})
+	}
+
+	return content, citations, nil
+}
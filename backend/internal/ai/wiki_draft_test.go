@@ -0,0 +1,52 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/wiki"
+)
+
+func TestParseWikiDraft(t *testing.T) {
+	sources := []citedSource{
+		{label: "S1", source: RewriteContextSource{SourceType: "chapter", SourceID: "ch-1", Title: "Arrival", ChunkID: "chunk-1"}},
+		{label: "S2", source: RewriteContextSource{SourceType: "chapter", SourceID: "ch-3", Title: "The Mill", ChunkID: "chunk-9"}},
+	}
+	reply := "```json\n" + `{
+		"content": "## Description\nMara has green eyes and a scar on her left hand.\n",
+		"citations": [
+			{"claim": "Mara has green eyes", "source": "S1", "quote": "her green eyes"},
+			{"claim": "a scar on her left hand", "source": "S2", "quote": "the old scar across her left hand"},
+			{"claim": "born in Ostwick", "source": "S7", "quote": "made up"}
+		]
+	}` + "\n```"
+
+	content, citations, err := parseWikiDraft(reply, sources)
+	require.NoError(t, err)
+	assert.Equal(t, "## Description\nMara has green eyes and a scar on her left hand.", content)
+	require.Len(t, citations, 2, "citations of unknown sources are dropped")
+	assert.Equal(t, WikiDraftCitation{
+		Claim:      "a scar on her left hand",
+		Quote:      "the old scar across her left hand",
+		SourceType: "chapter",
+		SourceID:   "ch-3",
+		Title:      "The Mill",
+		ChunkID:    "chunk-9",
+	}, citations[1])
+
+	_, _, err = parseWikiDraft(`{"content": "  ", "citations": []}`, sources)
+	assert.ErrorIs(t, err, ErrInvalidModelOutput)
+}
+
+func TestBuildWikiDraftUserPrompt(t *testing.T) {
+	sources := []citedSource{{label: "S1", heading: `Chapter 1: "Arrival"`, content: "Mara stepped off the boat."}}
+
+	prompt := buildWikiDraftUserPrompt(&wiki.WikiPage{Title: "Mara", PageType: "character"}, sources)
+	assert.Contains(t, prompt, "[S1] Chapter 1: \"Arrival\"\nMara stepped off the boat.")
+	assert.Contains(t, prompt, "The page is empty")
+
+	prompt = buildWikiDraftUserPrompt(&wiki.WikiPage{Title: "Mara", PageType: "character", Content: "Eyes: green"}, sources)
+	assert.Contains(t, prompt, "Current Content:\n---\nEyes: green\n---")
+}
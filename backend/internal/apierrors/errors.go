@@ -26,6 +26,7 @@ const (
 	ErrCodeNotFound            = "not_found"
 	ErrCodeConflict            = "conflict"
 	ErrCodeValidationFailed    = "validation_failed"
+	ErrCodeBudgetExceeded      = "budget_exceeded"
 	ErrCodeInternalServerError = "internal_server_error"
 )
 
@@ -70,6 +71,10 @@ func ValidationFailed(c echo.Context, message string, details map[string]interfa
 	return RespondWithError(c, http.StatusBadRequest, ErrCodeValidationFailed, message, details)
 }
 
+func BudgetExceeded(c echo.Context, message string, details map[string]interface{}) error {
+	return RespondWithError(c, http.StatusTooManyRequests, ErrCodeBudgetExceeded, message, details)
+}
+
 func InternalServerError(c echo.Context, message string) error {
 	return RespondWithError(c, http.StatusInternalServerError, ErrCodeInternalServerError, message, nil)
 }
@@ -0,0 +1,93 @@
+package chapters
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/imphyy/NovelCraft/backend/internal/textdiff"
+)
+
+// RevisionCurrent stands for a chapter's current content wherever a revision
+// ID is expected in a diff
+const RevisionCurrent = "current"
+
+// ErrInvalidRevisionID means a diff named a revision that is neither a UUID
+// nor RevisionCurrent
+var ErrInvalidRevisionID = errors.New("revision must be a revision ID or current")
+
+// DiffSide identifies one side of a RevisionDiff
+type DiffSide struct {
+	// RevisionID is RevisionCurrent for the chapter's current content
+	RevisionID string `json:"revisionId"`
+	Note       string `json:"note,omitempty"`
+	// SavedAt is when the revision was taken, or when the chapter was last
+	// updated
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// RevisionDiff compares two revisions of a chapter
+type RevisionDiff struct {
+	ChapterID string   `json:"chapterId"`
+	From      DiffSide `json:"from"`
+	To        DiffSide `json:"to"`
+	textdiff.ParagraphDiff
+}
+
+// DiffRevisions diffs two revisions of a chapter, either of which may be
+// RevisionCurrent, by paragraph and then by word
+func (s *Service) DiffRevisions(ctx context.Context, chapterID, userID, from, to string) (*RevisionDiff, error) {
+	for _, revisionID := range []string{from, to} {
+		if !validRevisionID(revisionID) {
+			return nil, ErrInvalidRevisionID
+		}
+	}
+
+	chapter, err := s.Get(ctx, chapterID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	fromSide, fromContent, err := s.diffSide(ctx, chapter, userID, from)
+	if err != nil {
+		return nil, err
+	}
+	toSide, toContent, err := s.diffSide(ctx, chapter, userID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevisionDiff{
+		ChapterID:     chapter.ID,
+		From:          fromSide,
+		To:            toSide,
+		ParagraphDiff: textdiff.Paragraphs(fromContent, toContent),
+	}, nil
+}
+
+// diffSide resolves a revision ID, or RevisionCurrent, to its content. A
+// revision of another chapter is not found.
+func (s *Service) diffSide(ctx context.Context, chapter *Chapter, userID, revisionID string) (DiffSide, string, error) {
+	if revisionID == RevisionCurrent {
+		return DiffSide{RevisionID: RevisionCurrent, SavedAt: chapter.UpdatedAt}, chapter.Content, nil
+	}
+
+	revision, err := s.GetRevision(ctx, revisionID, userID)
+	if err != nil {
+		return DiffSide{}, "", err
+	}
+	if revision.ChapterID != chapter.ID {
+		return DiffSide{}, "", ErrRevisionNotFound
+	}
+	return DiffSide{RevisionID: revision.ID, Note: revision.Note, SavedAt: revision.CreatedAt}, revision.Content, nil
+}
+
+func validRevisionID(revisionID string) bool {
+	if revisionID == RevisionCurrent {
+		return true
+	}
+	var id pgtype.UUID
+	return id.Scan(revisionID) == nil
+}
@@ -0,0 +1,15 @@
+package chapters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidRevisionID(t *testing.T) {
+	assert.True(t, validRevisionID(RevisionCurrent))
+	assert.True(t, validRevisionID("123e4567-e89b-12d3-a456-426614174000"))
+	assert.False(t, validRevisionID(""))
+	assert.False(t, validRevisionID("latest"))
+	assert.False(t, validRevisionID("123e4567-e89b-12d3-a456"))
+}
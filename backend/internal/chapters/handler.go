@@ -2,10 +2,12 @@ package chapters
 
 import (
 	"context"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/imphyy/NovelCraft/backend/internal/versioning"
 )
 
 // WikiLinkRebuilder interface for rebuilding wiki links
@@ -13,22 +15,22 @@ type WikiLinkRebuilder interface {
 	RebuildLinksForChapter(ctx context.Context, projectID, chapterID, content string) error
 }
 
-// DocumentProcessor interface for processing content for AI
-type DocumentProcessor interface {
-	ProcessDocument(ctx context.Context, projectID, sourceType, sourceID, content string) error
+// DocumentIndexer interface for queueing content for AI processing
+type DocumentIndexer interface {
+	EnqueueDocument(ctx context.Context, projectID, sourceType, sourceID string) error
 }
 
 type Handler struct {
 	service           *Service
 	wikiLinkRebuilder WikiLinkRebuilder
-	documentProcessor DocumentProcessor
+	documentIndexer   DocumentIndexer
 }
 
-func NewHandler(service *Service, wikiLinkRebuilder WikiLinkRebuilder, documentProcessor DocumentProcessor) *Handler {
+func NewHandler(service *Service, wikiLinkRebuilder WikiLinkRebuilder, documentIndexer DocumentIndexer) *Handler {
 	return &Handler{
 		service:           service,
 		wikiLinkRebuilder: wikiLinkRebuilder,
-		documentProcessor: documentProcessor,
+		documentIndexer:   documentIndexer,
 	}
 }
 
@@ -75,6 +77,7 @@ func (h *Handler) Create(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create chapter")
 	}
 
+	versioning.SetETag(c, chapter.Version)
 	return c.JSON(http.StatusCreated, chapter)
 }
 
@@ -95,19 +98,23 @@ func (h *Handler) Get(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get chapter")
 	}
 
+	versioning.SetETag(c, chapter.Version)
 	return c.JSON(http.StatusOK, chapter)
 }
 
 // Update godoc
 // PATCH /api/chapters/:id
+// The version being edited is required, as an If-Match ETag or the version
+// field. A stale version is answered with 409 and a Conflict.
 func (h *Handler) Update(c echo.Context) error {
 	userID := c.Get("user_id").(string)
 	chapterID := c.Param("id")
 
 	var req struct {
 		Title   *string `json:"title" validate:"omitempty,min=1,max=255"`
-		Status  *string `json:"status" validate:"omitempty,oneof=draft writing revision complete"`
+		Status  *string `json:"status" validate:"omitempty,chapter_status"`
 		Content *string `json:"content"`
+		Version *int    `json:"version"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -118,8 +125,24 @@ func (h *Handler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	chapter, err := h.service.Update(c.Request().Context(), chapterID, userID, req.Title, req.Status, req.Content)
+	version, err := versioning.Expected(c, req.Version)
+	if err != nil {
+		if err == versioning.ErrVersionRequired {
+			return echo.NewHTTPError(http.StatusPreconditionRequired, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	chapter, err := h.service.Update(c.Request().Context(), chapterID, userID, version, req.Title, req.Status, req.Content)
 	if err != nil {
+		if err == ErrVersionConflict {
+			conflict, err := h.service.Conflict(c.Request().Context(), chapterID, userID, version, req.Content)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update chapter")
+			}
+			versioning.SetETag(c, conflict.Current.Version)
+			return c.JSON(http.StatusConflict, conflict)
+		}
 		if err == ErrNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
 		}
@@ -137,55 +160,15 @@ func (h *Handler) Update(c echo.Context) error {
 		}
 	}
 
-	// Process document for AI (chunk and embed) in background
-	if req.Content != nil && h.documentProcessor != nil {
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					println("PANIC: Document embedding panicked:", r)
-				}
-			}()
-			println("DEBUG: Starting document embedding for chapter:", chapter.ID)
-			println("DEBUG: Chapter content length:", len(chapter.Content), "bytes")
-			println("DEBUG: Project ID:", chapter.ProjectID)
-
-			// Create context with timeout to prevent hanging forever
-			ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-			defer cancel()
-
-			// Start a progress ticker
-			done := make(chan bool)
-			go func() {
-				ticker := time.NewTicker(5 * time.Second)
-				defer ticker.Stop()
-				elapsed := 0
-				for {
-					select {
-					case <-ticker.C:
-						elapsed += 5
-						println("DEBUG: [PROGRESS] Embedding still in progress... elapsed:", elapsed, "seconds")
-					case <-done:
-						return
-					}
-				}
-			}()
-
-			if err := h.documentProcessor.ProcessDocument(ctx, chapter.ProjectID, "chapter", chapter.ID, chapter.Content); err != nil {
-				println("ERROR: Failed to process document for AI:", err.Error())
-			} else {
-				println("DEBUG: Successfully embedded document for chapter:", chapter.ID)
-			}
-			close(done)
-		}()
-	} else {
-		if req.Content == nil {
-			println("DEBUG: Content is nil, skipping embedding")
-		}
-		if h.documentProcessor == nil {
-			println("DEBUG: Document processor is nil (AI not configured)")
+	// Queue document for AI processing (chunk and embed)
+	if req.Content != nil && h.documentIndexer != nil {
+		if err := h.documentIndexer.EnqueueDocument(c.Request().Context(), chapter.ProjectID, "chapter", chapter.ID); err != nil {
+			// Log error but don't fail the request - the next save re-queues it
+			log.Printf("failed to queue chapter %s for embedding: %v", chapter.ID, err)
 		}
 	}
 
+	versioning.SetETag(c, chapter.Version)
 	return c.JSON(http.StatusOK, chapter)
 }
 
@@ -217,6 +200,82 @@ func (h *Handler) Reorder(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// Delete godoc
+// DELETE /api/chapters/:id
+func (h *Handler) Delete(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	chapterID := c.Param("id")
+
+	if err := h.service.Delete(c.Request().Context(), chapterID, userID); err != nil {
+		if err == ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete chapter")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListTrash godoc
+// GET /api/projects/:projectId/chapters/trash
+func (h *Handler) ListTrash(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	projectID := c.Param("projectId")
+
+	chapters, err := h.service.ListTrash(c.Request().Context(), projectID, userID)
+	if err != nil {
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to list trash")
+	}
+
+	return c.JSON(http.StatusOK, chapters)
+}
+
+// Restore godoc
+// POST /api/chapters/:id/restore
+func (h *Handler) Restore(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	chapterID := c.Param("id")
+
+	chapter, err := h.service.Restore(c.Request().Context(), chapterID, userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found in trash")
+		}
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore chapter")
+	}
+
+	versioning.SetETag(c, chapter.Version)
+	return c.JSON(http.StatusOK, chapter)
+}
+
+// Purge godoc
+// DELETE /api/chapters/:id/purge
+func (h *Handler) Purge(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	chapterID := c.Param("id")
+
+	if err := h.service.Purge(c.Request().Context(), chapterID, userID); err != nil {
+		if err == ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found in trash")
+		}
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to purge chapter")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 // CreateRevision godoc
 // POST /api/chapters/:id/revisions
 func (h *Handler) CreateRevision(c echo.Context) error {
@@ -269,6 +328,41 @@ func (h *Handler) ListRevisions(c echo.Context) error {
 	return c.JSON(http.StatusOK, revisions)
 }
 
+// DiffRevisions godoc
+// GET /api/chapters/:id/revisions/diff?from=&to=
+func (h *Handler) DiffRevisions(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	chapterID := c.Param("id")
+
+	from := c.QueryParam("from")
+	if from == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "from is required")
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		to = RevisionCurrent
+	}
+
+	diff, err := h.service.DiffRevisions(c.Request().Context(), chapterID, userID, from, to)
+	if err != nil {
+		if err == ErrInvalidRevisionID {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err == ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "chapter not found")
+		}
+		if err == ErrUnauthorized {
+			return echo.NewHTTPError(http.StatusForbidden, "access denied")
+		}
+		if err == ErrRevisionNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "revision not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to diff revisions")
+	}
+
+	return c.JSON(http.StatusOK, diff)
+}
+
 // GetRevision godoc
 // GET /api/revisions/:id
 func (h *Handler) GetRevision(c echo.Context) error {
@@ -294,5 +388,6 @@ func (h *Handler) RestoreRevision(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "revision not found")
 	}
 
+	versioning.SetETag(c, chapter.Version)
 	return c.JSON(http.StatusOK, chapter)
 }
@@ -0,0 +1,63 @@
+package chapters
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// periodic runs a background task now and then every interval until Shutdown
+type periodic struct {
+	interval time.Duration
+	run      func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newPeriodic(interval time.Duration, run func()) *periodic {
+	return &periodic{
+		interval: interval,
+		run:      run,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start runs the task now and then every interval until Shutdown
+func (p *periodic) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.run()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown stops the task, waiting for a run in progress to finish
+func (p *periodic) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -14,14 +14,21 @@ import (
 var (
 	ErrNotFound     = errors.New("chapter not found")
 	ErrUnauthorized = errors.New("unauthorized access to chapter")
+	// ErrVersionConflict means the chapter was saved since the version an
+	// update was based on
+	ErrVersionConflict  = errors.New("chapter was changed by another save")
+	ErrRevisionNotFound = errors.New("revision not found")
 )
 
 type Service struct {
-	db *pgxpool.Pool
+	db        *pgxpool.Pool
+	snapshots SnapshotPolicy
 }
 
-func NewService(db *pgxpool.Pool) *Service {
-	return &Service{db: db}
+// NewService creates a chapter service whose saves take revisions according
+// to snapshots
+func NewService(db *pgxpool.Pool, snapshots SnapshotPolicy) *Service {
+	return &Service{db: db, snapshots: snapshots}
 }
 
 type Chapter struct {
@@ -32,8 +39,11 @@ type Chapter struct {
 	Status    string    `json:"status"`
 	Content   string    `json:"content"`
 	WordCount int       `json:"wordCount"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// DeletedAt is set while the chapter is in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 type ChapterRevision struct {
@@ -41,6 +51,7 @@ type ChapterRevision struct {
 	ChapterID string    `json:"chapterId"`
 	Content   string    `json:"content"`
 	Note      string    `json:"note"`
+	Kind      string    `json:"kind"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -52,9 +63,9 @@ func (s *Service) ListByProject(ctx context.Context, projectID, userID string) (
 	}
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, project_id, sort_order, title, status, content, created_at, updated_at
+		SELECT id, project_id, sort_order, title, status, content, version, created_at, updated_at
 		FROM chapters
-		WHERE project_id = $1
+		WHERE project_id = $1 AND deleted_at IS NULL
 		ORDER BY sort_order ASC
 	`, projectID)
 	if err != nil {
@@ -65,7 +76,7 @@ func (s *Service) ListByProject(ctx context.Context, projectID, userID string) (
 	var chapters []Chapter
 	for rows.Next() {
 		var c Chapter
-		if err := rows.Scan(&c.ID, &c.ProjectID, &c.SortOrder, &c.Title, &c.Status, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.ProjectID, &c.SortOrder, &c.Title, &c.Status, &c.Content, &c.Version, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan chapter: %w", err)
 		}
 		c.WordCount = calculateWordCount(c.Content)
@@ -85,7 +96,7 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title string) (
 	// Get next sort_order
 	var maxOrder int
 	err := s.db.QueryRow(ctx, `
-		SELECT COALESCE(MAX(sort_order), 0) FROM chapters WHERE project_id = $1
+		SELECT COALESCE(MAX(sort_order), 0) FROM chapters WHERE project_id = $1 AND deleted_at IS NULL
 	`, projectID).Scan(&maxOrder)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get max sort_order: %w", err)
@@ -95,7 +106,7 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title string) (
 	err = s.db.QueryRow(ctx, `
 		INSERT INTO chapters (project_id, sort_order, title)
 		VALUES ($1, $2, $3)
-		RETURNING id, project_id, sort_order, title, status, content, created_at, updated_at
+		RETURNING id, project_id, sort_order, title, status, content, version, created_at, updated_at
 	`, projectID, maxOrder+1, title).Scan(
 		&chapter.ID,
 		&chapter.ProjectID,
@@ -103,6 +114,7 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title string) (
 		&chapter.Title,
 		&chapter.Status,
 		&chapter.Content,
+		&chapter.Version,
 		&chapter.CreatedAt,
 		&chapter.UpdatedAt,
 	)
@@ -119,10 +131,10 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title string) (
 func (s *Service) Get(ctx context.Context, chapterID, userID string) (*Chapter, error) {
 	var chapter Chapter
 	err := s.db.QueryRow(ctx, `
-		SELECT c.id, c.project_id, c.sort_order, c.title, c.status, c.content, c.created_at, c.updated_at
+		SELECT c.id, c.project_id, c.sort_order, c.title, c.status, c.content, c.version, c.created_at, c.updated_at
 		FROM chapters c
 		JOIN projects p ON c.project_id = p.id
-		WHERE c.id = $1 AND p.user_id = $2
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
 	`, chapterID, userID).Scan(
 		&chapter.ID,
 		&chapter.ProjectID,
@@ -130,6 +142,7 @@ func (s *Service) Get(ctx context.Context, chapterID, userID string) (*Chapter,
 		&chapter.Title,
 		&chapter.Status,
 		&chapter.Content,
+		&chapter.Version,
 		&chapter.CreatedAt,
 		&chapter.UpdatedAt,
 	)
@@ -145,12 +158,21 @@ func (s *Service) Get(ctx context.Context, chapterID, userID string) (*Chapter,
 	return &chapter, nil
 }
 
-// Update updates a chapter
-func (s *Service) Update(ctx context.Context, chapterID, userID string, title, status, content *string) (*Chapter, error) {
+// Update updates a chapter. version is the version the change was based on:
+// if the chapter has been saved since, nothing changes and
+// ErrVersionConflict is returned. A version of 0 updates whatever is there.
+func (s *Service) Update(ctx context.Context, chapterID, userID string, version int, title, status, content *string) (*Chapter, error) {
+	return s.update(ctx, chapterID, userID, version, title, status, content, "")
+}
+
+// update saves a chapter. A non-empty restoreNote marks the save as a restore,
+// which keeps the content it replaces as a revision with that note instead of
+// following the snapshot policy.
+func (s *Service) update(ctx context.Context, chapterID, userID string, version int, title, status, content *string, restoreNote string) (*Chapter, error) {
 	// Build dynamic update query
 	updates := []string{}
-	args := []interface{}{chapterID, userID}
-	argPos := 3
+	args := []interface{}{chapterID}
+	argPos := 2
 
 	if title != nil {
 		updates = append(updates, fmt.Sprintf("title = $%d", argPos))
@@ -174,46 +196,94 @@ func (s *Service) Update(ctx context.Context, chapterID, userID string, title, s
 		return s.Get(ctx, chapterID, userID)
 	}
 
-	updates = append(updates, "updated_at = now()")
+	updates = append(updates, "version = version + 1", "updated_at = now()")
+
+	// Diff against the latest revision before locking the row, so a long
+	// chapter doesn't hold up other saves while it's compared
+	var editedWords int
+	if content != nil && restoreNote == "" {
+		var err error
+		if editedWords, err = s.editedWords(ctx, chapterID, *content); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Verify ownership and lock the row until the new version is saved
+	var currentVersion int
+	var currentContent string
+	var lastSaved time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT c.version, c.content, c.updated_at
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
+		FOR UPDATE OF c
+	`, chapterID, userID).Scan(&currentVersion, &currentContent, &lastSaved)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+
+	if version != 0 && version != currentVersion {
+		return nil, ErrVersionConflict
+	}
 
 	query := fmt.Sprintf(`
-		UPDATE chapters c
+		UPDATE chapters
 		SET %s
-		FROM projects p
-		WHERE c.id = $1 AND c.project_id = p.id AND p.user_id = $2
-		RETURNING c.id, c.project_id, c.sort_order, c.title, c.status, c.content, c.created_at, c.updated_at
+		WHERE id = $1
+		RETURNING id, project_id, sort_order, title, status, content, version, created_at, updated_at
 	`, strings.Join(updates, ", "))
 
 	var chapter Chapter
-	err := s.db.QueryRow(ctx, query, args...).Scan(
+	err = tx.QueryRow(ctx, query, args...).Scan(
 		&chapter.ID,
 		&chapter.ProjectID,
 		&chapter.SortOrder,
 		&chapter.Title,
 		&chapter.Status,
 		&chapter.Content,
+		&chapter.Version,
 		&chapter.CreatedAt,
 		&chapter.UpdatedAt,
 	)
 
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
-		}
 		return nil, fmt.Errorf("failed to update chapter: %w", err)
 	}
 
+	if content != nil && *content != currentContent {
+		if err := keepVersions(ctx, tx, chapterID, currentVersion, currentContent, chapter.Version, chapter.Content); err != nil {
+			return nil, err
+		}
+		if restoreNote != "" {
+			if _, err := insertRevision(ctx, tx, chapterID, currentContent, RevisionRestore, restoreNote); err != nil {
+				return nil, err
+			}
+		} else if err := s.autoSnapshot(ctx, tx, chapterID, currentContent, lastSaved, editedWords); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	chapter.WordCount = calculateWordCount(chapter.Content)
 	return &chapter, nil
 }
 
-// Reorder reorders chapters within a project
+// Reorder reorders chapters within a project. Chapters missing from
+// orderedChapterIDs keep their relative order after the listed ones.
 func (s *Service) Reorder(ctx context.Context, projectID, userID string, orderedChapterIDs []string) error {
-	// Verify ownership
-	if err := s.verifyProjectOwnership(ctx, projectID, userID); err != nil {
-		return err
-	}
-
 	// Start transaction
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
@@ -221,18 +291,47 @@ func (s *Service) Reorder(ctx context.Context, projectID, userID string, ordered
 	}
 	defer tx.Rollback(ctx)
 
+	// Verify ownership
+	if err := lockProject(ctx, tx, projectID, userID); err != nil {
+		return err
+	}
+
+	// Park the current order at negative positions so the new positions
+	// never collide with a chapter that hasn't moved yet
+	_, err = tx.Exec(ctx, `
+		UPDATE chapters SET sort_order = -sort_order
+		WHERE project_id = $1 AND deleted_at IS NULL
+	`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to update sort_order: %w", err)
+	}
+
 	// Update each chapter's sort_order
 	for i, chapterID := range orderedChapterIDs {
 		_, err := tx.Exec(ctx, `
 			UPDATE chapters
 			SET sort_order = $1
-			WHERE id = $2 AND project_id = $3
+			WHERE id = $2 AND project_id = $3 AND deleted_at IS NULL
 		`, i+1, chapterID, projectID)
 		if err != nil {
 			return fmt.Errorf("failed to update sort_order: %w", err)
 		}
 	}
 
+	_, err = tx.Exec(ctx, `
+		UPDATE chapters c
+		SET sort_order = $2 + r.position
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY sort_order DESC) AS position
+			FROM chapters
+			WHERE project_id = $1 AND deleted_at IS NULL AND sort_order < 0
+		) r
+		WHERE c.id = r.id
+	`, projectID, len(orderedChapterIDs))
+	if err != nil {
+		return fmt.Errorf("failed to update sort_order: %w", err)
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -248,16 +347,22 @@ func (s *Service) CreateRevision(ctx context.Context, chapterID, userID, note st
 		return nil, err
 	}
 
+	return insertRevision(ctx, s.db, chapter.ID, chapter.Content, RevisionManual, note)
+}
+
+// insertRevision records content as a revision of a chapter
+func insertRevision(ctx context.Context, db querier, chapterID, content, kind, note string) (*ChapterRevision, error) {
 	var revision ChapterRevision
-	err = s.db.QueryRow(ctx, `
-		INSERT INTO chapter_revisions (chapter_id, content, note)
-		VALUES ($1, $2, $3)
-		RETURNING id, chapter_id, content, note, created_at
-	`, chapter.ID, chapter.Content, note).Scan(
+	err := db.QueryRow(ctx, `
+		INSERT INTO chapter_revisions (chapter_id, content, note, kind)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, chapter_id, content, note, kind, created_at
+	`, chapterID, content, note, kind).Scan(
 		&revision.ID,
 		&revision.ChapterID,
 		&revision.Content,
 		&revision.Note,
+		&revision.Kind,
 		&revision.CreatedAt,
 	)
 
@@ -276,7 +381,7 @@ func (s *Service) ListRevisions(ctx context.Context, chapterID, userID string) (
 	}
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, chapter_id, note, created_at
+		SELECT id, chapter_id, note, kind, created_at
 		FROM chapter_revisions
 		WHERE chapter_id = $1
 		ORDER BY created_at DESC
@@ -289,7 +394,7 @@ func (s *Service) ListRevisions(ctx context.Context, chapterID, userID string) (
 	var revisions []ChapterRevision
 	for rows.Next() {
 		var r ChapterRevision
-		if err := rows.Scan(&r.ID, &r.ChapterID, &r.Note, &r.CreatedAt); err != nil {
+		if err := rows.Scan(&r.ID, &r.ChapterID, &r.Note, &r.Kind, &r.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan revision: %w", err)
 		}
 		revisions = append(revisions, r)
@@ -302,22 +407,23 @@ func (s *Service) ListRevisions(ctx context.Context, chapterID, userID string) (
 func (s *Service) GetRevision(ctx context.Context, revisionID, userID string) (*ChapterRevision, error) {
 	var revision ChapterRevision
 	err := s.db.QueryRow(ctx, `
-		SELECT r.id, r.chapter_id, r.content, r.note, r.created_at
+		SELECT r.id, r.chapter_id, r.content, r.note, r.kind, r.created_at
 		FROM chapter_revisions r
 		JOIN chapters c ON r.chapter_id = c.id
 		JOIN projects p ON c.project_id = p.id
-		WHERE r.id = $1 AND p.user_id = $2
+		WHERE r.id = $1 AND p.user_id = $2 AND c.deleted_at IS NULL
 	`, revisionID, userID).Scan(
 		&revision.ID,
 		&revision.ChapterID,
 		&revision.Content,
 		&revision.Note,
+		&revision.Kind,
 		&revision.CreatedAt,
 	)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("revision not found")
+			return nil, ErrRevisionNotFound
 		}
 		return nil, fmt.Errorf("failed to get revision: %w", err)
 	}
@@ -325,7 +431,8 @@ func (s *Service) GetRevision(ctx context.Context, revisionID, userID string) (*
 	return &revision, nil
 }
 
-// RestoreRevision restores a chapter to a previous revision
+// RestoreRevision restores a chapter to a previous revision, first keeping
+// the content it replaces as a revision of its own
 func (s *Service) RestoreRevision(ctx context.Context, revisionID, userID string) (*Chapter, error) {
 	// Get revision
 	revision, err := s.GetRevision(ctx, revisionID, userID)
@@ -334,7 +441,8 @@ func (s *Service) RestoreRevision(ctx context.Context, revisionID, userID string
 	}
 
 	// Update chapter with revision content
-	return s.Update(ctx, revision.ChapterID, userID, nil, nil, &revision.Content)
+	note := "Before restoring the revision of " + revision.CreatedAt.UTC().Format("2006-01-02 15:04 UTC")
+	return s.update(ctx, revision.ChapterID, userID, 0, nil, nil, &revision.Content, note)
 }
 
 func (s *Service) verifyProjectOwnership(ctx context.Context, projectID, userID string) error {
@@ -0,0 +1,177 @@
+package chapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/imphyy/NovelCraft/backend/internal/textdiff"
+)
+
+// Revision kinds
+const (
+	// RevisionManual revisions are taken by the user and never thinned
+	RevisionManual = "manual"
+	// RevisionAuto revisions are taken by saves under the SnapshotPolicy and
+	// thinned by the RetentionPolicy
+	RevisionAuto = "auto"
+	// RevisionRestore revisions keep the content a restore replaced
+	RevisionRestore = "restore"
+)
+
+const autoSnapshotNote = "Automatic snapshot"
+
+// SnapshotPolicy decides when a save keeps the content it replaces as an
+// automatic revision. The zero value takes none.
+type SnapshotPolicy struct {
+	// IdleInterval snapshots the content of a chapter that had gone unsaved
+	// this long, so each writing session can be undone as a whole
+	IdleInterval time.Duration
+	// ChangedWords snapshots once this many words have been added or removed
+	// since the latest revision, so rewriting a passage counts even when its
+	// length stays the same
+	ChangedWords int
+}
+
+// due reports whether a save should take a snapshot, given how long the
+// chapter had gone unsaved and how many words have been edited since the
+// latest revision
+func (p SnapshotPolicy) due(unsaved time.Duration, editedWords int) bool {
+	if p.IdleInterval > 0 && unsaved >= p.IdleInterval {
+		return true
+	}
+	return p.ChangedWords > 0 && editedWords >= p.ChangedWords
+}
+
+// RetentionPolicy thins automatic revisions as they age: all are kept for
+// KeepAll, then the latest of each hour until KeepHourly, then the latest of
+// each day
+type RetentionPolicy struct {
+	KeepAll    time.Duration
+	KeepHourly time.Duration
+}
+
+// querier is satisfied by both the pool and a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// editedWords counts the words added or removed between a chapter's latest
+// revision and content. The diff is run by the save before it takes the
+// chapter's row lock, and is bounded by textdiff.MaxEdits per paragraph run.
+func (s *Service) editedWords(ctx context.Context, chapterID, content string) (int, error) {
+	if s.snapshots.ChangedWords <= 0 {
+		return 0, nil
+	}
+
+	latest, err := latestRevisionContent(ctx, s.db, chapterID)
+	if err != nil {
+		return 0, err
+	}
+	stats := textdiff.Paragraphs(latest, content).Stats
+	return stats.WordsAdded + stats.WordsRemoved, nil
+}
+
+// latestRevisionContent returns the content of a chapter's latest revision,
+// or "" if it has none
+func latestRevisionContent(ctx context.Context, db querier, chapterID string) (string, error) {
+	var latest string
+	err := db.QueryRow(ctx, `
+		SELECT content FROM chapter_revisions
+		WHERE chapter_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, chapterID).Scan(&latest)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to get latest revision: %w", err)
+	}
+	return latest, nil
+}
+
+// autoSnapshot applies the snapshot policy to a save that replaced content,
+// last saved at lastSaved, with editedWords words edited since the latest
+// revision
+func (s *Service) autoSnapshot(ctx context.Context, tx pgx.Tx, chapterID, content string, lastSaved time.Time, editedWords int) error {
+	if content == "" {
+		return nil
+	}
+
+	latest, err := latestRevisionContent(ctx, tx, chapterID)
+	if err != nil {
+		return err
+	}
+	if latest == content {
+		return nil
+	}
+
+	if !s.snapshots.due(time.Since(lastSaved), editedWords) {
+		return nil
+	}
+
+	_, err = insertRevision(ctx, tx, chapterID, content, RevisionAuto, autoSnapshotNote)
+	return err
+}
+
+// ThinRevisions deletes the automatic revisions that policy no longer keeps,
+// returning how many were deleted
+func (s *Service) ThinRevisions(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	return s.thinRevisions(ctx, policy, time.Now())
+}
+
+// thinRevisions keeps the latest automatic revision of each chapter in each
+// UTC hour or day, by age at now
+func (s *Service) thinRevisions(ctx context.Context, policy RetentionPolicy, now time.Time) (int64, error) {
+	result, err := s.db.Exec(ctx, `
+		DELETE FROM chapter_revisions
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY chapter_id, CASE
+						WHEN created_at >= $2 THEN date_trunc('hour', created_at AT TIME ZONE 'UTC')
+						ELSE date_trunc('day', created_at AT TIME ZONE 'UTC')
+					END
+					ORDER BY created_at DESC
+				) AS rank
+				FROM chapter_revisions
+				WHERE kind = 'auto' AND created_at < $1
+			) ranked
+			WHERE rank > 1
+		)
+	`, now.Add(-policy.KeepAll), now.Add(-policy.KeepHourly))
+	if err != nil {
+		return 0, fmt.Errorf("failed to thin revisions: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// RevisionPruner periodically thins automatic revisions
+type RevisionPruner struct {
+	*periodic
+	service *Service
+	policy  RetentionPolicy
+}
+
+// NewRevisionPruner thins automatic revisions to policy every interval
+func NewRevisionPruner(service *Service, policy RetentionPolicy, interval time.Duration) *RevisionPruner {
+	p := &RevisionPruner{service: service, policy: policy}
+	p.periodic = newPeriodic(interval, p.prune)
+	return p
+}
+
+func (p *RevisionPruner) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pruned, err := p.service.ThinRevisions(ctx, p.policy)
+	if err != nil {
+		log.Printf("chapter revisions: prune failed: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("chapter revisions: pruned %d automatic revisions", pruned)
+	}
+}
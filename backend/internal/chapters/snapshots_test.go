@@ -0,0 +1,221 @@
+package chapters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/db/dbtest"
+)
+
+func TestSnapshotPolicy_Due(t *testing.T) {
+	policy := SnapshotPolicy{IdleInterval: 30 * time.Minute, ChangedWords: 200}
+	tests := []struct {
+		name        string
+		policy      SnapshotPolicy
+		unsaved     time.Duration
+		editedWords int
+		want        bool
+	}{
+		{name: "busy and small", policy: policy, unsaved: time.Minute, editedWords: 10, want: false},
+		{name: "just short of idle", policy: policy, unsaved: 30*time.Minute - time.Second, want: false},
+		{name: "idle", policy: policy, unsaved: 30 * time.Minute, want: true},
+		{name: "just short of changed", policy: policy, unsaved: time.Minute, editedWords: 199, want: false},
+		{name: "changed", policy: policy, unsaved: time.Minute, editedWords: 200, want: true},
+		{name: "idle disabled", policy: SnapshotPolicy{ChangedWords: 200}, unsaved: 48 * time.Hour, editedWords: 5, want: false},
+		{name: "changed disabled", policy: SnapshotPolicy{IdleInterval: time.Hour}, unsaved: time.Minute, editedWords: 5000, want: false},
+		{name: "zero policy", unsaved: 48 * time.Hour, editedWords: 5000, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.due(tt.unsaved, tt.editedWords))
+		})
+	}
+}
+
+func TestThinRevisions(t *testing.T) {
+	pool := dbtest.Connect(t)
+	_, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	chapterIDs := make([]string, 2)
+	for i := range chapterIDs {
+		err := pool.QueryRow(ctx, `
+			INSERT INTO chapters (project_id, sort_order, title) VALUES ($1, $2, 'Chapter') RETURNING id
+		`, projectID, i+1).Scan(&chapterIDs[i])
+		require.NoError(t, err)
+	}
+
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	revisions := []struct {
+		chapter int
+		note    string
+		kind    string
+		at      time.Time
+	}{
+		// Within a day: all kept
+		{0, "recent", RevisionAuto, now.Add(-time.Hour)},
+		{0, "recent, same hour", RevisionAuto, now.Add(-time.Hour - 10*time.Minute)},
+		// Within a week: the latest of each hour
+		{0, "hourly", RevisionAuto, time.Date(2026, 3, 9, 6, 30, 0, 0, time.UTC)},
+		{0, "hourly, earlier that hour", RevisionAuto, time.Date(2026, 3, 9, 6, 10, 0, 0, time.UTC)},
+		{0, "hourly, hour before", RevisionAuto, time.Date(2026, 3, 9, 5, 50, 0, 0, time.UTC)},
+		{1, "hourly, other chapter", RevisionAuto, time.Date(2026, 3, 9, 6, 20, 0, 0, time.UTC)},
+		// Older: the latest of each day
+		{0, "daily", RevisionAuto, time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)},
+		{0, "daily, earlier that day", RevisionAuto, time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{0, "daily, day after", RevisionAuto, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+		// Only automatic revisions are thinned
+		{0, "manual", RevisionManual, time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{0, "restore", RevisionRestore, time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, r := range revisions {
+		_, err := pool.Exec(ctx, `
+			INSERT INTO chapter_revisions (chapter_id, content, note, kind, created_at)
+			VALUES ($1, '', $2, $3, $4)
+		`, chapterIDs[r.chapter], r.note, r.kind, r.at)
+		require.NoError(t, err)
+	}
+
+	service := NewService(pool, SnapshotPolicy{})
+	deleted, err := service.thinRevisions(ctx, RetentionPolicy{KeepAll: 24 * time.Hour, KeepHourly: 7 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	rows, err := pool.Query(ctx, `
+		SELECT note FROM chapter_revisions WHERE chapter_id = ANY($1::uuid[]) ORDER BY note
+	`, chapterIDs)
+	require.NoError(t, err)
+	kept, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"daily",
+		"daily, day after",
+		"hourly",
+		"hourly, hour before",
+		"hourly, other chapter",
+		"manual",
+		"recent",
+		"recent, same hour",
+		"restore",
+	}, kept)
+}
+
+// revisions returns the kind and content of a chapter's revisions, oldest first
+func revisions(t *testing.T, pool *pgxpool.Pool, chapterID string) [][2]string {
+	t.Helper()
+	rows, err := pool.Query(context.Background(), `
+		SELECT kind, content FROM chapter_revisions WHERE chapter_id = $1 ORDER BY created_at
+	`, chapterID)
+	require.NoError(t, err)
+	kept, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) ([2]string, error) {
+		var r [2]string
+		err := row.Scan(&r[0], &r[1])
+		return r, err
+	})
+	require.NoError(t, err)
+	return kept
+}
+
+func TestService_UpdateSnapshots(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{IdleInterval: time.Hour, ChangedWords: 6})
+
+	chapter, err := service.Create(ctx, projectID, userID, "Chapter")
+	require.NoError(t, err)
+	save := func(service *Service, content string) {
+		t.Helper()
+		_, err := service.Update(ctx, chapter.ID, userID, 0, nil, nil, &content)
+		require.NoError(t, err)
+	}
+
+	const (
+		first     = "The storm broke over the harbour."
+		touchedUp = "The storm broke over the bay."
+		rewritten = "Rain hammered every roof in town."
+		tidied    = "Rain hammered every roof in the town."
+	)
+	save(service, first)
+	_, err = service.CreateRevision(ctx, chapter.ID, userID, "first draft")
+	require.NoError(t, err)
+
+	// A small edit is under ChangedWords
+	save(service, touchedUp)
+	assert.Equal(t, [][2]string{{RevisionManual, first}}, revisions(t, pool, chapter.ID))
+
+	// Rewriting the sentence keeps its length but edits every word, so the
+	// content it replaces is snapshotted
+	save(service, rewritten)
+	assert.Equal(t, [][2]string{
+		{RevisionManual, first},
+		{RevisionAuto, touchedUp},
+	}, revisions(t, pool, chapter.ID))
+
+	// Without ChangedWords only a save after IdleInterval snapshots
+	idleOnly := NewService(pool, SnapshotPolicy{IdleInterval: time.Hour})
+	save(idleOnly, tidied)
+	assert.Len(t, revisions(t, pool, chapter.ID), 2)
+	_, err = pool.Exec(ctx, `UPDATE chapters SET updated_at = now() - interval '2 hours' WHERE id = $1`, chapter.ID)
+	require.NoError(t, err)
+	save(idleOnly, rewritten)
+	assert.Equal(t, [][2]string{
+		{RevisionManual, first},
+		{RevisionAuto, touchedUp},
+		{RevisionAuto, tidied},
+	}, revisions(t, pool, chapter.ID))
+
+	// Saving the title alone never snapshots
+	title := "Renamed"
+	_, err = pool.Exec(ctx, `UPDATE chapters SET updated_at = now() - interval '2 hours' WHERE id = $1`, chapter.ID)
+	require.NoError(t, err)
+	_, err = service.Update(ctx, chapter.ID, userID, 0, &title, nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, revisions(t, pool, chapter.ID), 3)
+}
+
+func TestService_RestoreRevision(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	// Every save would snapshot under this policy; restores keep their own
+	// revision instead
+	service := NewService(pool, SnapshotPolicy{ChangedWords: 1})
+
+	chapter, err := service.Create(ctx, projectID, userID, "Chapter")
+	require.NoError(t, err)
+	draft, later := "The storm broke over the harbour.", "The storm passed."
+	_, err = service.Update(ctx, chapter.ID, userID, 0, nil, nil, &draft)
+	require.NoError(t, err)
+	revision, err := service.CreateRevision(ctx, chapter.ID, userID, "first draft")
+	require.NoError(t, err)
+	saved, err := service.Update(ctx, chapter.ID, userID, 0, nil, nil, &later)
+	require.NoError(t, err)
+
+	otherUser, _ := dbtest.Project(t, pool)
+	_, err = service.RestoreRevision(ctx, revision.ID, otherUser)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	restored, err := service.RestoreRevision(ctx, revision.ID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, draft, restored.Content)
+	assert.Equal(t, saved.Version+1, restored.Version)
+
+	// The content the restore replaced is kept as a restore revision
+	assert.Equal(t, [][2]string{
+		{RevisionManual, draft},
+		{RevisionRestore, later},
+	}, revisions(t, pool, chapter.ID))
+	list, err := service.ListRevisions(ctx, chapter.ID, userID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, RevisionRestore, list[0].Kind)
+	assert.Contains(t, list[0].Note, "Before restoring the revision of ")
+}
@@ -0,0 +1,29 @@
+package chapters
+
+import (
+	"slices"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Chapter statuses, in the order a chapter moves through them
+const (
+	StatusDraft    = "draft"
+	StatusWriting  = "writing"
+	StatusRevision = "revision"
+	StatusComplete = "complete"
+)
+
+// Statuses lists every chapter status
+var Statuses = []string{StatusDraft, StatusWriting, StatusRevision, StatusComplete}
+
+// StatusValidation is the validator tag that accepts one of Statuses
+const StatusValidation = "chapter_status"
+
+// RegisterValidation registers StatusValidation with v, so that anything
+// taking a chapter status checks it against the same list
+func RegisterValidation(v *validator.Validate) error {
+	return v.RegisterValidation(StatusValidation, func(fl validator.FieldLevel) bool {
+		return slices.Contains(Statuses, fl.Field().String())
+	})
+}
@@ -0,0 +1,325 @@
+package chapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Delete moves a chapter to its project's trash. Its revisions, wiki links
+// and AI documents are kept but hidden until the chapter is restored, and the
+// chapters after it move up to close the gap.
+func (s *Service) Delete(ctx context.Context, chapterID, userID string) error {
+	chapter, err := s.Get(ctx, chapterID, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockProject(ctx, tx, chapter.ProjectID, userID); err != nil {
+		return err
+	}
+
+	var sortOrder int
+	err = tx.QueryRow(ctx, `
+		UPDATE chapters
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING sort_order
+	`, chapterID).Scan(&sortOrder)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete chapter: %w", err)
+	}
+
+	if err := shiftChapters(ctx, tx, chapter.ProjectID, sortOrder+1, -1); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ListTrash returns a project's trashed chapters, most recently deleted first
+func (s *Service) ListTrash(ctx context.Context, projectID, userID string) ([]Chapter, error) {
+	if err := s.verifyProjectOwnership(ctx, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, project_id, sort_order, title, status, content, version, created_at, updated_at, deleted_at
+		FROM chapters
+		WHERE project_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.ID, &c.ProjectID, &c.SortOrder, &c.Title, &c.Status, &c.Content, &c.Version, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan chapter: %w", err)
+		}
+		c.WordCount = calculateWordCount(c.Content)
+		chapters = append(chapters, c)
+	}
+
+	return chapters, nil
+}
+
+// Restore takes a chapter out of the trash and puts it back at the position
+// it was deleted from, or at the end if the project has fewer chapters now
+func (s *Service) Restore(ctx context.Context, chapterID, userID string) (*Chapter, error) {
+	projectID, err := s.trashedProject(ctx, chapterID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockProject(ctx, tx, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	var position, live int
+	err = tx.QueryRow(ctx, `
+		SELECT c.sort_order,
+		       (SELECT COUNT(*) FROM chapters WHERE project_id = c.project_id AND deleted_at IS NULL)
+		FROM chapters c
+		WHERE c.id = $1 AND c.deleted_at IS NOT NULL
+	`, chapterID).Scan(&position, &live)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get chapter: %w", err)
+	}
+	if position > live+1 {
+		position = live + 1
+	}
+
+	if err := shiftChapters(ctx, tx, projectID, position, 1); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE chapters SET deleted_at = NULL, sort_order = $2 WHERE id = $1
+	`, chapterID, position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore chapter: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.Get(ctx, chapterID, userID)
+}
+
+// Purge permanently deletes a trashed chapter
+func (s *Service) Purge(ctx context.Context, chapterID, userID string) error {
+	projectID, err := s.trashedProject(ctx, chapterID, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockProject(ctx, tx, projectID, userID); err != nil {
+		return err
+	}
+
+	purged, err := purgeChapters(ctx, tx, []string{chapterID})
+	if err != nil {
+		return err
+	}
+	if purged == 0 {
+		// Restored before the project lock was taken
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeExpired permanently deletes chapters that have been in the trash
+// since before cutoff, returning how many were deleted
+func (s *Service) PurgeExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the rows makes a concurrent Restore either finish first, so the
+	// chapter no longer matches, or wait until it is gone
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM chapters WHERE deleted_at < $1 FOR UPDATE
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired chapters: %w", err)
+	}
+	chapterIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan expired chapters: %w", err)
+	}
+	if len(chapterIDs) == 0 {
+		return 0, nil
+	}
+
+	purged, err := purgeChapters(ctx, tx, chapterIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return purged, nil
+}
+
+// trashedProject returns the project of a trashed chapter the user owns
+func (s *Service) trashedProject(ctx context.Context, chapterID, userID string) (string, error) {
+	var projectID string
+	err := s.db.QueryRow(ctx, `
+		SELECT c.project_id
+		FROM chapters c
+		JOIN projects p ON c.project_id = p.id
+		WHERE c.id = $1 AND p.user_id = $2 AND c.deleted_at IS NOT NULL
+	`, chapterID, userID).Scan(&projectID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to get chapter: %w", err)
+	}
+	return projectID, nil
+}
+
+// lockProject verifies ownership and locks the project row, serialising
+// changes to the order of its chapters until tx ends
+func lockProject(ctx context.Context, tx pgx.Tx, projectID, userID string) error {
+	var id string
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM projects WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, projectID, userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUnauthorized
+		}
+		return fmt.Errorf("failed to verify ownership: %w", err)
+	}
+	return nil
+}
+
+// shiftChapters moves the live chapters at or after position from by delta.
+// They pass through negative positions on the way so that no intermediate
+// row collides with the unique index on (project_id, sort_order).
+func shiftChapters(ctx context.Context, tx pgx.Tx, projectID string, from, delta int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE chapters SET sort_order = -(sort_order + $3)
+		WHERE project_id = $1 AND deleted_at IS NULL AND sort_order >= $2
+	`, projectID, from, delta)
+	if err != nil {
+		return fmt.Errorf("failed to update sort_order: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE chapters SET sort_order = -sort_order
+		WHERE project_id = $1 AND deleted_at IS NULL AND sort_order < 0
+	`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to update sort_order: %w", err)
+	}
+	return nil
+}
+
+// purgeChapters deletes those of chapterIDs that are still in the trash,
+// along with the wiki links, embedding jobs and AI documents that refer to
+// them by source. Revisions and summaries cascade with the chapter.
+func purgeChapters(ctx context.Context, tx pgx.Tx, chapterIDs []string) (int64, error) {
+	rows, err := tx.Query(ctx, `
+		DELETE FROM chapters WHERE id = ANY($1::uuid[]) AND deleted_at IS NOT NULL
+		RETURNING id
+	`, chapterIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge chapters: %w", err)
+	}
+	purged, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge chapters: %w", err)
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	for _, table := range []string{"wiki_links", "embedding_jobs", "documents"} {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM `+table+` WHERE source_type = 'chapter' AND source_id = ANY($1::uuid[])
+		`, purged)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete chapter %s: %w", table, err)
+		}
+	}
+
+	return int64(len(purged)), nil
+}
+
+// TrashPurger periodically purges chapters that have been in the trash
+// longer than the retention period
+type TrashPurger struct {
+	*periodic
+	service   *Service
+	retention time.Duration
+}
+
+// NewTrashPurger purges chapters trashed more than retention ago, checking
+// every interval
+func NewTrashPurger(service *Service, retention, interval time.Duration) *TrashPurger {
+	p := &TrashPurger{service: service, retention: retention}
+	p.periodic = newPeriodic(interval, p.purge)
+	return p
+}
+
+func (p *TrashPurger) purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	purged, err := p.service.PurgeExpired(ctx, time.Now().Add(-p.retention))
+	if err != nil {
+		log.Printf("chapter trash: purge failed: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("chapter trash: purged %d chapters", purged)
+	}
+}
@@ -0,0 +1,237 @@
+package chapters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/db/dbtest"
+)
+
+// createChapters appends chapters with the given titles to a project,
+// returning their IDs by title
+func createChapters(t *testing.T, service *Service, projectID, userID string, titles ...string) map[string]string {
+	t.Helper()
+	ids := make(map[string]string)
+	for _, title := range titles {
+		chapter, err := service.Create(context.Background(), projectID, userID, title)
+		require.NoError(t, err)
+		ids[title] = chapter.ID
+	}
+	return ids
+}
+
+// liveOrder returns the titles of a project's live chapters in order,
+// checking that their positions run 1..n without gaps
+func liveOrder(t *testing.T, service *Service, projectID, userID string) []string {
+	t.Helper()
+	chapters, err := service.ListByProject(context.Background(), projectID, userID)
+	require.NoError(t, err)
+	titles := make([]string, len(chapters))
+	for i, c := range chapters {
+		assert.Equal(t, i+1, c.SortOrder, "position of %q", c.Title)
+		titles[i] = c.Title
+	}
+	return titles
+}
+
+func countRows(t *testing.T, pool *pgxpool.Pool, query string, args ...any) int {
+	t.Helper()
+	var n int
+	require.NoError(t, pool.QueryRow(context.Background(), query, args...).Scan(&n))
+	return n
+}
+
+func TestService_DeleteAndRestore(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{})
+
+	ids := createChapters(t, service, projectID, userID, "A", "B", "C", "D")
+
+	// Deleting closes the gap
+	require.NoError(t, service.Delete(ctx, ids["B"], userID))
+	assert.Equal(t, []string{"A", "C", "D"}, liveOrder(t, service, projectID, userID))
+	assert.ErrorIs(t, service.Delete(ctx, ids["B"], userID), ErrNotFound)
+	_, err := service.Get(ctx, ids["B"], userID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	trash, err := service.ListTrash(ctx, projectID, userID)
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.Equal(t, ids["B"], trash[0].ID)
+	assert.NotNil(t, trash[0].DeletedAt)
+
+	// Restoring puts it back where it was
+	restored, err := service.Restore(ctx, ids["B"], userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.SortOrder)
+	assert.Equal(t, []string{"A", "B", "C", "D"}, liveOrder(t, service, projectID, userID))
+	_, err = service.Restore(ctx, ids["B"], userID)
+	assert.ErrorIs(t, err, ErrNotFound, "only trashed chapters can be restored")
+
+	// A position past the end is clamped to the end
+	require.NoError(t, service.Delete(ctx, ids["D"], userID))
+	require.NoError(t, service.Delete(ctx, ids["A"], userID))
+	require.NoError(t, service.Delete(ctx, ids["B"], userID))
+	assert.Equal(t, []string{"C"}, liveOrder(t, service, projectID, userID))
+	restored, err = service.Restore(ctx, ids["D"], userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.SortOrder)
+	assert.Equal(t, []string{"C", "D"}, liveOrder(t, service, projectID, userID))
+
+	// New chapters go after the live ones, whatever trashed chapters held
+	createChapters(t, service, projectID, userID, "E")
+	assert.Equal(t, []string{"C", "D", "E"}, liveOrder(t, service, projectID, userID))
+
+	otherUser, _ := dbtest.Project(t, pool)
+	assert.ErrorIs(t, service.Delete(ctx, ids["C"], otherUser), ErrNotFound)
+	_, err = service.Restore(ctx, ids["A"], otherUser)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestService_Reorder(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{})
+
+	ids := createChapters(t, service, projectID, userID, "A", "B", "C", "D")
+	// B keeps position 2 in the trash, alongside C's live position 2
+	require.NoError(t, service.Delete(ctx, ids["B"], userID))
+
+	// Every position is rewritten through negative parking, so no statement
+	// collides with idx_chapters_live_sort_order
+	require.NoError(t, service.Reorder(ctx, projectID, userID, []string{ids["D"], ids["A"], ids["C"]}))
+	assert.Equal(t, []string{"D", "A", "C"}, liveOrder(t, service, projectID, userID))
+
+	// Chapters left out keep their relative order after the listed ones
+	require.NoError(t, service.Reorder(ctx, projectID, userID, []string{ids["C"]}))
+	assert.Equal(t, []string{"C", "D", "A"}, liveOrder(t, service, projectID, userID))
+
+	// The trashed chapter kept its position and goes back into it
+	restored, err := service.Restore(ctx, ids["B"], userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.SortOrder)
+	assert.Equal(t, []string{"C", "B", "D", "A"}, liveOrder(t, service, projectID, userID))
+
+	otherUser, _ := dbtest.Project(t, pool)
+	assert.ErrorIs(t, service.Reorder(ctx, projectID, otherUser, []string{ids["A"]}), ErrUnauthorized)
+}
+
+// addSourceRows gives a chapter a wiki link, an AI document and a pending
+// embedding job, the rows that refer to it by source
+func addSourceRows(t *testing.T, pool *pgxpool.Pool, projectID, chapterID string) {
+	t.Helper()
+	ctx := context.Background()
+
+	var pageID string
+	err := pool.QueryRow(ctx, `
+		INSERT INTO wiki_pages (project_id, title, slug, page_type)
+		VALUES ($1, 'Mara', gen_random_uuid()::text, 'character')
+		RETURNING id
+	`, projectID).Scan(&pageID)
+	require.NoError(t, err)
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO wiki_links (project_id, source_type, source_id, target_page_id) VALUES ($1, 'chapter', $2, $3)
+	`, projectID, chapterID, pageID)
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, `
+		INSERT INTO documents (project_id, source_type, source_id, content, content_hash) VALUES ($1, 'chapter', $2, '', '')
+	`, projectID, chapterID)
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, `
+		INSERT INTO embedding_jobs (project_id, source_type, source_id) VALUES ($1, 'chapter', $2)
+	`, projectID, chapterID)
+	require.NoError(t, err)
+}
+
+// sourceRows counts the rows that refer to a chapter by source
+func sourceRows(t *testing.T, pool *pgxpool.Pool, chapterID string) int {
+	t.Helper()
+	return countRows(t, pool, `
+		SELECT (SELECT COUNT(*) FROM wiki_links WHERE source_type = 'chapter' AND source_id = $1)
+		     + (SELECT COUNT(*) FROM documents WHERE source_type = 'chapter' AND source_id = $1)
+		     + (SELECT COUNT(*) FROM embedding_jobs WHERE source_type = 'chapter' AND source_id = $1)
+	`, chapterID)
+}
+
+func TestService_Purge(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{})
+
+	ids := createChapters(t, service, projectID, userID, "A", "B")
+	addSourceRows(t, pool, projectID, ids["A"])
+	_, err := service.CreateRevision(ctx, ids["A"], userID, "before purge")
+	require.NoError(t, err)
+
+	// Live chapters can't be purged
+	assert.ErrorIs(t, service.Purge(ctx, ids["A"], userID), ErrNotFound)
+
+	require.NoError(t, service.Delete(ctx, ids["A"], userID))
+	otherUser, _ := dbtest.Project(t, pool)
+	assert.ErrorIs(t, service.Purge(ctx, ids["A"], otherUser), ErrNotFound)
+
+	require.NoError(t, service.Purge(ctx, ids["A"], userID))
+	assert.Equal(t, 0, countRows(t, pool, `SELECT COUNT(*) FROM chapters WHERE id = $1`, ids["A"]))
+	assert.Equal(t, 0, countRows(t, pool, `SELECT COUNT(*) FROM chapter_revisions WHERE chapter_id = $1`, ids["A"]))
+	assert.Equal(t, 0, sourceRows(t, pool, ids["A"]))
+	assert.Equal(t, []string{"B"}, liveOrder(t, service, projectID, userID))
+}
+
+func TestService_PurgeExpired(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{})
+
+	ids := createChapters(t, service, projectID, userID, "expired", "recent", "live")
+	for _, title := range []string{"expired", "recent", "live"} {
+		addSourceRows(t, pool, projectID, ids[title])
+	}
+	require.NoError(t, service.Delete(ctx, ids["expired"], userID))
+	require.NoError(t, service.Delete(ctx, ids["recent"], userID))
+	_, err := pool.Exec(ctx, `UPDATE chapters SET deleted_at = now() - interval '60 days' WHERE id = $1`, ids["expired"])
+	require.NoError(t, err)
+
+	purged, err := service.PurgeExpired(ctx, time.Now().Add(-30*24*time.Hour))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, int64(1))
+
+	assert.Equal(t, 0, countRows(t, pool, `SELECT COUNT(*) FROM chapters WHERE id = $1`, ids["expired"]))
+	assert.Equal(t, 0, sourceRows(t, pool, ids["expired"]))
+	assert.Equal(t, 1, countRows(t, pool, `SELECT COUNT(*) FROM chapters WHERE id = $1`, ids["recent"]))
+	assert.Equal(t, 3, sourceRows(t, pool, ids["recent"]))
+	assert.Equal(t, 3, sourceRows(t, pool, ids["live"]))
+}
+
+func TestPurgeChapters_SkipsRestored(t *testing.T) {
+	pool := dbtest.Connect(t)
+	userID, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+	service := NewService(pool, SnapshotPolicy{})
+
+	// A chapter listed for purging but restored before the delete ran keeps
+	// its row and everything that refers to it
+	ids := createChapters(t, service, projectID, userID, "A")
+	addSourceRows(t, pool, projectID, ids["A"])
+
+	tx, err := pool.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	purged, err := purgeChapters(ctx, tx, []string{ids["A"]})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	assert.Equal(t, int64(0), purged)
+	assert.Equal(t, []string{"A"}, liveOrder(t, service, projectID, userID))
+	assert.Equal(t, 3, sourceRows(t, pool, ids["A"]))
+}
@@ -0,0 +1,34 @@
+package chapters
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/imphyy/NovelCraft/backend/internal/versioning"
+)
+
+var chapterVersions = versioning.Table{Name: "chapter_versions", IDColumn: "chapter_id", Noun: "chapter"}
+
+// Conflict is the answer to an update rejected with ErrVersionConflict
+type Conflict = versioning.Conflict[*Chapter]
+
+// Conflict describes how a rejected update based on version differs from the
+// chapter as it is now, merging its content with the content saved since
+func (s *Service) Conflict(ctx context.Context, chapterID, userID string, version int, content *string) (*Conflict, error) {
+	current, err := s.Get(ctx, chapterID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	merge, err := chapterVersions.Merge(ctx, s.db, chapterID, version, content, current.Content)
+	if err != nil {
+		return nil, err
+	}
+	return &Conflict{Message: ErrVersionConflict.Error(), Current: current, Merge: merge}, nil
+}
+
+// keepVersions records the content a save replaced and the content it saved
+func keepVersions(ctx context.Context, tx pgx.Tx, chapterID string, oldVersion int, oldContent string, newVersion int, newContent string) error {
+	return chapterVersions.Keep(ctx, tx, chapterID, oldVersion, oldContent, newVersion, newContent)
+}
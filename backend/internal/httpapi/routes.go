@@ -41,13 +41,18 @@ func setupRoutes(e *echo.Echo, authHandler *auth.Handler, authService *auth.Serv
 	projectsGroup.GET("/:projectId/chapters", chaptersHandler.ListByProject)
 	projectsGroup.POST("/:projectId/chapters", chaptersHandler.Create)
 	projectsGroup.POST("/:projectId/chapters/reorder", chaptersHandler.Reorder)
+	projectsGroup.GET("/:projectId/chapters/trash", chaptersHandler.ListTrash)
 
 	// Chapters routes (all protected)
 	chaptersGroup := api.Group("/chapters", auth.RequireAuth(authService))
 	chaptersGroup.GET("/:id", chaptersHandler.Get)
 	chaptersGroup.PATCH("/:id", chaptersHandler.Update)
+	chaptersGroup.DELETE("/:id", chaptersHandler.Delete)
+	chaptersGroup.POST("/:id/restore", chaptersHandler.Restore)
+	chaptersGroup.DELETE("/:id/purge", chaptersHandler.Purge)
 	chaptersGroup.POST("/:id/revisions", chaptersHandler.CreateRevision)
 	chaptersGroup.GET("/:id/revisions", chaptersHandler.ListRevisions)
+	chaptersGroup.GET("/:id/revisions/diff", chaptersHandler.DiffRevisions)
 
 	// Revisions routes (all protected)
 	revisionsGroup := api.Group("/revisions", auth.RequireAuth(authService))
@@ -76,7 +81,31 @@ func setupRoutes(e *echo.Echo, authHandler *auth.Handler, authService *auth.Serv
 	// AI routes (all protected, optional - only if AI services configured)
 	if aiHandler != nil {
 		projectsGroup.POST("/:projectId/ai/ask", aiHandler.Ask)
+		projectsGroup.POST("/:projectId/ai/ask/stream", aiHandler.AskStream)
+		projectsGroup.GET("/:projectId/ai/index-status", aiHandler.IndexStatus)
+		projectsGroup.POST("/:projectId/ai/reindex", aiHandler.Reindex)
+		projectsGroup.POST("/:projectId/ai/reindex/stream", aiHandler.ReindexStream)
+		projectsGroup.GET("/:projectId/ai/settings", aiHandler.GetSettings)
+		projectsGroup.PUT("/:projectId/ai/settings", aiHandler.UpdateSettings)
+		projectsGroup.POST("/:projectId/ai/entities", aiHandler.ExtractProjectEntities)
+		projectsGroup.POST("/:projectId/ai/entities/create", aiHandler.CreateEntityPages)
+		projectsGroup.POST("/:projectId/ai/story-so-far", aiHandler.StorySoFar)
+		projectsGroup.GET("/:projectId/ai/threads", aiHandler.ListThreads)
+		projectsGroup.POST("/:projectId/ai/threads", aiHandler.CreateThread)
+		projectsGroup.GET("/:projectId/ai/threads/:threadId", aiHandler.GetThread)
+		projectsGroup.DELETE("/:projectId/ai/threads/:threadId", aiHandler.DeleteThread)
+		projectsGroup.POST("/:projectId/ai/threads/:threadId/messages", aiHandler.AskInThread)
+		projectsGroup.POST("/:projectId/ai/threads/:threadId/messages/stream", aiHandler.AskInThreadStream)
 		chaptersGroup.POST("/:id/ai/rewrite", aiHandler.Rewrite)
+		chaptersGroup.POST("/:id/ai/rewrite/stream", aiHandler.RewriteStream)
+		chaptersGroup.POST("/:id/ai/continuity", aiHandler.ContinuityCheck)
+		chaptersGroup.POST("/:id/ai/entities", aiHandler.ExtractChapterEntities)
+		chaptersGroup.GET("/:id/ai/summary", aiHandler.GetChapterSummary)
+		chaptersGroup.POST("/:id/ai/summary", aiHandler.SummarizeChapter)
+		wikiGroup.POST("/:id/ai/draft", aiHandler.DraftWikiPage)
+
+		meGroup := api.Group("/me", auth.RequireAuth(authService))
+		meGroup.GET("/ai-usage", aiHandler.Usage)
 	}
 }
 
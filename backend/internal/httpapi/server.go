@@ -1,6 +1,11 @@
 package httpapi
 
 import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -14,7 +19,15 @@ import (
 	"github.com/imphyy/NovelCraft/backend/internal/wiki"
 )
 
-func NewServer(db *pgxpool.Pool, cfg *config.Config) *echo.Echo {
+// Server wraps the HTTP server together with the background workers it owns
+type Server struct {
+	echo           *echo.Echo
+	jobQueue       *ai.JobQueue
+	trashPurger    *chapters.TrashPurger
+	revisionPruner *chapters.RevisionPruner
+}
+
+func NewServer(db *pgxpool.Pool, cfg *config.Config) *Server {
 	e := echo.New()
 
 	// Validator
@@ -23,7 +36,12 @@ func NewServer(db *pgxpool.Pool, cfg *config.Config) *echo.Echo {
 	// Middleware
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
-	e.Use(middleware.Gzip())
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		// Compressing Server-Sent Events delays delivery until the buffer fills
+		Skipper: func(c echo.Context) bool {
+			return strings.HasSuffix(c.Path(), "/stream")
+		},
+	}))
 	e.Use(middleware.Secure())
 	e.Use(middleware.BodyLimit("2M"))
 
@@ -32,7 +50,8 @@ func NewServer(db *pgxpool.Pool, cfg *config.Config) *echo.Echo {
 		AllowOrigins:     []string{cfg.CORSOrigin},
 		AllowCredentials: true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "If-Match"},
+		ExposeHeaders:    []string{"ETag"},
 	}))
 
 	// Services
@@ -42,27 +61,89 @@ func NewServer(db *pgxpool.Pool, cfg *config.Config) *echo.Echo {
 	projectsService := projects.NewService(db)
 	projectsHandler := projects.NewHandler(projectsService)
 
-	// AI services (optional - only if API key is configured)
-	var documentService chapters.DocumentProcessor
-	var aiHandler *ai.Handler
-	if cfg.OpenAIAPIKey != "" {
-		embeddingsService := ai.NewEmbeddingsService(cfg.OpenAIAPIKey)
-		documentService = ai.NewDocumentService(db, embeddingsService)
+	// AI services (optional - each is enabled when its provider is configured)
+	var documentIndexer chapters.DocumentIndexer
+	var jobQueue *ai.JobQueue
+	var retrievalService *ai.RetrievalService
+	var reindexer *ai.Reindexer
+	embedder, err := ai.NewEmbedder(cfg)
+	if err != nil {
+		log.Printf("AI embeddings disabled: %v", err)
+	}
+	if embedder != nil {
+		documentService := ai.NewDocumentService(db, embedder, ai.ChunkOptions{
+			TargetTokens:  cfg.ChunkTargetTokens,
+			OverlapTokens: cfg.ChunkOverlapTokens,
+		})
+		jobQueue = ai.NewJobQueue(db, documentService, ai.JobQueueOptions{
+			Workers:     cfg.EmbeddingWorkers,
+			MaxAttempts: cfg.EmbeddingMaxAttempts,
+			Debounce:    2 * time.Second,
+		})
+		documentIndexer = jobQueue
+		reindexer = ai.NewReindexer(db, documentService)
+		retrievalService = ai.NewRetrievalService(db, embedder)
+	}
 
-		// AI feature services
-		retrievalService := ai.NewRetrievalService(db, embeddingsService)
-		chatService := ai.NewChatService(cfg.OpenAIAPIKey)
-		askService := ai.NewAskService(db, retrievalService, chatService)
-		rewriteService := ai.NewRewriteService(chatService)
+	wikiService := wiki.NewService(db)
 
-		aiHandler = ai.NewHandler(askService, rewriteService)
+	var askService *ai.AskService
+	var rewriteService *ai.RewriteService
+	var continuityService *ai.ContinuityService
+	var wikiDraftService *ai.WikiDraftService
+	var summaryService *ai.SummaryService
+	chatProvider, err := ai.NewChatProvider(cfg)
+	if err != nil {
+		log.Printf("AI chat provider disabled: %v", err)
+	}
+	if chatProvider != nil && cfg.AIResponseCacheTTL > 0 {
+		chatProvider = ai.NewCachingChatProvider(chatProvider, db, time.Duration(cfg.AIResponseCacheTTL)*time.Second)
+	}
+	if chatProvider != nil {
+		askService = ai.NewAskService(db, retrievalService, chatProvider)
+		rewriteService = ai.NewRewriteService(db, retrievalService, chatProvider)
+		continuityService = ai.NewContinuityService(db, retrievalService, chatProvider)
+		wikiDraftService = ai.NewWikiDraftService(db, wikiService, retrievalService, chatProvider)
+		summaryService = ai.NewSummaryService(db, chatProvider)
 	}
 
-	wikiService := wiki.NewService(db)
-	wikiHandler := wiki.NewHandler(wikiService, documentService)
+	var aiHandler *ai.Handler
+	if embedder != nil || chatProvider != nil {
+		aiHandler = ai.NewHandler(ai.HandlerDeps{
+			Ask:        askService,
+			Rewrite:    rewriteService,
+			JobQueue:   jobQueue,
+			Usage:      ai.NewUsageService(db, cfg.AIMonthlyTokenBudget),
+			Threads:    ai.NewThreadService(db),
+			Settings:   ai.NewSettingsService(db),
+			Reindexer:  reindexer,
+			Continuity: continuityService,
+			WikiDraft:  wikiDraftService,
+			Entities:   ai.NewEntityService(db, wikiService, chatProvider),
+			Summaries:  summaryService,
+		})
+	}
+
+	wikiHandler := wiki.NewHandler(wikiService, documentIndexer)
 
-	chaptersService := chapters.NewService(db)
-	chaptersHandler := chapters.NewHandler(chaptersService, wikiService, documentService)
+	chaptersService := chapters.NewService(db, chapters.SnapshotPolicy{
+		IdleInterval: time.Duration(cfg.RevisionIdleMinutes) * time.Minute,
+		ChangedWords: cfg.RevisionChangedWords,
+	})
+	chaptersHandler := chapters.NewHandler(chaptersService, wikiService, documentIndexer)
+
+	var trashPurger *chapters.TrashPurger
+	if cfg.ChapterTrashDays > 0 {
+		trashPurger = chapters.NewTrashPurger(chaptersService, time.Duration(cfg.ChapterTrashDays)*24*time.Hour, time.Hour)
+	}
+
+	var revisionPruner *chapters.RevisionPruner
+	if cfg.RevisionKeepAllHours > 0 {
+		revisionPruner = chapters.NewRevisionPruner(chaptersService, chapters.RetentionPolicy{
+			KeepAll:    time.Duration(cfg.RevisionKeepAllHours) * time.Hour,
+			KeepHourly: time.Duration(cfg.RevisionHourlyDays) * 24 * time.Hour,
+		}, time.Hour)
+	}
 
 	searchService := search.NewService(db)
 	searchHandler := search.NewHandler(searchService)
@@ -70,5 +151,40 @@ func NewServer(db *pgxpool.Pool, cfg *config.Config) *echo.Echo {
 	// Routes
 	setupRoutes(e, authHandler, authService, projectsHandler, chaptersHandler, wikiHandler, searchHandler, aiHandler)
 
-	return e
+	return &Server{echo: e, jobQueue: jobQueue, trashPurger: trashPurger, revisionPruner: revisionPruner}
+}
+
+// Start launches the background workers and serves HTTP until Shutdown
+func (s *Server) Start(address string) error {
+	if s.jobQueue != nil {
+		s.jobQueue.Start()
+	}
+	if s.trashPurger != nil {
+		s.trashPurger.Start()
+	}
+	if s.revisionPruner != nil {
+		s.revisionPruner.Start()
+	}
+	return s.echo.Start(address)
+}
+
+// Shutdown stops accepting requests, then drains the background workers
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.echo.Shutdown(ctx)
+	if s.jobQueue != nil {
+		if queueErr := s.jobQueue.Shutdown(ctx); queueErr != nil && err == nil {
+			err = queueErr
+		}
+	}
+	if s.trashPurger != nil {
+		if purgerErr := s.trashPurger.Shutdown(ctx); purgerErr != nil && err == nil {
+			err = purgerErr
+		}
+	}
+	if s.revisionPruner != nil {
+		if prunerErr := s.revisionPruner.Shutdown(ctx); prunerErr != nil && err == nil {
+			err = prunerErr
+		}
+	}
+	return err
 }
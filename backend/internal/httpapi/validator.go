@@ -2,6 +2,8 @@ package httpapi
 
 import (
 	"github.com/go-playground/validator/v10"
+
+	"github.com/imphyy/NovelCraft/backend/internal/chapters"
 )
 
 type CustomValidator struct {
@@ -9,7 +11,11 @@ type CustomValidator struct {
 }
 
 func NewValidator() *CustomValidator {
-	return &CustomValidator{validator: validator.New()}
+	v := validator.New()
+	if err := chapters.RegisterValidation(v); err != nil {
+		panic(err)
+	}
+	return &CustomValidator{validator: v}
 }
 
 func (cv *CustomValidator) Validate(i interface{}) error {
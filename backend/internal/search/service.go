@@ -126,7 +126,7 @@ func (s *Service) Search(ctx context.Context, projectID, userID, query string) (
 	chapterRows, err := s.db.Query(ctx, `
 		SELECT id, title, content, sort_order
 		FROM chapters
-		WHERE project_id = $1
+		WHERE project_id = $1 AND deleted_at IS NULL
 		AND (
 			title ILIKE '%' || $2 || '%'
 			OR content ILIKE '%' || $2 || '%'
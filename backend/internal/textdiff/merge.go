@@ -0,0 +1,143 @@
+package textdiff
+
+import "strings"
+
+// Conflict markers written around the two sides of a conflicting region in
+// merged text
+const (
+	MarkerYours     = "<<<<<<< yours\n"
+	MarkerSeparator = "=======\n"
+	MarkerTheirs    = ">>>>>>> theirs\n"
+)
+
+// Conflict is a region that both sides changed, in different ways
+type Conflict struct {
+	Base   string `json:"base"`
+	Yours  string `json:"yours"`
+	Theirs string `json:"theirs"`
+}
+
+// MergeResult is a three-way merge. Text has every change that only one side
+// made; each conflicting region appears in it between conflict markers.
+type MergeResult struct {
+	Text      string     `json:"text"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Merge combines the changes yours and theirs each made to base, line by
+// line. Changes that touch or overlap each other conflict unless both sides
+// made the same change.
+func Merge(base, yours, theirs string) MergeResult {
+	lines := strings.SplitAfter(base, "\n")
+	ours := changes(lines, strings.SplitAfter(yours, "\n"))
+	others := changes(lines, strings.SplitAfter(theirs, "\n"))
+
+	result := MergeResult{Conflicts: []Conflict{}}
+	var text strings.Builder
+	pos, i, j := 0, 0, 0
+	for i < len(ours) || j < len(others) {
+		// Start a region at the earliest change, then grow it while changes
+		// from either side touch it
+		var start int
+		if j >= len(others) || (i < len(ours) && ours[i].start <= others[j].start) {
+			start = ours[i].start
+		} else {
+			start = others[j].start
+		}
+		end := start
+		var yoursIn, theirsIn []change
+		for grew := true; grew; {
+			grew = false
+			for i < len(ours) && ours[i].start <= end {
+				yoursIn = append(yoursIn, ours[i])
+				end = max(end, ours[i].end)
+				i++
+				grew = true
+			}
+			for j < len(others) && others[j].start <= end {
+				theirsIn = append(theirsIn, others[j])
+				end = max(end, others[j].end)
+				j++
+				grew = true
+			}
+		}
+
+		text.WriteString(strings.Join(lines[pos:start], ""))
+		yoursText := applyChanges(lines, start, end, yoursIn)
+		theirsText := applyChanges(lines, start, end, theirsIn)
+		switch {
+		case len(theirsIn) == 0 || yoursText == theirsText:
+			text.WriteString(yoursText)
+		case len(yoursIn) == 0:
+			text.WriteString(theirsText)
+		default:
+			result.Conflicts = append(result.Conflicts, Conflict{
+				Base:   strings.Join(lines[start:end], ""),
+				Yours:  yoursText,
+				Theirs: theirsText,
+			})
+			text.WriteString(MarkerYours)
+			text.WriteString(withNewline(yoursText))
+			text.WriteString(MarkerSeparator)
+			text.WriteString(withNewline(theirsText))
+			text.WriteString(MarkerTheirs)
+		}
+		pos = end
+	}
+	text.WriteString(strings.Join(lines[pos:], ""))
+
+	result.Text = text.String()
+	return result
+}
+
+// change replaces base[start:end] with lines
+type change struct {
+	start, end int
+	lines      []string
+}
+
+// changes lists the regions of base that other replaced, in order
+func changes(base, other []string) []change {
+	var result []change
+	pos := 0
+	open := false
+	for _, e := range script(base, other) {
+		if e.kind == Equal {
+			pos += len(e.tokens)
+			open = false
+			continue
+		}
+		if !open {
+			result = append(result, change{start: pos, end: pos})
+			open = true
+		}
+		c := &result[len(result)-1]
+		if e.kind == Delete {
+			pos += len(e.tokens)
+			c.end = pos
+		} else {
+			c.lines = append(c.lines, e.tokens...)
+		}
+	}
+	return result
+}
+
+// applyChanges returns base[start:end] with changes applied
+func applyChanges(base []string, start, end int, changes []change) string {
+	var text strings.Builder
+	at := start
+	for _, c := range changes {
+		text.WriteString(strings.Join(base[at:c.start], ""))
+		text.WriteString(strings.Join(c.lines, ""))
+		at = c.end
+	}
+	text.WriteString(strings.Join(base[at:end], ""))
+	return text.String()
+}
+
+func withNewline(text string) string {
+	if text == "" || strings.HasSuffix(text, "\n") {
+		return text
+	}
+	return text + "\n"
+}
@@ -0,0 +1,172 @@
+package textdiff
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Change is the kind of a paragraph hunk that replaces old paragraphs with
+// new ones
+const Change = "change"
+
+var (
+	// A paragraph is a line together with the newlines after it, so blank
+	// lines between paragraphs stay with the paragraph before them
+	paragraphPattern = regexp.MustCompile(`[^\n]*\n+|[^\n]+$`)
+	// Words and runs of whitespace are tokens of their own, as is any other
+	// character, so punctuation changes don't swallow the word beside them
+	wordPattern = regexp.MustCompile(`\s+|[\p{L}\p{N}_'’-]+|.`)
+	wordOnly    = regexp.MustCompile(`[\p{L}\p{N}]`)
+)
+
+// Hunk is a run of paragraphs that are equal in both texts, only in the new
+// text (Insert), only in the old text (Delete), or replaced (Change).
+// Paragraph positions count from 0.
+type Hunk struct {
+	Kind     string `json:"kind"`
+	OldStart int    `json:"oldStart"`
+	OldCount int    `json:"oldCount"`
+	NewStart int    `json:"newStart"`
+	NewCount int    `json:"newCount"`
+	// Text is the paragraphs of an Equal, Insert or Delete hunk
+	Text string `json:"text,omitempty"`
+	// Words are the word-level edits turning the old paragraphs of a Change
+	// hunk into the new ones
+	Words []Op `json:"words,omitempty"`
+}
+
+// Stats summarise a ParagraphDiff
+type Stats struct {
+	WordsAdded        int `json:"wordsAdded"`
+	WordsRemoved      int `json:"wordsRemoved"`
+	ParagraphsAdded   int `json:"paragraphsAdded"`
+	ParagraphsRemoved int `json:"paragraphsRemoved"`
+	ParagraphsChanged int `json:"paragraphsChanged"`
+}
+
+// ParagraphDiff is a diff of prose by paragraph, with changed paragraphs
+// diffed again by word
+type ParagraphDiff struct {
+	Hunks []Hunk `json:"hunks"`
+	Stats Stats  `json:"stats"`
+}
+
+// Paragraphs diffs two texts paragraph by paragraph, then word by word
+// within the paragraphs that changed. Diffing in two levels keeps each diff
+// small, so long chapters with scattered edits stay well under MaxEdits.
+//
+// Paragraphs that differ only in the blank lines after them are equal, and
+// the Text of an Equal hunk is taken from b.
+func Paragraphs(a, b string) ParagraphDiff {
+	diff := ParagraphDiff{Hunks: []Hunk{}}
+	oldPos, newPos := 0, 0
+	var old, new []string
+
+	flush := func() {
+		if len(old) == 0 && len(new) == 0 {
+			return
+		}
+		hunk := Hunk{OldStart: oldPos, OldCount: len(old), NewStart: newPos, NewCount: len(new)}
+		switch {
+		case len(new) == 0:
+			hunk.Kind = Delete
+			hunk.Text = strings.Join(old, "")
+			diff.Stats.WordsRemoved += countWords(hunk.Text)
+			diff.Stats.ParagraphsRemoved += countParagraphs(old)
+		case len(old) == 0:
+			hunk.Kind = Insert
+			hunk.Text = strings.Join(new, "")
+			diff.Stats.WordsAdded += countWords(hunk.Text)
+			diff.Stats.ParagraphsAdded += countParagraphs(new)
+		default:
+			hunk.Kind = Change
+			hunk.Words = Words(strings.Join(old, ""), strings.Join(new, ""))
+			for _, op := range hunk.Words {
+				switch op.Kind {
+				case Insert:
+					diff.Stats.WordsAdded += countWords(op.Text)
+				case Delete:
+					diff.Stats.WordsRemoved += countWords(op.Text)
+				}
+			}
+			oldCount, newCount := countParagraphs(old), countParagraphs(new)
+			changed := min(oldCount, newCount)
+			diff.Stats.ParagraphsChanged += changed
+			diff.Stats.ParagraphsAdded += newCount - changed
+			diff.Stats.ParagraphsRemoved += oldCount - changed
+		}
+		diff.Hunks = append(diff.Hunks, hunk)
+		oldPos += len(old)
+		newPos += len(new)
+		old, new = nil, nil
+	}
+
+	oldParagraphs, newParagraphs := splitParagraphs(a), splitParagraphs(b)
+	for _, e := range script(paragraphKeys(oldParagraphs), paragraphKeys(newParagraphs)) {
+		n := len(e.tokens)
+		switch e.kind {
+		case Equal:
+			flush()
+			diff.Hunks = append(diff.Hunks, Hunk{
+				Kind:     Equal,
+				OldStart: oldPos,
+				OldCount: n,
+				NewStart: newPos,
+				NewCount: n,
+				Text:     strings.Join(newParagraphs[newPos:newPos+n], ""),
+			})
+			oldPos += n
+			newPos += n
+		case Delete:
+			old = append(old, oldParagraphs[oldPos+len(old):oldPos+len(old)+n]...)
+		case Insert:
+			new = append(new, newParagraphs[newPos+len(new):newPos+len(new)+n]...)
+		}
+	}
+	flush()
+
+	return diff
+}
+
+// Words diffs two texts word by word. Joining the Text of the Equal and
+// Delete ops gives a back, and of the Equal and Insert ops gives b.
+func Words(a, b string) []Op {
+	return Diff(wordPattern.FindAllString(a, -1), wordPattern.FindAllString(b, -1))
+}
+
+func splitParagraphs(text string) []string {
+	return paragraphPattern.FindAllString(text, -1)
+}
+
+// paragraphKeys returns the paragraphs without their trailing whitespace, to
+// compare them by
+func paragraphKeys(paragraphs []string) []string {
+	keys := make([]string, len(paragraphs))
+	for i, p := range paragraphs {
+		keys[i] = strings.TrimRightFunc(p, unicode.IsSpace)
+	}
+	return keys
+}
+
+// countWords counts the words of text, ignoring punctuation and whitespace
+func countWords(text string) int {
+	words := 0
+	for _, token := range wordPattern.FindAllString(text, -1) {
+		if wordOnly.MatchString(token) {
+			words++
+		}
+	}
+	return words
+}
+
+// countParagraphs counts the paragraphs that aren't blank
+func countParagraphs(paragraphs []string) int {
+	n := 0
+	for _, p := range paragraphs {
+		if strings.TrimSpace(p) != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,175 @@
+// Package textdiff computes minimal edit scripts between texts split into
+// tokens, using Myers' O(ND) algorithm.
+package textdiff
+
+import "strings"
+
+// Kinds of edit
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// MaxEdits bounds the edit distance searched for. Beyond it the remaining
+// difference is reported as one deletion and one insertion, which keeps time
+// and memory bounded for texts that have little in common.
+const MaxEdits = 2000
+
+// Op is a run of tokens that are equal in both texts, only in the new text
+// (Insert) or only in the old text (Delete)
+type Op struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+// Lines diffs two texts line by line. Joining the Text of the Equal and Delete
+// ops gives a back, and of the Equal and Insert ops gives b.
+func Lines(a, b string) []Op {
+	return Diff(strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n"))
+}
+
+// Diff returns the edits turning the tokens of a into the tokens of b, with
+// consecutive tokens of the same kind joined into one op
+func Diff(a, b []string) []Op {
+	var ops []Op
+	emit := func(kind string, tokens []string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, Op{Kind: kind, Text: text})
+	}
+
+	for _, e := range script(a, b) {
+		emit(e.kind, e.tokens)
+	}
+
+	return ops
+}
+
+type edit struct {
+	kind   string
+	tokens []string
+}
+
+// script returns the edits turning a into b, one token slice per edit
+func script(a, b []string) []edit {
+	// Common prefix and suffix are cheap to strip and usually most of the text
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	if prefix > 0 {
+		edits = append(edits, edit{Equal, a[:prefix]})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	if suffix > 0 {
+		edits = append(edits, edit{Equal, a[len(a)-suffix:]})
+	}
+	return edits
+}
+
+// myers finds a shortest edit script from a to b
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	if n == 0 {
+		return []edit{{Insert, b}}
+	}
+	if m == 0 {
+		return []edit{{Delete, a}}
+	}
+
+	maxD := n + m
+	if maxD > MaxEdits {
+		maxD = MaxEdits
+	}
+
+	// v[k+offset] is the furthest x reached on diagonal k; trace keeps a copy
+	// of the reachable part of v after each round for backtracking
+	offset := maxD + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	found := -1
+	for d := 0; d <= maxD && found < 0; d++ {
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // Right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = d
+				break
+			}
+		}
+	}
+	if found < 0 {
+		return []edit{{Delete, a}, {Insert, b}}
+	}
+
+	// Walk back from (n, m), collecting edits in reverse
+	var reversed []edit
+	x, y := n, m
+	for d := found; d > 0; d-- {
+		prev := trace[d] // v as it was at the start of round d, diagonals -(d-1)..(d-1) at index k+d
+		at := func(k int) int { return prev[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, edit{Equal, a[x-1 : x]})
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, edit{Insert, b[prevY:y]})
+		} else {
+			reversed = append(reversed, edit{Delete, a[prevX:x]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, edit{Equal, a[x-1 : x]})
+		x--
+		y--
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
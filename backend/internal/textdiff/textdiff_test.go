@@ -0,0 +1,162 @@
+package textdiff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apply rebuilds both sides of a diff
+func apply(ops []Op) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.Kind != Insert {
+			a.WriteString(op.Text)
+		}
+		if op.Kind != Delete {
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestLines(t *testing.T) {
+	a := "Mara\nEyes: green\nAge: 20\n"
+	b := "Mara\nEyes: green\nAge: 21\nHome: Ostwick\n"
+
+	assert.Equal(t, []Op{
+		{Equal, "Mara\nEyes: green\n"},
+		{Delete, "Age: 20\n"},
+		{Insert, "Age: 21\nHome: Ostwick\n"},
+	}, Lines(a, b))
+
+	assert.Equal(t, []Op{{Equal, a}}, Lines(a, a))
+	assert.Equal(t, []Op{{Insert, b}}, Lines("", b))
+	assert.Empty(t, Lines("", ""))
+}
+
+func TestDiff_Minimal(t *testing.T) {
+	split := func(s string) []string { return strings.Split(s, "") }
+
+	// The classic example from Myers' paper has an edit distance of 5
+	ops := Diff(split("abcabba"), split("cbabac"))
+	edits := 0
+	for _, op := range ops {
+		if op.Kind != Equal {
+			edits += len(op.Text)
+		}
+	}
+	assert.Equal(t, 5, edits)
+
+	a, b := apply(ops)
+	assert.Equal(t, "abcabba", a)
+	assert.Equal(t, "cbabac", b)
+}
+
+func TestDiff_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"the ", "sea ", "was ", "grey ", "and ", "cold ", "\n"}
+	random := func() []string {
+		tokens := make([]string, rng.Intn(60))
+		for i := range tokens {
+			tokens[i] = words[rng.Intn(len(words))]
+		}
+		return tokens
+	}
+
+	for i := 0; i < 200; i++ {
+		x, y := random(), random()
+		a, b := apply(Diff(x, y))
+		assert.Equal(t, strings.Join(x, ""), a)
+		assert.Equal(t, strings.Join(y, ""), b)
+	}
+}
+
+func TestDiff_MaxEdits(t *testing.T) {
+	a := make([]string, MaxEdits)
+	b := make([]string, MaxEdits)
+	for i := range a {
+		a[i], b[i] = "a", "b"
+	}
+
+	ops := Diff(a, b)
+	assert.Equal(t, []Op{{Delete, strings.Repeat("a", MaxEdits)}, {Insert, strings.Repeat("b", MaxEdits)}}, ops)
+}
+
+func TestMerge(t *testing.T) {
+	base := "Mara rode in.\n\nThe mill was quiet.\n\nShe slept.\n"
+
+	// Edits to different paragraphs combine
+	result := Merge(base,
+		"Mara rode in at dusk.\n\nThe mill was quiet.\n\nShe slept.\n",
+		"Mara rode in.\n\nThe mill was quiet.\n\nShe slept badly.\n")
+	assert.Empty(t, result.Conflicts)
+	assert.Equal(t, "Mara rode in at dusk.\n\nThe mill was quiet.\n\nShe slept badly.\n", result.Text)
+
+	// The same edit on both sides is not a conflict
+	same := "Mara rode in.\n\nThe mill was loud.\n\nShe slept.\n"
+	result = Merge(base, same, same)
+	assert.Empty(t, result.Conflicts)
+	assert.Equal(t, same, result.Text)
+
+	// Different edits to one paragraph conflict
+	result = Merge(base,
+		"Mara rode in.\n\nThe mill was loud.\n\nShe slept.",
+		"Mara rode in.\n\nThe mill burned.\n\nShe slept.\n")
+	assert.Equal(t, []Conflict{{
+		Base:   "The mill was quiet.\n",
+		Yours:  "The mill was loud.\n",
+		Theirs: "The mill burned.\n",
+	}}, result.Conflicts)
+	assert.Equal(t, "Mara rode in.\n\n"+MarkerYours+"The mill was loud.\n"+MarkerSeparator+"The mill burned.\n"+MarkerTheirs+"\nShe slept.", result.Text)
+
+	// Unchanged sides merge to the other side
+	assert.Equal(t, MergeResult{Text: "x\n", Conflicts: []Conflict{}}, Merge(base, base, "x\n"))
+	assert.Equal(t, MergeResult{Text: "x\n", Conflicts: []Conflict{}}, Merge(base, "x\n", base))
+}
+
+func TestParagraphs(t *testing.T) {
+	a := "Mara rode in.\n\nThe mill was quiet.\n\nShe slept.\n"
+	b := "Mara rode in.\n\nThe old mill was loud.\n\nShe slept.\n\nDawn came.\n"
+
+	diff := Paragraphs(a, b)
+	assert.Equal(t, []Hunk{
+		{Kind: Equal, OldStart: 0, OldCount: 1, NewStart: 0, NewCount: 1, Text: "Mara rode in.\n\n"},
+		{Kind: Change, OldStart: 1, OldCount: 1, NewStart: 1, NewCount: 1, Words: []Op{
+			{Equal, "The "},
+			{Insert, "old "},
+			{Equal, "mill was "},
+			{Delete, "quiet"},
+			{Insert, "loud"},
+			{Equal, ".\n\n"},
+		}},
+		// Gaining a blank line after it doesn't change a paragraph
+		{Kind: Equal, OldStart: 2, OldCount: 1, NewStart: 2, NewCount: 1, Text: "She slept.\n\n"},
+		{Kind: Insert, OldStart: 3, OldCount: 0, NewStart: 3, NewCount: 1, Text: "Dawn came.\n"},
+	}, diff.Hunks)
+	assert.Equal(t, Stats{WordsAdded: 4, WordsRemoved: 1, ParagraphsAdded: 1, ParagraphsChanged: 1}, diff.Stats)
+
+	// The hunks rebuild the new text
+	var ops []Op
+	for _, hunk := range diff.Hunks {
+		if hunk.Kind == Change {
+			ops = append(ops, hunk.Words...)
+		} else {
+			ops = append(ops, Op{hunk.Kind, hunk.Text})
+		}
+	}
+	_, rebuilt := apply(ops)
+	assert.Equal(t, b, rebuilt)
+
+	diff = Paragraphs("One.\nTwo.\nThree.\n", "One.\nThree.\n")
+	assert.Equal(t, []Hunk{
+		{Kind: Equal, OldStart: 0, OldCount: 1, NewStart: 0, NewCount: 1, Text: "One.\n"},
+		{Kind: Delete, OldStart: 1, OldCount: 1, NewStart: 1, NewCount: 0, Text: "Two.\n"},
+		{Kind: Equal, OldStart: 2, OldCount: 1, NewStart: 1, NewCount: 1, Text: "Three.\n"},
+	}, diff.Hunks)
+	assert.Equal(t, Stats{WordsRemoved: 1, ParagraphsRemoved: 1}, diff.Stats)
+
+	assert.Equal(t, ParagraphDiff{Hunks: []Hunk{}}, Paragraphs("", ""))
+}
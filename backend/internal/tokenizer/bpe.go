@@ -0,0 +1,105 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// loadTiktokenRanks reads a vocabulary in tiktoken's format: one token per
+// line, base64-encoded bytes followed by the token's rank
+func loadTiktokenRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int, 100_000)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("vocabulary line %d: expected token and rank", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("vocabulary line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("vocabulary line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vocabulary: %w", err)
+	}
+
+	// Byte-level BPE needs every single byte to be a token
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("vocabulary is missing byte 0x%02x", b)
+		}
+	}
+
+	return ranks, nil
+}
+
+// bytePairEncode encodes one pretokenized piece by repeatedly merging the
+// adjacent pair with the lowest rank, as tiktoken does
+func bytePairEncode(piece string, ranks map[string]int) []int {
+	if rank, ok := ranks[piece]; ok {
+		return []int{rank}
+	}
+
+	// bounds[i] is the start of the i-th part; the last entry is len(piece)
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	pairRank := func(i int) int {
+		if i+2 >= len(bounds) {
+			return math.MaxInt
+		}
+		if rank, ok := ranks[piece[bounds[i]:bounds[i+2]]]; ok {
+			return rank
+		}
+		return math.MaxInt
+	}
+
+	pairRanks := make([]int, len(bounds)-1)
+	for i := range pairRanks {
+		pairRanks[i] = pairRank(i)
+	}
+
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i, rank := range pairRanks[:len(bounds)-2] {
+			if rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		// Merge parts best and best+1
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+		pairRanks = append(pairRanks[:best+1], pairRanks[best+2:]...)
+		pairRanks[best] = pairRank(best)
+		if best > 0 {
+			pairRanks[best-1] = pairRank(best - 1)
+		}
+	}
+
+	tokens := make([]int, 0, len(bounds)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		tokens = append(tokens, ranks[piece[bounds[i]:bounds[i+1]]])
+	}
+	return tokens
+}
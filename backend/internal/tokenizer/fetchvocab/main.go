@@ -0,0 +1,79 @@
+// Command fetchvocab downloads OpenAI's cl100k_base vocabulary for the
+// tokenizer to embed, checking it against the hash tiktoken pins. It does
+// nothing when a verified copy is already in place. Run it through
+// go generate ./internal/tokenizer.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	vocabURL    = "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken"
+	vocabSHA256 = "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: fetchvocab <destination>")
+	}
+	if err := fetch(os.Args[1]); err != nil {
+		log.Fatalf("fetchvocab: %v", err)
+	}
+}
+
+func fetch(dest string) error {
+	if data, err := os.ReadFile(dest); err == nil && checksum(data) == vocabSHA256 {
+		return nil
+	}
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Get(vocabURL)
+	if err != nil {
+		return fmt.Errorf("failed to download vocabulary: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download vocabulary: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download vocabulary: %w", err)
+	}
+	if sum := checksum(data); sum != vocabSHA256 {
+		return fmt.Errorf("vocabulary checksum mismatch: got %s, want %s", sum, vocabSHA256)
+	}
+
+	// Write beside the destination and rename, so a failed write never
+	// leaves a truncated vocabulary to be embedded
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".vocab-*")
+	if err != nil {
+		return fmt.Errorf("failed to write vocabulary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write vocabulary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write vocabulary: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to write vocabulary: %w", err)
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
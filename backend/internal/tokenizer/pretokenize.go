@@ -0,0 +1,169 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// pretokenize splits text into the pieces cl100k_base encodes independently.
+// It is a hand-written, linear-time equivalent of the cl100k pattern
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// which Go's regexp package can't express because of the lookahead. onPiece
+// is called with the byte range of each piece, in order; together the pieces
+// cover the whole text.
+func pretokenize(text string, onPiece func(start, end int)) {
+	for i := 0; i < len(text); {
+		end := nextPiece(text, i)
+		onPiece(i, end)
+		i = end
+	}
+}
+
+// nextPiece returns the end of the piece starting at i, trying the pattern's
+// alternatives in order
+func nextPiece(text string, i int) int {
+	r, size := utf8.DecodeRuneInString(text[i:])
+
+	// (?i:'s|'t|'re|'ve|'m|'ll|'d)
+	if r == '\'' {
+		if end := contraction(text, i+size); end > 0 {
+			return end
+		}
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	if isLetter(r) {
+		return skipLetters(text, i+size)
+	}
+	if r != '\r' && r != '\n' && !isNumber(r) {
+		if next, _ := utf8.DecodeRuneInString(text[i+size:]); i+size < len(text) && isLetter(next) {
+			return skipLetters(text, i+size)
+		}
+	}
+
+	// \p{N}{1,3}
+	if isNumber(r) {
+		end := i + size
+		for n := 1; n < 3 && end < len(text); n++ {
+			next, nextSize := utf8.DecodeRuneInString(text[end:])
+			if !isNumber(next) {
+				break
+			}
+			end += nextSize
+		}
+		return end
+	}
+
+	// ` ?[^\s\p{L}\p{N}]+[\r\n]*`
+	start := i
+	if r == ' ' && i+size < len(text) {
+		if next, _ := utf8.DecodeRuneInString(text[i+size:]); isPunctuation(next) {
+			start = i + size
+		}
+	}
+	if next, _ := utf8.DecodeRuneInString(text[start:]); isPunctuation(next) {
+		end := start
+		for end < len(text) {
+			next, nextSize := utf8.DecodeRuneInString(text[end:])
+			if !isPunctuation(next) {
+				break
+			}
+			end += nextSize
+		}
+		for end < len(text) && (text[end] == '\r' || text[end] == '\n') {
+			end++
+		}
+		return end
+	}
+
+	// What's left starts with whitespace. Find the whole run and the last
+	// line break in it.
+	end := i
+	lastBreak := -1
+	for end < len(text) {
+		next, nextSize := utf8.DecodeRuneInString(text[end:])
+		if !unicode.IsSpace(next) {
+			break
+		}
+		if next == '\r' || next == '\n' {
+			lastBreak = end + nextSize
+		}
+		end += nextSize
+	}
+
+	// \s*[\r\n]+
+	if lastBreak > 0 {
+		return lastBreak
+	}
+
+	// \s+(?!\S) leaves the last space of a run to start the next word
+	if end < len(text) {
+		_, lastSize := utf8.DecodeLastRuneInString(text[i:end])
+		if end-lastSize > i {
+			return end - lastSize
+		}
+	}
+
+	// \s+
+	return end
+}
+
+// contraction returns the end of an English contraction suffix starting at i
+// (after the apostrophe), or 0
+func contraction(text string, i int) int {
+	for _, suffix := range []string{"s", "t", "re", "ve", "m", "ll", "d"} {
+		if len(text)-i < len(suffix) {
+			continue
+		}
+		match := true
+		for k := 0; k < len(suffix); k++ {
+			if lower(text[i+k]) != suffix[k] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i + len(suffix)
+		}
+	}
+	return 0
+}
+
+func skipLetters(text string, i int) int {
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !isLetter(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + ('a' - 'A')
+	}
+	return b
+}
+
+func isLetter(r rune) bool {
+	if r < utf8.RuneSelf {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}
+	return unicode.IsLetter(r)
+}
+
+func isNumber(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r >= '0' && r <= '9'
+	}
+	return unicode.IsNumber(r)
+}
+
+// isPunctuation matches [^\s\p{L}\p{N}]
+func isPunctuation(r rune) bool {
+	return !unicode.IsSpace(r) && !isLetter(r) && !isNumber(r)
+}
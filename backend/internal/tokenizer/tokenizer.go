@@ -0,0 +1,196 @@
+// Package tokenizer counts and encodes text the way OpenAI's cl100k_base
+// byte-pair encoding does, so chunk sizes and context budgets are measured
+// in the tokens models actually see.
+//
+// The cl100k vocabulary is about 1.7 MB and isn't checked in. go generate
+// downloads it into the vocab directory (see vocab/README.md), where it is
+// embedded at build time for exact counts. Without it the tokenizer can only
+// approximate over the same pretokenized pieces, which is close for English
+// prose and never splits where the exact tokenizer wouldn't; the server
+// refuses to start that way unless approximate counts are asked for.
+package tokenizer
+
+//go:generate go run ./fetchvocab vocab/cl100k_base.tiktoken
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"unicode/utf8"
+)
+
+//go:embed vocab
+var vocab embed.FS
+
+const vocabFile = "vocab/cl100k_base.tiktoken"
+
+// ErrNoVocabulary is returned by Encode when the tokenizer is approximate
+var ErrNoVocabulary = errors.New("tokenizer has no vocabulary")
+
+// maxCacheEntries bounds the per-piece token count cache. Prose reuses a
+// small set of words, so this covers nearly every piece of a manuscript.
+const maxCacheEntries = 100_000
+
+// Span is one pretokenized piece of text: a byte range and the number of
+// tokens it encodes to. Tokens never cross span boundaries, so cutting text
+// between spans keeps counts exact.
+type Span struct {
+	Start  int
+	End    int
+	Tokens int
+}
+
+// Tokenizer counts tokens in text. It is safe for concurrent use.
+type Tokenizer struct {
+	ranks map[string]int // nil when approximate
+
+	mu    sync.RWMutex
+	cache map[string]int
+}
+
+var (
+	defaultOnce      sync.Once
+	defaultTokenizer *Tokenizer
+)
+
+// Default returns the shared tokenizer, exact when the cl100k vocabulary was
+// embedded and approximate otherwise
+func Default() *Tokenizer {
+	defaultOnce.Do(func() {
+		data, err := vocab.ReadFile(vocabFile)
+		if err != nil {
+			log.Printf("tokenizer: no embedded vocabulary, using approximate counts")
+			defaultTokenizer = Approximate()
+			return
+		}
+		t, err := New(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("tokenizer: invalid embedded vocabulary, using approximate counts: %v", err)
+			defaultTokenizer = Approximate()
+			return
+		}
+		defaultTokenizer = t
+	})
+	return defaultTokenizer
+}
+
+// New creates an exact tokenizer from a vocabulary in tiktoken's format
+func New(r io.Reader) (*Tokenizer, error) {
+	ranks, err := loadTiktokenRanks(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vocabulary: %w", err)
+	}
+	return &Tokenizer{ranks: ranks, cache: make(map[string]int)}, nil
+}
+
+// Approximate creates a tokenizer that estimates counts without a vocabulary
+func Approximate() *Tokenizer {
+	return &Tokenizer{}
+}
+
+// Exact reports whether counts come from the real vocabulary
+func (t *Tokenizer) Exact() bool {
+	return t.ranks != nil
+}
+
+// Count returns the number of tokens text encodes to
+func (t *Tokenizer) Count(text string) int {
+	total := 0
+	pretokenize(text, func(start, end int) {
+		total += t.pieceTokens(text[start:end])
+	})
+	return total
+}
+
+// Spans splits text into pretokenized pieces with their token counts, in
+// order. Together the spans cover the whole text.
+func (t *Tokenizer) Spans(text string) []Span {
+	// Prose averages a little over four bytes per piece
+	spans := make([]Span, 0, len(text)/4+1)
+	pretokenize(text, func(start, end int) {
+		spans = append(spans, Span{Start: start, End: end, Tokens: t.pieceTokens(text[start:end])})
+	})
+	return spans
+}
+
+// Encode returns the token ids of text. It needs the vocabulary, so an
+// approximate tokenizer returns ErrNoVocabulary.
+func (t *Tokenizer) Encode(text string) ([]int, error) {
+	if t.ranks == nil {
+		return nil, ErrNoVocabulary
+	}
+
+	var tokens []int
+	pretokenize(text, func(start, end int) {
+		tokens = append(tokens, bytePairEncode(text[start:end], t.ranks)...)
+	})
+	return tokens, nil
+}
+
+func (t *Tokenizer) pieceTokens(piece string) int {
+	if t.ranks == nil {
+		return approximatePieceTokens(piece)
+	}
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+
+	t.mu.RLock()
+	n, ok := t.cache[piece]
+	t.mu.RUnlock()
+	if ok {
+		return n
+	}
+
+	n = len(bytePairEncode(piece, t.ranks))
+	t.mu.Lock()
+	if len(t.cache) >= maxCacheEntries {
+		t.cache = make(map[string]int)
+	}
+	t.cache[piece] = n
+	t.mu.Unlock()
+	return n
+}
+
+// approximatePieceTokens estimates the tokens in one pretokenized piece. The
+// ratios come from cl100k on English prose: common words (with their leading
+// space) are one token and longer ones add a token every six letters or so,
+// digit groups are one token, punctuation merges in pairs and non-Latin text
+// runs at roughly three bytes per token.
+func approximatePieceTokens(piece string) int {
+	if piece == "" {
+		return 0
+	}
+
+	for i := 0; i < len(piece); i++ {
+		if piece[i] >= utf8.RuneSelf {
+			return max(1, (len(piece)+2)/3)
+		}
+	}
+
+	first, last := piece[0], piece[len(piece)-1]
+	switch {
+	case isLetter(rune(last)):
+		letters := len(piece)
+		extra := 0
+		if !isLetter(rune(first)) {
+			letters--
+			if first != ' ' {
+				// A leading quote or bracket is usually its own token
+				extra = 1
+			}
+		}
+		return extra + max(1, (letters+5)/6)
+	case isNumber(rune(first)):
+		return 1
+	case isPunctuation(rune(first)) || (first == ' ' && len(piece) > 1 && isPunctuation(rune(piece[1]))):
+		return max(1, (len(piece)+1)/2)
+	default:
+		// Whitespace runs are mostly single tokens
+		return 1
+	}
+}
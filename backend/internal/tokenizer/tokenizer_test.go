@@ -0,0 +1,163 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pieces(text string) []string {
+	var out []string
+	pretokenize(text, func(start, end int) {
+		out = append(out, text[start:end])
+	})
+	return out
+}
+
+func TestPretokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"words", "Hello world", []string{"Hello", " world"}},
+		{"contractions", "She'll say I'M fine", []string{"She", "'ll", " say", " I", "'M", " fine"}},
+		{"numbers in threes", "In 12345 years", []string{"In", " ", "123", "45", " years"}},
+		{"punctuation", `He said, "Go!"`, []string{"He", " said", ",", ` "`, "Go", "!\""}},
+		{"leading quote joins word", `"Wait`, []string{`"Wait`}},
+		{"newlines", "End.\n\nNext", []string{"End", ".\n\n", "Next"}},
+		{"space runs leave one for the word", "a   b", []string{"a", "  ", " b"}},
+		{"trailing spaces", "a  ", []string{"a", "  "}},
+		{"blank line with indent", "a\n  \n  b", []string{"a", "\n  \n", " ", " b"}},
+		{"unicode letters", "Première ligne", []string{"Première", " ligne"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pieces(tt.text)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.text, strings.Join(got, ""))
+		})
+	}
+}
+
+func testVocabulary(merges ...string) string {
+	var b strings.Builder
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, merge := range merges {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(merge)), 256+i)
+	}
+	return b.String()
+}
+
+func TestNew_BytePairEncoding(t *testing.T) {
+	tok, err := New(strings.NewReader(testVocabulary("ab", "abc", " a", "cd")))
+	require.NoError(t, err)
+	assert.True(t, tok.Exact())
+
+	// "ab" merges first, then "ab"+"c"; "cd" never gets a chance, and in
+	// " abd" the lower-ranked "ab" wins over " a"
+	ids, err := tok.Encode("abcd")
+	require.NoError(t, err)
+	assert.Equal(t, []int{257, 'd'}, ids)
+
+	ids, err = tok.Encode("abc abd")
+	require.NoError(t, err)
+	assert.Equal(t, []int{257, ' ', 256, 'd'}, ids)
+
+	assert.Equal(t, 4, tok.Count("abc abd"))
+	// Cached counts agree with the first pass
+	assert.Equal(t, 4, tok.Count("abc abd"))
+}
+
+func TestNew_InvalidVocabulary(t *testing.T) {
+	_, err := New(strings.NewReader("YQ== 0\n"))
+	assert.Error(t, err, "missing single bytes")
+
+	_, err = New(strings.NewReader("not base64!! 1\n"))
+	assert.Error(t, err)
+}
+
+func TestDefault_Cl100k(t *testing.T) {
+	tok := Default()
+	if !tok.Exact() {
+		t.Skip("cl100k vocabulary not embedded; run go generate ./internal/tokenizer")
+	}
+
+	tests := []struct {
+		text string
+		ids  []int
+	}{
+		{text: "hello world", ids: []int{15339, 1917}},
+		{text: "Hello, world!", ids: []int{9906, 11, 1917, 0}},
+		{text: "tiktoken is great!", ids: []int{83, 1609, 5963, 374, 2294, 0}},
+		{text: "The quick brown fox jumps over the lazy dog.", ids: []int{791, 4062, 14198, 39935, 35308, 927, 279, 16053, 5679, 13}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			ids, err := tok.Encode(tt.text)
+			require.NoError(t, err)
+			assert.Equal(t, tt.ids, ids)
+			assert.Equal(t, len(tt.ids), tok.Count(tt.text))
+		})
+	}
+
+	// Counts agree with encoding on prose that mixes contractions, numbers
+	// and whitespace runs
+	prose := "She'd counted 1,204 steps.\n\n  \"Again,\" he said — and they climbed."
+	ids, err := tok.Encode(prose)
+	require.NoError(t, err)
+	assert.Equal(t, len(ids), tok.Count(prose))
+}
+
+func TestApproximate(t *testing.T) {
+	tok := Approximate()
+	assert.False(t, tok.Exact())
+
+	_, err := tok.Encode("text")
+	assert.ErrorIs(t, err, ErrNoVocabulary)
+
+	assert.Equal(t, 0, tok.Count(""))
+	assert.Equal(t, 2, tok.Count("Hello world"))
+	assert.Equal(t, 3, tok.Count("Hello world."))
+	assert.Equal(t, 4, tok.Count("antidisestablishment"))
+	assert.Equal(t, 2, tok.Count("12345"))
+
+	// Roughly four characters a token on ordinary prose
+	prose := strings.Repeat("The lighthouse keeper climbed the stairs, counting each one aloud. ", 50)
+	count := tok.Count(prose)
+	assert.InDelta(t, len(prose)/4, count, float64(len(prose))/16)
+}
+
+func TestSpans(t *testing.T) {
+	tok := Approximate()
+	text := "The storm broke.\n\nMara ran."
+
+	spans := tok.Spans(text)
+	require.NotEmpty(t, spans)
+
+	total, pos := 0, 0
+	for _, span := range spans {
+		assert.Equal(t, pos, span.Start, "spans are contiguous")
+		pos = span.End
+		total += span.Tokens
+	}
+	assert.Equal(t, len(text), pos)
+	assert.Equal(t, tok.Count(text), total)
+}
+
+func BenchmarkCount(b *testing.B) {
+	text := strings.Repeat("The lighthouse keeper climbed the stairs, counting each one aloud. ", 10_000)
+	tok := Default()
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tok.Count(text)
+	}
+}
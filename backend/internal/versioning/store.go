@@ -0,0 +1,98 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Querier is satisfied by both a pool and a transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Table is a table of recent content by version, such as chapter_versions,
+// keyed by the ID of the row whose content it keeps
+type Table struct {
+	Name     string // e.g. "chapter_versions"
+	IDColumn string // e.g. "chapter_id"
+	Noun     string // Names the row in errors, e.g. "chapter"
+}
+
+// Conflict is the answer to an update rejected because the row was saved
+// since the version the update was based on
+type Conflict[T any] struct {
+	Message string `json:"message"`
+	Current T      `json:"current"`
+	// Merge is present when the rejected update changed the content
+	Merge *MergeSuggestion `json:"merge,omitempty"`
+}
+
+// Merge merges content, rejected because it was based on version, with the
+// current content. It returns nil when the rejected update left the content
+// alone.
+func (t Table) Merge(ctx context.Context, db Querier, id string, version int, content *string, current string) (*MergeSuggestion, error) {
+	if content == nil {
+		return nil, nil
+	}
+	ancestor, ancestorVersion, err := t.ContentAt(ctx, db, id, version)
+	if err != nil {
+		return nil, err
+	}
+	return Suggest(ancestor, ancestorVersion, *content, current), nil
+}
+
+// ContentAt returns a row's content as it was at version, and the version
+// that content was saved at. It returns version 0 when that content is no
+// longer kept.
+func (t Table) ContentAt(ctx context.Context, db Querier, id string, version int) (string, int, error) {
+	var content string
+	var savedAt int
+	err := db.QueryRow(ctx, `
+		SELECT version, content FROM `+t.Name+`
+		WHERE `+t.IDColumn+` = $1 AND version <= $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, id, version).Scan(&savedAt, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, nil
+		}
+		return "", 0, fmt.Errorf("failed to get %s version: %w", t.Noun, err)
+	}
+	return content, savedAt, nil
+}
+
+// Keep records the content a save replaced and the content it saved, then
+// prunes all but the latest KeptVersions. The replaced content is usually
+// recorded already, by the save that wrote it.
+func (t Table) Keep(ctx context.Context, db Querier, id string, oldVersion int, oldContent string, newVersion int, newContent string) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO `+t.Name+` (`+t.IDColumn+`, version, content)
+		VALUES ($1, $2, $3), ($1, $4, $5)
+		ON CONFLICT (`+t.IDColumn+`, version) DO NOTHING
+	`, id, oldVersion, oldContent, newVersion, newContent)
+	if err != nil {
+		return fmt.Errorf("failed to record %s version: %w", t.Noun, err)
+	}
+
+	_, err = db.Exec(ctx, `
+		DELETE FROM `+t.Name+`
+		WHERE `+t.IDColumn+` = $1 AND version < (
+			SELECT MIN(version) FROM (
+				SELECT version FROM `+t.Name+`
+				WHERE `+t.IDColumn+` = $1
+				ORDER BY version DESC
+				LIMIT $2
+			) kept
+		)
+	`, id, KeptVersions)
+	if err != nil {
+		return fmt.Errorf("failed to prune %s versions: %w", t.Noun, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package versioning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/imphyy/NovelCraft/backend/internal/db/dbtest"
+)
+
+func TestTable(t *testing.T) {
+	pool := dbtest.Connect(t)
+	_, projectID := dbtest.Project(t, pool)
+	ctx := context.Background()
+
+	var chapterID string
+	err := pool.QueryRow(ctx, `
+		INSERT INTO chapters (project_id, sort_order, title) VALUES ($1, 1, 'Chapter') RETURNING id
+	`, projectID).Scan(&chapterID)
+	require.NoError(t, err)
+
+	table := Table{Name: "chapter_versions", IDColumn: "chapter_id", Noun: "chapter"}
+
+	// Saves that don't change the content skip versions, so content is
+	// looked up at the latest version at or before the one asked for
+	require.NoError(t, table.Keep(ctx, pool, chapterID, 1, "One.\n", 2, "One.\n\nTwo.\n"))
+	require.NoError(t, table.Keep(ctx, pool, chapterID, 2, "One.\n\nTwo.\n", 5, "One.\n\nTwo!\n"))
+
+	content, savedAt, err := table.ContentAt(ctx, pool, chapterID, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "One.\n\nTwo.\n", content)
+	assert.Equal(t, 2, savedAt)
+
+	merge, err := table.Merge(ctx, pool, chapterID, 3, nil, "One.\n\nTwo!\n")
+	require.NoError(t, err)
+	assert.Nil(t, merge, "no merge when the content wasn't changed")
+
+	yours := "One!\n\nTwo.\n"
+	merge, err = table.Merge(ctx, pool, chapterID, 3, &yours, "One.\n\nTwo!\n")
+	require.NoError(t, err)
+	assert.True(t, merge.Clean)
+	assert.Equal(t, 2, merge.AncestorVersion)
+	assert.Equal(t, "One!\n\nTwo!\n", merge.Content)
+
+	// Only the latest KeptVersions are kept
+	for v := 6; v < 6+KeptVersions; v++ {
+		require.NoError(t, table.Keep(ctx, pool, chapterID, v-1, "", v, ""))
+	}
+	var kept int
+	require.NoError(t, pool.QueryRow(ctx, `SELECT COUNT(*) FROM chapter_versions WHERE chapter_id = $1`, chapterID).Scan(&kept))
+	assert.Equal(t, KeptVersions, kept)
+
+	_, savedAt, err = table.ContentAt(ctx, pool, chapterID, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 0, savedAt, "pruned versions have no ancestor")
+}
@@ -0,0 +1,85 @@
+// Package versioning implements the optimistic concurrency shared by chapter
+// and wiki page saves: versions sent as ETags, checked against If-Match, and
+// merge suggestions for saves that lost the race.
+package versioning
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/imphyy/NovelCraft/backend/internal/textdiff"
+)
+
+// KeptVersions is how many superseded versions of a chapter or wiki page's
+// content are kept as merge ancestors
+const KeptVersions = 100
+
+var (
+	ErrVersionRequired = errors.New("an If-Match header or version is required")
+	ErrInvalidIfMatch  = errors.New("invalid If-Match: expected a single version ETag or *")
+)
+
+// ETag formats a version as a strong entity tag
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag sets the ETag header of the response to version
+func SetETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", ETag(version))
+}
+
+// Expected returns the version a save was based on, taken from the If-Match
+// header or else from the request body. It returns 0 for "If-Match: *",
+// which saves over any version.
+func Expected(c echo.Context, bodyVersion *int) (int, error) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if ifMatch == "" {
+		if bodyVersion == nil {
+			return 0, ErrVersionRequired
+		}
+		if *bodyVersion < 1 {
+			return 0, ErrInvalidIfMatch
+		}
+		return *bodyVersion, nil
+	}
+	if ifMatch == "*" {
+		return 0, nil
+	}
+
+	tag := strings.TrimPrefix(ifMatch, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, ErrInvalidIfMatch
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, ErrInvalidIfMatch
+	}
+	return version, nil
+}
+
+// MergeSuggestion is a three-way merge of a rejected save with the current
+// content, against the version both were based on
+type MergeSuggestion struct {
+	// AncestorVersion is the version merged against, or 0 when it is no
+	// longer kept and the merge had no common ancestor
+	AncestorVersion int                 `json:"ancestorVersion"`
+	Content         string              `json:"content"`
+	Clean           bool                `json:"clean"`
+	Conflicts       []textdiff.Conflict `json:"conflicts"`
+}
+
+// Suggest merges yours, the rejected content, with current, the content
+// saved since. Conflicts list yours first and the current content second.
+func Suggest(ancestor string, ancestorVersion int, yours, current string) *MergeSuggestion {
+	result := textdiff.Merge(ancestor, yours, current)
+	return &MergeSuggestion{
+		AncestorVersion: ancestorVersion,
+		Content:         result.Text,
+		Clean:           len(result.Conflicts) == 0,
+		Conflicts:       result.Conflicts,
+	}
+}
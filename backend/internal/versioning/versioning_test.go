@@ -0,0 +1,56 @@
+package versioning
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpected(t *testing.T) {
+	five, zero := 5, 0
+	tests := []struct {
+		name    string
+		ifMatch string
+		body    *int
+		want    int
+		err     error
+	}{
+		{name: "etag", ifMatch: `"3"`, want: 3},
+		{name: "weak etag", ifMatch: `W/"3"`, want: 3},
+		{name: "header wins over body", ifMatch: `"3"`, body: &five, want: 3},
+		{name: "any version", ifMatch: "*", want: 0},
+		{name: "body", body: &five, want: 5},
+		{name: "missing", err: ErrVersionRequired},
+		{name: "unquoted", ifMatch: "3", err: ErrInvalidIfMatch},
+		{name: "list", ifMatch: `"3", "4"`, err: ErrInvalidIfMatch},
+		{name: "zero body", body: &zero, err: ErrInvalidIfMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			got, err := Expected(c, tt.body)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	merge := Suggest("One.\n\nTwo.\n", 4, "One!\n\nTwo.\n", "One.\n\nTwo!\n")
+	assert.True(t, merge.Clean)
+	assert.Equal(t, "One!\n\nTwo!\n", merge.Content)
+	assert.Equal(t, 4, merge.AncestorVersion)
+
+	merge = Suggest("", 0, "One!\n", "One?\n")
+	assert.False(t, merge.Clean, "without an ancestor differing texts conflict")
+	assert.Len(t, merge.Conflicts, 1)
+}
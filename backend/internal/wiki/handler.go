@@ -2,25 +2,28 @@ package wiki
 
 import (
 	"context"
+	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/imphyy/NovelCraft/backend/internal/versioning"
 )
 
-// DocumentProcessor interface for processing content for AI
-type DocumentProcessor interface {
-	ProcessDocument(ctx context.Context, projectID, sourceType, sourceID, content string) error
+// DocumentIndexer interface for queueing content for AI processing
+type DocumentIndexer interface {
+	EnqueueDocument(ctx context.Context, projectID, sourceType, sourceID string) error
 }
 
 type Handler struct {
-	service           *Service
-	documentProcessor DocumentProcessor
+	service         *Service
+	documentIndexer DocumentIndexer
 }
 
-func NewHandler(service *Service, documentProcessor DocumentProcessor) *Handler {
+func NewHandler(service *Service, documentIndexer DocumentIndexer) *Handler {
 	return &Handler{
-		service:           service,
-		documentProcessor: documentProcessor,
+		service:         service,
+		documentIndexer: documentIndexer,
 	}
 }
 
@@ -71,6 +74,7 @@ func (h *Handler) Create(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create wiki page")
 	}
 
+	versioning.SetETag(c, page.Version)
 	return c.JSON(http.StatusCreated, page)
 }
 
@@ -91,6 +95,7 @@ func (h *Handler) Get(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get wiki page")
 	}
 
+	versioning.SetETag(c, page.Version)
 	return c.JSON(http.StatusOK, page)
 }
 
@@ -112,11 +117,14 @@ func (h *Handler) GetBySlug(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get wiki page")
 	}
 
+	versioning.SetETag(c, page.Version)
 	return c.JSON(http.StatusOK, page)
 }
 
 // Update godoc
 // PATCH /api/wiki/:id
+// The version being edited is required, as an If-Match ETag or the version
+// field. A stale version is answered with 409 and a Conflict.
 func (h *Handler) Update(c echo.Context) error {
 	userID := c.Get("user_id").(string)
 	pageID := c.Param("id")
@@ -124,6 +132,7 @@ func (h *Handler) Update(c echo.Context) error {
 	var req struct {
 		Title   *string `json:"title" validate:"omitempty,min=1,max=255"`
 		Content *string `json:"content"`
+		Version *int    `json:"version"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -134,8 +143,24 @@ func (h *Handler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	page, err := h.service.Update(c.Request().Context(), pageID, userID, req.Title, req.Content)
+	version, err := versioning.Expected(c, req.Version)
+	if err != nil {
+		if err == versioning.ErrVersionRequired {
+			return echo.NewHTTPError(http.StatusPreconditionRequired, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	page, err := h.service.Update(c.Request().Context(), pageID, userID, version, req.Title, req.Content)
 	if err != nil {
+		if err == ErrVersionConflict {
+			conflict, err := h.service.Conflict(c.Request().Context(), pageID, userID, version, req.Content)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update wiki page")
+			}
+			versioning.SetETag(c, conflict.Current.Version)
+			return c.JSON(http.StatusConflict, conflict)
+		}
 		if err == ErrNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "wiki page not found")
 		}
@@ -148,16 +173,15 @@ func (h *Handler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update wiki page")
 	}
 
-	// Process document for AI (chunk and embed) in background
-	if req.Content != nil && h.documentProcessor != nil {
-		go func() {
-			if err := h.documentProcessor.ProcessDocument(context.Background(), page.ProjectID, "wiki_page", page.ID, page.Content); err != nil {
-				// Log error but don't fail - this is a background operation
-				// TODO: Add proper logging
-			}
-		}()
+	// Queue document for AI processing (chunk and embed)
+	if req.Content != nil && h.documentIndexer != nil {
+		if err := h.documentIndexer.EnqueueDocument(c.Request().Context(), page.ProjectID, "wiki_page", page.ID); err != nil {
+			// Log error but don't fail the request - the next save re-queues it
+			log.Printf("failed to queue wiki page %s for embedding: %v", page.ID, err)
+		}
 	}
 
+	versioning.SetETag(c, page.Version)
 	return c.JSON(http.StatusOK, page)
 }
 
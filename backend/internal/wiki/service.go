@@ -16,6 +16,9 @@ var (
 	ErrNotFound     = errors.New("wiki page not found")
 	ErrUnauthorized = errors.New("unauthorized")
 	ErrSlugTaken    = errors.New("slug already taken")
+	// ErrVersionConflict means the page was saved since the version an
+	// update was based on
+	ErrVersionConflict = errors.New("wiki page was changed by another save")
 )
 
 var wikiLinkPattern = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
@@ -28,6 +31,7 @@ type WikiPage struct {
 	Content   string    `json:"content"`
 	PageType  string    `json:"pageType"`
 	Tags      []string  `json:"tags"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -62,8 +66,8 @@ func NewService(db *pgxpool.Pool) *Service {
 	return &Service{db: db}
 }
 
-// generateSlug creates a URL-friendly slug from a title
-func generateSlug(title string) string {
+// GenerateSlug creates the URL-friendly slug used for a page title
+func GenerateSlug(title string) string {
 	slug := strings.ToLower(title)
 	slug = regexp.MustCompile(`[^a-z0-9\s-]`).ReplaceAllString(slug, "")
 	slug = regexp.MustCompile(`\s+`).ReplaceAllString(slug, "-")
@@ -79,7 +83,7 @@ func extractWikiLinks(content string) []string {
 
 	for _, match := range matches {
 		if len(match) > 1 {
-			slug := generateSlug(match[1])
+			slug := GenerateSlug(match[1])
 			if !seen[slug] {
 				slugs = append(slugs, slug)
 				seen[slug] = true
@@ -115,7 +119,7 @@ func (s *Service) ListByProject(ctx context.Context, projectID, userID string) (
 	}
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, project_id, title, slug, content, page_type, created_at, updated_at
+		SELECT id, project_id, title, slug, content, page_type, version, created_at, updated_at
 		FROM wiki_pages
 		WHERE project_id = $1
 		ORDER BY title ASC
@@ -128,7 +132,7 @@ func (s *Service) ListByProject(ctx context.Context, projectID, userID string) (
 	var pages []WikiPage
 	for rows.Next() {
 		var page WikiPage
-		if err := rows.Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.CreatedAt, &page.UpdatedAt); err != nil {
+		if err := rows.Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.Version, &page.CreatedAt, &page.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan wiki page: %w", err)
 		}
 
@@ -155,14 +159,14 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title, pageType
 		return nil, err
 	}
 
-	slug := generateSlug(title)
+	slug := GenerateSlug(title)
 
 	var page WikiPage
 	err := s.db.QueryRow(ctx, `
 		INSERT INTO wiki_pages (project_id, title, slug, page_type)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, project_id, title, slug, content, page_type, created_at, updated_at
-	`, projectID, title, slug, pageType).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.CreatedAt, &page.UpdatedAt)
+		RETURNING id, project_id, title, slug, content, page_type, version, created_at, updated_at
+	`, projectID, title, slug, pageType).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.Version, &page.CreatedAt, &page.UpdatedAt)
 
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
@@ -179,11 +183,11 @@ func (s *Service) Create(ctx context.Context, projectID, userID, title, pageType
 func (s *Service) Get(ctx context.Context, pageID, userID string) (*WikiPage, error) {
 	var page WikiPage
 	err := s.db.QueryRow(ctx, `
-		SELECT wp.id, wp.project_id, wp.title, wp.slug, wp.content, wp.page_type, wp.created_at, wp.updated_at
+		SELECT wp.id, wp.project_id, wp.title, wp.slug, wp.content, wp.page_type, wp.version, wp.created_at, wp.updated_at
 		FROM wiki_pages wp
 		JOIN projects p ON wp.project_id = p.id
 		WHERE wp.id = $1 AND p.user_id = $2
-	`, pageID, userID).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.CreatedAt, &page.UpdatedAt)
+	`, pageID, userID).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.Version, &page.CreatedAt, &page.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -210,10 +214,10 @@ func (s *Service) GetBySlug(ctx context.Context, projectID, slug, userID string)
 
 	var page WikiPage
 	err := s.db.QueryRow(ctx, `
-		SELECT id, project_id, title, slug, content, page_type, created_at, updated_at
+		SELECT id, project_id, title, slug, content, page_type, version, created_at, updated_at
 		FROM wiki_pages
 		WHERE project_id = $1 AND slug = $2
-	`, projectID, slug).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.CreatedAt, &page.UpdatedAt)
+	`, projectID, slug).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.Version, &page.CreatedAt, &page.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -233,7 +237,10 @@ func (s *Service) GetBySlug(ctx context.Context, projectID, slug, userID string)
 }
 
 // Update updates a wiki page
-func (s *Service) Update(ctx context.Context, pageID, userID string, title, content *string) (*WikiPage, error) {
+// version is the version the change was based on: if the page has been saved
+// since, nothing changes and ErrVersionConflict is returned. A version of 0
+// updates whatever is there.
+func (s *Service) Update(ctx context.Context, pageID, userID string, version int, title, content *string) (*WikiPage, error) {
 	// Verify ownership
 	existing, err := s.Get(ctx, pageID, userID)
 	if err != nil {
@@ -250,7 +257,7 @@ func (s *Service) Update(ctx context.Context, pageID, userID string, title, cont
 		argPos++
 
 		// Update slug if title changes
-		slug := generateSlug(*title)
+		slug := GenerateSlug(*title)
 		updates = append(updates, fmt.Sprintf("slug = $%d", argPos))
 		args = append(args, slug)
 		argPos++
@@ -266,17 +273,40 @@ func (s *Service) Update(ctx context.Context, pageID, userID string, title, cont
 		return existing, nil
 	}
 
-	updates = append(updates, "updated_at = now()")
+	updates = append(updates, "version = version + 1", "updated_at = now()")
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the row until the new version is saved
+	var currentVersion int
+	var currentContent string
+	err = tx.QueryRow(ctx, `
+		SELECT version, content FROM wiki_pages WHERE id = $1 FOR UPDATE
+	`, pageID).Scan(&currentVersion, &currentContent)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get wiki page: %w", err)
+	}
+
+	if version != 0 && version != currentVersion {
+		return nil, ErrVersionConflict
+	}
 
 	query := fmt.Sprintf(`
 		UPDATE wiki_pages
 		SET %s
 		WHERE id = $1
-		RETURNING id, project_id, title, slug, content, page_type, created_at, updated_at
+		RETURNING id, project_id, title, slug, content, page_type, version, created_at, updated_at
 	`, strings.Join(updates, ", "))
 
 	var page WikiPage
-	err = s.db.QueryRow(ctx, query, args...).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.CreatedAt, &page.UpdatedAt)
+	err = tx.QueryRow(ctx, query, args...).Scan(&page.ID, &page.ProjectID, &page.Title, &page.Slug, &page.Content, &page.PageType, &page.Version, &page.CreatedAt, &page.UpdatedAt)
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
 			return nil, ErrSlugTaken
@@ -284,6 +314,16 @@ func (s *Service) Update(ctx context.Context, pageID, userID string, title, cont
 		return nil, fmt.Errorf("failed to update wiki page: %w", err)
 	}
 
+	if content != nil && *content != currentContent {
+		if err := keepVersions(ctx, tx, pageID, currentVersion, currentContent, page.Version, page.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	// Load tags
 	tags, err := s.getPageTags(ctx, page.ID)
 	if err != nil {
@@ -510,7 +550,7 @@ func (s *Service) GetBacklinks(ctx context.Context, pageID, userID string) ([]Ba
 		FROM wiki_links wl
 		LEFT JOIN wiki_pages wp ON wl.source_type = 'wiki_page' AND wl.source_id = wp.id
 		LEFT JOIN chapters c ON wl.source_type = 'chapter' AND wl.source_id = c.id
-		WHERE wl.target_page_id = $1
+		WHERE wl.target_page_id = $1 AND c.deleted_at IS NULL
 		ORDER BY wl.created_at DESC
 	`, pageID)
 	if err != nil {
@@ -542,7 +582,7 @@ func (s *Service) GetMentions(ctx context.Context, pageID, userID string) ([]Men
 		SELECT c.id, c.title, wl.created_at
 		FROM wiki_links wl
 		JOIN chapters c ON wl.source_id = c.id
-		WHERE wl.source_type = 'chapter' AND wl.target_page_id = $1
+		WHERE wl.source_type = 'chapter' AND wl.target_page_id = $1 AND c.deleted_at IS NULL
 		ORDER BY wl.created_at DESC
 	`, pageID)
 	if err != nil {
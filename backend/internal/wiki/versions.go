@@ -0,0 +1,34 @@
+package wiki
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/imphyy/NovelCraft/backend/internal/versioning"
+)
+
+var pageVersions = versioning.Table{Name: "wiki_page_versions", IDColumn: "wiki_page_id", Noun: "wiki page"}
+
+// Conflict is the answer to an update rejected with ErrVersionConflict
+type Conflict = versioning.Conflict[*WikiPage]
+
+// Conflict describes how a rejected update based on version differs from the
+// page as it is now, merging its content with the content saved since
+func (s *Service) Conflict(ctx context.Context, pageID, userID string, version int, content *string) (*Conflict, error) {
+	current, err := s.Get(ctx, pageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	merge, err := pageVersions.Merge(ctx, s.db, pageID, version, content, current.Content)
+	if err != nil {
+		return nil, err
+	}
+	return &Conflict{Message: ErrVersionConflict.Error(), Current: current, Merge: merge}, nil
+}
+
+// keepVersions records the content a save replaced and the content it saved
+func keepVersions(ctx context.Context, tx pgx.Tx, pageID string, oldVersion int, oldContent string, newVersion int, newContent string) error {
+	return pageVersions.Keep(ctx, tx, pageID, oldVersion, oldContent, newVersion, newContent)
+}
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS update_embedding_jobs_updated_at ON embedding_jobs;
+DROP INDEX IF EXISTS idx_embedding_jobs_project_id;
+DROP INDEX IF EXISTS idx_embedding_jobs_source;
+DROP INDEX IF EXISTS idx_embedding_jobs_due;
+DROP INDEX IF EXISTS idx_embedding_jobs_pending_source;
+DROP TABLE IF EXISTS embedding_jobs;
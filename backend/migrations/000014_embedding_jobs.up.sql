@@ -0,0 +1,27 @@
+-- Durable queue of documents waiting to be chunked and embedded
+CREATE TABLE embedding_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    source_type TEXT NOT NULL,
+    source_id UUID NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (source_type IN ('chapter', 'wiki_page')),
+    CHECK (status IN ('pending', 'running', 'dead'))
+);
+
+-- At most one pending job per document, so rapid autosaves coalesce
+CREATE UNIQUE INDEX idx_embedding_jobs_pending_source ON embedding_jobs(source_type, source_id) WHERE status = 'pending';
+CREATE INDEX idx_embedding_jobs_due ON embedding_jobs(run_at) WHERE status = 'pending';
+CREATE INDEX idx_embedding_jobs_source ON embedding_jobs(source_type, source_id);
+CREATE INDEX idx_embedding_jobs_project_id ON embedding_jobs(project_id);
+
+CREATE TRIGGER update_embedding_jobs_updated_at
+    BEFORE UPDATE ON embedding_jobs
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
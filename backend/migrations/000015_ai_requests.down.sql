@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS ai_monthly_token_budget;
+DROP INDEX IF EXISTS idx_ai_requests_project_id;
+DROP INDEX IF EXISTS idx_ai_requests_user_created;
+DROP TABLE IF EXISTS ai_requests;
@@ -0,0 +1,21 @@
+-- Ledger of every AI tool call, used for usage reports and token budgets
+CREATE TABLE ai_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    project_id UUID REFERENCES projects(id) ON DELETE SET NULL,
+    tool TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    tokens_in INT NOT NULL DEFAULT 0,
+    tokens_out INT NOT NULL DEFAULT 0,
+    latency_ms INT NOT NULL DEFAULT 0,
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (outcome IN ('success', 'error', 'cancelled', 'budget_exceeded'))
+);
+
+CREATE INDEX idx_ai_requests_user_created ON ai_requests(user_id, created_at);
+CREATE INDEX idx_ai_requests_project_id ON ai_requests(project_id);
+
+-- Per-user monthly token budget; NULL falls back to AI_MONTHLY_TOKEN_BUDGET
+ALTER TABLE users ADD COLUMN ai_monthly_token_budget INT;
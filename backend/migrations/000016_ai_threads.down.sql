@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS update_ai_threads_updated_at ON ai_threads;
+DROP INDEX IF EXISTS idx_ai_thread_messages_thread_created;
+DROP TABLE IF EXISTS ai_thread_messages;
+DROP INDEX IF EXISTS idx_ai_threads_project_updated;
+DROP TABLE IF EXISTS ai_threads;
@@ -0,0 +1,30 @@
+-- Conversational Ask threads
+CREATE TABLE ai_threads (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    title TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_ai_threads_project_updated ON ai_threads(project_id, updated_at DESC);
+
+CREATE TABLE ai_thread_messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    thread_id UUID NOT NULL REFERENCES ai_threads(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    content TEXT NOT NULL,
+    citations JSONB NOT NULL DEFAULT '[]',
+    standalone_question TEXT NOT NULL DEFAULT '',
+    tokens_in INT NOT NULL DEFAULT 0,
+    tokens_out INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (role IN ('user', 'assistant'))
+);
+
+CREATE INDEX idx_ai_thread_messages_thread_created ON ai_thread_messages(thread_id, created_at);
+
+CREATE TRIGGER update_ai_threads_updated_at
+    BEFORE UPDATE ON ai_threads
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,4 @@
+-- Full-text index over chunk content for keyword and hybrid retrieval
+ALTER TABLE chunks ADD COLUMN content_tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX idx_chunks_content_tsv ON chunks USING gin (content_tsv);
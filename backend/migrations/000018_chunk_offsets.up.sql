@@ -0,0 +1,4 @@
+-- Character offsets of each chunk within its source document. NULL for chunks
+-- indexed before offsets were tracked; they are filled in on the next reindex.
+ALTER TABLE chunks ADD COLUMN start_offset INT;
+ALTER TABLE chunks ADD COLUMN end_offset INT;
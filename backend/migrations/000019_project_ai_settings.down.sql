@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS update_project_ai_settings_updated_at ON project_ai_settings;
+DROP INDEX IF EXISTS idx_project_rewrite_tools_project_id;
+DROP TABLE IF EXISTS project_rewrite_tools;
+DROP TABLE IF EXISTS project_ai_settings;
@@ -0,0 +1,29 @@
+-- Per-project AI settings: a style guide added to every AI prompt
+CREATE TABLE project_ai_settings (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    style_guide TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- User-defined rewrite tools, used alongside the built-in ones by name
+CREATE TABLE project_rewrite_tools (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    system_prompt TEXT NOT NULL,
+    user_prompt_template TEXT NOT NULL DEFAULT '',
+    temperature DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    max_tokens INT NOT NULL DEFAULT 0,
+    sort_order INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (project_id, name)
+);
+
+CREATE INDEX idx_project_rewrite_tools_project_id ON project_rewrite_tools(project_id);
+
+CREATE TRIGGER update_project_ai_settings_updated_at
+    BEFORE UPDATE ON project_ai_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
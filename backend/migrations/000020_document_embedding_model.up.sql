@@ -0,0 +1,4 @@
+-- Embedding model that produced a document's chunk vectors, so changing the
+-- configured model re-embeds existing documents. NULL for documents indexed
+-- before the model was tracked.
+ALTER TABLE documents ADD COLUMN embedding_model TEXT;
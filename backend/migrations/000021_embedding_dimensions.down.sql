@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_chunk_embeddings_768_embedding;
+DROP TABLE IF EXISTS chunk_embeddings_768;
+
+DROP INDEX IF EXISTS idx_chunks_embedding_model;
+ALTER TABLE chunks DROP COLUMN IF EXISTS embedding_dimensions;
+ALTER TABLE chunks DROP COLUMN IF EXISTS embedding_model;
+ALTER TABLE documents DROP COLUMN IF EXISTS embedding_dimensions;
@@ -0,0 +1,23 @@
+-- Record which model and dimension produced each vector, so retrieval only
+-- compares vectors from the configured model
+ALTER TABLE documents ADD COLUMN embedding_dimensions INT;
+ALTER TABLE chunks ADD COLUMN embedding_model TEXT;
+ALTER TABLE chunks ADD COLUMN embedding_dimensions INT;
+
+-- Until now every vector was stored in chunks.embedding, which is vector(1536)
+UPDATE documents SET embedding_dimensions = 1536 WHERE embedding_model IS NOT NULL;
+UPDATE chunks c
+SET embedding_model = d.embedding_model, embedding_dimensions = 1536
+FROM documents d
+WHERE c.document_id = d.id AND c.embedding IS NOT NULL;
+
+CREATE INDEX idx_chunks_embedding_model ON chunks(project_id, embedding_model);
+
+-- pgvector indexes need a fixed dimension, so vectors of other sizes live in
+-- a table per dimension. 1536-dimensional vectors stay in chunks.embedding.
+CREATE TABLE chunk_embeddings_768 (
+    chunk_id UUID PRIMARY KEY REFERENCES chunks(id) ON DELETE CASCADE,
+    embedding vector(768) NOT NULL
+);
+
+CREATE INDEX idx_chunk_embeddings_768_embedding ON chunk_embeddings_768 USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_chapter_summaries_updated_at ON chapter_summaries;
+DROP TABLE IF EXISTS chapter_summaries;
@@ -0,0 +1,15 @@
+-- AI summaries of chapters. content_hash is the hash of the chapter content
+-- that was summarised; a summary is stale once the chapter's hash differs.
+CREATE TABLE chapter_summaries (
+    chapter_id UUID PRIMARY KEY REFERENCES chapters(id) ON DELETE CASCADE,
+    content_hash TEXT NOT NULL,
+    summary TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TRIGGER update_chapter_summaries_updated_at
+    BEFORE UPDATE ON chapter_summaries
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,3 @@
+ALTER TABLE ai_requests DROP COLUMN IF EXISTS cached;
+DROP INDEX IF EXISTS idx_ai_response_cache_expires_at;
+DROP TABLE IF EXISTS ai_response_cache;
@@ -0,0 +1,14 @@
+-- Chat responses cached by a hash of the model, messages, temperature and
+-- max tokens, so repeated AI requests don't pay for tokens again
+CREATE TABLE ai_response_cache (
+    key TEXT PRIMARY KEY,
+    model TEXT NOT NULL,
+    response JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_ai_response_cache_expires_at ON ai_response_cache(expires_at);
+
+-- Requests answered from the cache, which spend no tokens
+ALTER TABLE ai_requests ADD COLUMN cached BOOLEAN NOT NULL DEFAULT false;
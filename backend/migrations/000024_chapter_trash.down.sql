@@ -0,0 +1,10 @@
+-- Trashed chapters can't be represented without deleted_at, so purge them
+DELETE FROM wiki_links WHERE source_type = 'chapter' AND source_id IN (SELECT id FROM chapters WHERE deleted_at IS NOT NULL);
+DELETE FROM embedding_jobs WHERE source_type = 'chapter' AND source_id IN (SELECT id FROM chapters WHERE deleted_at IS NOT NULL);
+DELETE FROM documents WHERE source_type = 'chapter' AND source_id IN (SELECT id FROM chapters WHERE deleted_at IS NOT NULL);
+DELETE FROM chapters WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_chapters_deleted_at;
+DROP INDEX IF EXISTS idx_chapters_live_sort_order;
+ALTER TABLE chapters ADD CONSTRAINT chapters_project_id_sort_order_key UNIQUE (project_id, sort_order);
+ALTER TABLE chapters DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,9 @@
+-- Deleted chapters stay in their project's trash until restored or purged
+ALTER TABLE chapters ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Only live chapters need distinct positions. Trashed chapters keep the
+-- position they were deleted from so a restore can put them back there.
+ALTER TABLE chapters DROP CONSTRAINT chapters_project_id_sort_order_key;
+CREATE UNIQUE INDEX idx_chapters_live_sort_order ON chapters(project_id, sort_order) WHERE deleted_at IS NULL;
+
+CREATE INDEX idx_chapters_deleted_at ON chapters(deleted_at) WHERE deleted_at IS NOT NULL;
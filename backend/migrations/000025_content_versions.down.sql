@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS wiki_page_versions;
+DROP TABLE IF EXISTS chapter_versions;
+ALTER TABLE wiki_pages DROP COLUMN IF EXISTS version;
+ALTER TABLE chapters DROP COLUMN IF EXISTS version;
@@ -0,0 +1,23 @@
+-- Version counters for optimistic concurrency: a save names the version it
+-- was based on and is rejected if the row has moved on since
+ALTER TABLE chapters ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE wiki_pages ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Recent content by version, kept as the common ancestor when merging a save
+-- that conflicts. A row holds the content from its version until the next
+-- row's version; saves that don't change the content add no row.
+CREATE TABLE chapter_versions (
+    chapter_id UUID NOT NULL REFERENCES chapters(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chapter_id, version)
+);
+
+CREATE TABLE wiki_page_versions (
+    wiki_page_id UUID NOT NULL REFERENCES wiki_pages(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (wiki_page_id, version)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chapter_revisions_auto;
+ALTER TABLE chapter_revisions DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,6 @@
+-- Revisions are taken by hand, automatically by saves, or by a restore of the
+-- content it replaced. Only automatic ones are thinned out as they age.
+ALTER TABLE chapter_revisions ADD COLUMN kind TEXT NOT NULL DEFAULT 'manual'
+    CHECK (kind IN ('manual', 'auto', 'restore'));
+
+CREATE INDEX idx_chapter_revisions_auto ON chapter_revisions(created_at) WHERE kind = 'auto';
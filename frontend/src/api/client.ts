@@ -54,8 +54,9 @@ export const chaptersAPI = {
   get: (id: string) =>
     apiClient.get(`/chapters/${id}`),
 
-  update: (id: string, data: { title?: string; status?: string; content?: string }) =>
-    apiClient.patch(`/chapters/${id}`, data),
+  // version is the version being edited; a stale one is answered with 409
+  update: (id: string, data: { title?: string; status?: string; content?: string }, version: number) =>
+    apiClient.patch(`/chapters/${id}`, data, { headers: { 'If-Match': `"${version}"` } }),
 
   reorder: (projectId: string, chapterIds: string[]) =>
     apiClient.post(`/projects/${projectId}/chapters/reorder`, { chapterIds }),
@@ -63,6 +64,10 @@ export const chaptersAPI = {
   listRevisions: (chapterId: string) =>
     apiClient.get(`/chapters/${chapterId}/revisions`),
 
+  // from and to are revision IDs or 'current'
+  diffRevisions: (chapterId: string, from: string, to = 'current') =>
+    apiClient.get(`/chapters/${chapterId}/revisions/diff`, { params: { from, to } }),
+
   restoreRevision: (revisionId: string) =>
     apiClient.post(`/revisions/${revisionId}/restore`),
 };
@@ -81,8 +86,9 @@ export const wikiAPI = {
   getBySlug: (projectId: string, slug: string) =>
     apiClient.get(`/projects/${projectId}/wiki/by-slug/${slug}`),
 
-  update: (id: string, data: { title?: string; content?: string }) =>
-    apiClient.patch(`/wiki/${id}`, data),
+  // version is the version being edited; a stale one is answered with 409
+  update: (id: string, data: { title?: string; content?: string }, version: number) =>
+    apiClient.patch(`/wiki/${id}`, data, { headers: { 'If-Match': `"${version}"` } }),
 
   delete: (id: string) =>
     apiClient.delete(`/wiki/${id}`),
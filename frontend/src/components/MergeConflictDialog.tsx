@@ -0,0 +1,94 @@
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Button } from '@/components/ui/button';
+
+// One region both saves changed differently, as returned in a 409's merge
+export interface MergeConflict {
+  base: string;
+  yours: string;
+  theirs: string;
+}
+
+export interface MergeSuggestion {
+  ancestorVersion: number;
+  content: string;
+  clean: boolean;
+  conflicts: MergeConflict[];
+}
+
+const conflictMarker = /^(<<<<<<< yours|=======|>>>>>>> theirs)$/m;
+
+// Merged text with unresolved conflicts must never be saved
+export function hasConflictMarkers(text: string) {
+  return conflictMarker.test(text);
+}
+
+interface MergeConflictDialogProps {
+  open: boolean;
+  conflicts: MergeConflict[];
+  onKeepMine: () => void;
+  onKeepTheirs: () => void;
+  // Loads the merged text, conflicts marked, for resolving by hand
+  onResolveInEditor: () => void;
+}
+
+export function MergeConflictDialog({
+  open,
+  conflicts,
+  onKeepMine,
+  onKeepTheirs,
+  onResolveInEditor,
+}: MergeConflictDialogProps) {
+  return (
+    <Dialog open={open} onOpenChange={(next) => !next && onResolveInEditor()}>
+      <DialogContent className="max-w-4xl max-h-[80vh] overflow-y-auto">
+        <DialogHeader>
+          <DialogTitle>Saved elsewhere</DialogTitle>
+          <DialogDescription>
+            This text was changed in another tab or device while you were editing, and{' '}
+            {conflicts.length === 1 ? 'one passage was' : `${conflicts.length} passages were`} changed
+            on both sides. Saving is paused until you choose what to keep.
+          </DialogDescription>
+        </DialogHeader>
+
+        <div className="space-y-6">
+          {conflicts.map((conflict, i) => (
+            <div key={i} className="grid grid-cols-2 gap-4">
+              <div>
+                <div className="text-[10px] font-semibold uppercase tracking-widest text-muted-foreground mb-2">
+                  Yours
+                </div>
+                <pre className="whitespace-pre-wrap font-serif text-sm bg-muted/30 p-3 rounded">
+                  {conflict.yours || <span className="italic text-muted-foreground">(deleted)</span>}
+                </pre>
+              </div>
+              <div>
+                <div className="text-[10px] font-semibold uppercase tracking-widest text-muted-foreground mb-2">
+                  Saved elsewhere
+                </div>
+                <pre className="whitespace-pre-wrap font-serif text-sm bg-muted/30 p-3 rounded">
+                  {conflict.theirs || <span className="italic text-muted-foreground">(deleted)</span>}
+                </pre>
+              </div>
+            </div>
+          ))}
+        </div>
+
+        <div className="flex justify-end gap-2 pt-4">
+          <Button variant="outline" onClick={onKeepTheirs}>
+            Keep saved version
+          </Button>
+          <Button variant="outline" onClick={onKeepMine}>
+            Keep mine
+          </Button>
+          <Button onClick={onResolveInEditor}>Resolve in editor</Button>
+        </div>
+      </DialogContent>
+    </Dialog>
+  );
+}
@@ -26,12 +26,14 @@ export function RewriteModal({
   const [instruction, setInstruction] = useState('');
   const [loading, setLoading] = useState(false);
   const [response, setResponse] = useState<RewriteResponse | null>(null);
+  const [selectedCandidate, setSelectedCandidate] = useState(0);
   const [error, setError] = useState<string | null>(null);
 
   const handleRewrite = async () => {
     setLoading(true);
     setError(null);
     setResponse(null);
+    setSelectedCandidate(0);
 
     try {
       const res = await aiAPI.rewrite(
@@ -51,9 +53,11 @@ export function RewriteModal({
     }
   };
 
+  const candidates = response?.candidates ?? [];
+
   const handleAccept = () => {
     if (response) {
-      onAccept(response.rewrittenText);
+      onAccept(candidates[selectedCandidate]?.text ?? response.rewrittenText);
       handleClose();
     }
   };
@@ -62,6 +66,7 @@ export function RewriteModal({
     setSelectedTool('rewrite');
     setInstruction('');
     setResponse(null);
+    setSelectedCandidate(0);
     setError(null);
     onOpenChange(false);
   };
@@ -145,8 +150,40 @@ export function RewriteModal({
           </Alert>
         )}
 
+        {/* Candidates */}
+        {response && candidates.length > 0 && (
+          <div className="space-y-3">
+            <p className="text-[10px] font-bold text-muted-foreground/50 uppercase tracking-widest">Choose a Version</p>
+            <div className="space-y-2 max-h-80 overflow-y-auto">
+              {candidates.map((candidate, i) => (
+                <button
+                  key={i}
+                  type="button"
+                  onClick={() => setSelectedCandidate(i)}
+                  className={`w-full text-left p-3 border rounded-sm transition-colors ${
+                    selectedCandidate === i
+                      ? 'bg-primary/5 border-primary/40'
+                      : 'border-border/20 hover:bg-muted/10'
+                  }`}
+                >
+                  <div className="flex items-baseline gap-2 mb-1">
+                    <span className="text-xs font-semibold">{candidate.label || `Option ${i + 1}`}</span>
+                    {candidate.tone && (
+                      <span className="text-xs text-muted-foreground italic">{candidate.tone}</span>
+                    )}
+                  </div>
+                  <div className="text-sm whitespace-pre-wrap">{candidate.text}</div>
+                </button>
+              ))}
+            </div>
+            <p className="text-xs text-muted-foreground/60">
+              Tokens: {response.tokensIn} in / {response.tokensOut} out
+            </p>
+          </div>
+        )}
+
         {/* Result */}
-        {response && (
+        {response && candidates.length === 0 && (
           <div className="space-y-3">
             <p className="text-[10px] font-bold text-muted-foreground/50 uppercase tracking-widest">Rewritten Text</p>
             <div className="p-3 bg-primary/5 border border-primary/20 rounded-sm text-sm whitespace-pre-wrap max-h-40 overflow-y-auto">
@@ -2,6 +2,7 @@ import { useState, useEffect, useRef } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
 import { projectsAPI, chaptersAPI } from '../api/client';
 import { RewriteModal } from '../components/RewriteModal';
+import { MergeConflictDialog, hasConflictMarkers, type MergeSuggestion } from '../components/MergeConflictDialog';
 import { AppShell } from '../components/layout/AppShell';
 import { EmptyState } from '../components/scaffolding/EmptyState';
 import { Book, Edit3 } from 'lucide-react';
@@ -22,6 +23,7 @@ interface Chapter {
   status: string;
   sortOrder: number;
   wordCount: number;
+  version: number;
 }
 
 export default function EditorPage() {
@@ -45,9 +47,17 @@ export default function EditorPage() {
   const [titleValue, setTitleValue] = useState('');
   const [history, setHistory] = useState<string[]>([]);
   const [historyIndex, setHistoryIndex] = useState(-1);
+  // A save whose edits conflicted with a save made elsewhere: the content it
+  // tried to save, the server's merge, and whether the merge has been loaded
+  // for resolving by hand. Autosave is paused while it is set.
+  const [conflict, setConflict] = useState<{ yours: string; merge: MergeSuggestion; inEditor: boolean } | null>(null);
+  const [showConflictDialog, setShowConflictDialog] = useState(false);
 
   const saveTimeoutRef = useRef<ReturnType<typeof setTimeout> | undefined>(undefined);
   const lastSavedContent = useRef('');
+  // The version saves are based on, kept outside state so a save that
+  // finishes mid-edit is seen by the next one
+  const savedVersion = useRef(0);
   const textareaRef = useRef<HTMLTextAreaElement>(null);
   const titleInputRef = useRef<HTMLInputElement>(null);
   const isUndoRedoRef = useRef(false);
@@ -61,9 +71,11 @@ export default function EditorPage() {
       setContent(selectedChapter.content);
       setTitleValue(selectedChapter.title);
       lastSavedContent.current = selectedChapter.content;
+      savedVersion.current = selectedChapter.version;
       // Initialize history with current content
       setHistory([selectedChapter.content]);
       setHistoryIndex(0);
+      setConflict(null);
     }
   }, [selectedChapter?.id]);
 
@@ -77,6 +89,7 @@ export default function EditorPage() {
   useEffect(() => {
     // Autosave logic
     if (!selectedChapter) return;
+    if (conflict) return;
     if (content === lastSavedContent.current) return;
 
     if (saveTimeoutRef.current) {
@@ -92,7 +105,7 @@ export default function EditorPage() {
         clearTimeout(saveTimeoutRef.current);
       }
     };
-  }, [content]);
+  }, [content, conflict]);
 
   const loadProjectAndChapters = async () => {
     try {
@@ -119,23 +132,56 @@ export default function EditorPage() {
 
     setSaving(true);
     try {
-      const response = await chaptersAPI.update(selectedChapter.id, { content });
+      const response = await chaptersAPI.update(selectedChapter.id, { content }, savedVersion.current);
       lastSavedContent.current = content;
+      savedVersion.current = response.data.version;
 
       // Update word count in sidebar
       setChapters(chapters.map(ch =>
         ch.id === selectedChapter.id
-          ? { ...ch, wordCount: response.data.wordCount }
+          ? { ...ch, content, wordCount: response.data.wordCount, version: response.data.version }
           : ch
       ));
-      setSelectedChapter({ ...selectedChapter, wordCount: response.data.wordCount });
-    } catch (err) {
-      console.error('Failed to save chapter:', err);
+      setSelectedChapter({ ...selectedChapter, wordCount: response.data.wordCount, version: response.data.version });
+    } catch (err: any) {
+      if (err.response?.status === 409) {
+        // Saved elsewhere since this copy was loaded. A clean merge of both
+        // autosaves against the new version; conflicting edits wait for the
+        // writer to choose what to keep.
+        const { current, merge } = err.response.data;
+        lastSavedContent.current = current.content;
+        savedVersion.current = current.version;
+        setChapters(chapters.map(ch => ch.id === current.id ? current : ch));
+        setSelectedChapter(current);
+        if (merge?.clean) {
+          updateContentWithHistory(merge.content);
+        } else if (merge) {
+          setConflict({ yours: content, merge, inEditor: false });
+          setShowConflictDialog(true);
+        }
+      } else {
+        console.error('Failed to save chapter:', err);
+      }
     } finally {
       setSaving(false);
     }
   };
 
+  const resolveConflict = (resolved: string) => {
+    updateContentWithHistory(resolved);
+    setConflict(null);
+    setShowConflictDialog(false);
+  };
+
+  const handleResolveInEditor = () => {
+    if (!conflict) return;
+    if (!conflict.inEditor) {
+      updateContentWithHistory(conflict.merge.content);
+      setConflict({ ...conflict, inEditor: true });
+    }
+    setShowConflictDialog(false);
+  };
+
   const handleCreateChapter = async (e: React.FormEvent) => {
     e.preventDefault();
     setError('');
@@ -243,11 +289,12 @@ export default function EditorPage() {
     }
 
     try {
-      await chaptersAPI.update(selectedChapter.id, { title: titleValue });
+      const response = await chaptersAPI.update(selectedChapter.id, { title: titleValue }, savedVersion.current);
+      savedVersion.current = response.data.version;
       setChapters(chapters.map(ch =>
-        ch.id === selectedChapter.id ? { ...ch, title: titleValue } : ch
+        ch.id === selectedChapter.id ? { ...ch, title: titleValue, version: response.data.version } : ch
       ));
-      setSelectedChapter({ ...selectedChapter, title: titleValue });
+      setSelectedChapter({ ...selectedChapter, title: titleValue, version: response.data.version });
       setEditingTitle(false);
     } catch (err) {
       console.error('Failed to update title:', err);
@@ -375,7 +422,9 @@ export default function EditorPage() {
                   )}
                 </div>
                 <div className="flex items-center gap-4">
-                  {saving ? (
+                  {conflict ? (
+                    <span className="text-[10px] text-destructive/70 italic">Saving paused</span>
+                  ) : saving ? (
                     <span className="text-[10px] text-primary/60 animate-pulse italic">Autosaving...</span>
                   ) : content !== lastSavedContent.current ? (
                     <span className="text-[10px] text-muted-foreground/40 italic">Unsaved</span>
@@ -385,6 +434,23 @@ export default function EditorPage() {
                   <span className="text-[10px] text-muted-foreground/30 uppercase tracking-[0.2em]">~{selectedChapter.wordCount.toLocaleString()} words</span>
                 </div>
               </div>
+              {conflict && !showConflictDialog && (
+                <div className="mb-6 flex items-center justify-between gap-4 border border-destructive/30 bg-destructive/5 px-4 py-3 text-xs">
+                  <span className="text-muted-foreground">
+                    {hasConflictMarkers(content)
+                      ? 'Saving is paused. Edit the passages between <<<<<<< yours and >>>>>>> theirs, keeping what you want and removing the markers.'
+                      : 'Conflicts resolved. Resume saving to save this version.'}
+                  </span>
+                  <div className="flex items-center gap-2 shrink-0">
+                    <Button variant="ghost" size="sm" onClick={() => setShowConflictDialog(true)}>
+                      Compare
+                    </Button>
+                    <Button size="sm" disabled={hasConflictMarkers(content)} onClick={() => setConflict(null)}>
+                      Resume saving
+                    </Button>
+                  </div>
+                </div>
+              )}
               <div className="flex-1">
                 <textarea
                   ref={textareaRef}
@@ -447,6 +513,15 @@ export default function EditorPage() {
         />
       )}
 
+      {/* Conflicting save */}
+      <MergeConflictDialog
+        open={showConflictDialog && !!conflict}
+        conflicts={conflict?.merge.conflicts ?? []}
+        onKeepMine={() => conflict && resolveConflict(conflict.yours)}
+        onKeepTheirs={() => resolveConflict(lastSavedContent.current)}
+        onResolveInEditor={handleResolveInEditor}
+      />
+
       {/* Create Chapter Modal */}
       <Modal open={showNewChapter} onOpenChange={setShowNewChapter}>
         <ModalHeader
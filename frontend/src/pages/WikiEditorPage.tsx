@@ -4,6 +4,7 @@ import { wikiAPI } from '../api/client';
 import type { WikiPage, Backlink, Mention } from '../types/wiki';
 import { WIKI_PAGE_TYPES } from '../types/wiki';
 import { AppShell } from '../components/layout/AppShell';
+import { MergeConflictDialog, hasConflictMarkers, type MergeSuggestion } from '../components/MergeConflictDialog';
 import { Button } from '@/components/ui/button';
 import { Link, MessageSquare, Tag, Plus, RefreshCw, Library } from 'lucide-react';
 import { cn } from '@/lib/utils';
@@ -23,9 +24,17 @@ export default function WikiEditorPage() {
   const [newTag, setNewTag] = useState('');
   const [showTagInput, setShowTagInput] = useState(false);
   const [rebuilding, setRebuilding] = useState(false);
+  // A save whose edits conflicted with a save made elsewhere: the content it
+  // tried to save, the server's merge, and whether the merge has been loaded
+  // for resolving by hand. Autosave is paused while it is set.
+  const [conflict, setConflict] = useState<{ yours: string; merge: MergeSuggestion; inEditor: boolean } | null>(null);
+  const [showConflictDialog, setShowConflictDialog] = useState(false);
 
   const saveTimeoutRef = useRef<ReturnType<typeof setTimeout> | undefined>(undefined);
   const lastSavedContent = useRef('');
+  // The version saves are based on, kept outside state so a save that
+  // finishes mid-edit is seen by the next one
+  const savedVersion = useRef(0);
 
   useEffect(() => {
     loadPage();
@@ -38,11 +47,14 @@ export default function WikiEditorPage() {
       setContent(page.content);
       setTitle(page.title);
       lastSavedContent.current = page.content;
+      savedVersion.current = page.version;
+      setConflict(null);
     }
   }, [page?.id]);
 
   useEffect(() => {
     if (!page) return;
+    if (conflict) return;
     if (content === lastSavedContent.current) return;
 
     if (saveTimeoutRef.current) {
@@ -58,7 +70,7 @@ export default function WikiEditorPage() {
         clearTimeout(saveTimeoutRef.current);
       }
     };
-  }, [content]);
+  }, [content, conflict]);
 
   const loadPage = async () => {
     try {
@@ -95,17 +107,50 @@ export default function WikiEditorPage() {
 
     setSaving(true);
     try {
-      await wikiAPI.update(page.id, { content });
+      const response = await wikiAPI.update(page.id, { content }, savedVersion.current);
       lastSavedContent.current = content;
+      savedVersion.current = response.data.version;
       // Reload backlinks after save (content might have new links)
       loadBacklinks();
-    } catch (err) {
-      console.error('Failed to save page:', err);
+    } catch (err: any) {
+      if (err.response?.status === 409) {
+        // Saved elsewhere since this copy was loaded. A clean merge of both
+        // autosaves against the new version; conflicting edits wait for the
+        // writer to choose what to keep.
+        const { current, merge } = err.response.data;
+        lastSavedContent.current = current.content;
+        savedVersion.current = current.version;
+        setPage(current);
+        setTitle(current.title);
+        if (merge?.clean) {
+          setContent(merge.content);
+        } else if (merge) {
+          setConflict({ yours: content, merge, inEditor: false });
+          setShowConflictDialog(true);
+        }
+      } else {
+        console.error('Failed to save page:', err);
+      }
     } finally {
       setSaving(false);
     }
   };
 
+  const resolveConflict = (resolved: string) => {
+    setContent(resolved);
+    setConflict(null);
+    setShowConflictDialog(false);
+  };
+
+  const handleResolveInEditor = () => {
+    if (!conflict) return;
+    if (!conflict.inEditor) {
+      setContent(conflict.merge.content);
+      setConflict({ ...conflict, inEditor: true });
+    }
+    setShowConflictDialog(false);
+  };
+
   const handleAddTag = async (e: React.FormEvent) => {
     e.preventDefault();
     if (!page || !newTag.trim()) return;
@@ -151,8 +196,9 @@ export default function WikiEditorPage() {
     }
 
     try {
-      await wikiAPI.update(page.id, { title });
-      setPage({ ...page, title });
+      const response = await wikiAPI.update(page.id, { title }, savedVersion.current);
+      savedVersion.current = response.data.version;
+      setPage({ ...page, title, version: response.data.version });
       setIsEditingTitle(false);
     } catch (err) {
       console.error('Failed to update title:', err);
@@ -265,78 +311,108 @@ export default function WikiEditorPage() {
   const pageTypeInfo = WIKI_PAGE_TYPES.find(t => t.value === page.pageType);
 
   return (
-    <AppShell
-      title={page.title}
-      rightPanel={rightPanel}
-      main={
-        <div className="h-full flex flex-col">
-          <div className="flex items-center justify-between mb-6 border-b border-border/50 pb-4">
-            <div className="flex items-center gap-3">
-              <span className="text-3xl">{pageTypeInfo?.icon}</span>
-              <div>
-                {isEditingTitle ? (
+    <>
+      <AppShell
+        title={page.title}
+        rightPanel={rightPanel}
+        main={
+          <div className="h-full flex flex-col">
+            <div className="flex items-center justify-between mb-6 border-b border-border/50 pb-4">
+              <div className="flex items-center gap-3">
+                <span className="text-3xl">{pageTypeInfo?.icon}</span>
+                <div>
+                  {isEditingTitle ? (
+                    <input
+                      type="text"
+                      value={title}
+                      onChange={(e) => setTitle(e.target.value)}
+                      onBlur={handleTitleBlur}
+                      onKeyDown={handleTitleKeyDown}
+                      className="text-3xl font-bold font-serif bg-transparent border-b border-border/50 focus:border-primary focus:outline-none"
+                      autoFocus
+                    />
+                  ) : (
+                    <h1
+                      className="text-3xl font-bold font-serif cursor-pointer hover:text-primary/80 transition-colors"
+                      onClick={() => setIsEditingTitle(true)}
+                    >
+                      {page.title}
+                    </h1>
+                  )}
+                  <p className="text-xs text-muted-foreground uppercase tracking-widest">{pageTypeInfo?.label}</p>
+                </div>
+              </div>
+              {conflict ? (
+                <span className="text-[10px] text-destructive/70 italic">Saving paused</span>
+              ) : saving && <span className="text-[10px] text-muted-foreground animate-pulse">Saving...</span>}
+            </div>
+
+            <div className="flex flex-wrap gap-2 mb-6">
+              {page.tags.map(tag => (
+                <span key={tag} className="flex items-center gap-1 px-2 py-1 bg-muted rounded-full text-[10px] font-medium text-muted-foreground">
+                  <Tag className="h-3 w-3" />
+                  {tag}
+                  <button onClick={() => handleRemoveTag(tag)} className="ml-1 hover:text-foreground">×</button>
+                </span>
+              ))}
+              {showTagInput ? (
+                <form onSubmit={handleAddTag} className="flex items-center gap-1">
                   <input
-                    type="text"
-                    value={title}
-                    onChange={(e) => setTitle(e.target.value)}
-                    onBlur={handleTitleBlur}
-                    onKeyDown={handleTitleKeyDown}
-                    className="text-3xl font-bold font-serif bg-transparent border-b border-border/50 focus:border-primary focus:outline-none"
                     autoFocus
+                    value={newTag}
+                    onChange={(e) => setNewTag(e.target.value)}
+                    className="px-2 py-1 bg-background border border-border rounded-full text-[10px] focus:outline-none focus:ring-1 focus:ring-primary w-24"
+                    placeholder="Tag name..."
                   />
-                ) : (
-                  <h1
-                    className="text-3xl font-bold font-serif cursor-pointer hover:text-primary/80 transition-colors"
-                    onClick={() => setIsEditingTitle(true)}
-                  >
-                    {page.title}
-                  </h1>
-                )}
-                <p className="text-xs text-muted-foreground uppercase tracking-widest">{pageTypeInfo?.label}</p>
-              </div>
+                </form>
+              ) : (
+                <button
+                  onClick={() => setShowTagInput(true)}
+                  className="flex items-center gap-1 px-2 py-1 border border-border border-dashed rounded-full text-[10px] font-medium text-muted-foreground hover:bg-muted transition-colors"
+                >
+                  <Plus className="h-3 w-3" />
+                  Add Tag
+                </button>
+              )}
             </div>
-            {saving && <span className="text-[10px] text-muted-foreground animate-pulse">Saving...</span>}
-          </div>
 
-          <div className="flex flex-wrap gap-2 mb-6">
-            {page.tags.map(tag => (
-              <span key={tag} className="flex items-center gap-1 px-2 py-1 bg-muted rounded-full text-[10px] font-medium text-muted-foreground">
-                <Tag className="h-3 w-3" />
-                {tag}
-                <button onClick={() => handleRemoveTag(tag)} className="ml-1 hover:text-foreground">×</button>
-              </span>
-            ))}
-            {showTagInput ? (
-              <form onSubmit={handleAddTag} className="flex items-center gap-1">
-                <input
-                  autoFocus
-                  value={newTag}
-                  onChange={(e) => setNewTag(e.target.value)}
-                  className="px-2 py-1 bg-background border border-border rounded-full text-[10px] focus:outline-none focus:ring-1 focus:ring-primary w-24"
-                  placeholder="Tag name..."
-                />
-              </form>
-            ) : (
-              <button
-                onClick={() => setShowTagInput(true)}
-                className="flex items-center gap-1 px-2 py-1 border border-border border-dashed rounded-full text-[10px] font-medium text-muted-foreground hover:bg-muted transition-colors"
-              >
-                <Plus className="h-3 w-3" />
-                Add Tag
-              </button>
+            {conflict && !showConflictDialog && (
+              <div className="mb-6 flex items-center justify-between gap-4 border border-destructive/30 bg-destructive/5 px-4 py-3 text-xs">
+                <span className="text-muted-foreground">
+                  {hasConflictMarkers(content)
+                    ? 'Saving is paused. Edit the passages between <<<<<<< yours and >>>>>>> theirs, keeping what you want and removing the markers.'
+                    : 'Conflicts resolved. Resume saving to save this version.'}
+                </span>
+                <div className="flex items-center gap-2 shrink-0">
+                  <Button variant="ghost" size="sm" onClick={() => setShowConflictDialog(true)}>
+                    Compare
+                  </Button>
+                  <Button size="sm" disabled={hasConflictMarkers(content)} onClick={() => setConflict(null)}>
+                    Resume saving
+                  </Button>
+                </div>
+              </div>
             )}
-          </div>
 
-          <div className="flex-1">
-            <textarea
-              value={content}
-              onChange={(e) => setContent(e.target.value)}
-              className="w-full h-[65vh] text-foreground resize-none focus:outline-none bg-transparent font-serif leading-relaxed text-lg md:text-xl placeholder:opacity-30"
-              placeholder="Start describing your world..."
-            />
+            <div className="flex-1">
+              <textarea
+                value={content}
+                onChange={(e) => setContent(e.target.value)}
+                className="w-full h-[65vh] text-foreground resize-none focus:outline-none bg-transparent font-serif leading-relaxed text-lg md:text-xl placeholder:opacity-30"
+                placeholder="Start describing your world..."
+              />
+            </div>
           </div>
-        </div>
-      }
-    />
+        }
+      />
+
+      <MergeConflictDialog
+        open={showConflictDialog && !!conflict}
+        conflicts={conflict?.merge.conflicts ?? []}
+        onKeepMine={() => conflict && resolveConflict(conflict.yours)}
+        onKeepTheirs={() => resolveConflict(lastSavedContent.current)}
+        onResolveInEditor={handleResolveInEditor}
+      />
+    </>
   );
 }
@@ -13,9 +13,18 @@ export interface AskResponse {
   tokensOut: number;
 }
 
+export interface RewriteCandidate {
+  label: string;
+  tone: string;
+  text: string;
+}
+
 export interface RewriteResponse {
   originalText: string;
+  // The rewrite, or the first candidate's text when there are candidates
   rewrittenText: string;
+  // Alternatives to choose from, e.g. for dialogue variants
+  candidates?: RewriteCandidate[];
   tokensIn: number;
   tokensOut: number;
 }
@@ -8,6 +8,7 @@ export interface WikiPage {
   content: string;
   pageType: WikiPageType;
   tags: string[];
+  version: number;
   createdAt: string;
   updatedAt: string;
 }